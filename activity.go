@@ -0,0 +1,248 @@
+package lcmgr
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Activity tracks the notice being handled so that the admin API can report
+// on it and act on its behalf.
+type Activity struct {
+	Clock Clock
+
+	mu           sync.Mutex
+	current      *activeNotice
+	snoozedUntil time.Time
+	drained      bool
+	subscribers  map[chan *ActivityEvent]struct{}
+}
+
+type activeNotice struct {
+	ctx      context.Context
+	notice   Notice
+	started  time.Time
+	progress *Progress
+	approved bool
+}
+
+// Progress is drain progress reported by a handler or a sibling agent.
+type Progress struct {
+	Done    int    `json:"done"`
+	Total   int    `json:"total"`
+	Message string `json:"message,omitempty"`
+}
+
+type ActivityStatus struct {
+	Type         string            `json:"type"`
+	Metadata     map[string]string `json:"metadata"`
+	Started      time.Time         `json:"started"`
+	Progress     *Progress         `json:"progress,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	SnoozedUntil *time.Time        `json:"snoozed_until,omitempty"`
+}
+
+// Activity event states.
+const (
+	StartedState  = "started"
+	ProgressState = "progress"
+	FinishedState = "finished"
+)
+
+// ActivityEvent is a transition of a notice being handled: it started, its
+// drain progressed, or it finished. Drained is true once the notice drains
+// the instance rather than launching it.
+type ActivityEvent struct {
+	State   string
+	Status  *ActivityStatus
+	Drained bool
+	Time    time.Time
+}
+
+// maxPendingEvents is how many events a slow subscriber can fall behind by
+// before progress events are dropped for it or it's cut off.
+const maxPendingEvents = 16
+
+func NewActivity() *Activity {
+	return &Activity{Clock: NewClock()}
+}
+
+// Begin records notice as being handled until the returned func is called.
+func (activity *Activity) Begin(ctx context.Context, notice Notice) func() {
+	active := &activeNotice{
+		ctx:     ctx,
+		notice:  notice,
+		started: activity.Clock.Now(),
+	}
+
+	activity.mu.Lock()
+	activity.current = active
+	_, launch := notice.(*LaunchNotice)
+	activity.drained = !launch
+	activity.publish(StartedState, active)
+	activity.mu.Unlock()
+
+	return func() {
+		activity.mu.Lock()
+		defer activity.mu.Unlock()
+		activity.publish(FinishedState, active)
+		if activity.current == active {
+			activity.current = nil
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every event from now on, and a func
+// that unsubscribes. Subscribers that fall behind miss progress events, which
+// later events carry the status of anyway, and have the channel closed
+// rather than miss a started or finished event, so they can resubscribe.
+func (activity *Activity) Subscribe() (<-chan *ActivityEvent, func()) {
+	events := make(chan *ActivityEvent, maxPendingEvents)
+
+	activity.mu.Lock()
+	if activity.subscribers == nil {
+		activity.subscribers = make(map[chan *ActivityEvent]struct{})
+	}
+	activity.subscribers[events] = struct{}{}
+	activity.mu.Unlock()
+
+	return events, func() {
+		activity.mu.Lock()
+		defer activity.mu.Unlock()
+		delete(activity.subscribers, events)
+	}
+}
+
+// publish sends an event for active to subscribers. The caller must hold mu.
+func (activity *Activity) publish(state string, active *activeNotice) {
+	if len(activity.subscribers) == 0 {
+		return
+	}
+	event := &ActivityEvent{
+		State:   state,
+		Status:  activity.status(active),
+		Drained: activity.drained,
+		Time:    activity.Clock.Now(),
+	}
+	for events := range activity.subscribers {
+		select {
+		case events <- event:
+		default:
+			if state != ProgressState {
+				delete(activity.subscribers, events)
+				close(events)
+			}
+		}
+	}
+}
+
+// Current returns the notice being handled and its context, or nil.
+func (activity *Activity) Current() (context.Context, Notice) {
+	activity.mu.Lock()
+	defer activity.mu.Unlock()
+	if activity.current == nil {
+		return nil, nil
+	}
+	return activity.current.ctx, activity.current.notice
+}
+
+// Drained reports whether the most recent notice drained the instance rather
+// than launching it, meaning its services were stopped on purpose.
+func (activity *Activity) Drained() bool {
+	activity.mu.Lock()
+	defer activity.mu.Unlock()
+	return activity.drained
+}
+
+func (activity *Activity) Status() *ActivityStatus {
+	activity.mu.Lock()
+	defer activity.mu.Unlock()
+	if activity.current == nil {
+		return nil
+	}
+	return activity.status(activity.current)
+}
+
+// status describes active. The caller must hold mu.
+func (activity *Activity) status(active *activeNotice) *ActivityStatus {
+	status := &ActivityStatus{
+		Type:        active.notice.Type(),
+		Metadata:    NoticeMetadata(active.notice),
+		Started:     active.started,
+		Progress:    active.progress,
+		Annotations: NoticeAnnotations(active.ctx),
+	}
+	if !activity.snoozedUntil.IsZero() {
+		until := activity.snoozedUntil
+		status.SnoozedUntil = &until
+	}
+	return status
+}
+
+// ReportProgress records progress on the current notice and feeds it to the
+// SLO tracker. It returns false if no notice is being handled.
+func (activity *Activity) ReportProgress(progress *Progress) bool {
+	activity.mu.Lock()
+	active := activity.current
+	if active != nil {
+		active.progress = progress
+		activity.publish(ProgressState, active)
+	}
+	activity.mu.Unlock()
+
+	if active == nil {
+		return false
+	}
+	ReportProgress(active.ctx, progress.Done, progress.Total)
+	return true
+}
+
+// Annotate attaches annotations to the current notice. It returns false if
+// no notice is being handled.
+func (activity *Activity) Annotate(values map[string]string) bool {
+	activity.mu.Lock()
+	active := activity.current
+	activity.mu.Unlock()
+
+	if active == nil {
+		return false
+	}
+	for key, value := range values {
+		Annotate(active.ctx, key, value)
+	}
+	return true
+}
+
+// Approve approves the current notice for approval gates. It returns false if
+// no notice is being handled.
+func (activity *Activity) Approve() bool {
+	activity.mu.Lock()
+	defer activity.mu.Unlock()
+	if activity.current == nil {
+		return false
+	}
+	activity.current.approved = true
+	return true
+}
+
+func (activity *Activity) Approved() bool {
+	activity.mu.Lock()
+	defer activity.mu.Unlock()
+	return activity.current != nil && activity.current.approved
+}
+
+// Snooze defers the start of drains, including ones for notices that haven't
+// arrived yet, until the given time.
+func (activity *Activity) Snooze(until time.Time) {
+	activity.mu.Lock()
+	defer activity.mu.Unlock()
+	if until.After(activity.snoozedUntil) {
+		activity.snoozedUntil = until
+	}
+}
+
+func (activity *Activity) SnoozedUntil() time.Time {
+	activity.mu.Lock()
+	defer activity.mu.Unlock()
+	return activity.snoozedUntil
+}
@@ -0,0 +1,89 @@
+package lcmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestActivitySlowSubscriberMissesOnlyProgress(t *testing.T) {
+	activity := NewActivity()
+	events, unsubscribe := activity.Subscribe()
+	defer unsubscribe()
+
+	finish := activity.Begin(context.Background(), NewTerminationNotice("hook", "token"))
+	for i := 0; i < 2*maxPendingEvents; i++ {
+		activity.ReportProgress(&Progress{Done: i, Total: 2 * maxPendingEvents})
+	}
+
+	if event := <-events; event.State != StartedState {
+		t.Fatalf("first event is %s, want %s", event.State, StartedState)
+	}
+	received := 1
+	for len(events) > 0 {
+		event := <-events
+		if event.State != ProgressState {
+			t.Fatalf("got %s event, want %s", event.State, ProgressState)
+		}
+		received++
+	}
+	if received != maxPendingEvents {
+		t.Fatalf("received %d events, want %d", received, maxPendingEvents)
+	}
+
+	finish()
+	if event, ok := <-events; !ok || event.State != FinishedState {
+		t.Fatalf("got %v, want %s event", event, FinishedState)
+	}
+}
+
+func TestActivitySlowSubscriberIsCutOffBeforeMissingTransition(t *testing.T) {
+	activity := NewActivity()
+	events, unsubscribe := activity.Subscribe()
+	defer unsubscribe()
+
+	for i := 0; i < maxPendingEvents; i++ {
+		activity.Begin(context.Background(), NewTerminationNotice("hook", "token"))()
+	}
+
+	var states []string
+	for event := range events {
+		states = append(states, event.State)
+	}
+	if len(states) != maxPendingEvents {
+		t.Fatalf("received %d events before the channel closed, want %d", len(states), maxPendingEvents)
+	}
+	for i, state := range states {
+		want := StartedState
+		if i%2 == 1 {
+			want = FinishedState
+		}
+		if state != want {
+			t.Fatalf("event %d is %s, want %s", i, state, want)
+		}
+	}
+
+	// Unsubscribing after being cut off must not close the channel again.
+	unsubscribe()
+}
+
+func TestActivityUsesClock(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(now)
+	activity := NewActivity()
+	activity.Clock = clock
+	events, unsubscribe := activity.Subscribe()
+	defer unsubscribe()
+
+	finish := activity.Begin(context.Background(), NewTerminationNotice("hook", "token"))
+	clock.Advance(time.Minute)
+	finish()
+
+	started, finished := <-events, <-events
+	if !started.Time.Equal(now) || !started.Status.Started.Equal(now) {
+		t.Errorf("started event at %s for a notice started at %s, want %s", started.Time, started.Status.Started, now)
+	}
+	if want := now.Add(time.Minute); !finished.Time.Equal(want) {
+		t.Errorf("finished event at %s, want %s", finished.Time, want)
+	}
+}
@@ -0,0 +1,100 @@
+package lcmgr
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var noticeAnnotationGauge = DefaultRegistry.Gauge("lcmgr_notice_annotation", "Numeric annotations attached to the most recently handled notice", "notice", "key")
+
+// metricAnnotations are the numeric annotations exported as metrics. Others,
+// including anything attached through the admin API, are only reported, so
+// the gauge's key label stays bounded.
+var metricAnnotations = map[string]bool{
+	"targets_deregistered":        true,
+	"instance_refresh_percentage": true,
+}
+
+type annotationsKey struct{}
+
+// Annotations are key/value pairs handlers attach to the notice being
+// handled, e.g. targets_deregistered=3, so drain records describe what was
+// done. They're reported with the notice to sinks, the admin API, the log
+// once the notice is handled, and as metrics when numeric and listed in
+// metricAnnotations.
+type Annotations struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// WithAnnotations returns a context that collects annotations for a notice.
+func WithAnnotations(ctx context.Context) (context.Context, *Annotations) {
+	annotations := &Annotations{values: make(map[string]string)}
+	return context.WithValue(ctx, annotationsKey{}, annotations), annotations
+}
+
+// Annotate attaches key=value to the notice being handled in ctx, replacing
+// any earlier value. It does nothing when annotations aren't collected.
+func Annotate(ctx context.Context, key, value string) {
+	if annotations, ok := ctx.Value(annotationsKey{}).(*Annotations); ok {
+		annotations.Set(key, value)
+	}
+}
+
+// NoticeAnnotations returns the annotations attached to the notice being
+// handled in ctx, or nil.
+func NoticeAnnotations(ctx context.Context) map[string]string {
+	if annotations, ok := ctx.Value(annotationsKey{}).(*Annotations); ok {
+		return annotations.Map()
+	}
+	return nil
+}
+
+func (annotations *Annotations) Set(key, value string) {
+	annotations.mu.Lock()
+	defer annotations.mu.Unlock()
+	annotations.values[key] = value
+}
+
+// Map returns a copy of the annotations, or nil if there are none.
+func (annotations *Annotations) Map() map[string]string {
+	annotations.mu.Lock()
+	defer annotations.mu.Unlock()
+	if len(annotations.values) == 0 {
+		return nil
+	}
+	values := make(map[string]string, len(annotations.values))
+	for key, value := range annotations.values {
+		values[key] = value
+	}
+	return values
+}
+
+// String renders the annotations as sorted key=value pairs.
+func (annotations *Annotations) String() string {
+	return formatAnnotations(annotations.Map())
+}
+
+// export sets the annotation gauge for each numeric annotation.
+func (annotations *Annotations) export(notice Notice) {
+	for key, value := range annotations.Map() {
+		if !metricAnnotations[key] {
+			continue
+		}
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			noticeAnnotationGauge.Set(number, notice.Type(), key)
+		}
+	}
+}
+
+func formatAnnotations(values map[string]string) string {
+	pairs := make([]string, 0, len(values))
+	for key, value := range values {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,205 @@
+package lcmgr
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// API is a local HTTP API that lets other agents and scripts on the host work
+// through lcmgr, which owns the lifecycle action token, instead of calling
+// Auto Scaling themselves and racing it to complete the action:
+//
+//	GET  /v1/status     the notice being handled, if any
+//	POST /v1/heartbeat  extend the current lifecycle action now
+//	POST /v1/progress   report drain progress, {"done": 1, "total": 3}
+//	POST /v1/annotate   annotate the current notice, {"shards_moved": "12"}
+//	POST /v1/approve    release an approval step waiting on the current notice
+//	POST /v1/snooze     defer drains, {"duration": "10m"}
+//	POST /v1/suspend    suspend the group's scaling processes, when enabled,
+//	                    {"processes": ["Terminate"], "duration": "30m"}
+//	POST /v1/resume     resume them, {"processes": ["Terminate"]}
+//	GET  /debug/pprof/  runtime profiles, when diagnostics are enabled
+//
+// The same listener serves the Notices gRPC service in
+// proto/lcmgr/v1/notices.proto, which streams notices and drain transitions
+// to sidecars as they happen.
+//
+// It has no authentication, so it should only listen on loopback or a unix
+// socket.
+type API struct {
+	Handler   *ServiceHandler
+	Suspender *ProcessSuspender
+	Clock     Clock
+
+	mux  *http.ServeMux
+	grpc *grpc.Server
+}
+
+func NewAPI(handler *ServiceHandler) *API {
+	api := &API{
+		Handler: handler,
+		Clock:   handler.Clock,
+		mux:     http.NewServeMux(),
+		grpc:    NewGRPCServer(handler.Activity),
+	}
+	api.mux.HandleFunc("/v1/status", api.status)
+	api.mux.HandleFunc("/v1/heartbeat", api.heartbeat)
+	api.mux.HandleFunc("/v1/progress", api.progress)
+	api.mux.HandleFunc("/v1/annotate", api.annotate)
+	api.mux.HandleFunc("/v1/approve", api.approve)
+	api.mux.HandleFunc("/v1/snooze", api.snooze)
+	return api
+}
+
+// ListenAPI listens on a TCP address, or on a unix socket when address is
+// prefixed with unix:, e.g. unix:/run/lcmgr.sock.
+func ListenAPI(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, "unix:") {
+		path := strings.TrimPrefix(address, "unix:")
+		os.Remove(path)
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		return listener, os.Chmod(path, 0600)
+	}
+	return net.Listen("tcp", address)
+}
+
+// Serve serves the API on listener over HTTP/1.1 and unencrypted HTTP/2,
+// which gRPC clients speak.
+func (api *API) Serve(listener net.Listener) error {
+	server := &http.Server{
+		Handler:   api,
+		Protocols: new(http.Protocols),
+	}
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetUnencryptedHTTP2(true)
+	return server.Serve(listener)
+}
+
+func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isGRPC(r) {
+		api.grpc.ServeHTTP(w, r)
+		return
+	}
+	api.mux.ServeHTTP(w, r)
+}
+
+func (api *API) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, struct {
+		Notice *ActivityStatus `json:"notice"`
+	}{api.Handler.Activity.Status()})
+}
+
+func (api *API) heartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, notice := api.Handler.Activity.Current()
+	switch notice.(type) {
+	case *LaunchNotice, *TerminationNotice:
+	default:
+		http.Error(w, "no lifecycle action in progress", http.StatusConflict)
+		return
+	}
+
+	err := api.Handler.Client.SendHeartbeat(ctx, notice)
+	heartbeatSent(ctx, notice, err)
+	if err != nil {
+		log.Printf("failed to send requested heartbeat for %s notice: %v", notice.Type(), err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) progress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var progress Progress
+	if err := json.NewDecoder(r.Body).Decode(&progress); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !api.Handler.Activity.ReportProgress(&progress) {
+		http.Error(w, "no notice in progress", http.StatusConflict)
+		return
+	}
+	log.Printf("progress reported: %d of %d %s", progress.Done, progress.Total, progress.Message)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) annotate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var annotations map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&annotations); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !api.Handler.Activity.Annotate(annotations) {
+		http.Error(w, "no notice in progress", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) approve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !api.Handler.Activity.Approve() {
+		http.Error(w, "no notice in progress", http.StatusConflict)
+		return
+	}
+	log.Printf("drain approved through admin api")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) snooze(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Duration Duration `json:"duration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	until := api.Clock.Now().Add(time.Duration(request.Duration))
+	api.Handler.Activity.Snooze(until)
+	log.Printf("drains snoozed until %s through admin api", until.Format(time.RFC3339))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
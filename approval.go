@@ -0,0 +1,116 @@
+package lcmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+const defaultApprovalInterval = 15 * time.Second
+
+// ApprovalHandler holds a drain open until an operator approves it, either
+// through the admin API's /v1/approve or by setting SSMParameter to
+// "approved". The lifecycle action keeps being heartbeated while it waits, up
+// to the hook's budget. If Webhook is set, a chat-ops message asking for
+// approval is posted to it first. {instance_id} in SSMParameter is replaced
+// with the instance ID, e.g. /lcmgr/approvals/{instance_id}.
+type ApprovalHandler struct {
+	SSMParameter string
+	Webhook      string
+	Interval     time.Duration
+	Handler      *ServiceHandler
+	HTTPClient   *http.Client
+	Clock        Clock
+}
+
+type approvalRequest struct {
+	Type       string `json:"type"`
+	Message    string `json:"message"`
+	InstanceID string `json:"instance_id"`
+	Notice     string `json:"notice"`
+}
+
+func NewApprovalHandler(ssmParameter, webhook string, interval time.Duration, handler *ServiceHandler) *ApprovalHandler {
+	if interval <= 0 {
+		interval = defaultApprovalInterval
+	}
+	return &ApprovalHandler{
+		SSMParameter: ssmParameter,
+		Webhook:      webhook,
+		Interval:     interval,
+		Handler:      handler,
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+		Clock:        NewClock(),
+	}
+}
+
+func (handler *ApprovalHandler) Handle(ctx context.Context, notice Notice) error {
+	instanceID, err := handler.Handler.Client.GetInstanceID(ctx)
+	if err != nil {
+		return err
+	}
+	parameter := strings.Replace(handler.SSMParameter, "{instance_id}", instanceID, -1)
+
+	message := fmt.Sprintf("%s is waiting for approval to finish handling a %s notice", instanceID, notice.Type())
+	if parameter != "" {
+		message += fmt.Sprintf(", set %s to approved", parameter)
+	}
+	log.Print(message)
+
+	if handler.Webhook != "" {
+		request := &approvalRequest{
+			Type:       "approval",
+			Message:    message,
+			InstanceID: instanceID,
+			Notice:     notice.Type(),
+		}
+		if err := postJSON(ctx, handler.HTTPClient, handler.Webhook, request); err != nil {
+			log.Printf("failed to request approval through webhook: %v", err)
+		}
+	}
+
+	for {
+		if handler.Handler.Activity.Approved() {
+			return nil
+		}
+		if parameter != "" {
+			value, err := handler.Handler.Client.GetParameter(ctx, parameter)
+			if err != nil {
+				log.Printf("failed to check approval parameter %s: %v", parameter, err)
+			} else if strings.EqualFold(strings.TrimSpace(value), "approved") {
+				log.Printf("drain approved through %s", parameter)
+				return nil
+			}
+		}
+
+		select {
+		case <-handler.Clock.After(handler.Interval):
+		case <-ctx.Done():
+			return fmt.Errorf("drain was not approved: %v", ctx.Err())
+		}
+	}
+}
+
+// GetParameter returns an SSM parameter's value, or "" if it doesn't exist.
+func (client *awsClient) GetParameter(ctx context.Context, name string) (string, error) {
+	output, err := client.SSM().GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		var notFound *types.ParameterNotFound
+		if errors.As(err, &notFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return aws.ToString(output.Parameter.Value), nil
+}
@@ -0,0 +1,163 @@
+package lcmgr
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"go.mozilla.org/pkcs7"
+)
+
+// InstanceIdentity is the instance identity document with the PKCS7 signature
+// AWS made over it with the region's RSA-2048 key. It's forwarded as is so
+// downstream consumers can check the signature themselves.
+type InstanceIdentity struct {
+	Document  string `json:"document"`
+	Signature string `json:"pkcs7"`
+}
+
+// IdentityDocument holds the fields of an instance identity document.
+type IdentityDocument struct {
+	InstanceID       string `json:"instanceId"`
+	AccountID        string `json:"accountId"`
+	Region           string `json:"region"`
+	AvailabilityZone string `json:"availabilityZone"`
+	InstanceType     string `json:"instanceType"`
+	ImageID          string `json:"imageId"`
+	PendingTime      string `json:"pendingTime"`
+}
+
+// GetInstanceIdentity reads the instance identity document and its RSA-2048
+// PKCS7 signature from instance metadata. The signature is returned without
+// line breaks.
+func (client *awsClient) GetInstanceIdentity(ctx context.Context) (*InstanceIdentity, error) {
+	document, err := client.getDynamicData(ctx, "instance-identity/document")
+	if err != nil {
+		return nil, err
+	}
+	signature, err := client.getDynamicData(ctx, "instance-identity/rsa2048")
+	if err != nil {
+		return nil, err
+	}
+	return &InstanceIdentity{
+		Document:  document,
+		Signature: strings.Join(strings.Fields(signature), ""),
+	}, nil
+}
+
+// GetIdentityDocument returns the instance identity document, which is read
+// from instance metadata once and cached since none of its fields change
+// while the instance runs. A failed read is retried on the next call.
+func (client *awsClient) GetIdentityDocument(ctx context.Context) (*IdentityDocument, error) {
+	client.identityMu.Lock()
+	defer client.identityMu.Unlock()
+	if client.identityDocument != nil {
+		return client.identityDocument, nil
+	}
+
+	data, err := client.getDynamicData(ctx, "instance-identity/document")
+	if err != nil {
+		return nil, err
+	}
+	var document IdentityDocument
+	if err := json.Unmarshal([]byte(data), &document); err != nil {
+		return nil, fmt.Errorf("failed to parse identity document: %v", err)
+	}
+	client.identityDocument = &document
+	return client.identityDocument, nil
+}
+
+// getDynamicData reads an instance dynamic data path, e.g.
+// "instance-identity/document".
+func (client *awsClient) getDynamicData(ctx context.Context, path string) (string, error) {
+	output, err := client.IMDS.GetDynamicData(ctx, &imds.GetDynamicDataInput{Path: path})
+	if err != nil {
+		return "", err
+	}
+	defer output.Content.Close()
+
+	content, err := ioutil.ReadAll(output.Content)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// Verify checks the signature over the document against the region's AWS
+// public certificate and returns the parsed document.
+func (identity *InstanceIdentity) Verify(certificate *x509.Certificate) (*IdentityDocument, error) {
+	der, err := base64.StdEncoding.DecodeString(identity.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode identity signature: %v", err)
+	}
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity signature: %v", err)
+	}
+	p7.Certificates = []*x509.Certificate{certificate}
+	if err := p7.Verify(); err != nil {
+		return nil, fmt.Errorf("failed to verify identity signature: %v", err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(p7.Content), bytes.TrimSpace([]byte(identity.Document))) {
+		return nil, errors.New("identity signature is not over the identity document")
+	}
+
+	var document IdentityDocument
+	if err := json.Unmarshal([]byte(identity.Document), &document); err != nil {
+		return nil, fmt.Errorf("failed to parse identity document: %v", err)
+	}
+	return &document, nil
+}
+
+// LoadCertificate reads a PEM encoded certificate, e.g. the AWS public
+// certificate for the instance's region.
+func LoadCertificate(path string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no pem certificate found in %s", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// AttestIdentity reads the instance identity document and verifies it with
+// the certificate at certificatePath before it's forwarded, so a tampered
+// metadata service is caught here rather than by every consumer.
+func AttestIdentity(ctx context.Context, client AWSClient, certificatePath string) (*InstanceIdentity, error) {
+	if certificatePath == "" {
+		return nil, errors.New("an aws public certificate for the instance's region is required to verify its identity")
+	}
+	certificate, err := LoadCertificate(certificatePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load identity certificate: %v", err)
+	}
+
+	identity, err := client.GetInstanceIdentity(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance identity document: %v", err)
+	}
+	document, err := identity.Verify(certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	instanceID, err := client.GetInstanceID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if document.InstanceID != instanceID {
+		return nil, fmt.Errorf("identity document is for %s, not %s", document.InstanceID, instanceID)
+	}
+	return identity, nil
+}
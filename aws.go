@@ -5,16 +5,29 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"strings"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/arn"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/autoscaling"
-	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancing"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/globalaccelerator"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 )
 
 const (
@@ -22,30 +35,143 @@ const (
 	TerminationLifecycleAction = "autoscaling:EC2_INSTANCE_TERMINATING"
 )
 
+const (
+	ContinueResult = "CONTINUE"
+	AbandonResult  = "ABANDON"
+)
+
 type AWSClient interface {
-	GetInstanceID() (string, error)
+	GetInstanceID(context.Context) (string, error)
 	GetAutoScalingGroupName(context.Context) (string, error)
+	GetLifecycleHooks(context.Context) ([]*LifecycleHook, error)
 	GetLifecycleNoticeQueues(context.Context) ([]*Queue, error)
-	GetSpotNotice() (Notice, error)
-	GetLifecycleNotice(context.Context, *Queue) (Notice, error)
+	GetDesiredCapacity(context.Context) (int64, error)
+	GetInstanceRefresh(context.Context) (*InstanceRefresh, error)
+	GetInServiceCapacity(context.Context) (int, int, error)
+	GetScheduledActions(context.Context, time.Time, time.Time) ([]*ScheduledAction, error)
+	GetInstanceLifeCycle(context.Context) (string, error)
+	GetAvailabilityZone(context.Context) (string, error)
+	GetInstanceIdentity(context.Context) (*InstanceIdentity, error)
+	GetIdentityDocument(context.Context) (*IdentityDocument, error)
+	GetCallerIdentity(context.Context) (string, error)
+	IsProtectedFromScaleIn(context.Context) (bool, error)
+	GetRebalanceRecommendation(context.Context) (*time.Time, error)
+	GetSpotNotice(context.Context) (Notice, error)
+	GetScheduledEvents(context.Context) ([]*ScheduledEvent, error)
+	GetStatusChecks(context.Context) (*StatusChecks, error)
+	SetSubscriptionFilterPolicy(context.Context, string) error
+	GetTargetGroup(context.Context, string) (*TargetGroup, error)
+	GetEndpointWeight(context.Context, string, string) (int64, error)
+	SetEndpointWeight(context.Context, string, string, int64) error
+	GetTargetHealth(context.Context, string) ([]*Target, error)
+	RegisterTargets(context.Context, string, []*Target) error
+	GetObjectInfo(context.Context, string, string) (*ObjectInfo, error)
+	GetObject(context.Context, string, string, string, int64) (io.ReadCloser, error)
+	GetParameter(context.Context, string) (string, error)
+	DeregisterTargets(context.Context, string, []*Target) error
+	DiscoverLoadBalancers(context.Context) (*LoadBalancers, error)
+	DeregisterFromClassicLoadBalancer(context.Context, string) error
+	ClassicLoadBalancerDrained(context.Context, string) (bool, error)
+	GetLifecycleNotices(context.Context, *Queue) ([]Notice, error)
+	SuspendProcesses(context.Context, []string) error
+	ResumeProcesses(context.Context, []string) error
+	ExtendNoticeMessage(context.Context, Notice) error
+	DeleteNoticeMessage(context.Context, Notice) error
 	SendHeartbeat(context.Context, Notice) error
-	CompleteLifecycleAction(context.Context, Notice) error
+	CompleteLifecycleAction(context.Context, Notice, string) error
+	CreateQueue(context.Context, string) (string, string, error)
+	CreateEphemeralQueue(context.Context) (*EphemeralQueue, error)
+	DeleteEphemeralQueue(context.Context, *EphemeralQueue) error
+	CleanupEphemeralQueues(context.Context, bool) ([]string, error)
+	PutLifecycleHook(context.Context, string, *LifecycleHook, string) error
 }
 
+// awsClient creates service clients on first use, so a daemon that never
+// receives a lifecycle notice doesn't pay for the SQS client and one that
+// never filters subscriptions doesn't pay for SNS. Instance metadata is read
+// with IMDSv2 session tokens, so it works where HttpTokens=required, falling
+// back to IMDSv1 if a token can't be fetched unless
+// AWS_EC2_METADATA_V1_DISABLED is set.
 type awsClient struct {
-	Session     *session.Session
-	AutoScaling *autoscaling.AutoScaling
-	EC2Metadata *ec2metadata.EC2Metadata
-	SQS         *sqs.SQS
+	Config     aws.Config
+	IMDS       *imds.Client
+	SQSRoleARN string
+	Endpoints  map[string]string
+	PathStyle  bool
+	Clock      Clock
+
+	autoScalingOnce sync.Once
+	autoScaling     *autoscaling.Client
+	snsOnce         sync.Once
+	sns             *sns.Client
+	sqsOnce         sync.Once
+	sqs             *sqs.Client
+	elbOnce         sync.Once
+	elb             *elasticloadbalancing.Client
+	elbv2Once       sync.Once
+	elbv2           *elasticloadbalancingv2.Client
+	gaOnce          sync.Once
+	ga              *globalaccelerator.Client
+	s3Once          sync.Once
+	s3              *s3.Client
+	ssmOnce         sync.Once
+	ssm             *ssm.Client
+	ec2Once         sync.Once
+	ec2             *ec2.Client
 
 	AutoScalingGroupName string
 	InstanceID           string
+
+	identityMu       sync.Mutex
+	identityDocument *IdentityDocument
+}
+
+// maxLifecycleActionTimeout is the longest a lifecycle action can be kept
+// open, no matter how many heartbeats are sent.
+const maxLifecycleActionTimeout = 48 * time.Hour
+
+type LifecycleHook struct {
+	Name                  string
+	Transition            string
+	NotificationTargetARN string
+	HeartbeatTimeout      time.Duration
+	GlobalTimeout         time.Duration
+	DefaultResult         string
 }
 
+// receiveVisibilityTimeout hides received messages long enough to delete
+// them. Messages for other instances are released right away instead of
+// being left to reappear, so the fleet doesn't keep receiving the same
+// messages, and a FIFO receipt handle stops working once its message is
+// received again.
+const receiveVisibilityTimeout = 30
+
+// Queue is an SQS queue lifecycle notifications are sent to. FIFO is set for
+// FIFO queues.
 type Queue struct {
 	Action string
 	Name   string
 	URL    string
+	FIFO   bool
+}
+
+type ScheduledAction struct {
+	Name            string
+	StartTime       time.Time
+	DesiredCapacity *int64
+	MinSize         *int64
+	MaxSize         *int64
+}
+
+// ScheduledEvent is an EC2 scheduled event for the instance, e.g. a
+// system-reboot for host maintenance.
+type ScheduledEvent struct {
+	ID          string
+	Code        string
+	Description string
+	State       string
+	NotBefore   time.Time
+	NotAfter    time.Time
 }
 
 type Message struct {
@@ -53,67 +179,293 @@ type Message struct {
 	LifecycleHookName    string `json:"LifecycleHookName"`
 	LifecycleActionToken string `json:"LifecycleActionToken"`
 	LifecycleTransition  string `json:"LifecycleTransition"`
+	NotificationMetadata string `json:"NotificationMetadata"`
+
+	// Notice is set instead of the lifecycle fields for EC2 spot
+	// interruption and rebalance events.
+	Notice Notice `json:"-"`
+}
+
+// AWSOption customizes the client built by NewAWSClient.
+type AWSOption func(*awsOptions)
+
+type awsOptions struct {
+	load       []func(*config.LoadOptions) error
+	imds       []func(*imds.Options)
+	sqsRoleARN string
+	endpoints  map[string]string
+	pathStyle  bool
+}
+
+// WithRegion sets the region instead of reading it from the environment or
+// instance metadata.
+func WithRegion(region string) AWSOption {
+	return func(options *awsOptions) {
+		options.load = append(options.load, config.WithRegion(region))
+	}
+}
+
+// endpointServices are the services whose endpoint can be set with
+// WithServiceEndpoint, named after their SDK packages.
+var endpointServices = []string{
+	"autoscaling",
+	"ec2",
+	"elasticloadbalancing",
+	"elasticloadbalancingv2",
+	"globalaccelerator",
+	"s3",
+	"sns",
+	"sqs",
+	"ssm",
+	"sts",
+}
+
+// IsEndpointService returns true if service's endpoint can be set with
+// WithServiceEndpoint.
+func IsEndpointService(service string) bool {
+	return containsString(endpointServices, service)
+}
+
+// WithEndpoint sends every AWS API call to url, the way LocalStack serves
+// them. S3 is addressed path style. Instance metadata is always read from the
+// instance.
+func WithEndpoint(url string) AWSOption {
+	return func(options *awsOptions) {
+		options.load = append(options.load, config.WithBaseEndpoint(url))
+		options.pathStyle = true
+	}
+}
+
+// WithServiceEndpoint sends service's API calls to url, e.g. an interface VPC
+// endpoint, instead of its regional endpoint or the one set by WithEndpoint.
+func WithServiceEndpoint(service, url string) AWSOption {
+	return func(options *awsOptions) {
+		if options.endpoints == nil {
+			options.endpoints = map[string]string{}
+		}
+		options.endpoints[service] = url
+	}
+}
+
+// WithHTTPClient sends AWS API calls with client.
+func WithHTTPClient(client *http.Client) AWSOption {
+	return func(options *awsOptions) {
+		options.load = append(options.load, config.WithHTTPClient(client))
+	}
+}
+
+// WithSQSRole makes SQS calls with roleARN, assumed through STS, so lifecycle
+// hook queues can live in another account while Auto Scaling and instance
+// metadata calls keep using the instance's credentials.
+func WithSQSRole(roleARN string) AWSOption {
+	return func(options *awsOptions) {
+		options.sqsRoleARN = roleARN
+	}
 }
 
-func NewAWSClient() AWSClient {
-	sess := session.Must(session.NewSession())
+// NewAWSClient loads the default AWS config, customized by options. AWS calls
+// are retried with exponential backoff and jitter.
+func NewAWSClient(options ...AWSOption) AWSClient {
+	var resolved awsOptions
+	for _, option := range options {
+		option(&resolved)
+	}
+
+	load := append(credentialOptions(), config.WithEC2IMDSRegion(), config.WithRetryer(newRetryer))
+	load = append(load, imdsLoadOptions(resolved.imds)...)
+	cfg, err := config.LoadDefaultConfig(context.Background(), append(load, resolved.load...)...)
+	if err != nil {
+		log.Fatalf("failed to load aws config: %v", err)
+	}
+	if cfg.Region == "" {
+		log.Printf("failed to determine aws region, set AWS_REGION")
+	}
+	if Debug {
+		instrumentConfig(&cfg)
+	}
 
 	return &awsClient{
-		Session:     sess,
-		AutoScaling: autoscaling.New(sess),
-		EC2Metadata: ec2metadata.New(sess),
-		SQS:         sqs.New(sess),
+		Config:     cfg,
+		IMDS:       imds.NewFromConfig(cfg, resolved.imds...),
+		SQSRoleARN: resolved.sqsRoleARN,
+		Endpoints:  resolved.endpoints,
+		PathStyle:  resolved.pathStyle,
+		Clock:      NewClock(),
 	}
 }
 
-func (client *awsClient) GetInstanceID() (string, error) {
-	if client.InstanceID != "" {
-		return client.InstanceID, nil
+// endpoint returns the endpoint set for service, or the one set for every
+// service, or nil to use the regional endpoint.
+func (client *awsClient) endpoint(service string) *string {
+	if url, ok := client.Endpoints[service]; ok {
+		return aws.String(url)
 	}
+	return client.Config.BaseEndpoint
+}
+
+func (client *awsClient) AutoScaling() *autoscaling.Client {
+	client.autoScalingOnce.Do(func() {
+		client.autoScaling = autoscaling.NewFromConfig(client.Config, func(options *autoscaling.Options) {
+			options.BaseEndpoint = client.endpoint("autoscaling")
+		})
+	})
+	return client.autoScaling
+}
+
+func (client *awsClient) EC2() *ec2.Client {
+	client.ec2Once.Do(func() {
+		client.ec2 = ec2.NewFromConfig(client.Config, func(options *ec2.Options) {
+			options.BaseEndpoint = client.endpoint("ec2")
+		})
+	})
+	return client.ec2
+}
 
-	if !client.EC2Metadata.Available() {
-		return "", errors.New("unable to access ec2 metadata api")
+func (client *awsClient) SNS() *sns.Client {
+	client.snsOnce.Do(func() {
+		client.sns = sns.NewFromConfig(client.Config, func(options *sns.Options) {
+			options.BaseEndpoint = client.endpoint("sns")
+		})
+	})
+	return client.sns
+}
+
+func (client *awsClient) SQS() *sqs.Client {
+	client.sqsOnce.Do(func() {
+		cfg := client.Config.Copy()
+		if client.SQSRoleARN != "" {
+			provider := stscreds.NewAssumeRoleProvider(client.STS(), client.SQSRoleARN, func(options *stscreds.AssumeRoleOptions) {
+				options.RoleSessionName = defaultRoleSessionName()
+			})
+			cfg.Credentials = aws.NewCredentialsCache(provider)
+		}
+		client.sqs = sqs.NewFromConfig(cfg, func(options *sqs.Options) {
+			options.BaseEndpoint = client.endpoint("sqs")
+		})
+	})
+	return client.sqs
+}
+
+// STS isn't cached since it's only used to assume the SQS role and by debug
+// bundles.
+func (client *awsClient) STS() *sts.Client {
+	return sts.NewFromConfig(client.Config, func(options *sts.Options) {
+		options.BaseEndpoint = client.endpoint("sts")
+	})
+}
+
+func (client *awsClient) ELB() *elasticloadbalancing.Client {
+	client.elbOnce.Do(func() {
+		client.elb = elasticloadbalancing.NewFromConfig(client.Config, func(options *elasticloadbalancing.Options) {
+			options.BaseEndpoint = client.endpoint("elasticloadbalancing")
+			options.APIOptions = append(options.APIOptions, loadBalancerBreaker.protect)
+		})
+	})
+	return client.elb
+}
+
+func (client *awsClient) ELBV2() *elasticloadbalancingv2.Client {
+	client.elbv2Once.Do(func() {
+		client.elbv2 = elasticloadbalancingv2.NewFromConfig(client.Config, func(options *elasticloadbalancingv2.Options) {
+			options.BaseEndpoint = client.endpoint("elasticloadbalancingv2")
+			options.APIOptions = append(options.APIOptions, loadBalancerBreaker.protect)
+		})
+	})
+	return client.elbv2
+}
+
+func (client *awsClient) S3() *s3.Client {
+	client.s3Once.Do(func() {
+		client.s3 = s3.NewFromConfig(client.Config, func(options *s3.Options) {
+			options.BaseEndpoint = client.endpoint("s3")
+			options.UsePathStyle = client.PathStyle
+		})
+	})
+	return client.s3
+}
+
+func (client *awsClient) SSM() *ssm.Client {
+	client.ssmOnce.Do(func() {
+		client.ssm = ssm.NewFromConfig(client.Config, func(options *ssm.Options) {
+			options.BaseEndpoint = client.endpoint("ssm")
+		})
+	})
+	return client.ssm
+}
+
+// GlobalAccelerator's API is only served from us-west-2, wherever the
+// accelerator's endpoints are.
+func (client *awsClient) GlobalAccelerator() *globalaccelerator.Client {
+	client.gaOnce.Do(func() {
+		client.ga = globalaccelerator.NewFromConfig(client.Config, func(options *globalaccelerator.Options) {
+			options.Region = "us-west-2"
+			options.BaseEndpoint = client.endpoint("globalaccelerator")
+			options.APIOptions = append(options.APIOptions, globalAcceleratorBreaker.protect)
+		})
+	})
+	return client.ga
+}
+
+// getMetadata reads an instance metadata path, e.g. "instance-id".
+func (client *awsClient) getMetadata(ctx context.Context, path string) (string, error) {
+	output, err := client.IMDS.GetMetadata(ctx, &imds.GetMetadataInput{Path: path})
+	if err != nil {
+		return "", err
 	}
+	defer output.Content.Close()
 
-	instanceID, err := client.EC2Metadata.GetMetadata("instance-id")
+	content, err := ioutil.ReadAll(output.Content)
 	if err != nil {
 		return "", err
 	}
+	return string(content), nil
+}
+
+func (client *awsClient) GetInstanceID(ctx context.Context) (string, error) {
+	if client.InstanceID != "" {
+		return client.InstanceID, nil
+	}
+
+	instanceID, err := client.getMetadata(ctx, "instance-id")
+	if err != nil {
+		return "", fmt.Errorf("unable to access ec2 metadata api, in a container the instance's metadata hop limit must be at least 2 or imds_endpoint must point at a proxy: %v", err)
+	}
 
 	client.InstanceID = instanceID
 	return instanceID, nil
 }
 
+// ErrNoAutoScalingGroup is returned for instances that aren't in an auto
+// scaling group, e.g. standalone spot instances.
+var ErrNoAutoScalingGroup = errors.New("instance is not controlled by an auto scaling group")
+
 func (client *awsClient) GetAutoScalingGroupName(ctx context.Context) (string, error) {
 	if client.AutoScalingGroupName != "" {
 		return client.AutoScalingGroupName, nil
 	}
 
-	instanceID, err := client.EC2Metadata.GetMetadata("instance-id")
+	instanceID, err := client.GetInstanceID(ctx)
 	if err != nil {
 		return "", err
 	}
 
 	input := &autoscaling.DescribeAutoScalingInstancesInput{
-		InstanceIds: []*string{
-			aws.String(instanceID),
-		},
+		InstanceIds: []string{instanceID},
 	}
-	output, err := client.AutoScaling.DescribeAutoScalingInstancesWithContext(ctx, input)
+	output, err := client.AutoScaling().DescribeAutoScalingInstances(ctx, input)
 	if err != nil {
 		return "", err
 	}
 	if len(output.AutoScalingInstances) != 1 {
-		return "", errors.New("instance is not controlled by an auto scaling group")
+		return "", ErrNoAutoScalingGroup
 	}
 
-	autoScalingGroupName := *output.AutoScalingInstances[0].AutoScalingGroupName
+	autoScalingGroupName := aws.ToString(output.AutoScalingInstances[0].AutoScalingGroupName)
 	client.AutoScalingGroupName = autoScalingGroupName
 	return autoScalingGroupName, nil
 }
 
-// TODO: Include heartbeat in queue
-func (client *awsClient) GetLifecycleNoticeQueues(ctx context.Context) ([]*Queue, error) {
+func (client *awsClient) GetLifecycleHooks(ctx context.Context) ([]*LifecycleHook, error) {
 	autoScalingGroupName, err := client.GetAutoScalingGroupName(ctx)
 	if err != nil {
 		return nil, err
@@ -122,20 +474,55 @@ func (client *awsClient) GetLifecycleNoticeQueues(ctx context.Context) ([]*Queue
 	input := &autoscaling.DescribeLifecycleHooksInput{
 		AutoScalingGroupName: aws.String(autoScalingGroupName),
 	}
-	output, err := client.AutoScaling.DescribeLifecycleHooksWithContext(ctx, input)
+	output, err := client.AutoScaling().DescribeLifecycleHooks(ctx, input)
 	if err != nil {
 		return nil, err
 	}
 
-	var queues map[string]*Queue
+	hooks := make([]*LifecycleHook, 0, len(output.LifecycleHooks))
 	for _, hook := range output.LifecycleHooks {
-		if _, ok := queues[*hook.NotificationTargetARN]; ok {
+		hooks = append(hooks, &LifecycleHook{
+			Name:                  aws.ToString(hook.LifecycleHookName),
+			Transition:            aws.ToString(hook.LifecycleTransition),
+			NotificationTargetARN: aws.ToString(hook.NotificationTargetARN),
+			HeartbeatTimeout:      time.Duration(aws.ToInt32(hook.HeartbeatTimeout)) * time.Second,
+			GlobalTimeout:         time.Duration(aws.ToInt32(hook.GlobalTimeout)) * time.Second,
+			DefaultResult:         aws.ToString(hook.DefaultResult),
+		})
+	}
+	return hooks, nil
+}
+
+// Budget is the longest a lifecycle action for the hook can be kept open by
+// heartbeats: the hook's global timeout, or when that isn't known, 100 times
+// the heartbeat timeout or 48 hours, whichever is less.
+func (hook *LifecycleHook) Budget() time.Duration {
+	if hook.GlobalTimeout > 0 {
+		return hook.GlobalTimeout
+	}
+	budget := 100 * hook.HeartbeatTimeout
+	if budget > maxLifecycleActionTimeout {
+		budget = maxLifecycleActionTimeout
+	}
+	return budget
+}
+
+// TODO: Include heartbeat in queue
+func (client *awsClient) GetLifecycleNoticeQueues(ctx context.Context) ([]*Queue, error) {
+	hooks, err := client.GetLifecycleHooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	queues := make(map[string]*Queue)
+	for _, hook := range hooks {
+		if _, ok := queues[hook.NotificationTargetARN]; ok {
 			continue
 		}
 
-		parsed, err := arn.Parse(*hook.NotificationTargetARN)
+		parsed, err := client.parsePartitionARN(hook.NotificationTargetARN)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("lifecycle hook %s: %v", hook.Name, err)
 		}
 		if parsed.Service != "sqs" {
 			continue
@@ -145,15 +532,16 @@ func (client *awsClient) GetLifecycleNoticeQueues(ctx context.Context) ([]*Queue
 			QueueName:              aws.String(parsed.Resource),
 			QueueOwnerAWSAccountId: aws.String(parsed.AccountID),
 		}
-		output, err := client.SQS.GetQueueUrlWithContext(ctx, input)
+		output, err := client.SQS().GetQueueUrl(ctx, input)
 		if err != nil {
 			return nil, err
 		}
 
-		queues[*hook.NotificationTargetARN] = &Queue{
-			Action: *hook.LifecycleTransition,
+		queues[hook.NotificationTargetARN] = &Queue{
+			Action: hook.Transition,
 			Name:   parsed.Resource,
-			URL:    *output.QueueUrl,
+			URL:    aws.ToString(output.QueueUrl),
+			FIFO:   IsFIFOQueue(parsed.Resource),
 		}
 	}
 
@@ -165,10 +553,199 @@ func (client *awsClient) GetLifecycleNoticeQueues(ctx context.Context) ([]*Queue
 	return unique, nil
 }
 
-func (client *awsClient) GetSpotNotice() (Notice, error) {
-	output, err := client.EC2Metadata.GetMetadata("spot/termination-time")
+func (client *awsClient) GetDesiredCapacity(ctx context.Context) (int64, error) {
+	autoScalingGroupName, err := client.GetAutoScalingGroupName(ctx)
 	if err != nil {
-		if e, ok := err.(awserr.Error); ok && strings.Contains(e.OrigErr().Error(), "404") {
+		return 0, err
+	}
+
+	input := &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []string{autoScalingGroupName},
+	}
+	output, err := client.AutoScaling().DescribeAutoScalingGroups(ctx, input)
+	if err != nil {
+		return 0, err
+	}
+	if len(output.AutoScalingGroups) != 1 {
+		return 0, fmt.Errorf("auto scaling group %s not found", autoScalingGroupName)
+	}
+
+	return int64(aws.ToInt32(output.AutoScalingGroups[0].DesiredCapacity)), nil
+}
+
+func (client *awsClient) GetScheduledActions(ctx context.Context, start, end time.Time) ([]*ScheduledAction, error) {
+	autoScalingGroupName, err := client.GetAutoScalingGroupName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &autoscaling.DescribeScheduledActionsInput{
+		AutoScalingGroupName: aws.String(autoScalingGroupName),
+		StartTime:            aws.Time(start),
+		EndTime:              aws.Time(end),
+	}
+
+	var actions []*ScheduledAction
+	paginator := autoscaling.NewDescribeScheduledActionsPaginator(client.AutoScaling(), input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, action := range output.ScheduledUpdateGroupActions {
+			startTime := aws.ToTime(action.StartTime)
+			if startTime.Before(start) || startTime.After(end) {
+				continue
+			}
+
+			actions = append(actions, &ScheduledAction{
+				Name:            aws.ToString(action.ScheduledActionName),
+				StartTime:       startTime,
+				DesiredCapacity: int64Pointer(action.DesiredCapacity),
+				MinSize:         int64Pointer(action.MinSize),
+				MaxSize:         int64Pointer(action.MaxSize),
+			})
+		}
+	}
+
+	return actions, nil
+}
+
+func int64Pointer(i *int32) *int64 {
+	if i == nil {
+		return nil
+	}
+	return aws.Int64(int64(*i))
+}
+
+func (client *awsClient) IsProtectedFromScaleIn(ctx context.Context) (bool, error) {
+	instanceID, err := client.GetInstanceID(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	input := &autoscaling.DescribeAutoScalingInstancesInput{
+		InstanceIds: []string{instanceID},
+	}
+	output, err := client.AutoScaling().DescribeAutoScalingInstances(ctx, input)
+	if err != nil {
+		return false, err
+	}
+	if len(output.AutoScalingInstances) != 1 {
+		return false, nil
+	}
+
+	return aws.ToBool(output.AutoScalingInstances[0].ProtectedFromScaleIn), nil
+}
+
+// GetInstanceLifeCycle returns "spot" or "on-demand".
+func (client *awsClient) GetInstanceLifeCycle(ctx context.Context) (string, error) {
+	return client.getMetadata(ctx, "instance-life-cycle")
+}
+
+func (client *awsClient) GetAvailabilityZone(ctx context.Context) (string, error) {
+	document, err := client.GetIdentityDocument(ctx)
+	if err != nil {
+		return "", err
+	}
+	return document.AvailabilityZone, nil
+}
+
+// GetRebalanceRecommendation returns the time EC2 signaled elevated
+// interruption risk for this instance, or nil if it has not.
+func (client *awsClient) GetRebalanceRecommendation(ctx context.Context) (*time.Time, error) {
+	output, err := client.getMetadata(ctx, "events/recommendations/rebalance")
+	if err != nil {
+		if isMetadataNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var recommendation struct {
+		NoticeTime time.Time `json:"noticeTime"`
+	}
+	if err := json.Unmarshal([]byte(output), &recommendation); err != nil {
+		return nil, err
+	}
+
+	return &recommendation.NoticeTime, nil
+}
+
+// scheduledEventTimeLayout is the time format used by the scheduled events
+// metadata endpoint, e.g. "21 Jan 2019 09:00:43 GMT".
+const scheduledEventTimeLayout = "2 Jan 2006 15:04:05 MST"
+
+// GetScheduledEvents returns the instance's scheduled events from instance
+// metadata, including completed and canceled ones.
+func (client *awsClient) GetScheduledEvents(ctx context.Context) ([]*ScheduledEvent, error) {
+	output, err := client.getMetadata(ctx, "events/maintenance/scheduled")
+	if err != nil {
+		if isMetadataNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var events []struct {
+		EventID     string `json:"EventId"`
+		Code        string `json:"Code"`
+		Description string `json:"Description"`
+		State       string `json:"State"`
+		NotBefore   string `json:"NotBefore"`
+		NotAfter    string `json:"NotAfter"`
+	}
+	if err := json.Unmarshal([]byte(output), &events); err != nil {
+		return nil, err
+	}
+
+	scheduled := make([]*ScheduledEvent, 0, len(events))
+	for _, event := range events {
+		notBefore, err := time.Parse(scheduledEventTimeLayout, event.NotBefore)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse start of scheduled event %s: %v", event.EventID, err)
+		}
+		var notAfter time.Time
+		if event.NotAfter != "" {
+			if notAfter, err = time.Parse(scheduledEventTimeLayout, event.NotAfter); err != nil {
+				return nil, fmt.Errorf("failed to parse end of scheduled event %s: %v", event.EventID, err)
+			}
+		}
+		scheduled = append(scheduled, &ScheduledEvent{
+			ID:          event.EventID,
+			Code:        event.Code,
+			Description: event.Description,
+			State:       event.State,
+			NotBefore:   notBefore,
+			NotAfter:    notAfter,
+		})
+	}
+	return scheduled, nil
+}
+
+// GetSpotNotice reads spot/instance-action, which says whether the instance
+// will be terminated, stopped, or hibernated, falling back to the older
+// spot/termination-time for instances that don't serve it.
+func (client *awsClient) GetSpotNotice(ctx context.Context) (Notice, error) {
+	output, err := client.getMetadata(ctx, "spot/instance-action")
+	if err == nil {
+		var action struct {
+			Action string    `json:"action"`
+			Time   time.Time `json:"time"`
+		}
+		if err := json.Unmarshal([]byte(output), &action); err != nil {
+			return nil, err
+		}
+		return NewSpotNotice(action.Action, action.Time), nil
+	}
+	if !isMetadataNotFound(err) {
+		return nil, err
+	}
+
+	output, err = client.getMetadata(ctx, "spot/termination-time")
+	if err != nil {
+		if isMetadataNotFound(err) {
 			return nil, nil
 		}
 		return nil, err
@@ -179,56 +756,93 @@ func (client *awsClient) GetSpotNotice() (Notice, error) {
 		return nil, err
 	}
 
-	return NewSpotNotice(terminationTime), nil
+	return NewSpotNotice(SpotTerminateAction, terminationTime), nil
 }
 
-func (client *awsClient) GetLifecycleNotice(ctx context.Context, queue *Queue) (Notice, error) {
-	instanceID, err := client.GetInstanceID()
+// GetLifecycleNotices receives a batch of messages from queue and returns a
+// notice for every lifecycle action, spot interruption and rebalance
+// recommendation on this instance in it. Messages for
+// other transitions, e.g. test notifications, are deleted together since
+// nothing will handle them, and messages for other instances are released.
+func (client *awsClient) GetLifecycleNotices(ctx context.Context, queue *Queue) ([]Notice, error) {
+	instanceID, err := client.GetInstanceID(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	input := &sqs.ReceiveMessageInput{
-		QueueUrl:            aws.String(queue.URL),
-		MaxNumberOfMessages: aws.Int64(10),
-		WaitTimeSeconds:     aws.Int64(20),
-		VisibilityTimeout:   aws.Int64(0),
+		QueueUrl:              aws.String(queue.URL),
+		MaxNumberOfMessages:   10,
+		WaitTimeSeconds:       20,
+		VisibilityTimeout:     receiveVisibilityTimeout,
+		MessageAttributeNames: []string{"All"},
+		MessageSystemAttributeNames: []sqstypes.MessageSystemAttributeName{
+			sqstypes.MessageSystemAttributeNameAWSTraceHeader,
+			sqstypes.MessageSystemAttributeNameSentTimestamp,
+		},
 	}
-	output, err := client.SQS.ReceiveMessageWithContext(ctx, input)
+	if queue.FIFO {
+		input.ReceiveRequestAttemptId = aws.String(receiveAttemptID())
+	}
+	output, err := client.SQS().ReceiveMessage(ctx, input, withSQSMaxAttempts(receiveMaxAttempts))
 	if err != nil {
 		return nil, err
 	}
 
+	received := client.Clock.Now()
+	var notices []Notice
+	var unhandled, others []sqstypes.Message
 	for _, message := range output.Messages {
-		var m Message
-
-		if err := json.Unmarshal([]byte(*message.Body), &m); err != nil {
+		if IsTestNotification(aws.ToString(message.Body)) {
+			log.Printf("deleting auto scaling test notification from queue %s", queue.Name)
+			testNotificationsCounter.Inc(queue.Name)
+			unhandled = append(unhandled, message)
 			continue
 		}
-		if m.EC2InstanceID != instanceID {
+		if !MessageMatchesInstance(message, instanceID) {
+			others = append(others, message)
 			continue
 		}
 
-		input := &sqs.DeleteMessageInput{
-			QueueUrl:      aws.String(queue.URL),
-			ReceiptHandle: message.ReceiptHandle,
+		m, ok := ParseMessage(aws.ToString(message.Body))
+		if !ok || m.EC2InstanceID != instanceID {
+			others = append(others, message)
+			continue
 		}
-		if _, err := client.SQS.DeleteMessageWithContext(ctx, input); err != nil {
-			return nil, err
+
+		if m.Notice != nil {
+			// EC2 events need no lifecycle action, so they're deleted as
+			// soon as they're received.
+			notices = append(notices, m.Notice)
+			unhandled = append(unhandled, message)
+			continue
 		}
 
-		var notice Notice
+		receipt := &Receipt{
+			QueueURL:      queue.URL,
+			ReceiptHandle: aws.ToString(message.ReceiptHandle),
+		}
+		var lifecycle *LifecycleNotice
 		switch m.LifecycleTransition {
 		case LaunchLifecycleAction:
-			notice = NewLaunchNotice(m.LifecycleHookName, m.LifecycleActionToken)
+			notice := NewLaunchNotice(m.LifecycleHookName, m.LifecycleActionToken)
+			notices, lifecycle = append(notices, notice), notice.LifecycleNotice
 		case TerminationLifecycleAction:
-			notice = NewTerminationNotice(m.LifecycleHookName, m.LifecycleActionToken)
+			notice := NewTerminationNotice(m.LifecycleHookName, m.LifecycleActionToken)
+			notices, lifecycle = append(notices, notice), notice.LifecycleNotice
+		default:
+			unhandled = append(unhandled, message)
+			continue
 		}
-
-		return notice, nil
+		lifecycle.Receipt = receipt
+		lifecycle.ReceivedAt = messageSentAt(message, received)
+		lifecycle.NotificationMetadata = m.NotificationMetadata
+		lifecycle.TraceHeader, lifecycle.Attributes = messageTrace(message)
 	}
+	client.releaseMessages(ctx, queue, others)
+	client.deleteMessages(ctx, queue, unhandled)
 
-	return nil, nil
+	return notices, nil
 }
 
 func (client *awsClient) SendHeartbeat(ctx context.Context, notice Notice) error {
@@ -242,7 +856,7 @@ func (client *awsClient) SendHeartbeat(ctx context.Context, notice Notice) error
 		return fmt.Errorf("cannot send heartbeat for %s notice", notice.Type())
 	}
 
-	instanceID, err := client.GetInstanceID()
+	instanceID, err := client.GetInstanceID(ctx)
 	if err != nil {
 		return err
 	}
@@ -258,13 +872,13 @@ func (client *awsClient) SendHeartbeat(ctx context.Context, notice Notice) error
 		LifecycleHookName:    aws.String(lifecycleNotice.LifecycleHookName),
 		LifecycleActionToken: aws.String(lifecycleNotice.LifecycleActionToken),
 	}
-	if _, err := client.AutoScaling.RecordLifecycleActionHeartbeat(input); err != nil {
+	if _, err := client.AutoScaling().RecordLifecycleActionHeartbeat(ctx, input, withAutoScalingMaxAttempts(heartbeatMaxAttempts)); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (client *awsClient) CompleteLifecycleAction(ctx context.Context, notice Notice) error {
+func (client *awsClient) CompleteLifecycleAction(ctx context.Context, notice Notice, result string) error {
 	var lifecycleNotice *LifecycleNotice
 	switch n := notice.(type) {
 	case *LaunchNotice:
@@ -275,7 +889,7 @@ func (client *awsClient) CompleteLifecycleAction(ctx context.Context, notice Not
 		return fmt.Errorf("cannot continue lifecycle action for %s notice", notice.Type())
 	}
 
-	instanceID, err := client.GetInstanceID()
+	instanceID, err := client.GetInstanceID(ctx)
 	if err != nil {
 		return err
 	}
@@ -290,10 +904,15 @@ func (client *awsClient) CompleteLifecycleAction(ctx context.Context, notice Not
 		AutoScalingGroupName:  aws.String(autoScalingGroupName),
 		LifecycleHookName:     aws.String(lifecycleNotice.LifecycleHookName),
 		LifecycleActionToken:  aws.String(lifecycleNotice.LifecycleActionToken),
-		LifecycleActionResult: aws.String("CONTINUE"),
+		LifecycleActionResult: aws.String(result),
 	}
-	if _, err := client.AutoScaling.CompleteLifecycleAction(input); err != nil {
+	if _, err := client.AutoScaling().CompleteLifecycleAction(ctx, input, withAutoScalingMaxAttempts(completeMaxAttempts)); err != nil {
 		return err
 	}
 	return nil
 }
+
+func isMetadataNotFound(err error) bool {
+	var responseErr *smithyhttp.ResponseError
+	return errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusNotFound
+}
@@ -0,0 +1,156 @@
+package lcmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	defaultBootstrapHeartbeatTimeout = 5 * time.Minute
+	// maxQueueNameLength is the longest name SQS accepts.
+	maxQueueNameLength = 80
+)
+
+var invalidQueueNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// BootstrapOptions describes the lifecycle hooks and queue Bootstrap
+// provisions for an auto scaling group. RoleARN is passed through to the
+// hooks as the role Auto Scaling assumes to publish to the queue, it isn't
+// created. QueueName defaults to lcmgr-<group>, HeartbeatTimeout to 5m, and
+// DefaultResult to CONTINUE.
+type BootstrapOptions struct {
+	AutoScalingGroupName string
+	QueueName            string
+	RoleARN              string
+	HeartbeatTimeout     time.Duration
+	DefaultResult        string
+	Launch               bool
+	Termination          bool
+}
+
+// Bootstrap creates the SQS queue and lifecycle hooks lcmgr needs, so a new
+// auto scaling group can adopt lcmgr without hand-built infrastructure. Both
+// steps are idempotent, an existing queue is reused and existing hooks with
+// the same names are updated.
+func Bootstrap(ctx context.Context, client AWSClient, options BootstrapOptions) ([]*LifecycleHook, error) {
+	if options.RoleARN == "" {
+		return nil, errors.New("a role for auto scaling to publish to the queue is required")
+	}
+	if !options.Launch && !options.Termination {
+		return nil, errors.New("at least one of the launch or termination hooks is required")
+	}
+
+	group := options.AutoScalingGroupName
+	if group == "" {
+		var err error
+		if group, err = client.GetAutoScalingGroupName(ctx); err != nil {
+			return nil, fmt.Errorf("failed to determine auto scaling group, pass it explicitly: %v", err)
+		}
+	}
+
+	queueName := options.QueueName
+	if queueName == "" {
+		queueName = defaultQueueName(group)
+	}
+	heartbeatTimeout := options.HeartbeatTimeout
+	if heartbeatTimeout <= 0 {
+		heartbeatTimeout = defaultBootstrapHeartbeatTimeout
+	}
+	defaultResult := options.DefaultResult
+	if defaultResult == "" {
+		defaultResult = ContinueResult
+	}
+
+	queueURL, queueARN, err := client.CreateQueue(ctx, queueName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create queue %s: %v", queueName, err)
+	}
+	log.Printf("using queue %s", queueURL)
+
+	var transitions []string
+	if options.Launch {
+		transitions = append(transitions, LaunchLifecycleAction)
+	}
+	if options.Termination {
+		transitions = append(transitions, TerminationLifecycleAction)
+	}
+
+	hooks := make([]*LifecycleHook, 0, len(transitions))
+	for _, transition := range transitions {
+		hook := &LifecycleHook{
+			Name:                  bootstrapHookName(transition),
+			Transition:            transition,
+			NotificationTargetARN: queueARN,
+			HeartbeatTimeout:      heartbeatTimeout,
+			DefaultResult:         defaultResult,
+		}
+		if err := client.PutLifecycleHook(ctx, group, hook, options.RoleARN); err != nil {
+			return nil, fmt.Errorf("failed to put lifecycle hook %s: %v", hook.Name, err)
+		}
+		log.Printf("put lifecycle hook %s on %s", hook.Name, group)
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+func defaultQueueName(group string) string {
+	name := "lcmgr-" + invalidQueueNameChars.ReplaceAllString(group, "-")
+	if len(name) > maxQueueNameLength {
+		name = name[:maxQueueNameLength]
+	}
+	return name
+}
+
+func bootstrapHookName(transition string) string {
+	if transition == LaunchLifecycleAction {
+		return "lcmgr-launch"
+	}
+	return "lcmgr-termination"
+}
+
+// CreateQueue creates an SQS queue, or returns the existing one with the
+// same name, and returns its URL and ARN. Names ending in .fifo create FIFO
+// queues.
+func (client *awsClient) CreateQueue(ctx context.Context, name string) (string, string, error) {
+	created, err := client.SQS().CreateQueue(ctx, &sqs.CreateQueueInput{
+		QueueName:  aws.String(name),
+		Attributes: fifoQueueAttributes(name),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	attributes, err := client.SQS().GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       created.QueueUrl,
+		AttributeNames: []sqstypes.QueueAttributeName{sqstypes.QueueAttributeNameQueueArn},
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return aws.ToString(created.QueueUrl), attributes.Attributes[string(sqstypes.QueueAttributeNameQueueArn)], nil
+}
+
+// PutLifecycleHook creates or updates a lifecycle hook that notifies its
+// target through roleARN.
+func (client *awsClient) PutLifecycleHook(ctx context.Context, autoScalingGroupName string, hook *LifecycleHook, roleARN string) error {
+	input := &autoscaling.PutLifecycleHookInput{
+		AutoScalingGroupName:  aws.String(autoScalingGroupName),
+		LifecycleHookName:     aws.String(hook.Name),
+		LifecycleTransition:   aws.String(hook.Transition),
+		NotificationTargetARN: aws.String(hook.NotificationTargetARN),
+		RoleARN:               aws.String(roleARN),
+		HeartbeatTimeout:      aws.Int32(int32(hook.HeartbeatTimeout / time.Second)),
+		DefaultResult:         aws.String(hook.DefaultResult),
+	}
+	_, err := client.AutoScaling().PutLifecycleHook(ctx, input)
+	return err
+}
@@ -0,0 +1,168 @@
+package lcmgr
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go/middleware"
+)
+
+const (
+	defaultBreakerFailures = 3
+	defaultBreakerCooldown = time.Minute
+)
+
+// ErrCircuitOpen is returned in place of calling a dependency whose circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+var breakerOpenGauge = DefaultRegistry.Gauge("lcmgr_circuit_breaker_open", "Whether a circuit breaker is open and failing calls fast", "breaker")
+
+var (
+	breakersMu sync.Mutex
+	breakers   = map[string]*CircuitBreaker{}
+)
+
+// Breakers around the AWS APIs drains depend on besides Auto Scaling, shared
+// by every handler calling them.
+var (
+	loadBalancerBreaker      = SharedCircuitBreaker("load-balancer", 0, 0)
+	globalAcceleratorBreaker = SharedCircuitBreaker("global-accelerator", 0, 0)
+)
+
+// CircuitBreaker fails calls to a flaky dependency fast once it has failed
+// Failures times in a row, so a drain doesn't spend its deadline retrying
+// something that's down. After Cooldown one trial call is let through while
+// the others keep failing fast, and the breaker closes again if it succeeds
+// or stays open for another Cooldown if it fails. A trial whose outcome is
+// never recorded is given up on after Cooldown.
+type CircuitBreaker struct {
+	Name     string
+	Failures int
+	Cooldown time.Duration
+	Clock    Clock
+
+	mu       sync.Mutex
+	failed   int
+	openedAt time.Time
+	trialAt  time.Time
+}
+
+func NewCircuitBreaker(name string, failures int, cooldown time.Duration) *CircuitBreaker {
+	if failures <= 0 {
+		failures = defaultBreakerFailures
+	}
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return &CircuitBreaker{
+		Name:     name,
+		Failures: failures,
+		Cooldown: cooldown,
+		Clock:    NewClock(),
+	}
+}
+
+// SharedCircuitBreaker returns the breaker registered under name, creating
+// it if needed, so steps that call the same dependency trip together. The
+// first registration's failures and cooldown win, and later ones that differ
+// are logged.
+func SharedCircuitBreaker(name string, failures int, cooldown time.Duration) *CircuitBreaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	wanted := NewCircuitBreaker(name, failures, cooldown)
+	breaker, ok := breakers[name]
+	if !ok {
+		breakers[name] = wanted
+		return wanted
+	}
+	if breaker.Failures != wanted.Failures || breaker.Cooldown != wanted.Cooldown {
+		log.Printf("circuit breaker %s is already configured with %d failures and a %s cooldown, ignoring %d failures and a %s cooldown",
+			name, breaker.Failures, breaker.Cooldown, wanted.Failures, wanted.Cooldown)
+	}
+	return breaker
+}
+
+// Allow returns ErrCircuitOpen while the breaker is open, unless the call is
+// the trial let through after Cooldown.
+func (breaker *CircuitBreaker) Allow() error {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	if breaker.openedAt.IsZero() {
+		return nil
+	}
+	now := breaker.Clock.Now()
+	if now.Before(breaker.openedAt.Add(breaker.Cooldown)) {
+		return ErrCircuitOpen
+	}
+	if !breaker.trialAt.IsZero() && now.Before(breaker.trialAt.Add(breaker.Cooldown)) {
+		return ErrCircuitOpen
+	}
+	breaker.trialAt = now
+	return nil
+}
+
+// Call runs f unless the breaker is open and records its outcome. Failures
+// caused by ctx ending aren't the dependency's fault and aren't recorded. A
+// nil breaker just runs f.
+func (breaker *CircuitBreaker) Call(ctx context.Context, f func() error) error {
+	if breaker == nil {
+		return f()
+	}
+	if err := breaker.Allow(); err != nil {
+		return err
+	}
+	err := f()
+	if ctx.Err() == nil {
+		breaker.Record(err)
+	}
+	return err
+}
+
+// protect adds the breaker around every call made by an AWS service client,
+// including its retries. Only errors worth retrying, e.g. throttling, server
+// errors and timeouts, count as failures, so a bad ARN doesn't trip the
+// breaker for every other call.
+func (breaker *CircuitBreaker) protect(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("lcmgrCircuitBreaker", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (out middleware.InitializeOutput, metadata middleware.Metadata, err error) {
+		open := breaker.Call(ctx, func() error {
+			out, metadata, err = next.HandleInitialize(ctx, in)
+			if retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary {
+				return err
+			}
+			return nil
+		})
+		if errors.Is(open, ErrCircuitOpen) {
+			return out, metadata, open
+		}
+		return out, metadata, err
+	}), middleware.Before)
+}
+
+// Record counts the outcome of a call, opening the breaker after too many
+// consecutive failures and closing it on success.
+func (breaker *CircuitBreaker) Record(err error) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	breaker.trialAt = time.Time{}
+	if err == nil {
+		breaker.failed = 0
+		breaker.openedAt = time.Time{}
+		breakerOpenGauge.Set(0, breaker.Name)
+		return
+	}
+
+	breaker.failed++
+	if breaker.failed >= breaker.Failures {
+		breaker.openedAt = breaker.Clock.Now()
+		breakerOpenGauge.Set(1, breaker.Name)
+	}
+}
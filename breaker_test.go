@@ -0,0 +1,45 @@
+package lcmgr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookSinkBreaker(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL).(*WebhookSink)
+	notice := NewTerminationNotice("hook", "token")
+	for i := 0; i < sink.Breaker.Failures; i++ {
+		if err := sink.Send(context.Background(), notice); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("send %d returned %v, want the receiver's failure", i, err)
+		}
+	}
+	if err := sink.Send(context.Background(), notice); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("send after %d failures returned %v, want %v", sink.Breaker.Failures, err, ErrCircuitOpen)
+	}
+	if got := atomic.LoadInt32(&requests); got != int32(sink.Breaker.Failures) {
+		t.Errorf("receiver got %d requests, want %d", got, sink.Breaker.Failures)
+	}
+}
+
+func TestSharedCircuitBreakerKeepsFirstConfig(t *testing.T) {
+	first := SharedCircuitBreaker("test-shared", 5, time.Minute)
+	second := SharedCircuitBreaker("test-shared", 2, time.Hour)
+	if first != second {
+		t.Fatal("registrations under the same name returned different breakers")
+	}
+	if second.Failures != 5 || second.Cooldown != time.Minute {
+		t.Errorf("breaker has %d failures and a %s cooldown, want the first registration's 5 and 1m0s", second.Failures, second.Cooldown)
+	}
+}
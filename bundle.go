@@ -0,0 +1,259 @@
+package lcmgr
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const (
+	// DebugBundleInterval is how often a debug bundle can be collected, so a
+	// script looping on it during an incident doesn't hammer the admin API
+	// and AWS or fill the disk.
+	DebugBundleInterval = time.Minute
+	// debugBundleKey is the Store key of when the last bundle was collected.
+	debugBundleKey = "debug_bundle"
+	// debugLogLines is how many recent log lines are collected.
+	debugLogLines = 2000
+	// debugRequestTimeout bounds each request made while collecting, so an
+	// unresponsive daemon doesn't hang the bundle.
+	debugRequestTimeout = 10 * time.Second
+)
+
+// DebugBundle collects what's needed to diagnose a misbehaving drain into a
+// gzipped tarball for a support ticket: the version, the config with secrets
+// removed, the daemon's status and goroutines from the admin API, the AWS
+// and instance identity, and recent logs from LogCommand. Whatever can't be
+// collected is listed in errors.txt instead of failing the bundle. Logs and
+// config are passed through the log redactor, and the webhook secret is left
+// out entirely.
+type DebugBundle struct {
+	Config     *Config
+	Client     AWSClient
+	Store      Store
+	LogCommand []string
+	Clock      Clock
+}
+
+func NewDebugBundle(config *Config, client AWSClient, logCommand []string) *DebugBundle {
+	return &DebugBundle{
+		Config:     config,
+		Client:     client,
+		Store:      NewFileStore(config.StateDir),
+		LogCommand: logCommand,
+		Clock:      NewClock(),
+	}
+}
+
+// Throttle returns an error if a bundle was collected less than
+// DebugBundleInterval ago, and otherwise records that one is being collected
+// now.
+func (bundle *DebugBundle) Throttle() error {
+	now := bundle.Clock.Now()
+	var last time.Time
+	if _, err := bundle.Store.Get(debugBundleKey, &last); err != nil {
+		return err
+	}
+	if wait := last.Add(DebugBundleInterval).Sub(now); wait > 0 {
+		return fmt.Errorf("a debug bundle was collected at %s, try again in %s", last.Format(time.RFC3339), wait.Round(time.Second))
+	}
+	return bundle.Store.Put(debugBundleKey, now)
+}
+
+// Write collects the bundle and writes it to w as a gzipped tarball.
+func (bundle *DebugBundle) Write(ctx context.Context, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	now := bundle.Clock.Now()
+
+	var failures []string
+	add := func(name string, data []byte, err error) error {
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+			return nil
+		}
+		header := &tar.Header{
+			Name:    name,
+			Mode:    0600,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err = archive.Write(data)
+		return err
+	}
+
+	if err := add("version.txt", []byte(VersionString()+"\n"), nil); err != nil {
+		return err
+	}
+	config, err := bundle.config()
+	if err := add("config.json", config, err); err != nil {
+		return err
+	}
+	status, err := bundle.admin(ctx, "/v1/status")
+	if err := add("status.json", status, err); err != nil {
+		return err
+	}
+	goroutines, err := bundle.admin(ctx, "/debug/pprof/goroutine?debug=2")
+	if err := add("goroutines.txt", goroutines, err); err != nil {
+		return err
+	}
+	identity, err := bundle.identity(ctx)
+	if err := add("identity.json", identity, err); err != nil {
+		return err
+	}
+	logs, err := bundle.logs(ctx)
+	if err := add("logs.txt", logs, err); err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		if err := add("errors.txt", []byte(strings.Join(failures, "\n")+"\n"), nil); err != nil {
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// config renders the config with the webhook secret removed and webhook URLs
+// redacted.
+func (bundle *DebugBundle) config() ([]byte, error) {
+	config := *bundle.Config
+	config.WebhookSecret = ""
+
+	data, err := json.MarshalIndent(&config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return bundle.redact(data)
+}
+
+func (bundle *DebugBundle) redact(data []byte) ([]byte, error) {
+	var redacted bytes.Buffer
+	redactor, err := NewRedactor(&redacted, bundle.Config.RedactPatterns)
+	if err != nil {
+		return nil, err
+	}
+	for _, webhook := range bundle.Config.Webhooks {
+		redactor.AddSecret(webhook)
+	}
+	if _, err := redactor.Write(data); err != nil {
+		return nil, err
+	}
+	return redacted.Bytes(), nil
+}
+
+// admin fetches path from the running daemon's admin API.
+func (bundle *DebugBundle) admin(ctx context.Context, path string) ([]byte, error) {
+	if bundle.Config.AdminAddress == "" {
+		return nil, fmt.Errorf("admin api is disabled")
+	}
+	client, base := adminClient(bundle.Config.AdminAddress)
+
+	ctx, cancel := context.WithTimeout(ctx, debugRequestTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("admin api returned %s", response.Status)
+	}
+	return bundle.redact(body)
+}
+
+// adminClient returns a client for the admin API listening on address, as
+// given to ListenAPI, and the base URL to request.
+func adminClient(address string) (*http.Client, string) {
+	if !strings.HasPrefix(address, "unix:") {
+		return http.DefaultClient, "http://" + address
+	}
+	path := strings.TrimPrefix(address, "unix:")
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		},
+	}, "http://lcmgr"
+}
+
+// identity describes who lcmgr calls AWS as and the instance it runs on. The
+// identity document's signature is left out.
+func (bundle *DebugBundle) identity(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, debugRequestTimeout)
+	defer cancel()
+
+	callerARN, err := bundle.Client.GetCallerIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	identity := struct {
+		CallerARN string          `json:"caller_arn"`
+		Document  json.RawMessage `json:"instance_identity_document,omitempty"`
+		Error     string          `json:"instance_identity_error,omitempty"`
+	}{CallerARN: callerARN}
+	if document, err := bundle.Client.GetInstanceIdentity(ctx); err != nil {
+		identity.Error = err.Error()
+	} else {
+		identity.Document = json.RawMessage(document.Document)
+	}
+	return json.MarshalIndent(identity, "", "  ")
+}
+
+func (bundle *DebugBundle) logs(ctx context.Context) ([]byte, error) {
+	if len(bundle.LogCommand) == 0 {
+		return nil, fmt.Errorf("no log command on this platform")
+	}
+	ctx, cancel := context.WithTimeout(ctx, debugRequestTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, bundle.LogCommand[0], bundle.LogCommand[1:]...).Output()
+	if err != nil {
+		return nil, err
+	}
+	return bundle.redact(output)
+}
+
+// JournalLogCommand reads the recent logs of a systemd unit from the journal.
+func JournalLogCommand(unit string) []string {
+	return []string{"journalctl", "--unit", unit, "--lines", fmt.Sprint(debugLogLines), "--no-pager", "--output", "short-iso"}
+}
+
+// GetCallerIdentity returns the ARN of the identity AWS calls are made as.
+func (client *awsClient) GetCallerIdentity(ctx context.Context) (string, error) {
+	output, err := client.STS().GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(output.Arn), nil
+}
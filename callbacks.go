@@ -0,0 +1,104 @@
+package lcmgr
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Drain phases reported to OnDrainPhase callbacks outside of chains, whose
+// phases are their step names.
+const (
+	DeregisterPhase   = "deregister"
+	StopServicesPhase = "stop services"
+)
+
+// callbacks are registered by code embedding lcmgr to observe notices
+// without implementing a Handler or Listener.
+var callbacks struct {
+	mu             sync.RWMutex
+	noticeReceived []func(context.Context, Notice)
+	drainPhase     []func(context.Context, Notice, string)
+	heartbeat      []func(context.Context, Notice, error)
+	completed      []func(context.Context, Notice, string, error)
+}
+
+// OnNoticeReceived registers f to be called when a handler starts on a
+// notice. Callbacks run on the goroutine handling the notice, so they should
+// return quickly. A callback that panics is logged and otherwise ignored.
+func OnNoticeReceived(f func(ctx context.Context, notice Notice)) {
+	callbacks.mu.Lock()
+	defer callbacks.mu.Unlock()
+	callbacks.noticeReceived = append(callbacks.noticeReceived, f)
+}
+
+// OnDrainPhase registers f to be called as each phase of handling a notice
+// starts: each chain step, named after the step, or deregistering and
+// stopping services without a chain.
+func OnDrainPhase(f func(ctx context.Context, notice Notice, phase string)) {
+	callbacks.mu.Lock()
+	defer callbacks.mu.Unlock()
+	callbacks.drainPhase = append(callbacks.drainPhase, f)
+}
+
+// OnHeartbeat registers f to be called after each lifecycle action heartbeat
+// with the error sending it, if any.
+func OnHeartbeat(f func(ctx context.Context, notice Notice, err error)) {
+	callbacks.mu.Lock()
+	defer callbacks.mu.Unlock()
+	callbacks.heartbeat = append(callbacks.heartbeat, f)
+}
+
+// OnCompleted registers f to be called after a lifecycle action is completed
+// with result, or failed to be completed with err.
+func OnCompleted(f func(ctx context.Context, notice Notice, result string, err error)) {
+	callbacks.mu.Lock()
+	defer callbacks.mu.Unlock()
+	callbacks.completed = append(callbacks.completed, f)
+}
+
+func noticeReceived(ctx context.Context, notice Notice) {
+	callbacks.mu.RLock()
+	registered := callbacks.noticeReceived
+	callbacks.mu.RUnlock()
+	for _, f := range registered {
+		safeCallback(func() { f(ctx, notice) })
+	}
+}
+
+func drainPhase(ctx context.Context, notice Notice, phase string) {
+	callbacks.mu.RLock()
+	registered := callbacks.drainPhase
+	callbacks.mu.RUnlock()
+	for _, f := range registered {
+		safeCallback(func() { f(ctx, notice, phase) })
+	}
+}
+
+func heartbeatSent(ctx context.Context, notice Notice, err error) {
+	callbacks.mu.RLock()
+	registered := callbacks.heartbeat
+	callbacks.mu.RUnlock()
+	for _, f := range registered {
+		safeCallback(func() { f(ctx, notice, err) })
+	}
+}
+
+func lifecycleActionCompleted(ctx context.Context, notice Notice, result string, err error) {
+	callbacks.mu.RLock()
+	registered := callbacks.completed
+	callbacks.mu.RUnlock()
+	for _, f := range registered {
+		safeCallback(func() { f(ctx, notice, result, err) })
+	}
+}
+
+// safeCallback keeps a panicking callback from taking a drain down with it.
+func safeCallback(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("callback panicked: %v", r)
+		}
+	}()
+	f()
+}
@@ -0,0 +1,396 @@
+package lcmgr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	StartServicesAction = "start"
+	StopServicesAction  = "stop"
+)
+
+// stepActions lists the step config fields that choose what a step does.
+const stepActions = "exec, service, shed, load_balancer, global_accelerator, signal, download, prefetch, register, health, approval, quorum, snooze, or group"
+
+// defaultStepRetryDelay is the pause between attempts of a failing step.
+const defaultStepRetryDelay = 5 * time.Second
+
+// optionalStepMargin is the least time that must be left before the notice's
+// deadline for optional steps to run.
+const optionalStepMargin = 30 * time.Second
+
+// Chain runs a sequence of steps for a notice, giving lcmgr a small drain and
+// bootstrap workflow engine. Steps whose condition doesn't match the notice
+// are skipped, as are Optional steps when less than optionalStepMargin is left
+// before the notice's deadline, and a failing step stops the chain unless it
+// is marked ContinueOnError. A step whose Breaker is open is skipped without
+// being run or retried. Observe, if set, is called after each step that runs.
+type Chain struct {
+	Steps   []*Step
+	Observe func(step string, duration time.Duration, err error)
+	Clock   Clock
+}
+
+type Step struct {
+	Name            string
+	Handler         Handler
+	Retries         int
+	RetryDelay      time.Duration
+	Timeout         time.Duration
+	ContinueOnError bool
+	Optional        bool
+	When            Condition
+	Breaker         *CircuitBreaker
+}
+
+var (
+	stepDurationGauge   = DefaultRegistry.Gauge("lcmgr_step_duration_seconds", "Time taken by the most recent run of a chain step, including retries", "step")
+	stepFailuresCounter = DefaultRegistry.Counter("lcmgr_step_failures_total", "Number of times a chain step failed after exhausting its retries", "step")
+)
+
+// Condition decides whether a step runs for a notice.
+type Condition func(Notice) bool
+
+// ExecHandler runs a command for a notice, passing the notice's metadata in
+// LCMGR_ prefixed environment variables, the notice's deadline, if any, in
+// LCMGR_DEADLINE and the instance's identity in LCMGR_INSTANCE_ prefixed
+// ones.
+type ExecHandler struct {
+	Command  []string
+	Identity *Identity
+}
+
+func (f HandlerFunc) Handle(ctx context.Context, notice Notice) error {
+	return f(ctx, notice)
+}
+
+// NewChain builds a chain from step configs. Service steps start or stop the
+// handler's services, and identity is made available to step conditions.
+func NewChain(configs []StepConfig, identity *Identity, handler *ServiceHandler) (*Chain, error) {
+	chain := &Chain{Clock: NewClock()}
+	for i, config := range configs {
+		name := config.Name
+		if name == "" {
+			name = fmt.Sprintf("step %d", i+1)
+		}
+
+		stepHandler, err := newStepHandler(config, identity, handler)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+
+		when, err := config.When.Condition(identity)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+
+		step := &Step{
+			Name:            name,
+			Handler:         stepHandler,
+			Retries:         config.Retries,
+			RetryDelay:      defaultStepRetryDelay,
+			Timeout:         time.Duration(config.Timeout),
+			ContinueOnError: config.ContinueOnError,
+			Optional:        config.Optional,
+			When:            when,
+		}
+		if breaker := config.Breaker; breaker != nil {
+			breakerName := breaker.Name
+			if breakerName == "" {
+				breakerName = name
+			}
+			step.Breaker = SharedCircuitBreaker(breakerName, breaker.Failures, time.Duration(breaker.Cooldown))
+		}
+		chain.Steps = append(chain.Steps, step)
+	}
+	return chain, nil
+}
+
+// newStepHandler returns the handler for the single action a step config
+// describes.
+func newStepHandler(config StepConfig, identity *Identity, handler *ServiceHandler) (Handler, error) {
+	var handlers []Handler
+	if len(config.Exec) > 0 {
+		handlers = append(handlers, &ExecHandler{Command: config.Exec, Identity: identity})
+	}
+	switch config.Service {
+	case "":
+	case StartServicesAction:
+		handlers = append(handlers, HandlerFunc(handler.WaitForServiceStart))
+	case StopServicesAction:
+		handlers = append(handlers, HandlerFunc(handler.WaitForServiceStop))
+	default:
+		return nil, fmt.Errorf("unknown service action %q, must be start or stop", config.Service)
+	}
+	if config.Shed != nil {
+		handlers = append(handlers, NewShedHandler(config.Shed.URL, config.Shed.Steps, time.Duration(config.Shed.Duration)))
+	}
+	if lb := config.LoadBalancer; lb != nil {
+		lbHandler := NewLoadBalancerDrainHandler(lb.TargetGroups, lb.ConnectionsURL, lb.Threshold, time.Duration(lb.Interval), lb.CapToDeadline, handler.Client)
+		lbHandler.ClassicNames = lb.Classic
+		lbHandler.Discover = lb.Discover
+		handlers = append(handlers, lbHandler)
+	}
+	if ga := config.GlobalAccelerator; ga != nil {
+		gaHandler := NewGlobalAcceleratorDrainHandler(ga.EndpointGroup, ga.EndpointID, handler.Client)
+		gaHandler.ConnectionsURL = ga.ConnectionsURL
+		gaHandler.Threshold = ga.Threshold
+		if ga.Wait > 0 {
+			gaHandler.Wait = time.Duration(ga.Wait)
+		}
+		handlers = append(handlers, gaHandler)
+	}
+	if signal := config.Signal; signal != nil {
+		handlers = append(handlers, NewSignalHandler(signal.Service, signal.Signal, handler.Manager))
+	}
+	if download := config.Download; download != nil {
+		handlers = append(handlers, NewDownloadHandler(download.URL, download.Path, download.SHA256))
+	}
+	if prefetch := config.Prefetch; prefetch != nil {
+		handlers = append(handlers, NewPrefetchHandler(prefetch.Objects, prefetch.Parallelism, handler.Client))
+	}
+	if register := config.Register; register != nil {
+		handlers = append(handlers, NewRegisterHandler(register.TargetGroups, register.Port, handler.Client))
+	}
+	if health := config.Health; health != nil {
+		handlers = append(handlers, NewHealthHandler(health.URL, time.Duration(health.Interval)))
+	}
+	if approval := config.Approval; approval != nil {
+		handlers = append(handlers, NewApprovalHandler(approval.SSMParameter, approval.Webhook, time.Duration(approval.Interval), handler))
+	}
+	if quorum := config.Quorum; quorum != nil {
+		handlers = append(handlers, NewQuorumHandler(quorum.URL, time.Duration(quorum.Interval), handler.Client))
+	}
+	if config.Snooze > 0 {
+		handlers = append(handlers, NewSnoozeHandler(time.Duration(config.Snooze), handler))
+	}
+	if len(config.Group) > 0 {
+		drainables := make([]Drainable, 0, len(config.Group))
+		for i, member := range config.Group {
+			memberHandler, err := newStepHandler(member, identity, handler)
+			if err != nil {
+				return nil, fmt.Errorf("group member %d: %v", i+1, err)
+			}
+			drainables = append(drainables, AsDrainable(memberHandler))
+		}
+		handlers = append(handlers, NewDrainGroup(drainables))
+	}
+
+	switch len(handlers) {
+	case 0:
+		return nil, errors.New("one of " + stepActions + " is required")
+	case 1:
+		return handlers[0], nil
+	default:
+		return nil, errors.New("only one of " + stepActions + " may be set")
+	}
+}
+
+func (chain *Chain) Handle(ctx context.Context, notice Notice) error {
+	var steps []*Step
+	for _, step := range chain.Steps {
+		if step.When == nil || step.When(notice) {
+			steps = append(steps, step)
+		}
+	}
+
+	var failed error
+	for i, step := range steps {
+		if step.Optional && chain.shortOnTime(ctx, notice) {
+			log.Printf("skipping optional step %s, less than %s left before the deadline", step.Name, optionalStepMargin)
+			ReportProgress(ctx, i+1, len(steps))
+			continue
+		}
+		drainPhase(ctx, notice, step.Name)
+		start := chain.Clock.Now()
+		err := chain.run(ctx, step, notice)
+		if errors.Is(err, ErrCircuitOpen) {
+			log.Printf("skipping step %s: %v", step.Name, err)
+			ReportProgress(ctx, i+1, len(steps))
+			continue
+		}
+		duration := chain.Clock.Now().Sub(start)
+		stepDurationGauge.Set(duration.Seconds(), step.Name)
+		if chain.Observe != nil {
+			chain.Observe(step.Name, duration, err)
+		}
+		ReportProgress(ctx, i+1, len(steps))
+		if err == nil {
+			continue
+		}
+		stepFailuresCounter.Inc(step.Name)
+		if !step.ContinueOnError {
+			return fmt.Errorf("step %s failed: %v", step.Name, err)
+		}
+
+		log.Printf("step %s failed, continuing: %v", step.Name, err)
+		if failed == nil {
+			failed = fmt.Errorf("step %s failed: %v", step.Name, err)
+		}
+	}
+	return failed
+}
+
+// shortOnTime returns true if less than optionalStepMargin is left before the
+// deadline the notice is being handled by, or the notice's own deadline.
+func (chain *Chain) shortOnTime(ctx context.Context, notice Notice) bool {
+	deadline, ok := NoticeDeadline(ctx)
+	if !ok {
+		deadline, ok = noticeDeadlineOf(notice)
+	}
+	return ok && deadline.Sub(chain.Clock.Now()) < optionalStepMargin
+}
+
+func (chain *Chain) run(ctx context.Context, step *Step, notice Notice) error {
+	var err error
+	for attempt := 0; attempt <= step.Retries; attempt++ {
+		if attempt > 0 {
+			log.Printf("retrying step %s (attempt %d of %d): %v", step.Name, attempt+1, step.Retries+1, err)
+			select {
+			case <-chain.Clock.After(step.RetryDelay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if step.Breaker != nil {
+			if open := step.Breaker.Allow(); open != nil {
+				// A breaker opened by this step's own failures leaves
+				// the last one standing.
+				if attempt > 0 {
+					return err
+				}
+				return open
+			}
+		}
+
+		stepCtx, cancel := ctx, context.CancelFunc(func() {})
+		if step.Timeout > 0 {
+			stepCtx, cancel = context.WithTimeout(ctx, step.Timeout)
+		}
+		err = step.Handler.Handle(stepCtx, notice)
+		cancel()
+
+		if ctx.Err() != nil {
+			return err
+		}
+		if step.Breaker != nil {
+			step.Breaker.Record(err)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (handler *ExecHandler) Handle(ctx context.Context, notice Notice) error {
+	cmd := exec.CommandContext(ctx, handler.Command[0], handler.Command[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), NoticeEnv(notice)...)
+	cmd.Env = append(cmd.Env, handler.Identity.env()...)
+	if deadline, ok := NoticeDeadline(ctx); ok {
+		cmd.Env = append(cmd.Env, "LCMGR_DEADLINE="+deadline.Format(time.RFC3339))
+	}
+	return cmd.Run()
+}
+
+// NoticeMetadata flattens the fields of a notice that conditions and exec
+// handlers can use into a map.
+func NoticeMetadata(notice Notice) map[string]string {
+	metadata := map[string]string{
+		"type": notice.Type(),
+	}
+
+	switch n := notice.(type) {
+	case *SpotNotice:
+		metadata["action"] = n.Action
+		metadata["termination_time"] = n.TerminationTime.Format(time.RFC3339)
+	case *RebalanceNotice:
+		metadata["notice_time"] = n.NoticeTime.Format(time.RFC3339)
+	case *ScheduledEventNotice:
+		metadata["event_id"] = n.ID
+		metadata["event_code"] = n.Code
+		metadata["not_before"] = n.NotBefore.Format(time.RFC3339)
+	case *DegradedNotice:
+		metadata["system_status"] = n.System
+		metadata["instance_status"] = n.Instance
+		metadata["failing_checks"] = strings.Join(n.Failing, ",")
+	case *LaunchNotice:
+		lifecycleMetadata(metadata, n.LifecycleNotice)
+	case *TerminationNotice:
+		lifecycleMetadata(metadata, n.LifecycleNotice)
+	case *ScheduledActionNotice:
+		metadata["scheduled_action_name"] = n.Name
+		metadata["start_time"] = n.StartTime.Format(time.RFC3339)
+	}
+
+	return metadata
+}
+
+func lifecycleMetadata(metadata map[string]string, notice *LifecycleNotice) {
+	metadata["lifecycle_hook_name"] = notice.LifecycleHookName
+	if notice.HeartbeatTimeout > 0 {
+		metadata["heartbeat_timeout"] = notice.HeartbeatTimeout.String()
+	}
+	if notice.GlobalTimeout > 0 {
+		metadata["global_timeout"] = notice.GlobalTimeout.String()
+	}
+	if notice.DefaultResult != "" {
+		metadata["default_result"] = notice.DefaultResult
+	}
+	notificationMetadata(metadata, notice.NotificationMetadata)
+	traceMetadata(metadata, notice)
+}
+
+// notificationMetadata adds the NotificationMetadata set on a lifecycle hook
+// to a notice's metadata as notification_metadata. When it's a JSON object
+// its top-level values are also added as notification_<key>, so steps can
+// match on them, e.g. {"team": "search"} as notification_team.
+func notificationMetadata(metadata map[string]string, raw string) {
+	if raw == "" {
+		return
+	}
+	metadata["notification_metadata"] = raw
+
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &values); err != nil {
+		return
+	}
+	for key, value := range values {
+		key = "notification_" + invalidMetadataKeyChars.ReplaceAllString(strings.ToLower(key), "_")
+		switch value := value.(type) {
+		case string:
+			metadata[key] = value
+		case nil:
+		default:
+			encoded, err := json.Marshal(value)
+			if err == nil {
+				metadata[key] = string(encoded)
+			}
+		}
+	}
+}
+
+// NoticeEnv renders notice metadata as LCMGR_ prefixed environment variables,
+// e.g. LCMGR_TYPE=termination.
+func NoticeEnv(notice Notice) []string {
+	metadata := NoticeMetadata(notice)
+
+	env := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		env = append(env, "LCMGR_"+strings.ToUpper(key)+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
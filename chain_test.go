@@ -0,0 +1,78 @@
+package lcmgr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// countingStep counts its runs and fails with err.
+type countingStep struct {
+	runs int
+	err  error
+}
+
+func (step *countingStep) Handle(ctx context.Context, notice Notice) error {
+	step.runs++
+	return step.err
+}
+
+func TestChainSkipsStepsWithOpenBreakers(t *testing.T) {
+	failure := errors.New("dependency down")
+	for _, test := range []struct {
+		name    string
+		open    bool
+		err     error
+		retries int
+		runs    int
+		failed  bool
+	}{
+		{
+			name: "closed breaker runs the step",
+			runs: 1,
+		},
+		{
+			name: "open breaker skips the step",
+			open: true,
+		},
+		{
+			name:    "breaker opened by the step's retries fails the chain",
+			err:     failure,
+			retries: 5,
+			runs:    defaultBreakerFailures,
+			failed:  true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			clock := NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+			breaker := NewCircuitBreaker("dependency", 0, 0)
+			breaker.Clock = clock
+			if test.open {
+				for i := 0; i < breaker.Failures; i++ {
+					breaker.Record(failure)
+				}
+			}
+
+			step, next := &countingStep{err: test.err}, &countingStep{}
+			chain := &Chain{
+				Steps: []*Step{
+					{Name: "guarded", Handler: step, Retries: test.retries, Breaker: breaker},
+					{Name: "next", Handler: next},
+				},
+				Clock: NewClock(),
+			}
+
+			err := chain.Handle(context.Background(), NewTerminationNotice("hook", "token"))
+			if (err != nil) != test.failed || (err != nil && !errors.Is(err, failure) && err.Error() != "step guarded failed: "+failure.Error()) {
+				t.Errorf("Handle returned %v, want failure %v", err, test.failed)
+			}
+			if step.runs != test.runs {
+				t.Errorf("guarded step ran %d times, want %d", step.runs, test.runs)
+			}
+			if wantNext := !test.failed; (next.runs == 1) != wantNext {
+				t.Errorf("next step ran %d times, want it run %v", next.runs, wantNext)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package lcmgr
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock abstracts the time functions used by listeners and handlers so that
+// polling, heartbeat, and deadline logic can be driven by a FakeClock in
+// tests instead of real sleeps.
+type Clock interface {
+	Now() time.Time
+	After(time.Duration) <-chan time.Time
+	NewTicker(time.Duration) Ticker
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type realClock struct{}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func NewClock() Clock {
+	return realClock{}
+}
+
+func (clock realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (clock realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (clock realClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{time.NewTicker(d)}
+}
+
+func (ticker *realTicker) C() <-chan time.Time {
+	return ticker.Ticker.C
+}
+
+// FakeClock is a Clock that only moves when Advance is called. Tickers and
+// timers created from it fire synchronously during Advance.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+	clock    *FakeClock
+	c        chan time.Time
+	next     time.Time
+	interval time.Duration
+	stopped  bool
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (clock *FakeClock) Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.now
+}
+
+func (clock *FakeClock) After(d time.Duration) <-chan time.Time {
+	return clock.add(d, 0).c
+}
+
+func (clock *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock.NewTicker")
+	}
+	return clock.add(d, d)
+}
+
+// Advance moves the clock forward by d, firing every timer and ticker whose
+// deadline falls within the window. Like time.Ticker, a ticker whose channel
+// is still full drops the tick.
+func (clock *FakeClock) Advance(d time.Duration) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	end := clock.now.Add(d)
+	for {
+		var next *fakeWaiter
+		for _, waiter := range clock.waiters {
+			if waiter.stopped || waiter.next.After(end) {
+				continue
+			}
+			if next == nil || waiter.next.Before(next.next) {
+				next = waiter
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		clock.now = next.next
+		select {
+		case next.c <- clock.now:
+		default:
+		}
+
+		if next.interval > 0 {
+			next.next = next.next.Add(next.interval)
+		} else {
+			next.stopped = true
+		}
+	}
+	clock.now = end
+
+	waiters := clock.waiters[:0]
+	for _, waiter := range clock.waiters {
+		if !waiter.stopped {
+			waiters = append(waiters, waiter)
+		}
+	}
+	clock.waiters = waiters
+}
+
+// Waiters returns the number of pending timers and tickers, which lets tests
+// wait until the code under test has blocked on the clock before advancing it.
+func (clock *FakeClock) Waiters() int {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	n := 0
+	for _, waiter := range clock.waiters {
+		if !waiter.stopped {
+			n++
+		}
+	}
+	return n
+}
+
+func (clock *FakeClock) add(d, interval time.Duration) *fakeWaiter {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	waiter := &fakeWaiter{
+		clock:    clock,
+		c:        make(chan time.Time, 1),
+		next:     clock.now.Add(d),
+		interval: interval,
+	}
+	clock.waiters = append(clock.waiters, waiter)
+	return waiter
+}
+
+func (waiter *fakeWaiter) C() <-chan time.Time {
+	return waiter.c
+}
+
+func (waiter *fakeWaiter) Stop() {
+	waiter.clock.mu.Lock()
+	defer waiter.clock.mu.Unlock()
+	waiter.stopped = true
+}
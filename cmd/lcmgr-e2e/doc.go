@@ -0,0 +1,13 @@
+// Package e2e checks lcmgr's full lifecycle path against real AWS. It
+// provisions a throwaway auto scaling group with lifecycle hooks and a queue,
+// launches one instance running lcmgr, scales it in, and asserts lcmgr
+// completed both lifecycle actions itself rather than leaving them to time
+// out. Everything it creates is deleted afterwards.
+//
+// It costs money and needs an image that starts lcmgr on boot, so it's only
+// built with the aws tag:
+//
+//	go test -tags=aws -timeout 40m ./cmd/lcmgr-e2e -args -image-id ami-... \
+//		-subnet-id subnet-... -instance-profile lcmgr \
+//		-role-arn arn:aws:iam::...:role/lcmgr-hooks
+package e2e
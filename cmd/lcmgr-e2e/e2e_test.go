@@ -0,0 +1,69 @@
+//go:build aws
+
+package e2e
+
+import (
+	"context"
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+var (
+	region           = flag.String("region", "", "AWS region to run in, defaults to the shared config's region")
+	imageID          = flag.String("image-id", "", "AMI with lcmgr installed and started on boot")
+	instanceType     = flag.String("instance-type", "t3.micro", "Instance type to launch")
+	subnetID         = flag.String("subnet-id", "", "Subnet to launch the instance in")
+	instanceProfile  = flag.String("instance-profile", "", "Instance profile that grants lcmgr its permissions")
+	roleARN          = flag.String("role-arn", "", "Role auto scaling assumes to publish lifecycle notifications to the queue")
+	userData         = flag.String("user-data", "", "Path to user data for the instance, e.g. to write lcmgr's config")
+	heartbeatTimeout = flag.Duration("heartbeat-timeout", 10*time.Minute, "Heartbeat timeout of the lifecycle hooks, lcmgr must complete each action before it")
+	timeout          = flag.Duration("suite-timeout", 30*time.Minute, "How long the whole suite may take")
+	keep             = flag.Bool("keep", false, "Leave the provisioned resources in place for debugging")
+)
+
+func TestLifecycle(t *testing.T) {
+	for name, value := range map[string]string{
+		"image-id":         *imageID,
+		"subnet-id":        *subnetID,
+		"instance-profile": *instanceProfile,
+		"role-arn":         *roleARN,
+	} {
+		if value == "" {
+			t.Fatalf("-%s is required", name)
+		}
+	}
+
+	var data []byte
+	if *userData != "" {
+		var err error
+		if data, err = os.ReadFile(*userData); err != nil {
+			t.Fatalf("failed to read user data: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	suite, err := NewSuite(ctx, SuiteOptions{
+		Region:           *region,
+		ImageID:          *imageID,
+		InstanceType:     *instanceType,
+		SubnetID:         *subnetID,
+		InstanceProfile:  *instanceProfile,
+		RoleARN:          *roleARN,
+		UserData:         data,
+		HeartbeatTimeout: *heartbeatTimeout,
+	})
+	if err != nil {
+		t.Fatalf("failed to set up suite: %v", err)
+	}
+	if !*keep {
+		defer suite.Cleanup(context.Background())
+	}
+
+	if err := suite.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
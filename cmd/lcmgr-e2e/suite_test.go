@@ -0,0 +1,323 @@
+//go:build aws
+
+package e2e
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	autoscalingtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/vanstee/lcmgr"
+)
+
+// pollInterval is how often the suite checks on the group while waiting.
+const pollInterval = 10 * time.Second
+
+// SuiteOptions describes the instance the suite launches.
+type SuiteOptions struct {
+	Region           string
+	ImageID          string
+	InstanceType     string
+	SubnetID         string
+	InstanceProfile  string
+	RoleARN          string
+	UserData         []byte
+	HeartbeatTimeout time.Duration
+}
+
+// Suite provisions the throwaway resources and drives the instance through
+// launch and termination. Names are suffixed with a random ID so concurrent
+// runs don't collide.
+type Suite struct {
+	Options     SuiteOptions
+	Client      lcmgr.AWSClient
+	AutoScaling *autoscaling.Client
+	EC2         *ec2.Client
+	SQS         *sqs.Client
+
+	name       string
+	templateID string
+	groupMade  bool
+	instanceID string
+}
+
+func NewSuite(ctx context.Context, options SuiteOptions) (*Suite, error) {
+	var load []func(*config.LoadOptions) error
+	var clientOptions []lcmgr.AWSOption
+	if options.Region != "" {
+		load = append(load, config.WithRegion(options.Region))
+		clientOptions = append(clientOptions, lcmgr.WithRegion(options.Region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, load...)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &Suite{
+		Options:     options,
+		Client:      lcmgr.NewAWSClient(clientOptions...),
+		AutoScaling: autoscaling.NewFromConfig(cfg),
+		EC2:         ec2.NewFromConfig(cfg),
+		SQS:         sqs.NewFromConfig(cfg),
+		name:        "lcmgr-e2e-" + hex.EncodeToString(id),
+	}, nil
+}
+
+// Run provisions the group, launches an instance, scales it in, and checks
+// every step of the drain path along the way.
+func (suite *Suite) Run(ctx context.Context) error {
+	log.Printf("provisioning %s", suite.name)
+	if err := suite.provision(ctx); err != nil {
+		return fmt.Errorf("failed to provision: %v", err)
+	}
+
+	log.Printf("launching an instance")
+	if err := suite.setDesiredCapacity(ctx, 1); err != nil {
+		return err
+	}
+	launched, err := suite.waitForState(ctx, autoscalingtypes.LifecycleStateInService)
+	if err != nil {
+		return fmt.Errorf("instance never went into service: %v", err)
+	}
+	log.Printf("%s went into service after %s", suite.instanceID, launched.Round(time.Second))
+	if launched >= suite.Options.HeartbeatTimeout {
+		return fmt.Errorf("launch lifecycle action took %s, it timed out instead of being completed by lcmgr", launched)
+	}
+
+	log.Printf("scaling in")
+	if err := suite.setDesiredCapacity(ctx, 0); err != nil {
+		return err
+	}
+	if _, err := suite.waitForState(ctx, autoscalingtypes.LifecycleStateTerminatingWait); err != nil {
+		return fmt.Errorf("instance never waited on the termination hook: %v", err)
+	}
+	drained, err := suite.waitForState(ctx, autoscalingtypes.LifecycleStateTerminatingProceed, autoscalingtypes.LifecycleStateTerminated, "")
+	if err != nil {
+		return fmt.Errorf("termination lifecycle action was never completed: %v", err)
+	}
+	log.Printf("%s drained in %s", suite.instanceID, drained.Round(time.Second))
+	if drained >= suite.Options.HeartbeatTimeout {
+		return fmt.Errorf("termination lifecycle action took %s, it timed out instead of being completed by lcmgr", drained)
+	}
+
+	if err := suite.checkQueueEmpty(ctx); err != nil {
+		return err
+	}
+	return suite.checkActivities(ctx)
+}
+
+func (suite *Suite) provision(ctx context.Context) error {
+	data := ec2types.RequestLaunchTemplateData{
+		ImageId:            aws.String(suite.Options.ImageID),
+		InstanceType:       ec2types.InstanceType(suite.Options.InstanceType),
+		IamInstanceProfile: &ec2types.LaunchTemplateIamInstanceProfileSpecificationRequest{Name: aws.String(suite.Options.InstanceProfile)},
+	}
+	if len(suite.Options.UserData) > 0 {
+		data.UserData = aws.String(base64.StdEncoding.EncodeToString(suite.Options.UserData))
+	}
+	template, err := suite.EC2.CreateLaunchTemplate(ctx, &ec2.CreateLaunchTemplateInput{
+		LaunchTemplateName: aws.String(suite.name),
+		LaunchTemplateData: &data,
+	})
+	if err != nil {
+		return err
+	}
+	suite.templateID = aws.ToString(template.LaunchTemplate.LaunchTemplateId)
+
+	_, err = suite.AutoScaling.CreateAutoScalingGroup(ctx, &autoscaling.CreateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(suite.name),
+		LaunchTemplate: &autoscalingtypes.LaunchTemplateSpecification{
+			LaunchTemplateId: aws.String(suite.templateID),
+			Version:          aws.String("$Latest"),
+		},
+		MinSize:           aws.Int32(0),
+		MaxSize:           aws.Int32(1),
+		DesiredCapacity:   aws.Int32(0),
+		VPCZoneIdentifier: aws.String(suite.Options.SubnetID),
+	})
+	if err != nil {
+		return err
+	}
+	suite.groupMade = true
+
+	_, err = lcmgr.Bootstrap(ctx, suite.Client, lcmgr.BootstrapOptions{
+		AutoScalingGroupName: suite.name,
+		QueueName:            suite.name,
+		RoleARN:              suite.Options.RoleARN,
+		HeartbeatTimeout:     suite.Options.HeartbeatTimeout,
+		DefaultResult:        lcmgr.AbandonResult,
+		Launch:               true,
+		Termination:          true,
+	})
+	return err
+}
+
+func (suite *Suite) setDesiredCapacity(ctx context.Context, capacity int32) error {
+	_, err := suite.AutoScaling.SetDesiredCapacity(ctx, &autoscaling.SetDesiredCapacityInput{
+		AutoScalingGroupName: aws.String(suite.name),
+		DesiredCapacity:      aws.Int32(capacity),
+	})
+	return err
+}
+
+// waitForState waits for the group's instance to reach one of states, where
+// an empty state means it has left the group, and returns how long it took.
+func (suite *Suite) waitForState(ctx context.Context, states ...autoscalingtypes.LifecycleState) (time.Duration, error) {
+	start := time.Now()
+	for {
+		state, err := suite.instanceState(ctx)
+		if err != nil {
+			log.Printf("failed to describe %s: %v", suite.name, err)
+		} else {
+			for _, want := range states {
+				if state == want {
+					return time.Since(start), nil
+				}
+			}
+		}
+
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			return time.Since(start), fmt.Errorf("gave up in state %q: %v", state, ctx.Err())
+		}
+	}
+}
+
+func (suite *Suite) instanceState(ctx context.Context) (autoscalingtypes.LifecycleState, error) {
+	output, err := suite.AutoScaling.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []string{suite.name},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(output.AutoScalingGroups) == 0 {
+		return "", errors.New("group not found")
+	}
+	for _, instance := range output.AutoScalingGroups[0].Instances {
+		if suite.instanceID == "" {
+			suite.instanceID = aws.ToString(instance.InstanceId)
+		}
+		if aws.ToString(instance.InstanceId) == suite.instanceID {
+			return instance.LifecycleState, nil
+		}
+	}
+	return "", nil
+}
+
+// checkQueueEmpty makes sure lcmgr deleted every message it handled, rather
+// than leaving them to be received again.
+func (suite *Suite) checkQueueEmpty(ctx context.Context) error {
+	url, err := suite.queueURL(ctx)
+	if err != nil {
+		return err
+	}
+	output, err := suite.SQS.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl: aws.String(url),
+		AttributeNames: []sqstypes.QueueAttributeName{
+			sqstypes.QueueAttributeNameApproximateNumberOfMessages,
+			sqstypes.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for name, value := range output.Attributes {
+		if count, _ := strconv.Atoi(value); count > 0 {
+			return fmt.Errorf("queue still holds messages (%s=%d)", name, count)
+		}
+	}
+	return nil
+}
+
+// checkActivities makes sure neither scaling activity failed, e.g. because a
+// lifecycle action was abandoned.
+func (suite *Suite) checkActivities(ctx context.Context) error {
+	output, err := suite.AutoScaling.DescribeScalingActivities(ctx, &autoscaling.DescribeScalingActivitiesInput{
+		AutoScalingGroupName: aws.String(suite.name),
+	})
+	if err != nil {
+		return err
+	}
+	for _, activity := range output.Activities {
+		if activity.StatusCode != autoscalingtypes.ScalingActivityStatusCodeSuccessful {
+			return fmt.Errorf("scaling activity %q ended %s: %s", aws.ToString(activity.Description), activity.StatusCode, aws.ToString(activity.StatusMessage))
+		}
+	}
+	return nil
+}
+
+func (suite *Suite) queueURL(ctx context.Context) (string, error) {
+	output, err := suite.SQS.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String(suite.name)})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(output.QueueUrl), nil
+}
+
+// Cleanup deletes everything the suite provisioned, logging rather than
+// stopping on failures so as much as possible is removed.
+func (suite *Suite) Cleanup(ctx context.Context) {
+	log.Printf("cleaning up %s", suite.name)
+	if suite.groupMade {
+		_, err := suite.AutoScaling.DeleteAutoScalingGroup(ctx, &autoscaling.DeleteAutoScalingGroupInput{
+			AutoScalingGroupName: aws.String(suite.name),
+			ForceDelete:          aws.Bool(true),
+		})
+		if err != nil {
+			log.Printf("failed to delete group %s: %v", suite.name, err)
+		}
+	}
+	if url, err := suite.queueURL(ctx); err == nil {
+		if _, err := suite.SQS.DeleteQueue(ctx, &sqs.DeleteQueueInput{QueueUrl: aws.String(url)}); err != nil {
+			log.Printf("failed to delete queue %s: %v", suite.name, err)
+		}
+	}
+	if suite.templateID != "" {
+		// The template can't be deleted while the group's instance is
+		// still using it.
+		suite.waitForGroupDeleted(ctx)
+		_, err := suite.EC2.DeleteLaunchTemplate(ctx, &ec2.DeleteLaunchTemplateInput{
+			LaunchTemplateId: aws.String(suite.templateID),
+		})
+		if err != nil {
+			log.Printf("failed to delete launch template %s: %v", suite.templateID, err)
+		}
+	}
+}
+
+func (suite *Suite) waitForGroupDeleted(ctx context.Context) {
+	if !suite.groupMade {
+		return
+	}
+	deadline := time.Now().Add(10 * time.Minute)
+	for time.Now().Before(deadline) {
+		output, err := suite.AutoScaling.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: []string{suite.name},
+		})
+		if err == nil && len(output.AutoScalingGroups) == 0 {
+			return
+		}
+		time.Sleep(pollInterval)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vanstee/lcmgr"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	benchCommand  = kingpin.Command("bench-drain", "Run the termination chain under a simulated spot deadline and report whether it fits, this stops the services for real")
+	benchDeadline = benchCommand.Flag("deadline", "Simulated time until termination").Default("2m").Duration()
+	benchNotice   = benchCommand.Flag("notice", "Notice to simulate: spot or termination").Default("spot").Enum("spot", "termination")
+)
+
+type benchPhase struct {
+	name     string
+	duration time.Duration
+	err      error
+}
+
+func benchDrain() {
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	lcmgr.Debug = config.Debug
+	manager, err := lcmgr.NewServiceManager(config)
+	if err != nil {
+		log.Fatalf("failed to create service manager: %v", err)
+	}
+	handler := newHandler(config, lcmgr.NewAWSClient(awsOptions(config)...), manager)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *benchDeadline)
+	defer cancel()
+
+	var notice lcmgr.Notice = lcmgr.NewSpotNotice(lcmgr.SpotTerminateAction, time.Now().Add(*benchDeadline))
+	if *benchNotice == "termination" {
+		notice = lcmgr.NewTerminationNotice("bench-drain", "")
+	}
+
+	var phases []benchPhase
+	observe := func(step string, duration time.Duration, err error) {
+		phases = append(phases, benchPhase{step, duration, err})
+	}
+	run := handler.WaitForServiceStop
+	switch chain := handler.Chain.(type) {
+	case *lcmgr.Chain:
+		chain.Observe = observe
+		run = chain.Handle
+	case *lcmgr.PolicyHandler:
+		for _, policy := range chain.Policies {
+			policy.Chain.Observe = observe
+		}
+		if defaultChain, ok := chain.Default.(*lcmgr.Chain); ok {
+			defaultChain.Observe = observe
+		}
+		run = chain.Handle
+	}
+
+	start := time.Now()
+	err = run(ctx, notice)
+	total := time.Since(start)
+	if len(phases) == 0 {
+		phases = append(phases, benchPhase{"stop services", total, err})
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PHASE\tDURATION\tRESULT")
+	for _, phase := range phases {
+		result := "ok"
+		if phase.err != nil {
+			result = phase.err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%v\t%s\n", phase.name, phase.duration.Round(time.Millisecond), result)
+	}
+	w.Flush()
+
+	switch {
+	case ctx.Err() != nil:
+		fmt.Printf("\ndrain did not finish within %v\n", *benchDeadline)
+		os.Exit(1)
+	case err != nil:
+		fmt.Printf("\ndrain failed after %v: %v\n", total.Round(time.Millisecond), err)
+		os.Exit(1)
+	default:
+		fmt.Printf("\ndrain took %v, fits within %v with %v to spare\n", total.Round(time.Millisecond), *benchDeadline, (*benchDeadline - total).Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/vanstee/lcmgr"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	bootstrapCommand          = kingpin.Command("bootstrap", "Create the SQS queue and lifecycle hooks lcmgr needs for an auto scaling group")
+	bootstrapGroup            = bootstrapCommand.Flag("auto-scaling-group", "Auto scaling group to provision, defaults to the group of the instance lcmgr is running on").String()
+	bootstrapQueueName        = bootstrapCommand.Flag("queue-name", "Name of the SQS queue to create or reuse (default lcmgr-<group>)").String()
+	bootstrapRoleARN          = bootstrapCommand.Flag("role-arn", "Role auto scaling assumes to publish lifecycle notifications to the queue").Required().String()
+	bootstrapHeartbeatTimeout = bootstrapCommand.Flag("heartbeat-timeout", "Heartbeat timeout of the lifecycle hooks").Default("5m").Duration()
+	bootstrapDefaultResult    = bootstrapCommand.Flag("default-result", "Result applied when a lifecycle action times out").Default(lcmgr.ContinueResult).Enum(lcmgr.ContinueResult, lcmgr.AbandonResult)
+	bootstrapLaunch           = bootstrapCommand.Flag("launch", "Create a launch lifecycle hook").Default("true").Bool()
+	bootstrapTermination      = bootstrapCommand.Flag("termination", "Create a termination lifecycle hook").Default("true").Bool()
+)
+
+func bootstrap() {
+	client := lcmgr.NewAWSClient(awsOptions(&lcmgr.Config{
+		AWSRegion:    *awsRegion,
+		AWSEndpoint:  *awsEndpoint,
+		AWSEndpoints: *awsServiceEndpoints,
+		SQSRoleARN:   *sqsRoleARN,
+	})...)
+	options := lcmgr.BootstrapOptions{
+		AutoScalingGroupName: *bootstrapGroup,
+		QueueName:            *bootstrapQueueName,
+		RoleARN:              *bootstrapRoleARN,
+		HeartbeatTimeout:     *bootstrapHeartbeatTimeout,
+		DefaultResult:        *bootstrapDefaultResult,
+		Launch:               *bootstrapLaunch,
+		Termination:          *bootstrapTermination,
+	}
+	hooks, err := lcmgr.Bootstrap(context.Background(), client, options)
+	if err != nil {
+		log.Fatalf("failed to bootstrap: %v", err)
+	}
+
+	for _, hook := range hooks {
+		fmt.Printf("%s\t%s\t%s\n", hook.Name, hook.Transition, hook.NotificationTargetARN)
+	}
+}
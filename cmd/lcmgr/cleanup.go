@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/vanstee/lcmgr"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	cleanupQueuesCommand = kingpin.Command("cleanup-queues", "Delete the ephemeral queues and subscriptions of instances that no longer exist, which are left behind when lcmgr is killed rather than stopped")
+	cleanupQueuesDryRun  = cleanupQueuesCommand.Flag("dry-run", "Print the queues that would be deleted without deleting them").Bool()
+)
+
+func cleanupQueues() {
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	client := lcmgr.NewAWSClient(awsOptions(config)...)
+	deleted, err := client.CleanupEphemeralQueues(context.Background(), *cleanupQueuesDryRun)
+	for _, name := range deleted {
+		if *cleanupQueuesDryRun {
+			log.Printf("would delete %s", name)
+		} else {
+			log.Printf("deleted %s", name)
+		}
+	}
+	if err != nil {
+		log.Fatalf("failed to clean up ephemeral queues: %v", err)
+	}
+}
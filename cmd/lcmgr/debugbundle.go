@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/vanstee/lcmgr"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	debugBundleCommand = kingpin.Command("debug-bundle", "Collect recent logs, status, redacted config, goroutines and AWS identity into a tarball to attach to a support ticket")
+	debugBundleOutput  = debugBundleCommand.Flag("output", "Path to write the tarball to (default lcmgr-debug-<time>.tar.gz)").Short('o').String()
+	debugBundleUnit    = debugBundleCommand.Flag("unit", "systemd unit lcmgr runs as, to read its logs from the journal").Default("lcmgr").String()
+)
+
+func debugBundle() {
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	if err := lcmgr.EnsureStateDir(config.StateDir); err != nil {
+		log.Fatalf("failed to prepare state directory: %v", err)
+	}
+
+	var logCommand []string
+	if runtime.GOOS == "linux" {
+		logCommand = lcmgr.JournalLogCommand(*debugBundleUnit)
+	}
+	bundle := lcmgr.NewDebugBundle(config, lcmgr.NewAWSClient(awsOptions(config)...), logCommand)
+	if err := bundle.Throttle(); err != nil {
+		log.Fatalf("failed to collect debug bundle: %v", err)
+	}
+
+	path := *debugBundleOutput
+	if path == "" {
+		path = "lcmgr-debug-" + time.Now().UTC().Format("20060102T150405Z") + ".tar.gz"
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("failed to create debug bundle: %v", err)
+	}
+	if err := bundle.Write(context.Background(), file); err != nil {
+		file.Close()
+		os.Remove(path)
+		log.Fatalf("failed to write debug bundle: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		log.Fatalf("failed to write debug bundle: %v", err)
+	}
+	fmt.Println(path)
+}
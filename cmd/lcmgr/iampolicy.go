@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/vanstee/lcmgr"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var iamPolicyCommand = kingpin.Command("iam-policy", "Print the least-privilege IAM policy the instance role needs for the listeners, handlers and steps the config enables, with ARNs in the partition of --aws-region")
+
+func iamPolicy() {
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	if config.AWSRegion == "" {
+		log.Printf("warning: --aws-region isn't set, ARNs are in the %s partition, set it for instances in another partition", lcmgr.PartitionForRegion(""))
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(lcmgr.RequiredPolicy(config)); err != nil {
+		log.Fatalf("failed to write policy: %v", err)
+	}
+}
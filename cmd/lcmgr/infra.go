@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/vanstee/lcmgr"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	infraCommand   = kingpin.Command("infra", "Print the lifecycle hooks, queue or topic and notification role matching the config as Terraform or CloudFormation")
+	infraFormat    = infraCommand.Flag("format", "Format to print: terraform or cloudformation").Default("terraform").Enum("terraform", "cloudformation")
+	infraGroup     = infraCommand.Flag("auto-scaling-group", "Auto scaling group to default the generated variable or parameter to").String()
+	infraQueueName = infraCommand.Flag("queue-name", "Name of the queue or topic (default lcmgr-<group>)").String()
+)
+
+func infra() {
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	options := lcmgr.NewInfraOptions(config, *infraGroup)
+	if *infraQueueName != "" {
+		options.QueueName = *infraQueueName
+	}
+	write := lcmgr.WriteTerraform
+	if *infraFormat == "cloudformation" {
+		write = lcmgr.WriteCloudFormation
+	}
+	if err := write(os.Stdout, options); err != nil {
+		log.Fatalf("failed to write infrastructure: %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/vanstee/lcmgr"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	k8sCommand         = kingpin.Command("k8s", "Kubernetes deployment helpers")
+	k8sManifestCommand = k8sCommand.Command("manifest", "Print the DaemonSet and RBAC manifests for running lcmgr on every node")
+	k8sName            = k8sManifestCommand.Flag("name", "Name for the generated objects").Default("lcmgr").String()
+	k8sNamespace       = k8sManifestCommand.Flag("namespace", "Namespace to deploy the DaemonSet in").Default("kube-system").String()
+	k8sImage           = k8sManifestCommand.Flag("image", "lcmgr container image").Default("vanstee/lcmgr:latest").String()
+)
+
+func k8sManifest() {
+	options := lcmgr.KubernetesManifestOptions{
+		Name:      *k8sName,
+		Namespace: *k8sNamespace,
+		Image:     *k8sImage,
+	}
+	if err := lcmgr.WriteKubernetesManifest(os.Stdout, options); err != nil {
+		log.Fatalf("failed to write kubernetes manifest: %v", err)
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/vanstee/lcmgr"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func listen() {
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	lcmgr.Debug = config.Debug
+	if config.LowMemory {
+		lcmgr.TuneForLowMemory()
+	}
+	if err := lcmgr.EnsureStateDir(config.StateDir); err != nil {
+		log.Fatalf("failed to prepare state directory: %v", err)
+	}
+
+	if config.MetricsAddress != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", lcmgr.DefaultRegistry)
+			if err := http.ListenAndServe(config.MetricsAddress, mux); err != nil {
+				log.Printf("failed to serve metrics: %v", err)
+			}
+		}()
+	}
+
+	if config.Diagnostics {
+		lcmgr.DumpOnSignal(config.StateDir)
+	}
+
+	signals := make(chan os.Signal, 1)
+	lcmgr.NotifyStop(signals)
+
+	notices := make(chan lcmgr.Notice)
+
+	client := lcmgr.NewAWSClient(awsOptions(config)...)
+
+	var exporter *lcmgr.OTLPExporter
+	if config.OTLPEndpoint != "" {
+		exporter = lcmgr.NewOTLPExporter(config.OTLPEndpoint, 0, lcmgr.OTLPResource(context.Background(), client))
+		log.SetOutput(io.MultiWriter(os.Stderr, exporter))
+	}
+	if config.RedactLogs {
+		redactor, err := lcmgr.NewRedactor(log.Writer(), config.RedactPatterns)
+		if err != nil {
+			log.Fatalf("failed to configure log redaction: %v", err)
+		}
+		for _, webhook := range config.Webhooks {
+			redactor.AddSecret(webhook)
+		}
+		redactor.AddSecret(config.WebhookSecret)
+		log.SetOutput(redactor)
+	}
+
+	sinks := lcmgr.NewSinks(config)
+	if config.IdentityCert != "" {
+		identity, err := lcmgr.AttestIdentity(context.Background(), client, config.IdentityCert)
+		if err != nil {
+			log.Fatalf("failed to attest instance identity: %v", err)
+		}
+		lcmgr.AttachIdentity(sinks, identity)
+	}
+
+	var queues []*lcmgr.Queue
+	if !config.NoASG {
+		queues, err = client.GetLifecycleNoticeQueues(context.Background())
+		if errors.Is(err, lcmgr.ErrNoAutoScalingGroup) {
+			log.Printf("instance is not in an auto scaling group")
+			config.NoASG = true
+		} else if err != nil {
+			log.Fatalf("failed to get lifecycle hooks: %v", err)
+		}
+	}
+	if config.NoASG {
+		log.Printf("running standalone, only spot interruption and rebalance notices are handled")
+	}
+
+	var handler lcmgr.Handler
+	if config.Mode == lcmgr.NotifyOnlyMode {
+		log.Printf("running in notify-only mode, services and lifecycle actions are left alone")
+		handler = lcmgr.NewNotifyHandler(sinks, config.FlagDir, client)
+	} else {
+		serviceHandler := newManagedHandler(config, client)
+		serviceHandler.Sinks = sinks
+		handler = serviceHandler
+	}
+	var tracer *lcmgr.XRayTracer
+	if config.XRay {
+		tracer, err = lcmgr.NewXRayTracer("", "lcmgr")
+		if err != nil {
+			log.Fatalf("failed to reach x-ray daemon: %v", err)
+		}
+	}
+
+	var shutdown lcmgr.Listener
+	if config.DetectShutdown {
+		shutdown = lcmgr.NewShutdownListener(newLastGaspHandler(config, client, handler))
+	}
+
+	// The ephemeral queue is only deleted once listening stops, so nothing
+	// after creating it may exit. Setup that can fail goes above.
+	deleteEphemeralQueue := func() {}
+	listeners := make([]lcmgr.Listener, 0, len(queues)+1)
+	listeners = append(listeners, lcmgr.NewSpotListener(notices, time.Duration(config.SpotInterval), config.AdaptiveSpot, config.DrainOnRebalance, client))
+	if !config.NoASG {
+		var static []*lcmgr.Queue
+		if config.EphemeralQueue {
+			ephemeral, err := client.CreateEphemeralQueue(context.Background())
+			if err != nil {
+				log.Fatalf("failed to create ephemeral queue: %v", err)
+			}
+			log.Printf("listening on ephemeral queue %s", ephemeral.Queue.URL)
+			deleteEphemeralQueue = func() {
+				if err := client.DeleteEphemeralQueue(context.Background(), ephemeral); err != nil {
+					log.Printf("failed to delete ephemeral queue: %v", err)
+				}
+			}
+			static = append(static, ephemeral.Queue)
+		}
+		listeners = append(listeners, groupListeners(config, client, handler, sinks, notices, queues, static)...)
+	} else {
+		// Standalone instances may still receive spot and rebalance events.
+		for _, url := range config.EventBridgeQueues {
+			listeners = append(listeners, lcmgr.NewLifecycleListener(notices, lcmgr.NewEventBridgeQueue(url), config.DrainOnRebalance, client))
+		}
+	}
+	if config.MetricsAddress != "" {
+		listeners = append(listeners, lcmgr.NewSpotRiskListener(time.Duration(config.SpotInterval), client))
+	}
+	if config.Supervise {
+		if serviceHandler, ok := handler.(*lcmgr.ServiceHandler); ok {
+			listeners = append(listeners, lcmgr.NewSupervisor(serviceHandler, sinks, lcmgr.DefaultSuperviseInterval))
+		} else {
+			log.Printf("ignoring supervise in %s mode", config.Mode)
+		}
+	}
+	if exporter != nil {
+		listeners = append(listeners, exporter)
+	}
+	if shutdown != nil {
+		listeners = append(listeners, shutdown)
+	}
+
+	if tracer != nil {
+		handler = lcmgr.NewXRayHandler(handler, tracer)
+	}
+
+	dispatcher := lcmgr.NewDispatcher(notices, listeners, handler)
+	if config.LowMemory {
+		dispatcher.AfterHandle = lcmgr.ReleaseMemory
+	}
+
+	// The first signal lets the notice being handled finish, so a drain
+	// isn't left halfway, and a second one interrupts it.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-signals
+		log.Printf("received signal, shutting down once the notice being handled is done, signal again to interrupt it")
+		cancel()
+		<-signals
+		log.Printf("received second signal, interrupting the notice being handled")
+		dispatcher.Interrupt()
+	}()
+
+	err = dispatcher.Run(ctx)
+	deleteEphemeralQueue()
+	if err != nil {
+		log.Fatalf("failed while listening: %v", err)
+	}
+}
+
+// groupListeners builds the listeners that only run on instances in an auto
+// scaling group. Standalone instances handle spot notices alone.
+func groupListeners(config *lcmgr.Config, client lcmgr.AWSClient, handler lcmgr.Handler, sinks []lcmgr.Sink, notices chan lcmgr.Notice, queues, static []*lcmgr.Queue) []lcmgr.Listener {
+	var listeners []lcmgr.Listener
+	if config.DrainOnMaintenance {
+		listeners = append(listeners, lcmgr.NewScheduledEventListener(notices, time.Duration(config.SpotInterval), time.Duration(config.MaintenanceLead), client))
+	}
+	if config.DrainOnDegraded {
+		listeners = append(listeners, lcmgr.NewStatusCheckListener(notices, time.Duration(config.SpotInterval), client))
+	}
+	for _, subscription := range config.SNSSubscriptions {
+		if err := client.SetSubscriptionFilterPolicy(context.Background(), subscription); err != nil {
+			log.Printf("failed to filter sns subscription to this instance: %v", err)
+		}
+	}
+	for _, url := range config.EventBridgeQueues {
+		static = append(static, lcmgr.NewEventBridgeQueue(url))
+	}
+	refresher := lcmgr.NewQueueRefresher(notices, queues, static, time.Duration(config.QueueRefresh), client)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	refresher.Reload = reload
+	refresher.Rebalance = config.DrainOnRebalance
+	if serviceHandler, ok := handler.(*lcmgr.ServiceHandler); ok {
+		refresher.SetHooks = serviceHandler.SetHooks
+	}
+	listeners = append(listeners, refresher)
+	if config.ScheduledActionLookahead > 0 {
+		listeners = append(listeners, lcmgr.NewScheduledActionListener(sinks, time.Duration(config.ScheduledActionInterval), time.Duration(config.ScheduledActionLookahead), client))
+	}
+	return listeners
+}
+
+// awsOptions customizes the AWS client from config.
+func awsOptions(config *lcmgr.Config) []lcmgr.AWSOption {
+	var options []lcmgr.AWSOption
+	if config.AWSRegion != "" {
+		options = append(options, lcmgr.WithRegion(config.AWSRegion))
+	}
+	if config.AWSEndpoint != "" {
+		options = append(options, lcmgr.WithEndpoint(config.AWSEndpoint))
+	}
+	for service, url := range config.AWSEndpoints {
+		options = append(options, lcmgr.WithServiceEndpoint(service, url))
+	}
+	if config.SQSRoleARN != "" {
+		options = append(options, lcmgr.WithSQSRole(config.SQSRoleARN))
+	}
+	if config.IMDSEndpoint != "" {
+		options = append(options, lcmgr.WithIMDSEndpoint(config.IMDSEndpoint))
+	}
+	if config.IMDSTimeout > 0 {
+		options = append(options, lcmgr.WithIMDSTimeout(time.Duration(config.IMDSTimeout)))
+	}
+	if config.IMDSRetries > 0 {
+		options = append(options, lcmgr.WithIMDSRetries(config.IMDSRetries))
+	}
+	if config.XRay {
+		options = append(options, lcmgr.WithXRay())
+	}
+	return options
+}
+
+// newLastGaspHandler builds the handler run when the operating system shuts
+// down without a notice. Last-gasp steps can only use the service handler in
+// manage mode.
+func newLastGaspHandler(config *lcmgr.Config, client lcmgr.AWSClient, handler lcmgr.Handler) *lcmgr.LastGaspHandler {
+	lastGasp := lcmgr.NewLastGaspHandler(config.FlagDir, filepath.Join(config.StateDir, "metrics.prom"))
+	if len(config.LastGasp) == 0 {
+		return lastGasp
+	}
+
+	serviceHandler, ok := handler.(*lcmgr.ServiceHandler)
+	if !ok {
+		log.Printf("ignoring last-gasp steps in %s mode", config.Mode)
+		return lastGasp
+	}
+	chain, err := lcmgr.NewChain(config.LastGasp, lcmgr.NewIdentity(context.Background(), client), serviceHandler)
+	if err != nil {
+		log.Fatalf("failed to configure last-gasp steps: %v", err)
+	}
+	lastGasp.Chain = chain
+	return lastGasp
+}
+
+// newManagedHandler builds the service handler that drains services and
+// completes lifecycle actions, checking the services and serving the admin
+// API along the way.
+func newManagedHandler(config *lcmgr.Config, client lcmgr.AWSClient) *lcmgr.ServiceHandler {
+	var err error
+	if len(config.ServiceNames()) == 0 && config.ServiceBackend == lcmgr.KubernetesBackend {
+		config.Service, err = lcmgr.DetectNodeName()
+		if err != nil {
+			log.Fatalf("failed to detect kubernetes node name: %v", err)
+		}
+	}
+	if len(config.ServiceNames()) == 0 {
+		kingpin.Fatalf("required flag --service not provided")
+	}
+
+	manager, err := lcmgr.NewServiceManager(config)
+	if err != nil {
+		log.Fatalf("failed to create service manager: %v", err)
+	}
+
+	handler := newHandler(config, client, manager)
+
+	handler.Outbox = lcmgr.NewCompletionOutbox(lcmgr.NewFileStore(config.StateDir), client)
+	handler.Outbox.Replay(context.Background())
+
+	if config.NoticeSLO > 0 {
+		handler.SLO = lcmgr.NewSLOTracker(time.Duration(config.NoticeSLO))
+	}
+
+	estimator, err := lcmgr.NewDrainEstimator(lcmgr.NewFileStore(config.StateDir))
+	if err != nil {
+		log.Printf("failed to load drain estimates: %v", err)
+	} else {
+		handler.Estimator = estimator
+	}
+
+	if config.AdminAddress != "" {
+		listener, err := lcmgr.ListenAPI(config.AdminAddress)
+		if err != nil {
+			log.Fatalf("failed to listen for admin api: %v", err)
+		}
+		api := lcmgr.NewAPI(handler)
+		if config.Diagnostics {
+			api.EnableProfiling()
+		}
+		if config.SuspendProcesses && config.NoASG {
+			log.Printf("ignoring suspend-processes without an auto scaling group")
+		} else if config.SuspendProcesses {
+			suspender := lcmgr.NewProcessSuspender(client, lcmgr.NewFileStore(config.StateDir))
+			suspender.Restore(context.Background())
+			api.EnableProcessSuspension(suspender)
+		}
+		go func() {
+			if err := api.Serve(listener); err != nil {
+				log.Printf("failed to serve admin api: %v", err)
+			}
+		}()
+	}
+
+	if err := handler.CheckServices(context.Background()); err != nil {
+		log.Fatalf("failed to check service: %v", err)
+	}
+
+	if config.NoASG {
+		return handler
+	}
+
+	hooks, err := client.GetLifecycleHooks(context.Background())
+	if err != nil {
+		log.Printf("failed to get lifecycle hooks to check drain budget: %v", err)
+	} else {
+		handler.SetHooks(hooks)
+		lcmgr.CheckDefaultResults(hooks)
+		if handler.Estimator != nil {
+			handler.Estimator.CheckBudget(hooks)
+		}
+	}
+
+	return handler
+}
+
+// newHandler builds the service handler and its chains from config.
+func newHandler(config *lcmgr.Config, client lcmgr.AWSClient, manager lcmgr.ServiceManager) *lcmgr.ServiceHandler {
+	handler := lcmgr.NewServiceHandler(config.ServiceNames(), time.Duration(config.HeartbeatInterval), client, manager)
+	handler.FastCompletion = config.FastCompletion
+	handler.MissingService = config.MissingService
+	handler.DrainTarget = config.DrainTarget
+	handler.AbandonOnFailure = config.AbandonOnFailure
+	handler.InhibitShutdown = config.InhibitShutdown
+	handler.RefreshWait = time.Duration(config.RefreshWait)
+	if config.DeregisterLBs {
+		loadBalancers := lcmgr.NewLoadBalancerDrainHandler(nil, "", 0, 0, false, client)
+		loadBalancers.Discover = true
+		handler.LoadBalancers = loadBalancers
+	}
+	if config.ServiceOrder != lcmgr.ConfigServiceOrder {
+		handler.StopOrder = lcmgr.DependencyStopOrder(manager)
+	}
+
+	if len(config.Steps) > 0 || len(config.Launch) > 0 || len(config.Rollback) > 0 || len(config.Policies) > 0 {
+		identity := lcmgr.NewIdentity(context.Background(), client)
+		if len(config.Steps) > 0 {
+			chain, err := lcmgr.NewChain(config.Steps, identity, handler)
+			if err != nil {
+				log.Fatalf("failed to configure handler chain: %v", err)
+			}
+			handler.Chain = chain
+		}
+		if len(config.Policies) > 0 {
+			policies, err := lcmgr.NewPolicyHandler(config.Policies, identity, handler)
+			if err != nil {
+				log.Fatalf("failed to configure drain policies: %v", err)
+			}
+			handler.Chain = policies
+		}
+		if len(config.Launch) > 0 {
+			launch, err := lcmgr.NewChain(config.Launch, identity, handler)
+			if err != nil {
+				log.Fatalf("failed to configure launch pipeline: %v", err)
+			}
+			handler.Launch = launch
+		}
+		if len(config.Rollback) > 0 {
+			rollback, err := lcmgr.NewChain(config.Rollback, identity, handler)
+			if err != nil {
+				log.Fatalf("failed to configure launch rollback: %v", err)
+			}
+			handler.Rollback = rollback
+		}
+	}
+
+	return handler
+}
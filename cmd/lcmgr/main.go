@@ -1,68 +1,268 @@
 package main
 
 import (
-	"context"
+	"fmt"
 	"log"
-	"os"
-	"os/signal"
+	"strings"
 
 	"github.com/vanstee/lcmgr"
-	"golang.org/x/sync/errgroup"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
-	service           = kingpin.Flag("service", "Name of systemd unit to monitor").Required().Short('s').String()
-	spotInterval      = kingpin.Flag("spot-interval", "Interval to wait between checking for a spot notice").Default("30s").Short('i').Duration()
-	heartbeatInterval = kingpin.Flag("heartbeat-interval", "Interval to wait between sending heartbeats").Default("1m").Short('t').Duration()
+	configPath          = kingpin.Flag("config", "Path to JSON config file, flags take precedence over its values").Short('c').String()
+	services            = kingpin.Flag("service", "Name of systemd unit or windows service to monitor, may be repeated").Short('s').Strings()
+	serviceOrder        = kingpin.Flag("service-order", "How to order multiple services: auto to stop dependents first using systemd dependencies, or config to use the given order (default auto)").Enum(lcmgr.AutoServiceOrder, lcmgr.ConfigServiceOrder)
+	spotInterval        = kingpin.Flag("spot-interval", "Interval to wait between checking for a spot notice (default 30s)").Short('i').Duration()
+	heartbeatInterval   = kingpin.Flag("heartbeat-interval", "Interval to wait between sending heartbeats when the lifecycle hook's heartbeat timeout is unknown, otherwise a third of the timeout is used (default 1m)").Short('t').Duration()
+	serviceBackend      = kingpin.Flag("service-backend", "How to control the service: auto, dbus, systemctl, or kubernetes to drain the node (default auto)").Enum(lcmgr.AutoBackend, lcmgr.DBusBackend, lcmgr.SystemctlBackend, lcmgr.KubernetesBackend)
+	stateDir            = kingpin.Flag("state-dir", "Directory to keep lcmgr state in (default "+lcmgr.DefaultStateDir+")").String()
+	dbusAddress         = kingpin.Flag("dbus-address", "D-Bus address to reach systemd on, e.g. unix:path=/host/run/dbus/system_bus_socket when running in a container with the host socket mounted").String()
+	hostPID             = kingpin.Flag("host-pid", "Run systemctl in the host's namespaces through nsenter, requires running in the host PID namespace (docker --pid=host) with CAP_SYS_ADMIN").Bool()
+	webhooks            = kingpin.Flag("webhook", "URL to POST notifications to as JSON, may be repeated").Strings()
+	eventBridgeQueues   = kingpin.Flag("eventbridge-queue", "URL of an SQS queue receiving Auto Scaling lifecycle action, EC2 spot interruption or rebalance recommendation events from an EventBridge rule, may be repeated").Strings()
+	scheduledLookahead  = kingpin.Flag("scheduled-action-lookahead", "Warn about scheduled scaling actions starting within this window, disabled when zero").Duration()
+	adaptiveSpot        = kingpin.Flag("adaptive-spot-polling", "Poll less often on on-demand or scale-in protected instances and more often after a rebalance recommendation").Bool()
+	fastCompletion      = kingpin.Flag("fast-completion", "Complete termination lifecycle actions immediately when the service is already stopped, masked, or missing").Bool()
+	missingService      = kingpin.Flag("missing-service", "What to do when the service doesn't exist: fail or skip (default fail)").Enum(lcmgr.MissingServiceFail, lcmgr.MissingServiceSkip)
+	drainTarget         = kingpin.Flag("drain-target", "systemd target to start when a drain begins so other units can hook into it, e.g. "+lcmgr.DefaultDrainTarget).String()
+	metricsAddress      = kingpin.Flag("metrics-address", "Address to serve prometheus metrics on, e.g. :9753, disabled when empty").String()
+	lowMemory           = kingpin.Flag("low-memory", "Reduce memory use on small instances by running on a single CPU, collecting garbage more often, and returning memory to the OS after each notice").Bool()
+	noticeSLO           = kingpin.Flag("notice-slo", "Warn and count a breach when handling a notice takes, or is projected to take, longer than this, disabled when zero").Duration()
+	adminAddress        = kingpin.Flag("admin-address", "Address to serve the local admin API on for sibling agents, e.g. 127.0.0.1:9754 or unix:/run/lcmgr.sock, disabled when empty").String()
+	debug               = kingpin.Flag("debug", "Log verbosely, including the request ID, retries, and latency of every AWS API call").Bool()
+	mode                = kingpin.Flag("mode", "What lcmgr does with notices: manage to drain services and complete lifecycle actions, or notify-only to only forward notices, write flag files, and export metrics (default manage)").Enum(lcmgr.ManageMode, lcmgr.NotifyOnlyMode)
+	flagDir             = kingpin.Flag("flag-dir", "Directory to write notice flag files to in notify-only mode (default "+lcmgr.DefaultFlagDir+")").String()
+	drainOnRebalance    = kingpin.Flag("drain-on-rebalance", "Drain when EC2 recommends rebalancing a spot instance instead of waiting for the interruption notice").Bool()
+	detectShutdown      = kingpin.Flag("detect-shutdown", "Run last-gasp actions when the operating system shuts down without a notice, using a logind inhibitor lock, or the preshutdown notification when running as a windows service").Bool()
+	drainOnMaintenance  = kingpin.Flag("drain-on-maintenance", "Drain before EC2 scheduled system-reboot and system-maintenance events start").Bool()
+	inhibitShutdown     = kingpin.Flag("inhibit-shutdown", "Delay operating system shutdowns while a drain is in progress, using a logind inhibitor lock").Bool()
+	otlpEndpoint        = kingpin.Flag("otlp-endpoint", "Base URL of an OpenTelemetry collector to export metrics and logs to over OTLP/HTTP, e.g. http://localhost:4318 (default $OTEL_EXPORTER_OTLP_ENDPOINT)").String()
+	diagnostics         = kingpin.Flag("diagnostics", "Serve pprof profiles on the admin address and dump goroutines and a heap profile to the state directory on SIGQUIT instead of exiting").Bool()
+	abandonOnFailure    = kingpin.Flag("abandon-on-failure", "Notice type, launch or termination, whose lifecycle action is completed with ABANDON instead of CONTINUE when handling it fails, may be repeated").Enums("launch", "termination")
+	redactLogs          = kingpin.Flag("redact-logs", "Shorten lifecycle action tokens, queue URLs, and webhook URLs in logs to a prefix").Bool()
+	sqsRoleARN          = kingpin.Flag("sqs-role-arn", "Role to assume for SQS calls when lifecycle hook queues live in another account").String()
+	identityCert        = kingpin.Flag("identity-certificate", "PEM encoded AWS public certificate for the region, used to verify the signed instance identity document included in webhook payloads").String()
+	awsRegion           = kingpin.Flag("aws-region", "AWS region to use instead of the instance's region").String()
+	awsEndpoint         = kingpin.Flag("aws-endpoint", "URL to send every AWS API call to, e.g. http://localhost:4566 for LocalStack, see --aws-service-endpoint for VPC endpoints").String()
+	webhookSecret       = kingpin.Flag("webhook-secret", "Secret shared with webhook receivers to sign payloads with HMAC-SHA256").Envar("LCMGR_WEBHOOK_SECRET").String()
+	drainOnDegraded     = kingpin.Flag("drain-on-degraded", "Drain when the instance's EC2 system or instance status checks fail").Bool()
+	supervise           = kingpin.Flag("supervise", "Restart managed services that fail between notices, backing off between restarts and alerting webhooks").Bool()
+	snsSubscriptions    = kingpin.Flag("sns-subscription", "ARN of an SNS subscription feeding this instance's queue to filter to lifecycle notifications for this instance, may be repeated").Strings()
+	suspendProcesses    = kingpin.Flag("suspend-processes", "Let the admin API suspend and resume the group's scaling processes around maintenance, resuming them after a safety timeout").Bool()
+	xray                = kingpin.Flag("xray", "Send X-Ray segments for handling each notice, with subsegments for its AWS calls, to the X-Ray daemon at $AWS_XRAY_DAEMON_ADDRESS (default "+lcmgr.DefaultXRayDaemonAddress+")").Bool()
+	refreshWait         = kingpin.Flag("refresh-wait", "During an instance refresh that launches replacements first, wait up to this long for them to be InService before completing termination lifecycle actions, disabled when zero").Duration()
+	deregisterLBs       = kingpin.Flag("deregister-load-balancers", "Deregister from the target groups and classic load balancers the instance is registered with, and wait for connection draining, before stopping services on termination notices").Bool()
+	noASG               = kingpin.Flag("no-asg", "Run standalone without an auto scaling group, handling only spot interruption and rebalance notices. Detected automatically when the instance is not in a group").Bool()
+	preset              = kingpin.Flag("preset", "Built-in configuration for a well-known stack that fills in services, steps and launch steps left unset: "+strings.Join(lcmgr.PresetNames(), ", ")).Enum(lcmgr.PresetNames()...)
+	queueRefresh        = kingpin.Flag("queue-refresh-interval", "Interval to rediscover lifecycle hook queues at, so hooks added after startup are listened on. They are also rediscovered on SIGHUP. Disabled when zero").Duration()
+	ephemeralQueue      = kingpin.Flag("ephemeral-queue", "Create an SQS queue for this instance alone, subscribed to the SNS topics the lifecycle hooks notify and filtered to its notifications, and delete it on shutdown. Queues left by instances that were killed can be deleted with cleanup-queues").Bool()
+	imdsEndpoint        = kingpin.Flag("imds-endpoint", "URL of the instance metadata service, e.g. a proxy in front of it in a container (default http://169.254.169.254)").String()
+	imdsTimeout         = kingpin.Flag("imds-timeout", "Timeout of each instance metadata request, lower it so IMDSv1 is fallen back to quickly when the hop limit is too low for a container (default 5s)").Duration()
+	imdsRetries         = kingpin.Flag("imds-retries", "Times to retry a failed instance metadata request, with backoff, before declaring metadata unavailable").Int()
+	awsServiceEndpoints = kingpin.Flag("aws-service-endpoint", "Endpoint to send one service's AWS API calls to, e.g. sqs=https://vpce-....sqs.us-east-1.vpce.amazonaws.com for an interface VPC endpoint, may be repeated. Services are autoscaling, ec2, elasticloadbalancing, elasticloadbalancingv2, globalaccelerator, s3, sns, sqs, ssm and sts").PlaceHolder("SERVICE=URL").StringMap()
+
+	listenCommand = kingpin.Command("listen", "Listen for notices and manage the service (default)").Default()
 )
 
 func main() {
-	kingpin.Parse()
-
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt)
-
-	notices := make(chan lcmgr.Notice)
-
-	client := lcmgr.NewAWSClient()
-
-	queues, err := client.GetLifecycleNoticeQueues(context.Background())
-	if err != nil {
-		log.Fatalf("failed to get lifecycle hooks: %v", err)
+	switch kingpin.Parse() {
+	case listenCommand.FullCommand():
+		if err := lcmgr.RunService("lcmgr", listen); err != nil {
+			log.Fatalf("failed to run as a windows service: %v", err)
+		}
+	case runCommand.FullCommand():
+		runWrapped()
+	case k8sManifestCommand.FullCommand():
+		k8sManifest()
+	case versionCommand.FullCommand():
+		version()
+	case benchCommand.FullCommand():
+		benchDrain()
+	case bootstrapCommand.FullCommand():
+		bootstrap()
+	case debugBundleCommand.FullCommand():
+		debugBundle()
+	case verifyImageCommand.FullCommand():
+		verifyImage()
+	case iamPolicyCommand.FullCommand():
+		iamPolicy()
+	case infraCommand.FullCommand():
+		infra()
+	case cleanupQueuesCommand.FullCommand():
+		cleanupQueues()
 	}
+}
 
-	listeners := make([]lcmgr.Listener, 0, len(queues)+1)
-	listeners = append(listeners, lcmgr.NewSpotListener(notices, *spotInterval, client))
-	for _, queue := range queues {
-		listeners = append(listeners, lcmgr.NewLifecycleListener(notices, queue, client))
+// loadConfig reads the config file, if any, and applies flags on top of it.
+func loadConfig() (*lcmgr.Config, error) {
+	config := lcmgr.DefaultConfig()
+	if *configPath != "" {
+		var err error
+		config, err = lcmgr.LoadConfig(*configPath)
+		if err != nil {
+			return nil, err
+		}
 	}
-
-	ctx, cancel := context.WithCancel(context.Background())
-	group, ctx := errgroup.WithContext(ctx)
-	for _, listener := range listeners {
-		listener := listener
-		group.Go(func() error {
-			return listener.Listen(ctx)
-		})
+	if len(*services) > 0 {
+		config.Service = ""
+		config.Services = *services
 	}
-
-	handler := lcmgr.NewServiceHandler(*service, *heartbeatInterval, client)
-
-	for ctx.Err() != nil {
-		var notice lcmgr.Notice
-		select {
-		case notice = <-notices:
-			if err := handler.Handle(ctx, notice); err != nil {
-				log.Printf("failed to handle %v notice: %v", notice.Type(), err)
-			}
-		case <-signals:
-			log.Printf("received signal, shutting down")
-			cancel()
+	if *serviceOrder != "" {
+		config.ServiceOrder = *serviceOrder
+	}
+	if *spotInterval != 0 {
+		config.SpotInterval = lcmgr.Duration(*spotInterval)
+	}
+	if *heartbeatInterval != 0 {
+		config.HeartbeatInterval = lcmgr.Duration(*heartbeatInterval)
+	}
+	if *serviceBackend != "" {
+		config.ServiceBackend = *serviceBackend
+	}
+	if *stateDir != "" {
+		config.StateDir = *stateDir
+	}
+	if *dbusAddress != "" {
+		config.DBusAddress = *dbusAddress
+	}
+	if *hostPID {
+		config.HostPID = true
+	}
+	if len(*webhooks) > 0 {
+		config.Webhooks = *webhooks
+	}
+	if len(*eventBridgeQueues) > 0 {
+		config.EventBridgeQueues = *eventBridgeQueues
+	}
+	if *adaptiveSpot {
+		config.AdaptiveSpot = true
+	}
+	if *fastCompletion {
+		config.FastCompletion = true
+	}
+	if *missingService != "" {
+		config.MissingService = *missingService
+	}
+	if *drainTarget != "" {
+		config.DrainTarget = *drainTarget
+	}
+	if *metricsAddress != "" {
+		config.MetricsAddress = *metricsAddress
+	}
+	if *scheduledLookahead != 0 {
+		config.ScheduledActionLookahead = lcmgr.Duration(*scheduledLookahead)
+	}
+	if *lowMemory {
+		config.LowMemory = true
+	}
+	if *noticeSLO != 0 {
+		config.NoticeSLO = lcmgr.Duration(*noticeSLO)
+	}
+	if *adminAddress != "" {
+		config.AdminAddress = *adminAddress
+	}
+	if *debug {
+		config.Debug = true
+	}
+	if *mode != "" {
+		config.Mode = *mode
+	}
+	if *flagDir != "" {
+		config.FlagDir = *flagDir
+	}
+	if *drainOnRebalance {
+		config.DrainOnRebalance = true
+	}
+	if *detectShutdown {
+		config.DetectShutdown = true
+	}
+	if *drainOnMaintenance {
+		config.DrainOnMaintenance = true
+	}
+	if *inhibitShutdown {
+		config.InhibitShutdown = true
+	}
+	if *otlpEndpoint != "" {
+		config.OTLPEndpoint = *otlpEndpoint
+	}
+	if *diagnostics {
+		config.Diagnostics = true
+	}
+	if len(*abandonOnFailure) > 0 {
+		config.AbandonOnFailure = *abandonOnFailure
+	}
+	if *redactLogs {
+		config.RedactLogs = true
+	}
+	if *sqsRoleARN != "" {
+		config.SQSRoleARN = *sqsRoleARN
+	}
+	if *identityCert != "" {
+		config.IdentityCert = *identityCert
+	}
+	if *awsRegion != "" {
+		config.AWSRegion = *awsRegion
+	}
+	if *awsEndpoint != "" {
+		config.AWSEndpoint = *awsEndpoint
+	}
+	if *webhookSecret != "" {
+		config.WebhookSecret = *webhookSecret
+	}
+	if *drainOnDegraded {
+		config.DrainOnDegraded = true
+	}
+	if *supervise {
+		config.Supervise = true
+	}
+	if len(*snsSubscriptions) > 0 {
+		config.SNSSubscriptions = *snsSubscriptions
+	}
+	if *suspendProcesses {
+		config.SuspendProcesses = true
+	}
+	if *xray {
+		config.XRay = true
+	}
+	if *refreshWait != 0 {
+		config.RefreshWait = lcmgr.Duration(*refreshWait)
+	}
+	if *deregisterLBs {
+		config.DeregisterLBs = true
+	}
+	if *noASG {
+		config.NoASG = true
+	}
+	if *preset != "" {
+		config.Preset = *preset
+	}
+	if err := config.ApplyPreset(); err != nil {
+		return nil, err
+	}
+	if *queueRefresh != 0 {
+		config.QueueRefresh = lcmgr.Duration(*queueRefresh)
+	}
+	if *ephemeralQueue {
+		config.EphemeralQueue = true
+	}
+	if *imdsEndpoint != "" {
+		config.IMDSEndpoint = *imdsEndpoint
+	}
+	if *imdsTimeout != 0 {
+		config.IMDSTimeout = lcmgr.Duration(*imdsTimeout)
+	}
+	if *imdsRetries != 0 {
+		config.IMDSRetries = *imdsRetries
+	}
+	if len(*awsServiceEndpoints) > 0 {
+		config.AWSEndpoints = *awsServiceEndpoints
+	}
+	for service := range config.AWSEndpoints {
+		if !lcmgr.IsEndpointService(service) {
+			return nil, fmt.Errorf("unknown service %q in aws endpoints", service)
 		}
 	}
 
-	if err := group.Wait(); err != nil {
-		log.Fatalf("failed while listening: %v", err)
-	}
+	return config, nil
 }
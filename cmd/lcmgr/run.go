@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/vanstee/lcmgr"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	runCommand    = kingpin.Command("run", "Run a command while heartbeating a lifecycle action, completing it with CONTINUE or ABANDON based on the exit code")
+	runHookName   = runCommand.Flag("hook-name", "Name of the lifecycle hook").Required().String()
+	runToken      = runCommand.Flag("token", "Lifecycle action token").Required().String()
+	runTransition = runCommand.Flag("transition", "Lifecycle transition: launch or termination").Default("termination").Enum("launch", "termination")
+	runArgs       = runCommand.Arg("command", "Command to run, after --").Required().Strings()
+)
+
+func runWrapped() {
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	interval := time.Duration(config.HeartbeatInterval)
+	if interval == 0 {
+		interval = time.Minute
+	}
+
+	var notice lcmgr.Notice
+	switch *runTransition {
+	case "launch":
+		notice = lcmgr.NewLaunchNotice(*runHookName, *runToken)
+	default:
+		notice = lcmgr.NewTerminationNotice(*runHookName, *runToken)
+	}
+
+	lcmgr.Debug = config.Debug
+	client := lcmgr.NewAWSClient(awsOptions(config)...)
+	runner := lcmgr.NewCommandRunner(*runArgs, interval, client)
+
+	code, err := runner.Run(context.Background(), notice)
+	if err != nil {
+		log.Fatalf("failed to run command: %v", err)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/vanstee/lcmgr"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	verifyImageCommand = kingpin.Command("verify-image", "Check the config, services, step commands and IAM policy while baking an image, exiting non-zero if the image would fail to drain")
+	verifyImagePolicy  = verifyImageCommand.Flag("policy", "IAM policy document for the instance role to check required actions against").ExistingFile()
+)
+
+func verifyImage() {
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	manager, err := lcmgr.NewServiceManager(config)
+	if err != nil {
+		log.Fatalf("failed to create service manager: %v", err)
+	}
+
+	problems := lcmgr.NewImageVerifier(config, manager, *verifyImagePolicy).Verify(context.Background())
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	if len(problems) > 0 {
+		log.Fatalf("image failed verification with %d problems", len(problems))
+	}
+	if *verifyImagePolicy == "" {
+		fmt.Println("image verified, without an IAM policy to check")
+		return
+	}
+	fmt.Println("image verified")
+}
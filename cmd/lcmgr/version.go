@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/vanstee/lcmgr"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	versionCommand = kingpin.Command("version", "Print version and build information")
+	versionSBOM    = versionCommand.Flag("sbom", "Print an SPDX JSON software bill of materials for this binary instead").Bool()
+)
+
+func version() {
+	if *versionSBOM {
+		if err := lcmgr.WriteSBOM(os.Stdout); err != nil {
+			log.Fatalf("failed to write sbom: %v", err)
+		}
+		return
+	}
+	fmt.Println(lcmgr.VersionString())
+}
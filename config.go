@@ -0,0 +1,363 @@
+package lcmgr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"time"
+)
+
+type Config struct {
+	Service           string            `json:"service"`
+	Services          []string          `json:"services"`
+	ServiceOrder      string            `json:"service_order"`
+	SpotInterval      Duration          `json:"spot_interval"`
+	HeartbeatInterval Duration          `json:"heartbeat_interval"`
+	ServiceBackend    string            `json:"service_backend"`
+	StateDir          string            `json:"state_dir"`
+	AdaptiveSpot      bool              `json:"adaptive_spot_polling"`
+	FastCompletion    bool              `json:"fast_completion"`
+	DeregisterLBs     bool              `json:"deregister_load_balancers"`
+	NoASG             bool              `json:"no_asg"`
+	EphemeralQueue    bool              `json:"ephemeral_queue"`
+	DrainOnRebalance  bool              `json:"drain_on_rebalance"`
+	DrainOnDegraded   bool              `json:"drain_on_degraded"`
+	DetectShutdown    bool              `json:"detect_shutdown"`
+	InhibitShutdown   bool              `json:"inhibit_shutdown"`
+	Supervise         bool              `json:"supervise"`
+	SuspendProcesses  bool              `json:"suspend_processes"`
+	MissingService    string            `json:"missing_service"`
+	DrainTarget       string            `json:"drain_target"`
+	AbandonOnFailure  []string          `json:"abandon_on_failure"`
+	DBusAddress       string            `json:"dbus_address"`
+	HostPID           bool              `json:"host_pid"`
+	Webhooks          []string          `json:"webhooks"`
+	WebhookSecret     string            `json:"webhook_secret"`
+	IdentityCert      string            `json:"identity_certificate"`
+	SQSRoleARN        string            `json:"sqs_role_arn"`
+	AWSRegion         string            `json:"aws_region"`
+	AWSEndpoint       string            `json:"aws_endpoint"`
+	AWSEndpoints      map[string]string `json:"aws_endpoints"`
+	IMDSEndpoint      string            `json:"imds_endpoint"`
+	IMDSTimeout       Duration          `json:"imds_timeout"`
+	IMDSRetries       int               `json:"imds_retries"`
+	EventBridgeQueues []string          `json:"eventbridge_queues"`
+	SNSSubscriptions  []string          `json:"sns_subscriptions"`
+	MetricsAddress    string            `json:"metrics_address"`
+	OTLPEndpoint      string            `json:"otlp_endpoint"`
+	XRay              bool              `json:"xray"`
+	Debug             bool              `json:"debug"`
+	RedactLogs        bool              `json:"redact_logs"`
+	RedactPatterns    []string          `json:"redact_patterns"`
+	Diagnostics       bool              `json:"diagnostics"`
+	Mode              string            `json:"mode"`
+	Preset            string            `json:"preset"`
+	FlagDir           string            `json:"flag_dir"`
+	AdminAddress      string            `json:"admin_address"`
+	NoticeSLO         Duration          `json:"notice_slo"`
+	RefreshWait       Duration          `json:"refresh_wait"`
+	QueueRefresh      Duration          `json:"queue_refresh_interval"`
+	LowMemory         bool              `json:"low_memory"`
+
+	ScheduledActionInterval  Duration `json:"scheduled_action_interval"`
+	ScheduledActionLookahead Duration `json:"scheduled_action_lookahead"`
+
+	DrainOnMaintenance bool     `json:"drain_on_maintenance"`
+	MaintenanceLead    Duration `json:"maintenance_lead"`
+
+	Steps    []StepConfig   `json:"steps"`
+	Launch   []StepConfig   `json:"launch"`
+	Rollback []StepConfig   `json:"rollback"`
+	Policies []PolicyConfig `json:"policies"`
+	LastGasp []StepConfig   `json:"last_gasp"`
+
+	Linux   *Profile `json:"linux"`
+	Windows *Profile `json:"windows"`
+}
+
+// Profile holds settings that only apply on a single operating system, so one
+// config file can be baked into both Linux and Windows images. Non-zero
+// profile values override the top-level ones.
+type Profile struct {
+	Service           string   `json:"service"`
+	Services          []string `json:"services"`
+	SpotInterval      Duration `json:"spot_interval"`
+	HeartbeatInterval Duration `json:"heartbeat_interval"`
+	ServiceBackend    string   `json:"service_backend"`
+	StateDir          string   `json:"state_dir"`
+}
+
+// PolicyConfig runs Steps instead of the top-level steps for notices that
+// arrive between two times of day, e.g. "09:00-17:00", on Days (mon, tue,
+// ...) in Timezone. The first matching policy wins.
+type PolicyConfig struct {
+	Name     string       `json:"name"`
+	Between  string       `json:"between"`
+	Days     []string     `json:"days"`
+	Timezone string       `json:"timezone"`
+	Steps    []StepConfig `json:"steps"`
+}
+
+// StepConfig describes one step of a handler chain. A step either runs a
+// command (exec), starts or stops the managed services (service), sheds load
+// gradually (shed), drains the instance from load balancer target groups
+// (load_balancer), dials down its Global Accelerator endpoint
+// (global_accelerator), signals a unit (signal), downloads a file
+// (download), prefetches S3 objects (prefetch), registers the instance with
+// target groups (register), waits for a health check to pass (health),
+// waits for an operator's approval (approval), waits until the cluster can
+// safely lose the instance (quorum), snoozes the rest of the drain (snooze),
+// or drains a group of resources in parallel (group). Only the action fields
+// of group members are used. Optional steps are skipped when the notice's
+// deadline is less than 30s away.
+type StepConfig struct {
+	Name              string                   `json:"name"`
+	Exec              []string                 `json:"exec"`
+	Service           string                   `json:"service"`
+	Shed              *ShedConfig              `json:"shed"`
+	LoadBalancer      *LoadBalancerConfig      `json:"load_balancer"`
+	GlobalAccelerator *GlobalAcceleratorConfig `json:"global_accelerator"`
+	Signal            *SignalConfig            `json:"signal"`
+	Download          *DownloadConfig          `json:"download"`
+	Prefetch          *PrefetchConfig          `json:"prefetch"`
+	Register          *RegisterConfig          `json:"register"`
+	Health            *HealthConfig            `json:"health"`
+	Approval          *ApprovalConfig          `json:"approval"`
+	Quorum            *QuorumConfig            `json:"quorum"`
+	Snooze            Duration                 `json:"snooze"`
+	Group             []StepConfig             `json:"group"`
+	Retries           int                      `json:"retries"`
+	Timeout           Duration                 `json:"timeout"`
+	ContinueOnError   bool                     `json:"continue_on_error"`
+	Optional          bool                     `json:"optional"`
+	When              *WhenConfig              `json:"when"`
+	Breaker           *BreakerConfig           `json:"breaker"`
+}
+
+// ShedConfig configures a ShedHandler.
+type ShedConfig struct {
+	URL      string   `json:"url"`
+	Steps    int      `json:"steps"`
+	Duration Duration `json:"duration"`
+}
+
+// LoadBalancerConfig configures a LoadBalancerDrainHandler. Discover adds the
+// target groups and classic load balancers the instance is registered with to
+// the configured ones.
+type LoadBalancerConfig struct {
+	TargetGroups   []string `json:"target_groups"`
+	Classic        []string `json:"classic_load_balancers"`
+	Discover       bool     `json:"discover"`
+	ConnectionsURL string   `json:"connections_url"`
+	Threshold      int      `json:"threshold"`
+	Interval       Duration `json:"interval"`
+	CapToDeadline  bool     `json:"cap_to_deadline"`
+}
+
+// GlobalAcceleratorConfig configures a GlobalAcceleratorDrainHandler.
+type GlobalAcceleratorConfig struct {
+	EndpointGroup  string   `json:"endpoint_group"`
+	EndpointID     string   `json:"endpoint_id"`
+	ConnectionsURL string   `json:"connections_url"`
+	Threshold      int      `json:"threshold"`
+	Wait           Duration `json:"wait"`
+}
+
+// SignalConfig configures a SignalHandler. Signal defaults to HUP.
+type SignalConfig struct {
+	Service string `json:"service"`
+	Signal  string `json:"signal"`
+}
+
+// DownloadConfig configures a DownloadHandler.
+type DownloadConfig struct {
+	URL    string `json:"url"`
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// PrefetchConfig configures a PrefetchHandler.
+type PrefetchConfig struct {
+	Objects     []PrefetchObject `json:"objects"`
+	Parallelism int              `json:"parallelism"`
+}
+
+// RegisterConfig configures a RegisterHandler.
+type RegisterConfig struct {
+	TargetGroups []string `json:"target_groups"`
+	Port         int64    `json:"port"`
+}
+
+// HealthConfig configures a HealthHandler.
+type HealthConfig struct {
+	URL      string   `json:"url"`
+	Interval Duration `json:"interval"`
+}
+
+// ApprovalConfig configures an ApprovalHandler.
+type ApprovalConfig struct {
+	SSMParameter string   `json:"ssm_parameter"`
+	Webhook      string   `json:"webhook"`
+	Interval     Duration `json:"interval"`
+}
+
+// QuorumConfig configures a QuorumHandler.
+type QuorumConfig struct {
+	URL      string   `json:"url"`
+	Interval Duration `json:"interval"`
+}
+
+// BreakerConfig puts a circuit breaker around a step. Steps with the same
+// Name share a breaker, which defaults to the step's name, and the step is
+// skipped while it's open. Failures defaults to 3 and Cooldown to 1m. The
+// load-balancer and global-accelerator breakers also guard lcmgr's own calls
+// to those APIs.
+type BreakerConfig struct {
+	Name     string   `json:"name"`
+	Failures int      `json:"failures"`
+	Cooldown Duration `json:"cooldown"`
+}
+
+// WhenConfig restricts a step to notices of the given types whose metadata
+// (see NoticeMetadata) matches every key in Match and, when set, for which
+// Expr evaluates to true.
+type WhenConfig struct {
+	Notice []string          `json:"notice"`
+	Match  map[string]string `json:"match"`
+	Expr   string            `json:"expr"`
+}
+
+type Duration time.Duration
+
+func DefaultConfig() *Config {
+	return &Config{
+		SpotInterval:      Duration(30 * time.Second),
+		HeartbeatInterval: Duration(time.Minute),
+		StateDir:          DefaultStateDir,
+		Mode:              ManageMode,
+		FlagDir:           DefaultFlagDir,
+		OTLPEndpoint:      os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+
+		ScheduledActionInterval: Duration(5 * time.Minute),
+		MaintenanceLead:         Duration(DefaultMaintenanceLead),
+	}
+}
+
+func LoadConfig(path string) (*Config, error) {
+	config := DefaultConfig()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %v", path, err)
+	}
+
+	return config.ForOS(runtime.GOOS), nil
+}
+
+func (config *Config) ForOS(goos string) *Config {
+	var profile *Profile
+	switch goos {
+	case "linux":
+		profile = config.Linux
+	case "windows":
+		profile = config.Windows
+	}
+
+	resolved := *config
+	resolved.Linux = nil
+	resolved.Windows = nil
+	if profile == nil {
+		return &resolved
+	}
+
+	if profile.Service != "" || len(profile.Services) > 0 {
+		resolved.Service = profile.Service
+		resolved.Services = profile.Services
+	}
+	if profile.SpotInterval != 0 {
+		resolved.SpotInterval = profile.SpotInterval
+	}
+	if profile.HeartbeatInterval != 0 {
+		resolved.HeartbeatInterval = profile.HeartbeatInterval
+	}
+	if profile.ServiceBackend != "" {
+		resolved.ServiceBackend = profile.ServiceBackend
+	}
+	if profile.StateDir != "" {
+		resolved.StateDir = profile.StateDir
+	}
+
+	return &resolved
+}
+
+// ServiceNames returns every configured service, service followed by
+// services.
+func (config *Config) ServiceNames() []string {
+	var names []string
+	if config.Service != "" {
+		names = append(names, config.Service)
+	}
+	return append(names, config.Services...)
+}
+
+func (when *WhenConfig) Condition(identity *Identity) (Condition, error) {
+	if when == nil {
+		return nil, nil
+	}
+
+	var expression Condition
+	if when.Expr != "" {
+		var err error
+		expression, err = CompileExpression(when.Expr, identity)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func(notice Notice) bool {
+		if len(when.Notice) > 0 && !containsString(when.Notice, notice.Type()) {
+			return false
+		}
+
+		metadata := NoticeMetadata(notice)
+		for key, value := range when.Match {
+			if metadata[key] != value {
+				return false
+			}
+		}
+		return expression == nil || expression(notice)
+	}, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
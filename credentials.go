@@ -0,0 +1,48 @@
+package lcmgr
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+)
+
+// Environment variables injected into pods by EKS for IAM Roles for Service
+// Accounts and EKS Pod Identity.
+const (
+	webIdentityTokenFileEnvVar = "AWS_WEB_IDENTITY_TOKEN_FILE"
+	roleARNEnvVar              = "AWS_ROLE_ARN"
+	podIdentityURIEnvVar       = "AWS_CONTAINER_CREDENTIALS_FULL_URI"
+	podIdentityTokenFileEnvVar = "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"
+)
+
+// credentialOptions adjusts the SDK's default credential chain, which picks
+// up pod level credentials when EKS has configured them (re-reading the
+// rotated pod identity token on every refresh) and falls back to the instance
+// profile otherwise. Web identity sessions are named after the host unless
+// AWS_ROLE_SESSION_NAME is set.
+func credentialOptions() []func(*config.LoadOptions) error {
+	if uri, tokenFile := os.Getenv(podIdentityURIEnvVar), os.Getenv(podIdentityTokenFileEnvVar); uri != "" && tokenFile != "" {
+		log.Printf("using eks pod identity credentials from %s", uri)
+	} else if tokenFile, roleARN := os.Getenv(webIdentityTokenFileEnvVar), os.Getenv(roleARNEnvVar); tokenFile != "" && roleARN != "" {
+		log.Printf("using web identity credentials for role %s", roleARN)
+	}
+
+	return []func(*config.LoadOptions) error{
+		config.WithWebIdentityRoleCredentialOptions(func(options *stscreds.WebIdentityRoleOptions) {
+			if options.RoleSessionName == "" {
+				options.RoleSessionName = defaultRoleSessionName()
+			}
+		}),
+	}
+}
+
+func defaultRoleSessionName() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "lcmgr"
+	}
+	return "lcmgr-" + strings.Split(hostname, ".")[0]
+}
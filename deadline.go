@@ -0,0 +1,229 @@
+package lcmgr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+var staleNoticesCounter = DefaultRegistry.Counter("lcmgr_stale_notices_total", "Notices skipped because the action they announce had already happened or timed out", "notice")
+
+type noticeDeadlineKey struct{}
+
+// noticeDeadlineValue is the deadline set by WithNoticeDeadline, with a timer
+// that expires it by the wall clock once recomputeNoticeDeadline re-arms it.
+type noticeDeadlineValue struct {
+	deadline time.Time
+	expire   context.CancelFunc
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	stopped bool
+}
+
+// WithNoticeDeadline bounds ctx by the time a notice has to be handled by, so
+// every handler, AWS call, and command run for the notice gives up once its
+// budget is spent rather than each keeping its own timeout. The deadline is
+// also kept as a value, since a step timeout can tighten ctx's deadline.
+func WithNoticeDeadline(ctx context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	ctx, expire := context.WithCancel(ctx)
+	value := &noticeDeadlineValue{deadline: deadline, expire: expire}
+	ctx = context.WithValue(ctx, noticeDeadlineKey{}, value)
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	return ctx, func() {
+		cancel()
+		expire()
+		value.stop()
+	}
+}
+
+// NoticeDeadline returns the deadline set by WithNoticeDeadline.
+func NoticeDeadline(ctx context.Context) (time.Time, bool) {
+	value, ok := ctx.Value(noticeDeadlineKey{}).(*noticeDeadlineValue)
+	if !ok {
+		return time.Time{}, false
+	}
+	return value.deadline, true
+}
+
+// recomputeNoticeDeadline re-arms the notice deadline in ctx by the wall
+// clock after the host was suspended. Context deadlines are timed on the
+// monotonic clock, which stops while the host is suspended, so they'd
+// otherwise expire late by the length of the pause. The context is cancelled
+// right away if the deadline passed during the pause.
+func recomputeNoticeDeadline(ctx context.Context, now time.Time) {
+	value, ok := ctx.Value(noticeDeadlineKey{}).(*noticeDeadlineValue)
+	if !ok {
+		return
+	}
+	remaining := value.deadline.Round(0).Sub(now.Round(0))
+	if remaining <= 0 {
+		log.Printf("notice deadline %s passed while the host was suspended", value.deadline.Format(time.RFC3339))
+		value.expire()
+		return
+	}
+
+	value.mu.Lock()
+	defer value.mu.Unlock()
+	if value.stopped {
+		return
+	}
+	if value.timer != nil {
+		value.timer.Stop()
+	}
+	value.timer = time.AfterFunc(remaining, value.expire)
+}
+
+func (value *noticeDeadlineValue) stop() {
+	value.mu.Lock()
+	defer value.mu.Unlock()
+	value.stopped = true
+	if value.timer != nil {
+		value.timer.Stop()
+	}
+}
+
+// completionMargin is kept back from the budget of launches whose hook
+// abandons them on timeout, so lcmgr completes the lifecycle action itself
+// rather than leaving the instance to be terminated.
+const completionMargin = 30 * time.Second
+
+// noticeBudget returns when a notice received at start must be handled by:
+// the notice's deadline, e.g. the termination time for spot notices, or the
+// hook's global timeout for lifecycle notices, less completionMargin for
+// launches that would otherwise be abandoned. Lifecycle notices received
+// from a queue are budgeted from when they were received instead.
+func (handler *ServiceHandler) noticeBudget(notice Notice, start time.Time) (time.Time, bool) {
+	if deadline, ok := noticeDeadlineOf(notice); ok {
+		return deadline, true
+	}
+	if lifecycle := lifecycleNotice(notice); lifecycle != nil && lifecycle.GlobalTimeout > 0 {
+		budget := lifecycle.GlobalTimeout
+		if _, ok := notice.(*LaunchNotice); ok && lifecycle.DefaultResult == AbandonResult && budget > 2*completionMargin {
+			budget -= completionMargin
+		}
+		if !lifecycle.ReceivedAt.IsZero() {
+			start = lifecycle.ReceivedAt
+		}
+		return start.Add(budget), true
+	}
+	return time.Time{}, false
+}
+
+// staleNotice returns why handling a notice at now would be futile, if it
+// would: the spot termination time has passed, or the lifecycle hook's
+// global timeout passed before handling started, so the action can no
+// longer be completed.
+func staleNotice(notice Notice, now time.Time) (string, bool) {
+	switch n := notice.(type) {
+	case *SpotNotice:
+		if deadline, ok := n.Deadline(); ok && now.After(deadline) {
+			return fmt.Sprintf("termination time %s has passed", deadline.Format(time.RFC3339)), true
+		}
+	case *LaunchNotice, *TerminationNotice:
+		if expiry, ok := lifecycleNotice(notice).Expiry(); ok && now.After(expiry) {
+			return fmt.Sprintf("lifecycle hook timed out at %s", expiry.Format(time.RFC3339)), true
+		}
+	}
+	return "", false
+}
+
+// checkStale is staleNotice, plus a heartbeat for lifecycle notices past
+// their heartbeat timeout, since heartbeats sent before the notice was
+// redelivered keep the action alive. The notice is only stale if Auto
+// Scaling no longer knows the action; any other heartbeat failure leaves it
+// to be handled.
+func (handler *ServiceHandler) checkStale(ctx context.Context, notice Notice) (string, bool) {
+	now := handler.Clock.Now()
+	if reason, ok := staleNotice(notice, now); ok {
+		return reason, true
+	}
+	lifecycle := lifecycleNotice(notice)
+	if lifecycle == nil {
+		return "", false
+	}
+	expiry, ok := lifecycle.HeartbeatExpiry()
+	if !ok || !now.After(expiry) {
+		return "", false
+	}
+
+	err := handler.Client.SendHeartbeat(ctx, notice)
+	if isLifecycleActionGone(err) {
+		return fmt.Sprintf("lifecycle action is no longer active after its heartbeat timeout at %s", expiry.Format(time.RFC3339)), true
+	}
+	if err != nil {
+		log.Printf("failed to check %s lifecycle action is still active, handling it anyway: %v", notice.Type(), err)
+	}
+	return "", false
+}
+
+// skipStale records a stale outcome for a notice instead of handling it, and
+// deletes its message so it isn't received again.
+func (handler *ServiceHandler) skipStale(ctx context.Context, notice Notice, reason string) {
+	log.Printf("skipping stale %s notice: %s", notice.Type(), reason)
+	staleNoticesCounter.Inc(notice.Type())
+	ctx, annotations := WithAnnotations(ctx)
+	Annotate(ctx, "outcome", "stale")
+	handler.recordAnnotations(ctx, notice, annotations)
+	acknowledgeNotice(ctx, handler.Client, notice)
+}
+
+// CheckDefaultResults warns about launch hooks that abandon the launch when
+// they time out, terminating the instance, since a slow launch pipeline is
+// fatal there rather than just late.
+func CheckDefaultResults(hooks []*LifecycleHook) {
+	for _, hook := range hooks {
+		if hook.Transition == LaunchLifecycleAction && hook.DefaultResult == AbandonResult {
+			log.Printf("lifecycle hook %s abandons launches that time out, launch notices will be completed %s before its %s timeout", hook.Name, completionMargin, hook.Budget())
+		}
+	}
+}
+
+// completionContext returns a context for completing a lifecycle action
+// after handling it, which still has completionMargin to run when the
+// notice's deadline has already passed.
+func completionContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx.Err() == nil {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(context.WithoutCancel(ctx), completionMargin)
+}
+
+// SetHooks replaces the lifecycle hooks notices are matched against, e.g.
+// once QueueRefresher rediscovers them.
+func (handler *ServiceHandler) SetHooks(hooks []*LifecycleHook) {
+	handler.hooksMu.Lock()
+	defer handler.hooksMu.Unlock()
+	handler.Hooks = hooks
+}
+
+// attachHook copies the timeouts of a lifecycle notice's hook onto it.
+func (handler *ServiceHandler) attachHook(notice Notice) {
+	lifecycle := lifecycleNotice(notice)
+	if lifecycle == nil {
+		return
+	}
+	handler.hooksMu.RLock()
+	defer handler.hooksMu.RUnlock()
+	for _, hook := range handler.Hooks {
+		if hook.Name == lifecycle.LifecycleHookName {
+			lifecycle.SetHook(hook)
+			return
+		}
+	}
+}
+
+// lifecycleNotice returns the lifecycle action of launch and termination
+// notices, or nil.
+func lifecycleNotice(notice Notice) *LifecycleNotice {
+	switch n := notice.(type) {
+	case *LaunchNotice:
+		return n.LifecycleNotice
+	case *TerminationNotice:
+		return n.LifecycleNotice
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,82 @@
+package lcmgr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestHandleRedeliveredLifecycleNotice(t *testing.T) {
+	sent := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	hook := &LifecycleHook{
+		Name:             "launch",
+		Transition:       LaunchLifecycleAction,
+		HeartbeatTimeout: 5 * time.Minute,
+		GlobalTimeout:    time.Hour,
+		DefaultResult:    ContinueResult,
+	}
+	gone := &smithy.GenericAPIError{Code: "ValidationError", Message: "No active Lifecycle Action found with instance ID i-0123456789abcdef0"}
+
+	for _, test := range []struct {
+		name         string
+		since        time.Duration
+		heartbeatErr error
+		heartbeats   int
+		handled      bool
+	}{
+		{
+			name:    "inside heartbeat timeout",
+			since:   time.Minute,
+			handled: true,
+		},
+		{
+			name:       "after heartbeat timeout with a live action",
+			since:      20 * time.Minute,
+			heartbeats: 1,
+			handled:    true,
+		},
+		{
+			name:         "after heartbeat timeout when the heartbeat fails",
+			since:        20 * time.Minute,
+			heartbeatErr: errors.New("connection reset by peer"),
+			heartbeats:   1,
+			handled:      true,
+		},
+		{
+			name:         "after heartbeat timeout with no active action",
+			since:        20 * time.Minute,
+			heartbeatErr: gone,
+			heartbeats:   1,
+		},
+		{
+			name:  "after global timeout",
+			since: 2 * time.Hour,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client := &fakeAWSClient{heartbeatErr: test.heartbeatErr}
+			handler := NewServiceHandler(nil, time.Minute, client, nil)
+			handler.Clock = NewFakeClock(sent.Add(test.since))
+			handler.Hooks = []*LifecycleHook{hook}
+
+			notice := NewLaunchNotice("launch", "token")
+			notice.ReceivedAt = sent
+			if err := handler.Handle(context.Background(), notice); err != nil {
+				t.Fatalf("Handle returned %v", err)
+			}
+
+			if client.heartbeats != test.heartbeats {
+				t.Errorf("sent %d heartbeats before handling, want %d", client.heartbeats, test.heartbeats)
+			}
+			if handled := len(client.completed) == 1; handled != test.handled {
+				t.Errorf("handled is %v, want %v", handled, test.handled)
+			}
+			if client.deleted != 1 {
+				t.Errorf("deleted the message %d times, want 1", client.deleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package lcmgr
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Debug enables verbose logging, including every AWS API call. It must be set
+// before clients are created.
+var Debug = false
+
+func debugf(format string, args ...interface{}) {
+	if Debug {
+		log.Printf("debug: "+format, args...)
+	}
+}
+
+type debugAttemptsKey struct{}
+
+// instrumentConfig logs every attempt of every AWS call made with cfg with
+// its request ID, retry count, and latency, which is what AWS support asks
+// for when heartbeats fail in the middle of an incident.
+func instrumentConfig(cfg *aws.Config) {
+	cfg.APIOptions = append(cfg.APIOptions, func(stack *middleware.Stack) error {
+		if err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("lcmgrDebugCall", debugCall), middleware.Before); err != nil {
+			return err
+		}
+		return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("lcmgrDebugAttempt", debugAttempt), "Retry", middleware.After)
+	})
+}
+
+func debugCall(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	start := time.Now()
+	attempts := 0
+	ctx = middleware.WithStackValue(ctx, debugAttemptsKey{}, &attempts)
+
+	out, metadata, err := next.HandleInitialize(ctx, in)
+	requestID, _ := awsmiddleware.GetRequestIDMetadata(metadata)
+	retries := 0
+	if results, ok := retry.GetAttemptResults(metadata); ok && len(results.Results) > 0 {
+		retries = len(results.Results) - 1
+	}
+	debugf("aws %s.%s finished: %d retries, request id %q, took %v, error: %v",
+		awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx), retries, requestID, time.Since(start), err)
+	return out, metadata, err
+}
+
+func debugAttempt(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+	start := time.Now()
+	attempt := 1
+	if attempts, ok := middleware.GetStackValue(ctx, debugAttemptsKey{}).(*int); ok {
+		*attempts++
+		attempt = *attempts
+	}
+
+	out, metadata, err := next.HandleFinalize(ctx, in)
+	status := 0
+	if response, ok := awsmiddleware.GetRawResponse(metadata).(*smithyhttp.Response); ok {
+		status = response.StatusCode
+	}
+	requestID, _ := awsmiddleware.GetRequestIDMetadata(metadata)
+	debugf("aws %s.%s attempt %d: status %d, request id %q, took %v, error: %v",
+		awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx), attempt, status, requestID, time.Since(start), err)
+	return out, metadata, err
+}
@@ -0,0 +1,60 @@
+package lcmgr
+
+import (
+	"fmt"
+	"log"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"syscall"
+	"time"
+)
+
+// EnableProfiling serves the runtime profiles under /debug/pprof/, e.g.
+// go tool pprof http://localhost:9090/debug/pprof/heap.
+func (api *API) EnableProfiling() {
+	api.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	api.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	api.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	api.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	api.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// DumpOnSignal replaces the runtime's SIGQUIT handling, which dumps every
+// goroutine and exits, with one that keeps lcmgr running. Goroutine stacks
+// are written to stderr and a heap profile to dir, so a hung drain can be
+// diagnosed without abandoning it.
+func DumpOnSignal(dir string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGQUIT)
+	go func() {
+		for range signals {
+			if err := dumpDiagnostics(dir); err != nil {
+				log.Printf("failed to dump diagnostics: %v", err)
+			}
+		}
+	}()
+}
+
+func dumpDiagnostics(dir string) error {
+	fmt.Fprintf(os.Stderr, "lcmgr diagnostics: %d goroutines\n", runtime.NumGoroutine())
+	if err := rpprof.Lookup("goroutine").WriteTo(os.Stderr, 2); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, "heap-"+time.Now().UTC().Format("20060102T150405Z")+".pprof")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := rpprof.Lookup("heap").WriteTo(file, 0); err != nil {
+		return err
+	}
+	log.Printf("wrote goroutine stacks to stderr and heap profile to %s", path)
+	return nil
+}
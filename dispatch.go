@@ -0,0 +1,105 @@
+package lcmgr
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Dispatcher runs listeners and hands the notices they send on Notices to
+// Handler. It makes these guarantees to listeners and handlers:
+//
+//   - Handler.Handle is called for one notice at a time, in the order notices
+//     are received, and never concurrently.
+//   - A notice belongs to the handler once it has been sent. Listeners must
+//     not read or modify it afterwards, and must send a new value for each
+//     notice rather than reusing one.
+//   - Listeners must select on their context's Done channel when sending, so
+//     they return once the dispatcher stops.
+//   - Cancelling the context passed to Run stops the dispatcher between
+//     notices: the notice being handled is finished, the listeners are
+//     cancelled and Run returns once every one of them has.
+//   - A listener that fails cancels the others and the notice being handled,
+//     and Run returns its error.
+//   - Interrupt cancels the notice being handled and the listeners right
+//     away, e.g. when shutting down can't wait for a drain to finish.
+//
+// AfterHandle, if set, is called after each notice is handled.
+type Dispatcher struct {
+	Notices     chan Notice
+	Listeners   []Listener
+	Handler     Handler
+	AfterHandle func()
+
+	mu          sync.Mutex
+	interrupt   context.CancelFunc
+	interrupted bool
+}
+
+func NewDispatcher(notices chan Notice, listeners []Listener, handler Handler) *Dispatcher {
+	return &Dispatcher{
+		Notices:   notices,
+		Listeners: listeners,
+		Handler:   handler,
+	}
+}
+
+// Run dispatches notices until ctx is cancelled or a listener fails.
+func (dispatcher *Dispatcher) Run(ctx context.Context) error {
+	// Notices are handled with a context that outlives ctx so shutting down
+	// doesn't interrupt a drain halfway through.
+	listenCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	dispatcher.mu.Lock()
+	dispatcher.interrupt = cancel
+	if dispatcher.interrupted {
+		cancel()
+	}
+	dispatcher.mu.Unlock()
+	group, listenCtx := errgroup.WithContext(listenCtx)
+	for _, listener := range dispatcher.Listeners {
+		listener := listener
+		group.Go(func() error {
+			return listener.Listen(listenCtx)
+		})
+	}
+
+	for {
+		// Checked on its own first since select picks randomly between ready
+		// cases, and a busy listener could otherwise delay shutdown.
+		if ctx.Err() != nil || listenCtx.Err() != nil {
+			break
+		}
+
+		select {
+		case notice := <-dispatcher.Notices:
+			dispatcher.handle(listenCtx, notice)
+		case <-ctx.Done():
+		case <-listenCtx.Done():
+		}
+	}
+
+	cancel()
+	return group.Wait()
+}
+
+// Interrupt stops Run without waiting for the notice being handled.
+func (dispatcher *Dispatcher) Interrupt() {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	dispatcher.interrupted = true
+	if dispatcher.interrupt != nil {
+		dispatcher.interrupt()
+	}
+}
+
+func (dispatcher *Dispatcher) handle(ctx context.Context, notice Notice) {
+	if err := dispatcher.Handler.Handle(ctx, notice); err != nil {
+		log.Printf("failed to handle %v notice: %v", notice.Type(), err)
+	}
+	if dispatcher.AfterHandle != nil {
+		dispatcher.AfterHandle()
+	}
+}
@@ -0,0 +1,244 @@
+package lcmgr
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sequenceNotice is the seq'th notice sent by listener.
+type sequenceNotice struct {
+	listener int
+	seq      int
+}
+
+func (notice *sequenceNotice) Type() string {
+	return "sequence"
+}
+
+// sequenceListener sends count notices numbered from 0, then fails with err
+// if it's set or waits to be cancelled.
+type sequenceListener struct {
+	notices chan Notice
+	id      int
+	count   int
+	err     error
+}
+
+func (listener *sequenceListener) Type() string {
+	return "sequence"
+}
+
+func (listener *sequenceListener) Listen(ctx context.Context) error {
+	for seq := 0; seq < listener.count; seq++ {
+		select {
+		case listener.notices <- &sequenceNotice{listener: listener.id, seq: seq}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	if listener.err != nil {
+		return listener.err
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// checkingHandler records the notices it handles and fails the test if
+// they're handled concurrently or out of order.
+type checkingHandler struct {
+	t        *testing.T
+	inFlight int32
+	next     map[int]int
+	handled  int32
+	done     chan struct{}
+	total    int32
+}
+
+func newCheckingHandler(t *testing.T, total int) *checkingHandler {
+	return &checkingHandler{
+		t:     t,
+		next:  make(map[int]int),
+		done:  make(chan struct{}),
+		total: int32(total),
+	}
+}
+
+func (handler *checkingHandler) Handle(ctx context.Context, notice Notice) error {
+	if inFlight := atomic.AddInt32(&handler.inFlight, 1); inFlight != 1 {
+		handler.t.Errorf("%d notices handled at once", inFlight)
+	}
+	defer atomic.AddInt32(&handler.inFlight, -1)
+
+	// next is only touched here, so the race detector catches concurrent
+	// calls even when the counter doesn't.
+	sequence := notice.(*sequenceNotice)
+	if want := handler.next[sequence.listener]; sequence.seq != want {
+		handler.t.Errorf("listener %d notice %d handled, want %d", sequence.listener, sequence.seq, want)
+	}
+	handler.next[sequence.listener] = sequence.seq + 1
+
+	if atomic.AddInt32(&handler.handled, 1) == handler.total {
+		close(handler.done)
+	}
+	return nil
+}
+
+func TestDispatcherHandlesNoticesOneAtATime(t *testing.T) {
+	const listenerCount, perListener = 8, 1000
+
+	notices := make(chan Notice)
+	listeners := make([]Listener, 0, listenerCount)
+	for id := 0; id < listenerCount; id++ {
+		listeners = append(listeners, &sequenceListener{notices: notices, id: id, count: perListener})
+	}
+	handler := newCheckingHandler(t, listenerCount*perListener)
+	var afterHandle int32
+	dispatcher := NewDispatcher(notices, listeners, handler)
+	dispatcher.AfterHandle = func() { atomic.AddInt32(&afterHandle, 1) }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- dispatcher.Run(ctx) }()
+
+	select {
+	case <-handler.done:
+	case <-time.After(30 * time.Second):
+		t.Fatalf("only %d of %d notices handled", atomic.LoadInt32(&handler.handled), listenerCount*perListener)
+	}
+	cancel()
+	if err := <-errs; err != nil {
+		t.Fatalf("Run returned %v", err)
+	}
+	if afterHandle != listenerCount*perListener {
+		t.Errorf("AfterHandle called %d times, want %d", afterHandle, listenerCount*perListener)
+	}
+}
+
+// blockingHandler signals started when it handles a notice, then blocks
+// until release is closed or its context is cancelled.
+type blockingHandler struct {
+	started  chan struct{}
+	release  chan struct{}
+	canceled int32
+}
+
+func (handler *blockingHandler) Handle(ctx context.Context, notice Notice) error {
+	close(handler.started)
+	select {
+	case <-handler.release:
+		return nil
+	case <-ctx.Done():
+		atomic.StoreInt32(&handler.canceled, 1)
+		return ctx.Err()
+	}
+}
+
+func TestDispatcherStopCancellation(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		stop      func(cancel context.CancelFunc, dispatcher *Dispatcher)
+		listenErr error
+		canceled  bool
+		err       error
+	}{
+		{
+			name:     "cancelling finishes the notice",
+			stop:     func(cancel context.CancelFunc, dispatcher *Dispatcher) { cancel() },
+			canceled: false,
+		},
+		{
+			name: "interrupting cancels the notice",
+			stop: func(cancel context.CancelFunc, dispatcher *Dispatcher) {
+				cancel()
+				dispatcher.Interrupt()
+			},
+			canceled: true,
+		},
+		{
+			name:      "failing listener cancels the notice",
+			stop:      func(cancel context.CancelFunc, dispatcher *Dispatcher) {},
+			listenErr: errors.New("listener failed"),
+			canceled:  true,
+			err:       errors.New("listener failed"),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			notices := make(chan Notice)
+			handler := &blockingHandler{started: make(chan struct{}), release: make(chan struct{})}
+			listeners := []Listener{&sequenceListener{notices: notices, count: 1}}
+			if test.listenErr != nil {
+				listeners = append(listeners, &failingListener{started: handler.started, err: test.listenErr})
+			}
+			dispatcher := NewDispatcher(notices, listeners, handler)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			errs := make(chan error, 1)
+			go func() { errs <- dispatcher.Run(ctx) }()
+
+			<-handler.started
+			test.stop(cancel, dispatcher)
+
+			var once sync.Once
+			release := func() { once.Do(func() { close(handler.release) }) }
+			defer release()
+			if !test.canceled {
+				// Give a cancellation that shouldn't happen a chance to.
+				time.Sleep(50 * time.Millisecond)
+				release()
+			}
+
+			select {
+			case err := <-errs:
+				if (err == nil) != (test.err == nil) || (err != nil && err.Error() != test.err.Error()) {
+					t.Errorf("Run returned %v, want %v", err, test.err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("Run didn't return")
+			}
+			if canceled := atomic.LoadInt32(&handler.canceled) == 1; canceled != test.canceled {
+				t.Errorf("notice cancelled is %v, want %v", canceled, test.canceled)
+			}
+		})
+	}
+}
+
+func TestDispatcherInterruptBeforeRun(t *testing.T) {
+	notices := make(chan Notice)
+	dispatcher := NewDispatcher(notices, []Listener{&sequenceListener{notices: notices}}, newCheckingHandler(t, 0))
+	dispatcher.Interrupt()
+
+	errs := make(chan error, 1)
+	go func() { errs <- dispatcher.Run(context.Background()) }()
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatalf("Run returned %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run didn't return")
+	}
+}
+
+// failingListener fails with err once the notice is being handled.
+type failingListener struct {
+	started chan struct{}
+	err     error
+}
+
+func (listener *failingListener) Type() string {
+	return "failing"
+}
+
+func (listener *failingListener) Listen(ctx context.Context) error {
+	select {
+	case <-listener.started:
+		return listener.err
+	case <-ctx.Done():
+		return nil
+	}
+}
@@ -0,0 +1,92 @@
+package lcmgr
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Drainable is a resource that sends traffic to the instance and is drained
+// in three phases: Deregister stops new traffic, WaitDrained blocks until
+// existing traffic is gone, and Finalize cleans up afterwards.
+type Drainable interface {
+	Deregister(context.Context, Notice) error
+	WaitDrained(context.Context, Notice) error
+	Finalize(context.Context, Notice) error
+}
+
+// Drain runs each phase of drainable in turn.
+func Drain(ctx context.Context, drainable Drainable, notice Notice) error {
+	if err := drainable.Deregister(ctx, notice); err != nil {
+		return err
+	}
+	if err := drainable.WaitDrained(ctx, notice); err != nil {
+		return err
+	}
+	return drainable.Finalize(ctx, notice)
+}
+
+// AsDrainable returns handler itself if it's Drainable, and otherwise a
+// Drainable that runs it as its Deregister phase.
+func AsDrainable(handler Handler) Drainable {
+	if drainable, ok := handler.(Drainable); ok {
+		return drainable
+	}
+	return &handlerDrainable{handler}
+}
+
+type handlerDrainable struct {
+	Handler
+}
+
+func (drainable *handlerDrainable) Deregister(ctx context.Context, notice Notice) error {
+	return drainable.Handle(ctx, notice)
+}
+
+func (drainable *handlerDrainable) WaitDrained(ctx context.Context, notice Notice) error {
+	return nil
+}
+
+func (drainable *handlerDrainable) Finalize(ctx context.Context, notice Notice) error {
+	return nil
+}
+
+// DrainGroup drains several resources in parallel, phase by phase, so e.g.
+// a load balancer and a service registry are deregistered at the same time
+// and total drain time is that of the slowest rather than the sum. Each
+// phase finishes for every member before the next begins, and a failure
+// cancels the rest of its phase.
+type DrainGroup struct {
+	Drainables []Drainable
+}
+
+func NewDrainGroup(drainables []Drainable) *DrainGroup {
+	return &DrainGroup{Drainables: drainables}
+}
+
+func (group *DrainGroup) Handle(ctx context.Context, notice Notice) error {
+	return Drain(ctx, group, notice)
+}
+
+func (group *DrainGroup) Deregister(ctx context.Context, notice Notice) error {
+	return group.each(ctx, func(drainable Drainable) HandlerFunc { return drainable.Deregister }, notice)
+}
+
+func (group *DrainGroup) WaitDrained(ctx context.Context, notice Notice) error {
+	return group.each(ctx, func(drainable Drainable) HandlerFunc { return drainable.WaitDrained }, notice)
+}
+
+func (group *DrainGroup) Finalize(ctx context.Context, notice Notice) error {
+	return group.each(ctx, func(drainable Drainable) HandlerFunc { return drainable.Finalize }, notice)
+}
+
+func (group *DrainGroup) each(ctx context.Context, phase func(Drainable) HandlerFunc, notice Notice) error {
+	g, ctx := errgroup.WithContext(ctx)
+	for _, drainable := range group.Drainables {
+		f := phase(drainable)
+		g.Go(func() error {
+			return f(ctx, notice)
+		})
+	}
+	return g.Wait()
+}
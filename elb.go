@@ -0,0 +1,131 @@
+package lcmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancing"
+	elbtypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancing/types"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+)
+
+// LoadBalancers are the target groups and classic load balancers the
+// instance is registered with.
+type LoadBalancers struct {
+	TargetGroupARNs []string
+	ClassicNames    []string
+}
+
+// DiscoverLoadBalancers finds the load balancers the instance is registered
+// with. For instances in an auto scaling group these are the group's
+// attached target groups and classic load balancers. Otherwise every target
+// group and classic load balancer in the region is checked for the instance.
+func (client *awsClient) DiscoverLoadBalancers(ctx context.Context) (*LoadBalancers, error) {
+	autoScalingGroupName, err := client.GetAutoScalingGroupName(ctx)
+	if err == nil && autoScalingGroupName != "" {
+		output, err := client.AutoScaling().DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: []string{autoScalingGroupName},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(output.AutoScalingGroups) != 1 {
+			return nil, fmt.Errorf("auto scaling group %s not found", autoScalingGroupName)
+		}
+		group := output.AutoScalingGroups[0]
+		return &LoadBalancers{
+			TargetGroupARNs: group.TargetGroupARNs,
+			ClassicNames:    group.LoadBalancerNames,
+		}, nil
+	}
+
+	return client.scanLoadBalancers(ctx)
+}
+
+func (client *awsClient) scanLoadBalancers(ctx context.Context) (*LoadBalancers, error) {
+	instanceID, err := client.GetInstanceID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	found := &LoadBalancers{}
+	groups := elasticloadbalancingv2.NewDescribeTargetGroupsPaginator(client.ELBV2(), &elasticloadbalancingv2.DescribeTargetGroupsInput{})
+	for groups.HasMorePages() {
+		page, err := groups.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range page.TargetGroups {
+			targets, err := client.GetTargetHealth(ctx, aws.ToString(group.TargetGroupArn))
+			if err != nil {
+				return nil, err
+			}
+			if len(targets) > 0 {
+				found.TargetGroupARNs = append(found.TargetGroupARNs, aws.ToString(group.TargetGroupArn))
+			}
+		}
+	}
+
+	classics := elasticloadbalancing.NewDescribeLoadBalancersPaginator(client.ELB(), &elasticloadbalancing.DescribeLoadBalancersInput{})
+	for classics.HasMorePages() {
+		page, err := classics.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, description := range page.LoadBalancerDescriptions {
+			for _, instance := range description.Instances {
+				if aws.ToString(instance.InstanceId) == instanceID {
+					found.ClassicNames = append(found.ClassicNames, aws.ToString(description.LoadBalancerName))
+				}
+			}
+		}
+	}
+	return found, nil
+}
+
+// DeregisterFromClassicLoadBalancer removes the instance from a classic load
+// balancer, which starts connection draining when it's enabled.
+func (client *awsClient) DeregisterFromClassicLoadBalancer(ctx context.Context, name string) error {
+	instanceID, err := client.GetInstanceID(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.ELB().DeregisterInstancesFromLoadBalancer(ctx, &elasticloadbalancing.DeregisterInstancesFromLoadBalancerInput{
+		LoadBalancerName: aws.String(name),
+		Instances:        []elbtypes.Instance{{InstanceId: aws.String(instanceID)}},
+	})
+	return err
+}
+
+// ClassicLoadBalancerDrained returns true once a classic load balancer has
+// finished draining connections to the instance.
+func (client *awsClient) ClassicLoadBalancerDrained(ctx context.Context, name string) (bool, error) {
+	instanceID, err := client.GetInstanceID(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	output, err := client.ELB().DescribeInstanceHealth(ctx, &elasticloadbalancing.DescribeInstanceHealthInput{
+		LoadBalancerName: aws.String(name),
+		Instances:        []elbtypes.Instance{{InstanceId: aws.String(instanceID)}},
+	})
+	var notRegistered *elbtypes.InvalidEndPointException
+	if errors.As(err, &notRegistered) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, state := range output.InstanceStates {
+		if strings.Contains(strings.ToLower(aws.ToString(state.Description)), "deregistration currently in progress") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
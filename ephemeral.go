@@ -0,0 +1,275 @@
+package lcmgr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// ephemeralQueuePrefix starts the name of every ephemeral queue, followed
+	// by the instance ID, so queues left behind by instances that never shut
+	// lcmgr down can be found by CleanupEphemeralQueues.
+	ephemeralQueuePrefix = "lcmgr-"
+	// ephemeralRetention keeps undelivered notifications as long as a
+	// lifecycle action can be kept open.
+	ephemeralRetention = maxLifecycleActionTimeout
+	// bodyInstanceIDKey is the key of the instance ID in the body of lifecycle
+	// notifications Auto Scaling publishes to SNS.
+	bodyInstanceIDKey = "EC2InstanceId"
+)
+
+// EphemeralQueue is an SQS queue created for the instance alone and
+// subscribed to the SNS topics its group's lifecycle hooks notify, filtered
+// to the instance's notifications. The fleet then doesn't long-poll one
+// shared queue and fight over message visibility.
+type EphemeralQueue struct {
+	Queue            *Queue
+	SubscriptionARNs []string
+}
+
+// CreateEphemeralQueue creates the instance's ephemeral queue, or reuses the
+// one left by a previous run, and subscribes it to every SNS topic the
+// group's lifecycle hooks notify.
+func (client *awsClient) CreateEphemeralQueue(ctx context.Context) (*EphemeralQueue, error) {
+	instanceID, err := client.GetInstanceID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hooks, err := client.GetLifecycleHooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var topics []string
+	for _, hook := range hooks {
+		parsed, err := arn.Parse(hook.NotificationTargetARN)
+		if err != nil || parsed.Service != "sns" || containsString(topics, hook.NotificationTargetARN) {
+			continue
+		}
+		if _, err := client.parsePartitionARN(hook.NotificationTargetARN); err != nil {
+			return nil, fmt.Errorf("lifecycle hook %s: %v", hook.Name, err)
+		}
+		topics = append(topics, hook.NotificationTargetARN)
+	}
+	if len(topics) == 0 {
+		return nil, errors.New("no lifecycle hooks notify an sns topic")
+	}
+
+	name := ephemeralQueuePrefix + instanceID
+	url, queueARN, err := client.CreateQueue(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := ephemeralQueuePolicy(queueARN, topics)
+	if err != nil {
+		return nil, err
+	}
+	_, err = client.SQS().SetQueueAttributes(ctx, &sqs.SetQueueAttributesInput{
+		QueueUrl: aws.String(url),
+		Attributes: map[string]string{
+			string(sqstypes.QueueAttributeNamePolicy):                 policy,
+			string(sqstypes.QueueAttributeNameMessageRetentionPeriod): strconv.Itoa(int(ephemeralRetention.Seconds())),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := InstanceFilterPolicy(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	queue := &EphemeralQueue{Queue: &Queue{Name: name, URL: url}}
+	for _, topic := range topics {
+		// Raw delivery leaves the notification as the message body, and
+		// filtering on it works without Auto Scaling setting attributes.
+		output, err := client.SNS().Subscribe(ctx, &sns.SubscribeInput{
+			TopicArn: aws.String(topic),
+			Protocol: aws.String("sqs"),
+			Endpoint: aws.String(queueARN),
+			Attributes: map[string]string{
+				"RawMessageDelivery": "true",
+				"FilterPolicy":       filter,
+				"FilterPolicyScope":  "MessageBody",
+			},
+			ReturnSubscriptionArn: true,
+		})
+		if err != nil {
+			client.DeleteEphemeralQueue(ctx, queue)
+			return nil, fmt.Errorf("failed to subscribe to %s: %v", topic, err)
+		}
+		queue.SubscriptionARNs = append(queue.SubscriptionARNs, aws.ToString(output.SubscriptionArn))
+	}
+	return queue, nil
+}
+
+// DeleteEphemeralQueue unsubscribes the ephemeral queue from its topics and
+// deletes it.
+func (client *awsClient) DeleteEphemeralQueue(ctx context.Context, queue *EphemeralQueue) error {
+	var failed error
+	for _, subscription := range queue.SubscriptionARNs {
+		if _, err := client.SNS().Unsubscribe(ctx, &sns.UnsubscribeInput{SubscriptionArn: aws.String(subscription)}); err != nil {
+			log.Printf("failed to unsubscribe %s: %v", subscription, err)
+			failed = err
+		}
+	}
+	if _, err := client.SQS().DeleteQueue(ctx, &sqs.DeleteQueueInput{QueueUrl: aws.String(queue.Queue.URL)}); err != nil {
+		return err
+	}
+	return failed
+}
+
+// ephemeralQueuePolicy lets topics send messages to the queue.
+func ephemeralQueuePolicy(queueARN string, topics []string) (string, error) {
+	policy, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{{
+			"Effect":    "Allow",
+			"Principal": map[string]string{"Service": "sns.amazonaws.com"},
+			"Action":    "sqs:SendMessage",
+			"Resource":  queueARN,
+			"Condition": map[string]interface{}{
+				"ArnEquals": map[string][]string{"aws:SourceArn": topics},
+			},
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(policy), nil
+}
+
+// CleanupEphemeralQueues deletes the ephemeral queues of instances that no
+// longer exist, along with their subscriptions. They're left behind when an
+// instance goes away without lcmgr shutting down, e.g. when it's killed or
+// the instance is terminated before lcmgr is stopped. It returns the names
+// of the queues deleted, or that would be when dryRun is set.
+func (client *awsClient) CleanupEphemeralQueues(ctx context.Context, dryRun bool) ([]string, error) {
+	queues := make(map[string]string)
+	paginator := sqs.NewListQueuesPaginator(client.SQS(), &sqs.ListQueuesInput{
+		QueueNamePrefix: aws.String(ephemeralQueuePrefix + "i-"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, url := range page.QueueUrls {
+			name := url[strings.LastIndex(url, "/")+1:]
+			queues[strings.TrimPrefix(name, ephemeralQueuePrefix)] = url
+		}
+	}
+	if len(queues) == 0 {
+		return nil, nil
+	}
+
+	instanceIDs := make([]string, 0, len(queues))
+	for instanceID := range queues {
+		instanceIDs = append(instanceIDs, instanceID)
+	}
+	sort.Strings(instanceIDs)
+	live, err := client.liveInstances(ctx, instanceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var subscriptions map[string][]string
+	var deleted []string
+	for _, instanceID := range instanceIDs {
+		if live[instanceID] {
+			continue
+		}
+		queue := &EphemeralQueue{Queue: &Queue{Name: ephemeralQueuePrefix + instanceID, URL: queues[instanceID]}}
+		if dryRun {
+			deleted = append(deleted, queue.Queue.Name)
+			continue
+		}
+
+		if subscriptions == nil {
+			if subscriptions, err = client.queueSubscriptions(ctx); err != nil {
+				return deleted, err
+			}
+		}
+		attributes, err := client.SQS().GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+			QueueUrl:       aws.String(queue.Queue.URL),
+			AttributeNames: []sqstypes.QueueAttributeName{sqstypes.QueueAttributeNameQueueArn},
+		})
+		if err != nil {
+			return deleted, err
+		}
+		queue.SubscriptionARNs = subscriptions[attributes.Attributes[string(sqstypes.QueueAttributeNameQueueArn)]]
+		if err := client.DeleteEphemeralQueue(ctx, queue); err != nil {
+			return deleted, fmt.Errorf("failed to delete %s: %v", queue.Queue.Name, err)
+		}
+		deleted = append(deleted, queue.Queue.Name)
+	}
+	return deleted, nil
+}
+
+// liveInstances returns which of instanceIDs haven't been terminated.
+func (client *awsClient) liveInstances(ctx context.Context, instanceIDs []string) (map[string]bool, error) {
+	live := make(map[string]bool)
+	// Filters, unlike InstanceIds, don't fail on instances that are gone,
+	// and take at most 200 values.
+	for len(instanceIDs) > 0 {
+		batch := instanceIDs
+		if len(batch) > 200 {
+			batch = batch[:200]
+		}
+		instanceIDs = instanceIDs[len(batch):]
+
+		paginator := ec2.NewDescribeInstancesPaginator(client.EC2(), &ec2.DescribeInstancesInput{
+			Filters: []ec2types.Filter{
+				{Name: aws.String("instance-id"), Values: batch},
+				{Name: aws.String("instance-state-name"), Values: []string{"pending", "running", "shutting-down", "stopping", "stopped"}},
+			},
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, reservation := range page.Reservations {
+				for _, instance := range reservation.Instances {
+					live[aws.ToString(instance.InstanceId)] = true
+				}
+			}
+		}
+	}
+	return live, nil
+}
+
+// queueSubscriptions returns the ARNs of the account's SNS subscriptions by
+// the queue ARN they deliver to.
+func (client *awsClient) queueSubscriptions(ctx context.Context) (map[string][]string, error) {
+	subscriptions := make(map[string][]string)
+	paginator := sns.NewListSubscriptionsPaginator(client.SNS(), &sns.ListSubscriptionsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, subscription := range page.Subscriptions {
+			if aws.ToString(subscription.Protocol) != "sqs" || !arn.IsARN(aws.ToString(subscription.SubscriptionArn)) {
+				continue
+			}
+			endpoint := aws.ToString(subscription.Endpoint)
+			subscriptions[endpoint] = append(subscriptions[endpoint], aws.ToString(subscription.SubscriptionArn))
+		}
+	}
+	return subscriptions, nil
+}
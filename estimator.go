@@ -0,0 +1,100 @@
+package lcmgr
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+var drainEstimateGauge = DefaultRegistry.Gauge("lcmgr_drain_duration_estimate_seconds", "Expected time to drain, weighted towards recent drains", "notice")
+
+const (
+	drainEstimatesKey = "drain-estimates"
+
+	// defaultDrainEstimateWeight is how much each new drain moves the
+	// estimate, higher values forget older drains faster.
+	defaultDrainEstimateWeight = 0.3
+)
+
+// DrainEstimator keeps an exponentially weighted moving average of how long
+// drains take for each notice type, since the steps that run differ by
+// notice. Estimates are persisted to the Store so they survive restarts.
+type DrainEstimator struct {
+	Store  Store
+	Weight float64
+
+	mu        sync.Mutex
+	estimates map[string]*DrainEstimate
+}
+
+type DrainEstimate struct {
+	Seconds float64 `json:"seconds"`
+	Samples int     `json:"samples"`
+}
+
+func NewDrainEstimator(store Store) (*DrainEstimator, error) {
+	estimator := &DrainEstimator{
+		Store:     store,
+		Weight:    defaultDrainEstimateWeight,
+		estimates: make(map[string]*DrainEstimate),
+	}
+	if _, err := store.Get(drainEstimatesKey, &estimator.estimates); err != nil {
+		return nil, err
+	}
+
+	for notice, estimate := range estimator.estimates {
+		drainEstimateGauge.Set(estimate.Seconds, notice)
+	}
+	return estimator, nil
+}
+
+// Record adds a completed drain to the estimate for its notice type.
+func (estimator *DrainEstimator) Record(notice Notice, duration time.Duration) {
+	estimator.mu.Lock()
+	defer estimator.mu.Unlock()
+
+	estimate, ok := estimator.estimates[notice.Type()]
+	if !ok {
+		estimate = &DrainEstimate{Seconds: duration.Seconds()}
+		estimator.estimates[notice.Type()] = estimate
+	} else {
+		estimate.Seconds += estimator.Weight * (duration.Seconds() - estimate.Seconds)
+	}
+	estimate.Samples++
+	drainEstimateGauge.Set(estimate.Seconds, notice.Type())
+
+	if err := estimator.Store.Put(drainEstimatesKey, estimator.estimates); err != nil {
+		log.Printf("failed to save drain estimates: %v", err)
+	}
+}
+
+// Estimate returns the expected drain time for a notice type, if any drains
+// have been recorded.
+func (estimator *DrainEstimator) Estimate(noticeType string) (time.Duration, bool) {
+	estimator.mu.Lock()
+	defer estimator.mu.Unlock()
+
+	estimate, ok := estimator.estimates[noticeType]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(estimate.Seconds * float64(time.Second)), true
+}
+
+// CheckBudget warns about termination hooks whose timeout budget is shorter
+// than the expected drain time, since those drains are likely to be cut off.
+func (estimator *DrainEstimator) CheckBudget(hooks []*LifecycleHook) {
+	estimate, ok := estimator.Estimate("termination")
+	if !ok {
+		return
+	}
+
+	for _, hook := range hooks {
+		if hook.Transition != TerminationLifecycleAction {
+			continue
+		}
+		if budget := hook.Budget(); estimate > budget {
+			log.Printf("warning: expected drain time %v exceeds the %v budget of lifecycle hook %s", estimate.Round(time.Second), budget, hook.Name)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package lcmgr
+
+import (
+	"encoding/json"
+	"path"
+	"time"
+)
+
+// EventBridge detail types of Auto Scaling lifecycle action events.
+const (
+	launchLifecycleEvent      = "EC2 Instance-launch Lifecycle Action"
+	terminationLifecycleEvent = "EC2 Instance-terminate Lifecycle Action"
+)
+
+// EventBridge detail types of EC2 spot interruption and rebalance events.
+const (
+	spotInterruptionEvent        = "EC2 Spot Instance Interruption Warning"
+	rebalanceRecommendationEvent = "EC2 Instance Rebalance Recommendation"
+)
+
+// spotInterruptionWarning is how long before the instance is interrupted EC2
+// sends a spot interruption warning.
+const spotInterruptionWarning = 2 * time.Minute
+
+// testNotificationEvent is the event of the test notification Auto Scaling
+// sends to a lifecycle hook's target when the hook is created.
+const testNotificationEvent = "autoscaling:TEST_NOTIFICATION"
+
+var testNotificationsCounter = DefaultRegistry.Counter("lcmgr_test_notifications_total", "Number of Auto Scaling test notifications deleted from lifecycle hook queues", "queue")
+
+// eventEnvelope is an EventBridge event as delivered to an SQS rule target.
+type eventEnvelope struct {
+	Source     string          `json:"source"`
+	DetailType string          `json:"detail-type"`
+	Time       time.Time       `json:"time"`
+	Detail     json.RawMessage `json:"detail"`
+}
+
+// ec2EventDetail is the detail of an EC2 spot interruption or rebalance
+// event.
+type ec2EventDetail struct {
+	InstanceID     string `json:"instance-id"`
+	InstanceAction string `json:"instance-action"`
+}
+
+// NewEventBridgeQueue returns a queue fed by an EventBridge rule matching
+// Auto Scaling lifecycle action events, EC2 spot interruption warnings or
+// rebalance recommendations. It carries both launch and termination events,
+// so it has no single action.
+func NewEventBridgeQueue(url string) *Queue {
+	return &Queue{
+		Name: path.Base(url),
+		URL:  url,
+		FIFO: IsFIFOQueue(url),
+	}
+}
+
+// ParseMessage parses the body of a lifecycle hook notification, either as
+// sent by Auto Scaling directly or wrapped in an EventBridge event, or of an
+// EC2 spot interruption or rebalance event, which is returned with Notice
+// set. It returns false for messages that can't be parsed and for other
+// events sent to the same queue.
+func ParseMessage(body string) (*Message, bool) {
+	var envelope eventEnvelope
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+		return nil, false
+	}
+
+	data := []byte(body)
+	if envelope.Source == "aws.ec2" {
+		return parseEC2Event(envelope)
+	}
+	if envelope.Source != "" {
+		if envelope.Source != "aws.autoscaling" {
+			return nil, false
+		}
+		switch envelope.DetailType {
+		case launchLifecycleEvent, terminationLifecycleEvent:
+		default:
+			return nil, false
+		}
+		data = envelope.Detail
+	}
+
+	var message Message
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, false
+	}
+	return &message, true
+}
+
+// parseEC2Event converts a spot interruption warning to a SpotNotice, which
+// terminates the instance two minutes after the event, and a rebalance
+// recommendation to a RebalanceNotice.
+func parseEC2Event(envelope eventEnvelope) (*Message, bool) {
+	var detail ec2EventDetail
+	if err := json.Unmarshal(envelope.Detail, &detail); err != nil || detail.InstanceID == "" {
+		return nil, false
+	}
+
+	message := &Message{EC2InstanceID: detail.InstanceID}
+	switch envelope.DetailType {
+	case spotInterruptionEvent:
+		action := detail.InstanceAction
+		if action == "" {
+			action = SpotTerminateAction
+		}
+		message.Notice = NewSpotNotice(action, envelope.Time.Add(spotInterruptionWarning))
+	case rebalanceRecommendationEvent:
+		message.Notice = NewRebalanceNotice(envelope.Time)
+	default:
+		return nil, false
+	}
+	return message, true
+}
+
+// IsTestNotification returns true for the test notification Auto Scaling
+// sends when a lifecycle hook is created. It isn't addressed to any instance,
+// so every instance would otherwise release it back to the queue forever.
+func IsTestNotification(body string) bool {
+	var message struct {
+		Event string `json:"Event"`
+	}
+	if err := json.Unmarshal([]byte(body), &message); err != nil {
+		return false
+	}
+	return message.Event == testNotificationEvent
+}
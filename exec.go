@@ -0,0 +1,28 @@
+package lcmgr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// runCommand runs command with args appended and returns its stdout. When the
+// command fails its stderr is folded into the error.
+func runCommand(ctx context.Context, command []string, args ...string) (string, error) {
+	argv := append(append([]string{}, command[1:]...), args...)
+	cmd := exec.CommandContext(ctx, command[0], argv...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return stdout.String(), fmt.Errorf("%v: %s", err, message)
+		}
+		return stdout.String(), err
+	}
+
+	return stdout.String(), nil
+}
@@ -0,0 +1,111 @@
+package lcmgr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/vm"
+)
+
+// Identity describes the instance lcmgr is running on. It's exposed to
+// condition expressions as instance.
+type Identity struct {
+	InstanceID           string
+	AutoScalingGroupName string
+	LifeCycle            string
+	Region               string
+	AccountID            string
+	AvailabilityZone     string
+	InstanceType         string
+}
+
+// NewIdentity looks up the instance's identity. Anything that can't be looked
+// up is left empty rather than failing, since conditions can still be
+// evaluated against the notice.
+func NewIdentity(ctx context.Context, client AWSClient) *Identity {
+	identity := &Identity{}
+
+	var err error
+	if identity.InstanceID, err = client.GetInstanceID(ctx); err != nil {
+		log.Printf("failed to get instance id for conditions: %v", err)
+	}
+	if identity.AutoScalingGroupName, err = client.GetAutoScalingGroupName(ctx); err != nil {
+		log.Printf("failed to get auto scaling group name for conditions: %v", err)
+	}
+	if identity.LifeCycle, err = client.GetInstanceLifeCycle(ctx); err != nil {
+		log.Printf("failed to get instance life cycle for conditions: %v", err)
+	}
+	if document, err := client.GetIdentityDocument(ctx); err != nil {
+		log.Printf("failed to get instance identity document for conditions: %v", err)
+	} else {
+		identity.Region = document.Region
+		identity.AccountID = document.AccountID
+		identity.AvailabilityZone = document.AvailabilityZone
+		identity.InstanceType = document.InstanceType
+	}
+
+	return identity
+}
+
+func (identity *Identity) metadata() map[string]string {
+	if identity == nil {
+		identity = &Identity{}
+	}
+	return map[string]string{
+		"instance_id":             identity.InstanceID,
+		"auto_scaling_group_name": identity.AutoScalingGroupName,
+		"life_cycle":              identity.LifeCycle,
+		"region":                  identity.Region,
+		"account_id":              identity.AccountID,
+		"availability_zone":       identity.AvailabilityZone,
+		"instance_type":           identity.InstanceType,
+	}
+}
+
+// env returns the identity as LCMGR_INSTANCE_ prefixed environment
+// variables, leaving out what couldn't be looked up.
+func (identity *Identity) env() []string {
+	var env []string
+	for key, value := range identity.metadata() {
+		if value != "" {
+			env = append(env, "LCMGR_INSTANCE_"+strings.ToUpper(key)+"="+value)
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+// CompileExpression compiles a boolean expr-lang expression evaluated against
+// notice (see NoticeMetadata) and instance (see Identity), e.g.
+//
+//	notice.type == "termination" && instance.life_cycle == "spot"
+func CompileExpression(source string, identity *Identity) (Condition, error) {
+	program, err := expr.Compile(source, expr.Env(expressionEnv(nil, identity)), expr.AsBool())
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", source, err)
+	}
+
+	return func(notice Notice) bool {
+		out, err := vm.Run(program, expressionEnv(notice, identity))
+		if err != nil {
+			log.Printf("failed to evaluate expression %q for %s notice: %v", source, notice.Type(), err)
+			return false
+		}
+		return out.(bool)
+	}, nil
+}
+
+func expressionEnv(notice Notice, identity *Identity) map[string]interface{} {
+	metadata := map[string]string{}
+	if notice != nil {
+		metadata = NoticeMetadata(notice)
+	}
+	return map[string]interface{}{
+		"notice":   metadata,
+		"instance": identity.metadata(),
+	}
+}
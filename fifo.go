@@ -0,0 +1,41 @@
+package lcmgr
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// fifoSuffix ends the name of every FIFO queue.
+const fifoSuffix = ".fifo"
+
+// IsFIFOQueue returns true when name, a queue name or URL, is a FIFO queue.
+func IsFIFOQueue(name string) bool {
+	return strings.HasSuffix(name, fifoSuffix)
+}
+
+// receiveAttemptID identifies a FIFO receive so SQS returns the same
+// messages if the SDK retries it, rather than hiding them from every
+// consumer until their visibility timeout expires.
+func receiveAttemptID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+// fifoQueueAttributes makes CreateQueue create a FIFO queue, deduplicating
+// messages by their content since lifecycle notifications have no
+// deduplication ID.
+func fifoQueueAttributes(name string) map[string]string {
+	if !IsFIFOQueue(name) {
+		return nil
+	}
+	return map[string]string{
+		string(types.QueueAttributeNameFifoQueue):                 "true",
+		string(types.QueueAttributeNameContentBasedDeduplication): "true",
+	}
+}
@@ -0,0 +1,100 @@
+package lcmgr
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// InstanceIDAttribute is the message attribute carrying the target instance
+// id. When publishers set it, lcmgr can discard other instances' messages
+// without parsing them.
+const InstanceIDAttribute = "EC2InstanceID"
+
+// MessageMatchesInstance reports whether a message may be addressed to the
+// instance. Messages without the attribute are ruled out when their body
+// doesn't mention the instance at all, which is much cheaper than parsing it.
+func MessageMatchesInstance(message types.Message, instanceID string) bool {
+	attribute, ok := message.MessageAttributes[InstanceIDAttribute]
+	if !ok || attribute.StringValue == nil {
+		return strings.Contains(aws.ToString(message.Body), instanceID)
+	}
+	return *attribute.StringValue == instanceID
+}
+
+// InstanceFilterPolicy returns an SNS subscription filter policy that only
+// delivers lifecycle notifications for instanceID. It filters on the message
+// body, since Auto Scaling doesn't set message attributes, so it has to be
+// applied with a FilterPolicyScope of MessageBody.
+func InstanceFilterPolicy(instanceID string) (string, error) {
+	policy, err := json.Marshal(map[string][]string{
+		bodyInstanceIDKey: {instanceID},
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(policy), nil
+}
+
+// SetSubscriptionFilterPolicy restricts an SNS subscription, typically one
+// feeding a per-instance queue, to messages for this instance.
+func (client *awsClient) SetSubscriptionFilterPolicy(ctx context.Context, subscriptionARN string) error {
+	instanceID, err := client.GetInstanceID(ctx)
+	if err != nil {
+		return err
+	}
+
+	policy, err := InstanceFilterPolicy(instanceID)
+	if err != nil {
+		return err
+	}
+
+	for _, attribute := range [][2]string{{"FilterPolicyScope", "MessageBody"}, {"FilterPolicy", policy}} {
+		input := &sns.SetSubscriptionAttributesInput{
+			SubscriptionArn: aws.String(subscriptionARN),
+			AttributeName:   aws.String(attribute[0]),
+			AttributeValue:  aws.String(attribute[1]),
+		}
+		if _, err := client.SNS().SetSubscriptionAttributes(ctx, input); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseMessages makes messages addressed to other instances visible again
+// right away, so the right instance picks them up without waiting out the
+// visibility timeout, and FIFO messages don't hold up the rest of their
+// message group.
+func (client *awsClient) releaseMessages(ctx context.Context, queue *Queue, messages []types.Message) {
+	if len(messages) == 0 {
+		return
+	}
+
+	entries := make([]types.ChangeMessageVisibilityBatchRequestEntry, 0, len(messages))
+	for i, message := range messages {
+		entries = append(entries, types.ChangeMessageVisibilityBatchRequestEntry{
+			Id:                aws.String(strconv.Itoa(i)),
+			ReceiptHandle:     message.ReceiptHandle,
+			VisibilityTimeout: 0,
+		})
+	}
+	output, err := client.SQS().ChangeMessageVisibilityBatch(ctx, &sqs.ChangeMessageVisibilityBatchInput{
+		QueueUrl: aws.String(queue.URL),
+		Entries:  entries,
+	})
+	if err != nil {
+		log.Printf("failed to release messages for other instances on %s: %v", queue.Name, err)
+		return
+	}
+	for _, failed := range output.Failed {
+		log.Printf("failed to release message for another instance on %s: %s", queue.Name, aws.ToString(failed.Message))
+	}
+}
@@ -0,0 +1,26 @@
+package lcmgr
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// lowMemoryGCPercent keeps the heap close to lcmgr's small live set at the
+// cost of collecting more often, which is cheap since it's mostly idle.
+const lowMemoryGCPercent = 25
+
+// TuneForLowMemory trades CPU for memory on small instances. A single P keeps
+// per-P allocation caches from multiplying with the core count, and a lower
+// GC target returns garbage before the heap grows. Idle, lcmgr's RSS is
+// mostly the binary's own pages, about 17MB, which this doesn't change; it
+// only bounds the heap growth from handling notices.
+func TuneForLowMemory() {
+	runtime.GOMAXPROCS(1)
+	debug.SetGCPercent(lowMemoryGCPercent)
+}
+
+// ReleaseMemory returns freed memory to the OS, used in low memory mode after
+// a notice is handled since handling is the only time lcmgr allocates much.
+func ReleaseMemory() {
+	debug.FreeOSMemory()
+}
@@ -0,0 +1,181 @@
+package lcmgr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/globalaccelerator"
+	"github.com/aws/aws-sdk-go-v2/service/globalaccelerator/types"
+)
+
+// defaultGlobalAcceleratorWait is how long to let traffic dial down after
+// the weight is zeroed when there's no connection count to poll. Weight
+// changes take effect within seconds, this covers clients finishing up.
+const defaultGlobalAcceleratorWait = 30 * time.Second
+
+// GlobalAcceleratorDrainHandler sets the instance's endpoint weight in a
+// Global Accelerator endpoint group to zero so new traffic goes elsewhere,
+// then waits for existing traffic to dial down, either until ConnectionsURL
+// reports at most Threshold connections or for Wait.
+//
+// Global Accelerator only supports replacing an endpoint group's whole
+// configuration, so instances draining at the same time can overwrite each
+// other's weights. The weight is checked and reapplied while waiting.
+type GlobalAcceleratorDrainHandler struct {
+	EndpointGroupARN string
+	EndpointID       string
+	ConnectionsURL   string
+	Threshold        int
+	Wait             time.Duration
+	Interval         time.Duration
+	Client           AWSClient
+	HTTPClient       *http.Client
+	Clock            Clock
+}
+
+// NewGlobalAcceleratorDrainHandler returns a handler for the endpoint in the
+// endpoint group, which defaults to the instance ID for EC2 endpoints.
+func NewGlobalAcceleratorDrainHandler(endpointGroupARN, endpointID string, client AWSClient) *GlobalAcceleratorDrainHandler {
+	return &GlobalAcceleratorDrainHandler{
+		EndpointGroupARN: endpointGroupARN,
+		EndpointID:       endpointID,
+		Wait:             defaultGlobalAcceleratorWait,
+		Interval:         defaultLoadBalancerPollInterval,
+		Client:           client,
+		HTTPClient:       &http.Client{Timeout: 5 * time.Second},
+		Clock:            NewClock(),
+	}
+}
+
+func (handler *GlobalAcceleratorDrainHandler) Handle(ctx context.Context, notice Notice) error {
+	return Drain(ctx, handler, notice)
+}
+
+// Deregister sets the endpoint's weight to zero.
+func (handler *GlobalAcceleratorDrainHandler) Deregister(ctx context.Context, notice Notice) error {
+	endpointID, err := handler.endpointID(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := handler.Client.SetEndpointWeight(ctx, handler.EndpointGroupARN, endpointID, 0); err != nil {
+		return fmt.Errorf("failed to set weight of %s to 0: %v", endpointID, err)
+	}
+	log.Printf("set global accelerator weight of %s to 0", endpointID)
+	return nil
+}
+
+// WaitDrained waits for traffic to dial down, keeping the weight at zero.
+func (handler *GlobalAcceleratorDrainHandler) WaitDrained(ctx context.Context, notice Notice) error {
+	endpointID, err := handler.endpointID(ctx)
+	if err != nil {
+		return err
+	}
+
+	wait := handler.Clock.After(handler.Wait)
+	for {
+		select {
+		case <-handler.Clock.After(handler.Interval):
+		case <-wait:
+			if handler.ConnectionsURL == "" {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		weight, err := handler.Client.GetEndpointWeight(ctx, handler.EndpointGroupARN, endpointID)
+		if err != nil {
+			log.Printf("failed to check global accelerator weight of %s: %v", endpointID, err)
+		} else if weight != 0 {
+			log.Printf("global accelerator weight of %s was changed to %d, setting it to 0 again", endpointID, weight)
+			if err := handler.Client.SetEndpointWeight(ctx, handler.EndpointGroupARN, endpointID, 0); err != nil {
+				log.Printf("failed to set weight of %s to 0: %v", endpointID, err)
+			}
+			continue
+		}
+
+		if handler.ConnectionsURL != "" {
+			connections, err := readConnections(ctx, handler.HTTPClient, handler.ConnectionsURL)
+			if err != nil {
+				log.Printf("failed to check drain progress: %v", err)
+				continue
+			}
+			log.Printf("%d connections remaining", connections)
+			if connections <= handler.Threshold {
+				return nil
+			}
+		}
+	}
+}
+
+func (handler *GlobalAcceleratorDrainHandler) Finalize(ctx context.Context, notice Notice) error {
+	return nil
+}
+
+func (handler *GlobalAcceleratorDrainHandler) endpointID(ctx context.Context) (string, error) {
+	if handler.EndpointID != "" {
+		return handler.EndpointID, nil
+	}
+	return handler.Client.GetInstanceID(ctx)
+}
+
+func (client *awsClient) GetEndpointWeight(ctx context.Context, endpointGroupARN, endpointID string) (int64, error) {
+	group, err := client.describeEndpointGroup(ctx, endpointGroupARN)
+	if err != nil {
+		return 0, err
+	}
+	for _, endpoint := range group.EndpointDescriptions {
+		if aws.ToString(endpoint.EndpointId) == endpointID {
+			return int64(aws.ToInt32(endpoint.Weight)), nil
+		}
+	}
+	return 0, fmt.Errorf("endpoint %s not found in %s", endpointID, endpointGroupARN)
+}
+
+func (client *awsClient) SetEndpointWeight(ctx context.Context, endpointGroupARN, endpointID string, weight int64) error {
+	group, err := client.describeEndpointGroup(ctx, endpointGroupARN)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	configurations := make([]types.EndpointConfiguration, 0, len(group.EndpointDescriptions))
+	for _, endpoint := range group.EndpointDescriptions {
+		// UpdateEndpointGroup replaces every endpoint's configuration, so
+		// settings left out would be reset on the other endpoints.
+		configuration := types.EndpointConfiguration{
+			EndpointId:                  endpoint.EndpointId,
+			Weight:                      endpoint.Weight,
+			ClientIPPreservationEnabled: endpoint.ClientIPPreservationEnabled,
+		}
+		if aws.ToString(endpoint.EndpointId) == endpointID {
+			configuration.Weight = aws.Int32(int32(weight))
+			found = true
+		}
+		configurations = append(configurations, configuration)
+	}
+	if !found {
+		return fmt.Errorf("endpoint %s not found in %s", endpointID, endpointGroupARN)
+	}
+
+	_, err = client.GlobalAccelerator().UpdateEndpointGroup(ctx, &globalaccelerator.UpdateEndpointGroupInput{
+		EndpointGroupArn:       aws.String(endpointGroupARN),
+		EndpointConfigurations: configurations,
+	})
+	return err
+}
+
+func (client *awsClient) describeEndpointGroup(ctx context.Context, endpointGroupARN string) (*types.EndpointGroup, error) {
+	output, err := client.GlobalAccelerator().DescribeEndpointGroup(ctx, &globalaccelerator.DescribeEndpointGroupInput{
+		EndpointGroupArn: aws.String(endpointGroupARN),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.EndpointGroup, nil
+}
@@ -0,0 +1,220 @@
+package lcmgr
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// noticesServiceDesc describes the Notices service in
+// proto/lcmgr/v1/notices.proto. It's written out by hand, along with the wire
+// encoding of its two messages, rather than generated, so clients generated
+// from the proto file interoperate without lcmgr depending on protoc.
+var noticesServiceDesc = grpc.ServiceDesc{
+	ServiceName: "lcmgr.v1.Notices",
+	HandlerType: (*noticesServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchNotices",
+			Handler:       watchNoticesHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "lcmgr/v1/notices.proto",
+}
+
+type noticesServer interface {
+	WatchNotices(*WatchNoticesRequest, grpc.ServerStream) error
+}
+
+// WatchNoticesRequest subscribes to notices. It has no fields.
+type WatchNoticesRequest struct{}
+
+// NoticeEvent is an ActivityEvent as sent to sidecars.
+type NoticeEvent struct {
+	State           string
+	Type            string
+	Metadata        map[string]string
+	Annotations     map[string]string
+	Drained         bool
+	ProgressDone    int64
+	ProgressTotal   int64
+	ProgressMessage string
+	StartedUnixNano int64
+	TimeUnixNano    int64
+}
+
+func newNoticeEvent(event *ActivityEvent) *NoticeEvent {
+	noticeEvent := &NoticeEvent{
+		State:           event.State,
+		Type:            event.Status.Type,
+		Metadata:        event.Status.Metadata,
+		Annotations:     event.Status.Annotations,
+		Drained:         event.Drained,
+		StartedUnixNano: event.Status.Started.UnixNano(),
+		TimeUnixNano:    event.Time.UnixNano(),
+	}
+	if progress := event.Status.Progress; progress != nil {
+		noticeEvent.ProgressDone = int64(progress.Done)
+		noticeEvent.ProgressTotal = int64(progress.Total)
+		noticeEvent.ProgressMessage = progress.Message
+	}
+	return noticeEvent
+}
+
+// NoticeStream serves WatchNotices from an Activity.
+type NoticeStream struct {
+	Activity *Activity
+}
+
+func NewNoticeStream(activity *Activity) *NoticeStream {
+	return &NoticeStream{
+		Activity: activity,
+	}
+}
+
+// WatchNotices sends the notice being handled, if any, as started and then
+// every event until the client goes away. A client too slow to keep up gets
+// an Unavailable error rather than a stream missing transitions.
+func (noticeStream *NoticeStream) WatchNotices(request *WatchNoticesRequest, stream grpc.ServerStream) error {
+	events, unsubscribe := noticeStream.Activity.Subscribe()
+	defer unsubscribe()
+
+	if current := noticeStream.Activity.Status(); current != nil {
+		event := &ActivityEvent{
+			State:   StartedState,
+			Status:  current,
+			Drained: noticeStream.Activity.Drained(),
+			Time:    current.Started,
+		}
+		if err := stream.SendMsg(newNoticeEvent(event)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return status.Error(codes.Unavailable, "fell behind on notice events, watch again")
+			}
+			if err := stream.SendMsg(newNoticeEvent(event)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func watchNoticesHandler(server interface{}, stream grpc.ServerStream) error {
+	request := &WatchNoticesRequest{}
+	if err := stream.RecvMsg(request); err != nil {
+		return err
+	}
+	return server.(noticesServer).WatchNotices(request, stream)
+}
+
+// NewGRPCServer returns a gRPC server for the Notices service.
+func NewGRPCServer(activity *Activity) *grpc.Server {
+	server := grpc.NewServer(grpc.ForceServerCodec(wireCodec{}))
+	server.RegisterService(&noticesServiceDesc, NewNoticeStream(activity))
+	return server
+}
+
+// isGRPC returns true for gRPC requests, which come in over HTTP/2.
+func isGRPC(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// wireCodec encodes the Notices service's messages in the protobuf wire
+// format.
+type wireCodec struct{}
+
+func (wireCodec) Name() string {
+	return "proto"
+}
+
+func (wireCodec) Marshal(v interface{}) ([]byte, error) {
+	switch message := v.(type) {
+	case *WatchNoticesRequest:
+		return nil, nil
+	case *NoticeEvent:
+		return message.appendWire(nil), nil
+	default:
+		return nil, fmt.Errorf("can't marshal %T", v)
+	}
+}
+
+func (wireCodec) Unmarshal(data []byte, v interface{}) error {
+	if _, ok := v.(*WatchNoticesRequest); !ok {
+		return fmt.Errorf("can't unmarshal %T", v)
+	}
+	// Fields added to the request later are skipped.
+	for len(data) > 0 {
+		_, _, n := protowire.ConsumeField(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (event *NoticeEvent) appendWire(b []byte) []byte {
+	b = appendWireString(b, 1, event.State)
+	b = appendWireString(b, 2, event.Type)
+	b = appendWireMap(b, 3, event.Metadata)
+	b = appendWireMap(b, 4, event.Annotations)
+	if event.Drained {
+		b = protowire.AppendTag(b, 5, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	b = appendWireInt(b, 6, event.ProgressDone)
+	b = appendWireInt(b, 7, event.ProgressTotal)
+	b = appendWireString(b, 8, event.ProgressMessage)
+	b = appendWireInt(b, 9, event.StartedUnixNano)
+	b = appendWireInt(b, 10, event.TimeUnixNano)
+	return b
+}
+
+func appendWireString(b []byte, number protowire.Number, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, number, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+func appendWireInt(b []byte, number protowire.Number, value int64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, number, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(value))
+}
+
+// appendWireMap appends a map<string, string> field, one entry message per
+// key in key order.
+func appendWireMap(b []byte, number protowire.Number, values map[string]string) []byte {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var entry []byte
+		entry = appendWireString(entry, 1, key)
+		entry = appendWireString(entry, 2, values[key])
+		b = protowire.AppendTag(b, number, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b
+}
@@ -0,0 +1,181 @@
+package lcmgr
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var protoFieldPattern = regexp.MustCompile(`^\s*(map<string, string>|string|bool|int64)\s+(\w+)\s*=\s*(\d+);`)
+
+// noticeEventDescriptor builds the NoticeEvent descriptor from the fields
+// declared in proto/lcmgr/v1/notices.proto, so the hand written encoding is
+// checked against the proto file rather than a copy of it.
+func noticeEventDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	source, err := os.ReadFile("proto/lcmgr/v1/notices.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := &descriptorpb.DescriptorProto{Name: proto.String("NoticeEvent")}
+	inMessage := false
+	for _, line := range strings.Split(string(source), "\n") {
+		switch {
+		case strings.HasPrefix(line, "message NoticeEvent "):
+			inMessage = true
+			continue
+		case strings.HasPrefix(line, "}"):
+			inMessage = false
+		}
+		match := protoFieldPattern.FindStringSubmatch(line)
+		if !inMessage || match == nil {
+			continue
+		}
+
+		number, _ := strconv.Atoi(match[3])
+		field := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(match[2]),
+			Number:   proto.Int32(int32(number)),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			JsonName: proto.String(match[2]),
+		}
+		switch match[1] {
+		case "string":
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+		case "bool":
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_BOOL.Enum()
+		case "int64":
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()
+		default:
+			entry := strings.ToUpper(match[2][:1]) + match[2][1:] + "Entry"
+			message.NestedType = append(message.NestedType, &descriptorpb.DescriptorProto{
+				Name: proto.String(entry),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("key"), JsonName: proto.String("key"), Number: proto.Int32(1), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+					{Name: proto.String("value"), JsonName: proto.String("value"), Number: proto.Int32(2), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+				},
+				Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+			})
+			field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			field.TypeName = proto.String(".lcmgr.v1.NoticeEvent." + entry)
+		}
+		message.Field = append(message.Field, field)
+	}
+	if len(message.Field) == 0 {
+		t.Fatal("no NoticeEvent fields found in notices.proto")
+	}
+
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:        proto.String("lcmgr/v1/notices.proto"),
+		Package:     proto.String("lcmgr.v1"),
+		Syntax:      proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{message},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.Messages().ByName("NoticeEvent")
+}
+
+func TestNoticeEventWireRoundTrip(t *testing.T) {
+	descriptor := noticeEventDescriptor(t)
+	event := &NoticeEvent{
+		State:           ProgressState,
+		Type:            "termination",
+		Metadata:        map[string]string{"hook": "drain", "token": "abc"},
+		Annotations:     map[string]string{"targets_deregistered": "2"},
+		Drained:         true,
+		ProgressDone:    3,
+		ProgressTotal:   7,
+		ProgressMessage: "deregistering",
+		StartedUnixNano: 1700000000000000000,
+		TimeUnixNano:    1700000001000000000,
+	}
+
+	wire, err := wireCodec{}.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := dynamicpb.NewMessage(descriptor)
+	if err := proto.Unmarshal(wire, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if unknown := decoded.GetUnknown(); len(unknown) != 0 {
+		t.Fatalf("encoding has %d bytes of fields missing from notices.proto", len(unknown))
+	}
+
+	fields := descriptor.Fields()
+	get := func(name string) protoreflect.Value {
+		field := fields.ByName(protoreflect.Name(name))
+		if field == nil {
+			t.Fatalf("notices.proto has no %s field", name)
+		}
+		return decoded.Get(field)
+	}
+	getMap := func(name string) map[string]string {
+		values := make(map[string]string)
+		get(name).Map().Range(func(key protoreflect.MapKey, value protoreflect.Value) bool {
+			values[key.String()] = value.String()
+			return true
+		})
+		return values
+	}
+
+	for _, test := range []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"state", get("state").String(), event.State},
+		{"type", get("type").String(), event.Type},
+		{"drained", get("drained").Bool(), event.Drained},
+		{"progress_done", get("progress_done").Int(), event.ProgressDone},
+		{"progress_total", get("progress_total").Int(), event.ProgressTotal},
+		{"progress_message", get("progress_message").String(), event.ProgressMessage},
+		{"started_unix_nano", get("started_unix_nano").Int(), event.StartedUnixNano},
+		{"time_unix_nano", get("time_unix_nano").Int(), event.TimeUnixNano},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s = %v, want %v", test.name, test.got, test.want)
+		}
+	}
+	for name, want := range map[string]map[string]string{"metadata": event.Metadata, "annotations": event.Annotations} {
+		got := getMap(name)
+		if len(got) != len(want) {
+			t.Errorf("%s = %v, want %v", name, got, want)
+			continue
+		}
+		for key, value := range want {
+			if got[key] != value {
+				t.Errorf("%s = %v, want %v", name, got, want)
+				break
+			}
+		}
+	}
+
+	reencoded, err := proto.MarshalOptions{Deterministic: true}.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reencoded) != string(wire) {
+		t.Errorf("protobuf encodes the event as %x, lcmgr as %x", reencoded, wire)
+	}
+}
+
+func TestWatchNoticesRequestSkipsUnknownFields(t *testing.T) {
+	request := []byte{0x0a, 0x03, 'n', 'e', 'w', 0x10, 0x01}
+	if err := (wireCodec{}).Unmarshal(request, &WatchNoticesRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := (wireCodec{}).Unmarshal([]byte{0x0a, 0x05}, &WatchNoticesRequest{}); err == nil {
+		t.Fatal("truncated request was accepted")
+	}
+}
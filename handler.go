@@ -3,11 +3,10 @@ package lcmgr
 import (
 	"context"
 	"errors"
-	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"time"
-
-	"github.com/coreos/go-systemd/dbus"
 )
 
 type HandlerFunc func(context.Context, Notice) error
@@ -16,120 +15,350 @@ type Handler interface {
 	Handle(context.Context, Notice) error
 }
 
+// ServiceHandler stops or starts services in response to notices. Services
+// are stopped in the order returned by StopOrder, or in the configured order
+// when it is nil, and started in reverse. When FastCompletion is set,
+// termination notices for services that are already stopped, masked, or
+// missing complete the lifecycle action immediately. MissingService decides
+// whether a service that doesn't exist is an error (MissingServiceFail, the
+// default) or is skipped (MissingServiceSkip). DrainTarget, if set, is a unit
+// started before services are stopped so that other units can join the drain
+// through Conflicts= or Before= without being configured in lcmgr. When Chain
+// is set it replaces the default start and stop behavior for every notice.
+// Launch, if set, is a provisioning pipeline run for launch notices in place
+// of Chain or starting services. A failing launch pipeline always abandons
+// the lifecycle action so the instance never enters service half
+// provisioned, and other failing handlers abandon it for the notice types in
+// AbandonOnFailure. Rollback, if set, runs before a launch is abandoned to
+// clean up after it. Successful drains are recorded in Estimator, if
+// set, and handling time is tracked against SLO, if set. Hooks bound how long
+// a lifecycle notice may take, and everything run for a notice is cancelled
+// once its deadline passes. Launches whose hook would abandon them on timeout
+// get a shorter deadline so lcmgr completes them first, and notices that are
+// already stale when handling starts are skipped. When InhibitShutdown
+// is set, a shutdown started during a drain is delayed until the drain
+// finishes, up to logind's InhibitDelayMaxSec. Annotations attached with
+// Annotate are recorded once a notice is handled, and the notice is then
+// reported with them to Sinks, if set. Lifecycle actions are
+// completed through Outbox, if set. Without a chain, services are left
+// running for spot hibernation so they resume with the instance.
 type ServiceHandler struct {
-	Service           string
+	Services          []string
 	HeartbeatInterval time.Duration
+	FastCompletion    bool
+	MissingService    string
+	StopOrder         StopOrderFunc
+	DrainTarget       string
+	AbandonOnFailure  []string
+	InhibitShutdown   bool
+	RefreshWait       time.Duration
+	LoadBalancers     Handler
+	Chain             Handler
+	Launch            Handler
+	Rollback          Handler
+	Outbox            *CompletionOutbox
+	Estimator         *DrainEstimator
+	SLO               *SLOTracker
+	Activity          *Activity
+	Sinks             []Sink
+	Hooks             []*LifecycleHook
 	Client            AWSClient
+	Manager           ServiceManager
+	Clock             Clock
+
+	hooksMu sync.RWMutex
 }
 
-func NewServiceHandler(service string, heartbeatInterval time.Duration, client AWSClient) Handler {
+func NewServiceHandler(services []string, heartbeatInterval time.Duration, client AWSClient, manager ServiceManager) *ServiceHandler {
 	return &ServiceHandler{
-		Service:           service,
+		Services:          services,
 		HeartbeatInterval: heartbeatInterval,
 		Client:            client,
+		Manager:           manager,
+		Activity:          NewActivity(),
+		Clock:             NewClock(),
 	}
 }
 
 func (handler *ServiceHandler) Handle(ctx context.Context, notice Notice) error {
-	switch notice.(type) {
+	if handler.SLO != nil {
+		var timer *SLOTimer
+		ctx, timer = handler.SLO.Start(ctx, notice)
+		defer timer.Finish()
+	}
+	handler.attachHook(notice)
+	if reason, ok := handler.checkStale(ctx, notice); ok {
+		handler.skipStale(ctx, notice, reason)
+		return nil
+	}
+	ctx = WithNoticeTrace(ctx, notice)
+	if deadline, ok := handler.noticeBudget(notice, handler.Clock.Now()); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = WithNoticeDeadline(ctx, deadline)
+		defer cancel()
+	}
+	ctx, annotations := WithAnnotations(ctx)
+	defer handler.recordAnnotations(ctx, notice, annotations)
+	defer handler.Activity.Begin(ctx, notice)()
+	noticeReceived(ctx, notice)
+	if _, ok := notice.(*LaunchNotice); !ok && handler.InhibitShutdown {
+		defer handler.inhibitShutdown(notice)()
+	}
+
+	if _, ok := notice.(*LaunchNotice); ok && handler.Launch != nil {
+		return handler.ForLifecycleActionWithResult(ctx, notice, handler.rollbackOnAbandon(handler.Launch.Handle, true), AbandonResult)
+	}
+	if handler.Chain != nil {
+		return handler.handleChain(ctx, notice)
+	}
+
+	switch n := notice.(type) {
 	case *SpotNotice:
-		return handler.WaitForServiceStop(ctx, notice)
+		if n.Action == SpotHibernateAction {
+			log.Printf("spot instance will be hibernated, leaving services running to resume with it")
+			return nil
+		}
+		return handler.drain(handler.WaitForServiceStop)(ctx, notice)
+	case *RebalanceNotice, *ScheduledEventNotice, *DegradedNotice:
+		return handler.drain(handler.WaitForServiceStop)(ctx, notice)
 	case *LaunchNotice:
-		return handler.ForLifecycleAction(ctx, notice, handler.WaitForServiceStart)
+		return handler.ForLifecycleAction(ctx, notice, handler.rollbackOnAbandon(handler.WaitForServiceStart, handler.abandonsOnFailure(notice)))
 	case *TerminationNotice:
-		return handler.ForLifecycleAction(ctx, notice, handler.WaitForServiceStop)
+		if handler.FastCompletion && handler.servicesIdle(ctx) {
+			log.Printf("services are idle, completing %s lifecycle action immediately", notice.Type())
+			if err := handler.completeLifecycleAction(ctx, notice, ContinueResult); err != nil {
+				return err
+			}
+			acknowledgeNotice(ctx, handler.Client, notice)
+			return nil
+		}
+		return handler.ForLifecycleAction(ctx, notice, handler.drain(handler.deregisterAndStop))
 	default:
 		return errors.New("failed to handle unexpected notice type")
 	}
 }
 
-func (handler *ServiceHandler) WaitForServiceStart(ctx context.Context, notice Notice) error {
-	conn, err := dbus.New()
-	if err != nil {
-		return err
+func (handler *ServiceHandler) handleChain(ctx context.Context, notice Notice) error {
+	switch notice.(type) {
+	case *LaunchNotice:
+		return handler.ForLifecycleAction(ctx, notice, handler.rollbackOnAbandon(handler.Chain.Handle, handler.abandonsOnFailure(notice)))
+	case *TerminationNotice:
+		return handler.ForLifecycleAction(ctx, notice, handler.drain(handler.Chain.Handle))
+	default:
+		return handler.drain(handler.Chain.Handle)(ctx, notice)
 	}
-	defer conn.Close()
+}
 
-	units, err := conn.ListUnitsByNames([]string{handler.Service})
-	if err != nil {
+// drain wraps a handler that drains the instance so it waits out any snooze
+// and records its duration.
+func (handler *ServiceHandler) drain(f HandlerFunc) HandlerFunc {
+	measured := handler.measure(f)
+	return func(ctx context.Context, notice Notice) error {
+		if err := handler.WaitForSnooze(ctx, notice); err != nil {
+			return err
+		}
+		return measured(ctx, notice)
+	}
+}
+
+// measure records how long f takes in the drain estimator when it succeeds.
+func (handler *ServiceHandler) measure(f HandlerFunc) HandlerFunc {
+	if handler.Estimator == nil {
+		return f
+	}
+
+	return func(ctx context.Context, notice Notice) error {
+		start := handler.Clock.Now()
+		err := f(ctx, notice)
+		if err == nil {
+			handler.Estimator.Record(notice, handler.Clock.Now().Sub(start))
+		}
 		return err
 	}
-	if len(units) != 1 {
-		return fmt.Errorf("failed to list status of systemd unit %s: %v", handler.Service, err)
+}
+
+// recordAnnotations exports the annotations handlers attached to a notice
+// once it's handled and reports the notice with them to Sinks, or logs them
+// without any. Reporting outlives the notice's deadline, which may have
+// passed by now.
+func (handler *ServiceHandler) recordAnnotations(ctx context.Context, notice Notice, annotations *Annotations) {
+	annotations.export(notice)
+	if len(handler.Sinks) > 0 {
+		SendToSinks(context.WithoutCancel(ctx), handler.Sinks, notice)
+		return
+	}
+	if annotations.Map() != nil {
+		log.Printf("handled %s notice: %s", notice.Type(), annotations)
 	}
+}
 
-	results := make(chan string)
-	n, err := conn.StartUnit(handler.Service, "fail", results)
+// inhibitShutdown holds off shutdowns until the returned function is called,
+// covering both the drain and completing the lifecycle action.
+func (handler *ServiceHandler) inhibitShutdown(notice Notice) func() {
+	release, err := InhibitShutdown("Draining for " + notice.Type() + " notice")
 	if err != nil {
-		return err
-	} else if n == 0 {
-		fmt.Errorf("failed to start systemd unit %s due to unknown error", handler.Service)
+		log.Printf("failed to take shutdown inhibitor lock: %v", err)
+		return func() {}
 	}
+	return release
+}
 
-	result := <-results
-	if result != "done" {
-		fmt.Errorf("failed to start systemd unit %s, job returned %v result", handler.Service, result)
+// completeLifecycleAction completes notice's lifecycle action through Outbox,
+// if set, so the completion survives a restart. An action Auto Scaling no
+// longer knows, e.g. one redelivered after it was completed or timed out,
+// counts as completed so its message is deleted.
+func (handler *ServiceHandler) completeLifecycleAction(ctx context.Context, notice Notice, result string) error {
+	var err error
+	if handler.Outbox != nil {
+		err = handler.Outbox.Complete(ctx, notice, result)
+	} else {
+		err = handler.Client.CompleteLifecycleAction(ctx, notice, result)
+		if isLifecycleActionGone(err) {
+			log.Printf("%s lifecycle action was already completed or timed out: %v", notice.Type(), err)
+			err = nil
+		}
 	}
+	lifecycleActionCompleted(ctx, notice, result, err)
+	return err
+}
 
+// HandleServices starts the services for launch notices and stops them for
+// anything else, the behavior without a chain.
+func (handler *ServiceHandler) HandleServices(ctx context.Context, notice Notice) error {
+	if _, ok := notice.(*LaunchNotice); ok {
+		return handler.WaitForServiceStart(ctx, notice)
+	}
+	return handler.WaitForServiceStop(ctx, notice)
+}
+
+func (handler *ServiceHandler) servicesIdle(ctx context.Context) bool {
+	for _, service := range handler.Services {
+		state, err := handler.Manager.ServiceState(ctx, service)
+		if err != nil {
+			log.Printf("failed to get state of %s: %v", service, err)
+			return false
+		}
+		if !state.Idle() {
+			return false
+		}
+	}
+	return true
+}
+
+// deregisterAndStop drains the instance from LoadBalancers, when set, before
+// stopping the services.
+func (handler *ServiceHandler) deregisterAndStop(ctx context.Context, notice Notice) error {
+	if handler.LoadBalancers != nil {
+		drainPhase(ctx, notice, DeregisterPhase)
+		if err := handler.LoadBalancers.Handle(ctx, notice); err != nil {
+			return err
+		}
+	}
+	return handler.WaitForServiceStop(ctx, notice)
+}
+
+func (handler *ServiceHandler) WaitForServiceStart(ctx context.Context, notice Notice) error {
+	order := handler.stopOrder(ctx)
+	for i := len(order) - 1; i >= 0; i-- {
+		if err := handler.checkMissing(order[i], handler.Manager.StartService(ctx, order[i])); err != nil {
+			return err
+		}
+		ReportProgress(ctx, len(order)-i, len(order))
+	}
 	return nil
 }
 
 func (handler *ServiceHandler) WaitForServiceStop(ctx context.Context, notice Notice) error {
-	conn, err := dbus.New()
-	if err != nil {
-		return err
+	drainPhase(ctx, notice, StopServicesPhase)
+	if handler.DrainTarget != "" {
+		log.Printf("starting %s", handler.DrainTarget)
+		if err := handler.Manager.StartService(ctx, handler.DrainTarget); err != nil {
+			log.Printf("failed to start drain target %s: %v", handler.DrainTarget, err)
+		}
 	}
-	defer conn.Close()
 
-	units, err := conn.ListUnitsByNames([]string{handler.Service})
-	if err != nil {
-		return err
-	}
-	if len(units) != 1 {
-		return fmt.Errorf("failed to list status of systemd unit %s: %v", handler.Service, err)
+	order := handler.stopOrder(ctx)
+	for i, service := range order {
+		if err := handler.checkMissing(service, handler.Manager.StopService(ctx, service)); err != nil {
+			return err
+		}
+		ReportProgress(ctx, i+1, len(order))
 	}
+	return nil
+}
 
-	results := make(chan string)
-	n, err := conn.StopUnit(handler.Service, "fail", results)
-	if err != nil {
-		return err
-	} else if n == 0 {
-		fmt.Errorf("failed to start systemd unit %s due to unknown error", handler.Service)
+func (handler *ServiceHandler) checkMissing(service string, err error) error {
+	if _, ok := err.(*ServiceNotFoundError); ok && handler.MissingService == MissingServiceSkip {
+		log.Printf("skipping %s: %v", service, err)
+		return nil
 	}
+	return err
+}
 
-	result := <-results
-	if result != "done" {
-		fmt.Errorf("failed to start systemd unit %s, job returned %v result", handler.Service, result)
+// CheckServices reports the state of the managed services before any notices
+// are handled, so a misconfigured unit name is caught at startup.
+func (handler *ServiceHandler) CheckServices(ctx context.Context) error {
+	for _, service := range handler.Services {
+		state, err := handler.Manager.ServiceState(ctx, service)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("managing %s (load state %s, active state %s)", service, state.LoadState, state.ActiveState)
+		if state.LoadState == "not-found" {
+			if err := handler.checkMissing(service, &ServiceNotFoundError{Service: service}); err != nil {
+				return err
+			}
+		}
 	}
 
+	if len(handler.Services) > 1 {
+		log.Printf("services will be stopped in order: %s", strings.Join(handler.stopOrder(ctx), ", "))
+	}
 	return nil
 }
 
+// ForLifecycleAction runs f for a lifecycle notice, abandoning the lifecycle
+// action if f fails and the notice's type is in AbandonOnFailure.
 func (handler *ServiceHandler) ForLifecycleAction(ctx context.Context, notice Notice, f HandlerFunc) error {
+	failureResult := ContinueResult
+	if handler.abandonsOnFailure(notice) {
+		failureResult = AbandonResult
+	}
+	return handler.ForLifecycleActionWithResult(ctx, notice, f, failureResult)
+}
+
+func (handler *ServiceHandler) abandonsOnFailure(notice Notice) bool {
+	return containsString(handler.AbandonOnFailure, notice.Type())
+}
+
+// ForLifecycleActionWithResult heartbeats while f runs and then completes the
+// lifecycle action with CONTINUE, or with failureResult if f failed. The
+// notice's message is kept hidden while f runs and deleted only once the
+// lifecycle action is completed.
+func (handler *ServiceHandler) ForLifecycleActionWithResult(ctx context.Context, notice Notice, f HandlerFunc, failureResult string) error {
 	ctx, cancel := context.WithCancel(ctx)
-	ticker := time.NewTicker(handler.HeartbeatInterval)
-
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				handler.Client.SendHeartbeat(ctx, notice)
-			case <-ctx.Done():
-				return
-			}
-		}
-	}()
+	go handler.SendHeartbeats(ctx, notice)
+	go HoldNoticeMessage(ctx, handler.Client, handler.Clock, notice)
 
+	result := ContinueResult
 	err := f(ctx, notice)
 	if err != nil {
 		log.Printf("failed to run %s handler: %v", notice.Type(), err)
+		result = failureResult
+	} else {
+		handler.waitForRefresh(ctx, notice)
 	}
 
-	if err := handler.Client.CompleteLifecycleAction(ctx, notice); err != nil {
+	completeCtx, cancelComplete := completionContext(ctx)
+	defer cancelComplete()
+	if err := handler.completeLifecycleAction(completeCtx, notice, result); err != nil {
 		log.Printf("failed to complete %s lifecycle action: %v", notice.Type(), err)
+	} else {
+		acknowledgeNotice(completeCtx, handler.Client, notice)
 	}
 
-	cancel() // Stop sending heartbeats
+	cancel() // Stop sending heartbeats and holding the message
 
 	return err
 }
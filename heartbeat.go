@@ -0,0 +1,103 @@
+package lcmgr
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// The heartbeat loop wakes up at least this often to compare wall-clock time
+// against monotonic time. The monotonic clock stops while the host is
+// suspended, so checking frequently is what lets us notice a pause and catch
+// up shortly after resume.
+const maxHeartbeatCheckInterval = 5 * time.Second
+
+// minHeartbeatSpacing is the least time between two heartbeats for the same
+// notice, however many pauses are detected.
+const minHeartbeatSpacing = 10 * time.Second
+
+// Heartbeater keeps a lifecycle action alive by recording heartbeats every
+// Interval until its context is canceled.
+type Heartbeater struct {
+	Interval time.Duration
+	Client   AWSClient
+	Clock    Clock
+}
+
+func NewHeartbeater(interval time.Duration, client AWSClient) *Heartbeater {
+	return &Heartbeater{
+		Interval: interval,
+		Client:   client,
+		Clock:    NewClock(),
+	}
+}
+
+// SendHeartbeats heartbeats a lifecycle action at its hook's cadence when the
+// hook is known, or every HeartbeatInterval otherwise.
+func (handler *ServiceHandler) SendHeartbeats(ctx context.Context, notice Notice) {
+	heartbeater := &Heartbeater{
+		Interval: heartbeatInterval(notice, handler.HeartbeatInterval),
+		Client:   handler.Client,
+		Clock:    handler.Clock,
+	}
+	heartbeater.Run(ctx, notice)
+}
+
+// heartbeatInterval returns the notice's hook cadence, or fallback when it
+// isn't known.
+func heartbeatInterval(notice Notice, fallback time.Duration) time.Duration {
+	if lifecycle := lifecycleNotice(notice); lifecycle != nil && lifecycle.HeartbeatInterval() > 0 {
+		return lifecycle.HeartbeatInterval()
+	}
+	return fallback
+}
+
+func (heartbeater *Heartbeater) Run(ctx context.Context, notice Notice) {
+	check := heartbeater.Interval
+	if check > maxHeartbeatCheckInterval {
+		check = maxHeartbeatCheckInterval
+	}
+
+	ticker := heartbeater.Clock.NewTicker(check)
+	defer ticker.Stop()
+
+	// Heartbeats are scheduled on the monotonic clock, so wall-clock steps
+	// from NTP can't delay them. Round(0) strips the monotonic reading, and
+	// wall time running ahead of monotonic time means the host was paused
+	// (or the clock stepped forward), so a heartbeat is sent right away and
+	// the notice's deadline is recomputed by the wall clock.
+	last := heartbeater.Clock.Now()
+	next := last.Add(heartbeater.Interval)
+	var sent time.Time
+	for {
+		select {
+		case <-ticker.C():
+			now := heartbeater.Clock.Now()
+			wall := now.Round(0).Sub(last.Round(0))
+			if jump := wall - now.Sub(last); jump > check {
+				log.Printf("detected host suspension or clock jump of %v while handling %s notice, sending heartbeat", jump, notice.Type())
+				recomputeNoticeDeadline(ctx, now)
+				next = now
+			}
+			last = now
+
+			if now.Before(next) {
+				continue
+			}
+			// Suspensions and jumps in quick succession would otherwise send a
+			// burst of heartbeats and get throttled.
+			if !sent.IsZero() && now.Sub(sent) < minHeartbeatSpacing {
+				continue
+			}
+			err := heartbeater.Client.SendHeartbeat(ctx, notice)
+			if err != nil {
+				log.Printf("failed to send heartbeat for %s notice: %v", notice.Type(), err)
+			}
+			heartbeatSent(ctx, notice, err)
+			sent = now
+			next = now.Add(heartbeater.Interval)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
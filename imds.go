@@ -0,0 +1,75 @@
+package lcmgr
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+)
+
+// maxIMDSBackoff caps the delay between attempts of an instance metadata
+// call when its retries are configured.
+const maxIMDSBackoff = 5 * time.Second
+
+// WithIMDSEndpoint reads instance metadata from endpoint, e.g. a proxy
+// in front of the metadata service in a container, instead of
+// http://169.254.169.254.
+func WithIMDSEndpoint(endpoint string) AWSOption {
+	return func(options *awsOptions) {
+		options.imds = append(options.imds, func(imdsOptions *imds.Options) {
+			imdsOptions.Endpoint = endpoint
+		})
+	}
+}
+
+// WithIMDSTimeout gives up on an instance metadata request after timeout
+// instead of the SDK's 5 seconds, so the IMDSv2 token request that never
+// returns when the hop limit is too low for a container falls back to IMDSv1
+// quickly.
+func WithIMDSTimeout(timeout time.Duration) AWSOption {
+	return func(options *awsOptions) {
+		options.imds = append(options.imds, func(imdsOptions *imds.Options) {
+			imdsOptions.HTTPClient = awshttp.NewBuildableClient().WithTimeout(timeout)
+			imdsOptions.DisableDefaultTimeout = true
+		})
+	}
+}
+
+// WithIMDSRetries retries failed instance metadata requests up to retries
+// times with exponential backoff and jitter before declaring metadata
+// unavailable.
+func WithIMDSRetries(retries int) AWSOption {
+	return func(options *awsOptions) {
+		options.imds = append(options.imds, func(imdsOptions *imds.Options) {
+			imdsOptions.Retryer = retry.NewStandard(func(retryOptions *retry.StandardOptions) {
+				retryOptions.MaxAttempts = retries + 1
+				retryOptions.MaxBackoff = maxIMDSBackoff
+				retryOptions.Backoff = retry.NewExponentialJitterBackoff(maxIMDSBackoff)
+				retryOptions.RateLimiter = ratelimit.None
+			})
+			imdsOptions.DisableDefaultMaxBackoff = true
+		})
+	}
+}
+
+// imdsLoadOptions makes the SDK look up the region and instance profile
+// credentials with the customized metadata client, so they work wherever
+// lcmgr's own metadata calls do.
+func imdsLoadOptions(options []func(*imds.Options)) []func(*config.LoadOptions) error {
+	if len(options) == 0 {
+		return nil
+	}
+	client := imds.New(imds.Options{}, options...)
+	return []func(*config.LoadOptions) error{
+		config.WithEC2IMDSRegion(func(region *config.UseEC2IMDSRegion) {
+			region.Client = client
+		}),
+		config.WithEC2RoleCredentialOptions(func(credentials *ec2rolecreds.Options) {
+			credentials.Client = client
+		}),
+	}
+}
@@ -0,0 +1,282 @@
+package lcmgr
+
+import (
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// InfraOptions describes the lifecycle hooks lcmgr needs and what they
+// notify: an SQS queue, or an SNS topic that each instance subscribes an
+// ephemeral queue to. The role Auto Scaling assumes to publish notifications
+// is created alongside them. QueueName defaults to lcmgr-<group>.
+// ConsumerRoleARN, if set, is granted receiving from the queue for lcmgr
+// running with a role in another account.
+type InfraOptions struct {
+	AutoScalingGroupName string
+	QueueName            string
+	Topic                bool
+	ConsumerRoleARN      string
+	Hooks                []*LifecycleHook
+}
+
+// NewInfraOptions describes the infrastructure that matches config: a
+// termination hook, a launch hook when launch steps are configured, and a
+// topic instead of a queue when each instance listens on its own queue.
+// Hooks abandon on timeout when config abandons their notices on failure, and
+// time out well after a missed heartbeat.
+func NewInfraOptions(config *Config, autoScalingGroupName string) InfraOptions {
+	options := InfraOptions{
+		AutoScalingGroupName: autoScalingGroupName,
+		Topic:                config.EphemeralQueue,
+		ConsumerRoleARN:      config.SQSRoleARN,
+	}
+	if autoScalingGroupName != "" {
+		options.QueueName = defaultQueueName(autoScalingGroupName)
+	}
+
+	heartbeatTimeout := time.Duration(config.HeartbeatInterval) * heartbeatTimeoutFraction
+	if heartbeatTimeout < defaultBootstrapHeartbeatTimeout {
+		heartbeatTimeout = defaultBootstrapHeartbeatTimeout
+	}
+	transitions := map[string]string{
+		"termination": TerminationLifecycleAction,
+	}
+	if len(config.Launch) > 0 {
+		transitions["launch"] = LaunchLifecycleAction
+	}
+	for _, noticeType := range []string{"launch", "termination"} {
+		transition, ok := transitions[noticeType]
+		if !ok {
+			continue
+		}
+		defaultResult := ContinueResult
+		if containsString(config.AbandonOnFailure, noticeType) {
+			defaultResult = AbandonResult
+		}
+		options.Hooks = append(options.Hooks, &LifecycleHook{
+			Name:             bootstrapHookName(transition),
+			Transition:       transition,
+			HeartbeatTimeout: heartbeatTimeout,
+			DefaultResult:    defaultResult,
+		})
+	}
+	return options
+}
+
+// WriteTerraform writes the infrastructure as Terraform resources for the
+// AWS provider. The auto scaling group is a variable.
+func WriteTerraform(w io.Writer, options InfraOptions) error {
+	return terraformTemplate.Execute(w, options)
+}
+
+// WriteCloudFormation writes the infrastructure as a CloudFormation
+// template. The auto scaling group is a parameter.
+func WriteCloudFormation(w io.Writer, options InfraOptions) error {
+	return cloudFormationTemplate.Execute(w, options)
+}
+
+var infraFuncs = template.FuncMap{
+	"seconds": func(d time.Duration) int64 {
+		return int64(d / time.Second)
+	},
+	// terraformName turns lcmgr-launch into lcmgr_launch.
+	"terraformName": func(name string) string {
+		return strings.Replace(name, "-", "_", -1)
+	},
+	// logicalID turns lcmgr-launch into LcmgrLaunch.
+	"logicalID": func(name string) string {
+		parts := strings.Split(name, "-")
+		for i, part := range parts {
+			if part != "" {
+				parts[i] = strings.ToUpper(part[:1]) + part[1:]
+			}
+		}
+		return strings.Join(parts, "")
+	},
+	"isFIFO": IsFIFOQueue,
+}
+
+var terraformTemplate = template.Must(template.New("terraform").Funcs(infraFuncs).Parse(`variable "auto_scaling_group_name" {
+{{- if .AutoScalingGroupName }}
+  type    = string
+  default = "{{ .AutoScalingGroupName }}"
+{{- else }}
+  type = string
+{{- end }}
+}
+{{ if .Topic }}
+resource "aws_sns_topic" "lcmgr" {
+  name = {{ if .QueueName }}"{{ .QueueName }}"{{ else }}"lcmgr-${var.auto_scaling_group_name}"{{ end }}
+}
+{{ else }}
+resource "aws_sqs_queue" "lcmgr" {
+  name = {{ if .QueueName }}"{{ .QueueName }}"{{ else }}"lcmgr-${var.auto_scaling_group_name}"{{ end }}
+{{- if isFIFO .QueueName }}
+  fifo_queue                  = true
+  content_based_deduplication = true
+{{- end }}
+}
+
+resource "aws_sqs_queue_policy" "lcmgr" {
+  queue_url = aws_sqs_queue.lcmgr.id
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect    = "Allow"
+        Principal = { AWS = aws_iam_role.lcmgr_notification.arn }
+        Action    = "sqs:SendMessage"
+        Resource  = aws_sqs_queue.lcmgr.arn
+      },
+{{- if .ConsumerRoleARN }}
+      {
+        Effect    = "Allow"
+        Principal = { AWS = "{{ .ConsumerRoleARN }}" }
+        Action = [
+          "sqs:GetQueueUrl",
+          "sqs:ReceiveMessage",
+          "sqs:DeleteMessage",
+          "sqs:DeleteMessageBatch",
+          "sqs:ChangeMessageVisibility",
+          "sqs:ChangeMessageVisibilityBatch",
+        ]
+        Resource = aws_sqs_queue.lcmgr.arn
+      },
+{{- end }}
+    ]
+  })
+}
+{{ end }}
+resource "aws_iam_role" "lcmgr_notification" {
+  name_prefix = "lcmgr-notification-"
+  assume_role_policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [{
+      Effect    = "Allow"
+      Principal = { Service = "autoscaling.amazonaws.com" }
+      Action    = "sts:AssumeRole"
+    }]
+  })
+}
+
+resource "aws_iam_role_policy" "lcmgr_notification" {
+  role = aws_iam_role.lcmgr_notification.id
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [{
+      Effect   = "Allow"
+{{- if .Topic }}
+      Action   = "sns:Publish"
+      Resource = aws_sns_topic.lcmgr.arn
+{{- else }}
+      Action   = ["sqs:SendMessage", "sqs:GetQueueUrl"]
+      Resource = aws_sqs_queue.lcmgr.arn
+{{- end }}
+    }]
+  })
+}
+{{- range .Hooks }}
+
+resource "aws_autoscaling_lifecycle_hook" "{{ terraformName .Name }}" {
+  name                    = "{{ .Name }}"
+  autoscaling_group_name  = var.auto_scaling_group_name
+  lifecycle_transition    = "{{ .Transition }}"
+  heartbeat_timeout       = {{ seconds .HeartbeatTimeout }}
+  default_result          = "{{ .DefaultResult }}"
+  notification_target_arn = {{ if $.Topic }}aws_sns_topic.lcmgr.arn{{ else }}aws_sqs_queue.lcmgr.arn{{ end }}
+  role_arn                = aws_iam_role.lcmgr_notification.arn
+  depends_on              = [aws_iam_role_policy.lcmgr_notification]
+}
+{{- end }}
+`))
+
+var cloudFormationTemplate = template.Must(template.New("cloudformation").Funcs(infraFuncs).Parse(`AWSTemplateFormatVersion: "2010-09-09"
+Description: Lifecycle hooks and notifications for lcmgr
+Parameters:
+  AutoScalingGroupName:
+    Type: String
+{{- if .AutoScalingGroupName }}
+    Default: "{{ .AutoScalingGroupName }}"
+{{- end }}
+Resources:
+{{- if .Topic }}
+  Topic:
+    Type: AWS::SNS::Topic
+    Properties:
+      TopicName: {{ if .QueueName }}"{{ .QueueName }}"{{ else }}!Sub "lcmgr-${AutoScalingGroupName}"{{ end }}
+{{- else }}
+  Queue:
+    Type: AWS::SQS::Queue
+    Properties:
+      QueueName: {{ if .QueueName }}"{{ .QueueName }}"{{ else }}!Sub "lcmgr-${AutoScalingGroupName}"{{ end }}
+{{- if isFIFO .QueueName }}
+      FifoQueue: true
+      ContentBasedDeduplication: true
+{{- end }}
+  QueuePolicy:
+    Type: AWS::SQS::QueuePolicy
+    Properties:
+      Queues:
+        - !Ref Queue
+      PolicyDocument:
+        Version: "2012-10-17"
+        Statement:
+          - Effect: Allow
+            Principal:
+              AWS: !GetAtt NotificationRole.Arn
+            Action: sqs:SendMessage
+            Resource: !GetAtt Queue.Arn
+{{- if .ConsumerRoleARN }}
+          - Effect: Allow
+            Principal:
+              AWS: "{{ .ConsumerRoleARN }}"
+            Action:
+              - sqs:GetQueueUrl
+              - sqs:ReceiveMessage
+              - sqs:DeleteMessage
+              - sqs:DeleteMessageBatch
+              - sqs:ChangeMessageVisibility
+              - sqs:ChangeMessageVisibilityBatch
+            Resource: !GetAtt Queue.Arn
+{{- end }}
+{{- end }}
+  NotificationRole:
+    Type: AWS::IAM::Role
+    Properties:
+      AssumeRolePolicyDocument:
+        Version: "2012-10-17"
+        Statement:
+          - Effect: Allow
+            Principal:
+              Service: autoscaling.amazonaws.com
+            Action: sts:AssumeRole
+      Policies:
+        - PolicyName: lcmgr-notification
+          PolicyDocument:
+            Version: "2012-10-17"
+            Statement:
+              - Effect: Allow
+{{- if .Topic }}
+                Action: sns:Publish
+                Resource: !Ref Topic
+{{- else }}
+                Action:
+                  - sqs:SendMessage
+                  - sqs:GetQueueUrl
+                Resource: !GetAtt Queue.Arn
+{{- end }}
+{{- range .Hooks }}
+  {{ logicalID .Name }}Hook:
+    Type: AWS::AutoScaling::LifecycleHook
+    Properties:
+      LifecycleHookName: "{{ .Name }}"
+      AutoScalingGroupName: !Ref AutoScalingGroupName
+      LifecycleTransition: "{{ .Transition }}"
+      HeartbeatTimeout: {{ seconds .HeartbeatTimeout }}
+      DefaultResult: "{{ .DefaultResult }}"
+      NotificationTargetARN: {{ if $.Topic }}!Ref Topic{{ else }}!GetAtt Queue.Arn{{ end }}
+      RoleARN: !GetAtt NotificationRole.Arn
+{{- end }}
+`))
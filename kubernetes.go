@@ -0,0 +1,160 @@
+package lcmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+)
+
+const KubernetesBackend = "kubernetes"
+
+// KubernetesManager treats the "service" as the Kubernetes node lcmgr runs on:
+// stopping it cordons and drains the node, starting it uncordons the node.
+// This lets a DaemonSet pod drive its own node's lifecycle with the regular
+// ServiceHandler.
+type KubernetesManager struct {
+	Command []string
+}
+
+type KubernetesManifestOptions struct {
+	Name      string
+	Namespace string
+	Image     string
+}
+
+func NewKubernetesManager() *KubernetesManager {
+	return &KubernetesManager{
+		Command: []string{"kubectl"},
+	}
+}
+
+func (manager *KubernetesManager) StartService(ctx context.Context, node string) error {
+	if _, err := runCommand(ctx, manager.Command, "uncordon", node); err != nil {
+		return fmt.Errorf("failed to uncordon kubernetes node %s: %v", node, err)
+	}
+	return nil
+}
+
+func (manager *KubernetesManager) StopService(ctx context.Context, node string) error {
+	if _, err := runCommand(ctx, manager.Command, "drain", node, "--ignore-daemonsets", "--delete-emptydir-data"); err != nil {
+		return fmt.Errorf("failed to drain kubernetes node %s: %v", node, err)
+	}
+	return nil
+}
+
+// ServiceState reports a cordoned node as inactive, since its workloads are
+// already being moved elsewhere.
+func (manager *KubernetesManager) ServiceState(ctx context.Context, node string) (*ServiceState, error) {
+	output, err := runCommand(ctx, manager.Command, "get", "node", node, "--output=jsonpath={.spec.unschedulable}")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kubernetes node %s: %v", node, err)
+	}
+
+	state := &ServiceState{LoadState: "loaded", ActiveState: "active"}
+	if strings.TrimSpace(output) == "true" {
+		state.ActiveState = "inactive"
+	}
+	return state, nil
+}
+
+func (manager *KubernetesManager) ServiceDependencies(ctx context.Context, node string) ([]string, error) {
+	return nil, nil
+}
+
+// DetectNodeName returns the name of the Kubernetes node lcmgr is running on,
+// preferring NODE_NAME as set from spec.nodeName by the generated DaemonSet.
+func DetectNodeName() (string, error) {
+	if name := os.Getenv("NODE_NAME"); name != "" {
+		return name, nil
+	}
+
+	name, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", errors.New("unable to detect kubernetes node name, set NODE_NAME")
+	}
+	return name, nil
+}
+
+func WriteKubernetesManifest(w io.Writer, options KubernetesManifestOptions) error {
+	return kubernetesManifestTemplate.Execute(w, options)
+}
+
+var kubernetesManifestTemplate = template.Must(template.New("manifest").Parse(`apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: {{ .Name }}
+rules:
+- apiGroups: [""]
+  resources: ["nodes"]
+  verbs: ["get", "list", "patch"]
+- apiGroups: [""]
+  resources: ["pods"]
+  verbs: ["get", "list", "delete"]
+- apiGroups: [""]
+  resources: ["pods/eviction"]
+  verbs: ["create"]
+- apiGroups: ["apps"]
+  resources: ["daemonsets", "statefulsets", "replicasets"]
+  verbs: ["get", "list"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: {{ .Name }}
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: {{ .Name }}
+subjects:
+- kind: ServiceAccount
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+spec:
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: {{ .Name }}
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: {{ .Name }}
+    spec:
+      serviceAccountName: {{ .Name }}
+      # Host networking keeps the instance metadata service reachable even
+      # when the instance's metadata hop limit is 1.
+      hostNetwork: true
+      tolerations:
+      - operator: Exists
+      containers:
+      - name: lcmgr
+        image: {{ .Image }}
+        args:
+        - --service-backend=kubernetes
+        env:
+        - name: NODE_NAME
+          valueFrom:
+            fieldRef:
+              fieldPath: spec.nodeName
+        resources:
+          requests:
+            cpu: 10m
+            memory: 32Mi
+`))
@@ -0,0 +1,204 @@
+package lcmgr
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const defaultHealthInterval = 5 * time.Second
+
+// DownloadHandler fetches a URL to Path, replacing it atomically once the
+// download completes and, when SHA256 is set, its checksum matches.
+type DownloadHandler struct {
+	URL    string
+	Path   string
+	SHA256 string
+	Client *http.Client
+}
+
+// RegisterHandler registers the instance with target groups, usually the
+// last step of a launch pipeline before health is verified.
+type RegisterHandler struct {
+	TargetGroupARNs []string
+	Port            int64
+	Client          AWSClient
+}
+
+// HealthHandler waits until URL responds with a 2xx status. It's bounded by
+// the step's timeout.
+type HealthHandler struct {
+	URL      string
+	Interval time.Duration
+	Client   *http.Client
+	Clock    Clock
+}
+
+func NewDownloadHandler(url, path, sha string) *DownloadHandler {
+	return &DownloadHandler{
+		URL:    url,
+		Path:   path,
+		SHA256: sha,
+		Client: &http.Client{},
+	}
+}
+
+func NewRegisterHandler(targetGroupARNs []string, port int64, client AWSClient) *RegisterHandler {
+	return &RegisterHandler{
+		TargetGroupARNs: targetGroupARNs,
+		Port:            port,
+		Client:          client,
+	}
+}
+
+func NewHealthHandler(url string, interval time.Duration) *HealthHandler {
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+	return &HealthHandler{
+		URL:      url,
+		Interval: interval,
+		Client:   &http.Client{Timeout: 5 * time.Second},
+		Clock:    NewClock(),
+	}
+}
+
+func (handler *DownloadHandler) Handle(ctx context.Context, notice Notice) error {
+	req, err := http.NewRequest(http.MethodGet, handler.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := handler.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status downloading %s: %s", handler.URL, resp.Status)
+	}
+
+	return writeVerified(handler.Path, handler.SHA256, resp.Body)
+}
+
+// writeVerified writes r to a temporary file next to path and renames it into
+// place if its SHA-256 matches sum, or if sum is empty.
+func writeVerified(path, sum string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), r); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return renameVerified(file.Name(), path, sum, hash)
+}
+
+// renameVerified renames the file at temp, whose contents were written to
+// digest, to path if its SHA-256 matches sum, or if sum is empty, and removes
+// it otherwise.
+func renameVerified(temp, path, sum string, digest hash.Hash) error {
+	if actual := hex.EncodeToString(digest.Sum(nil)); sum != "" && actual != sum {
+		os.Remove(temp)
+		return fmt.Errorf("checksum mismatch for %s, expected %s but got %s", path, sum, actual)
+	}
+	return os.Rename(temp, path)
+}
+
+func (handler *RegisterHandler) Handle(ctx context.Context, notice Notice) error {
+	instanceID, err := handler.Client.GetInstanceID(ctx)
+	if err != nil {
+		return err
+	}
+
+	targets := []*Target{{ID: instanceID, Port: handler.Port}}
+	for _, arn := range handler.TargetGroupARNs {
+		if err := handler.Client.RegisterTargets(ctx, arn, targets); err != nil {
+			return fmt.Errorf("failed to register with %s: %v", arn, err)
+		}
+		log.Printf("registered with %s", arn)
+	}
+	return nil
+}
+
+func (handler *HealthHandler) Handle(ctx context.Context, notice Notice) error {
+	for {
+		err := handler.check(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Printf("waiting for %s to become healthy: %v", handler.URL, err)
+
+		select {
+		case <-handler.Clock.After(handler.Interval):
+		case <-ctx.Done():
+			return fmt.Errorf("%s never became healthy: %v", handler.URL, err)
+		}
+	}
+}
+
+func (handler *HealthHandler) check(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, handler.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := handler.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// rollbackOnAbandon runs Rollback when launch fails and the lifecycle action
+// is about to be abandoned, so a half provisioned instance removes what it
+// registered in external systems while it still exists.
+func (handler *ServiceHandler) rollbackOnAbandon(launch HandlerFunc, abandon bool) HandlerFunc {
+	if handler.Rollback == nil || !abandon {
+		return launch
+	}
+
+	return func(ctx context.Context, notice Notice) error {
+		err := launch(ctx, notice)
+		if err == nil {
+			return nil
+		}
+
+		// The launch may have failed by running out its deadline, which
+		// mustn't keep the cleanup from running, but it's bounded the same
+		// way as completing the lifecycle action so it can't hang.
+		log.Printf("launch failed, running rollback steps before abandoning it")
+		rollbackCtx, cancel := completionContext(ctx)
+		defer cancel()
+		if rollbackErr := handler.Rollback.Handle(rollbackCtx, notice); rollbackErr != nil {
+			log.Printf("failed to roll back launch: %v", rollbackErr)
+			Annotate(ctx, "rollback", "failed")
+		} else {
+			Annotate(ctx, "rollback", "succeeded")
+		}
+		return err
+	}
+}
@@ -6,21 +6,57 @@ import (
 	"time"
 )
 
+var (
+	spotPollsCounter      = DefaultRegistry.Counter("lcmgr_spot_polls_total", "Number of times instance metadata was polled for a spot notice")
+	spotPollIntervalGauge = DefaultRegistry.Gauge("lcmgr_spot_poll_interval_seconds", "Current interval between spot notice polls")
+)
+
+// Adaptive spot polling backs off by spotBackoffFactor on on-demand instances,
+// which never receive spot notices, and by spotProtectedBackoffFactor on
+// instances protected from scale-in. A rebalance recommendation speeds polling
+// up by spotRebalanceFactor.
+const (
+	spotBackoffFactor             = 10
+	spotProtectedBackoffFactor    = 2
+	spotRebalanceFactor           = 6
+	minSpotInterval               = 5 * time.Second
+	spotProtectionRefreshInterval = 10 * time.Minute
+)
+
 type Listener interface {
 	Type() string
 	Listen(context.Context) error
 }
 
+// SpotListener polls instance metadata for spot interruption notices. When
+// Adaptive is set the poll interval backs off on instances that can't be
+// interrupted soon and tightens once EC2 recommends rebalancing. When
+// Rebalance is set each rebalance recommendation is also sent as a
+// RebalanceNotice, so draining can start before the spot notice.
 type SpotListener struct {
-	Notices  chan Notice
-	Interval time.Duration
-	Client   AWSClient
+	Notices   chan Notice
+	Interval  time.Duration
+	Adaptive  bool
+	Rebalance bool
+	Client    AWSClient
+	Clock     Clock
+
+	lifeCycle   string
+	protected   bool
+	protectedAt time.Time
+	rebalanced  time.Time
 }
 
+// LifecycleListener receives lifecycle notices from Queue. Queues fed by
+// EventBridge carry both launch and termination notices, and may also carry
+// spot and rebalance notices. Rebalance notices are only sent on when
+// Rebalance is set, as with SpotListener.
 type LifecycleListener struct {
-	Notices chan Notice
-	Queue   *Queue
-	Client  AWSClient
+	Notices   chan Notice
+	Queue     *Queue
+	Rebalance bool
+	Client    AWSClient
+	Clock     Clock
 }
 
 type LaunchListener struct {
@@ -31,21 +67,46 @@ type TerminationListener struct {
 	*LifecycleListener
 }
 
+// ScheduledActionListener periodically looks for scheduled scaling actions
+// starting within Lookahead and sends a warning for each to Sinks once.
+type ScheduledActionListener struct {
+	Sinks     []Sink
+	Interval  time.Duration
+	Lookahead time.Duration
+	Client    AWSClient
+	Clock     Clock
+}
+
 type ErrorListener struct{}
 
-func NewSpotListener(notices chan Notice, interval time.Duration, client AWSClient) Listener {
+func NewSpotListener(notices chan Notice, interval time.Duration, adaptive, rebalance bool, client AWSClient) Listener {
 	return &SpotListener{
-		Notices:  notices,
-		Interval: interval,
-		Client:   client,
+		Notices:   notices,
+		Interval:  interval,
+		Adaptive:  adaptive,
+		Rebalance: rebalance,
+		Client:    client,
+		Clock:     NewClock(),
+	}
+}
+
+func NewScheduledActionListener(sinks []Sink, interval, lookahead time.Duration, client AWSClient) Listener {
+	return &ScheduledActionListener{
+		Sinks:     sinks,
+		Interval:  interval,
+		Lookahead: lookahead,
+		Client:    client,
+		Clock:     NewClock(),
 	}
 }
 
-func NewLifecycleListener(notices chan Notice, queue *Queue, client AWSClient) Listener {
+func NewLifecycleListener(notices chan Notice, queue *Queue, rebalance bool, client AWSClient) Listener {
 	listener := &LifecycleListener{
-		Notices: notices,
-		Queue:   queue,
-		Client:  client,
+		Notices:   notices,
+		Queue:     queue,
+		Rebalance: rebalance,
+		Client:    client,
+		Clock:     NewClock(),
 	}
 
 	switch queue.Action {
@@ -53,64 +114,194 @@ func NewLifecycleListener(notices chan Notice, queue *Queue, client AWSClient) L
 		return &LaunchListener{listener}
 	case TerminationLifecycleAction:
 		return &TerminationListener{listener}
+	case "":
+		return listener
 	default:
 		return &TerminationListener{listener}
 	}
 }
 
 func (listener *SpotListener) Listen(ctx context.Context) error {
-	ticker := time.NewTicker(listener.Interval)
-	defer ticker.Stop()
-
-	var notice Notice
-	var notices chan Notice
+	interval := listener.Interval
 	for {
-		if notice != nil {
-			notices = listener.Notices
-		}
-
 		select {
-		case notices <- notice:
-			notices = nil
-		case <-ticker.C:
-			var err error
-			notice, err = listener.Client.GetSpotNotice()
-			if err != nil {
-				log.Printf("failed to get spot notice: %v", err)
-			}
+		case <-listener.Clock.After(interval):
 		case <-ctx.Done():
 			return nil
 		}
+
+		notice := listener.poll(ctx)
+		if notice != nil {
+			select {
+			case listener.Notices <- notice:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		if listener.Adaptive {
+			interval = listener.adaptiveInterval(ctx)
+		}
+		spotPollIntervalGauge.Set(interval.Seconds())
 	}
 }
 
+// poll returns the spot notice, if any, or else a rebalance notice that
+// hasn't been sent yet when Rebalance is set. A rebalance notice is marked
+// sent before it's returned, since it belongs to the handler once sent.
+func (listener *SpotListener) poll(ctx context.Context) Notice {
+	spotPollsCounter.Inc()
+	notice, err := listener.Client.GetSpotNotice(ctx)
+	if err != nil {
+		log.Printf("failed to get spot notice: %v", err)
+	}
+	if notice != nil || !listener.Rebalance {
+		return notice
+	}
+
+	rebalance := listener.rebalanceNotice(ctx)
+	if rebalance != nil {
+		listener.rebalanced = rebalance.NoticeTime
+		return rebalance
+	}
+	return nil
+}
+
+// rebalanceNotice returns a notice for a rebalance recommendation that
+// hasn't been sent yet, or nil.
+func (listener *SpotListener) rebalanceNotice(ctx context.Context) *RebalanceNotice {
+	recommendation, err := listener.Client.GetRebalanceRecommendation(ctx)
+	if err != nil {
+		log.Printf("failed to get rebalance recommendation: %v", err)
+		return nil
+	}
+	if recommendation == nil || recommendation.Equal(listener.rebalanced) {
+		return nil
+	}
+	return NewRebalanceNotice(*recommendation)
+}
+
+func (listener *SpotListener) adaptiveInterval(ctx context.Context) time.Duration {
+	if listener.lifeCycle == "" {
+		lifeCycle, err := listener.Client.GetInstanceLifeCycle(ctx)
+		if err != nil {
+			log.Printf("failed to get instance life cycle: %v", err)
+			return listener.Interval
+		}
+		listener.lifeCycle = lifeCycle
+	}
+	if listener.lifeCycle != "spot" {
+		return listener.Interval * spotBackoffFactor
+	}
+
+	recommendation, err := listener.Client.GetRebalanceRecommendation(ctx)
+	if err != nil {
+		log.Printf("failed to get rebalance recommendation: %v", err)
+	} else if recommendation != nil {
+		interval := listener.Interval / spotRebalanceFactor
+		if interval < minSpotInterval {
+			interval = minSpotInterval
+		}
+		return interval
+	}
+
+	now := listener.Clock.Now()
+	if now.Sub(listener.protectedAt) >= spotProtectionRefreshInterval {
+		protected, err := listener.Client.IsProtectedFromScaleIn(ctx)
+		if err != nil {
+			log.Printf("failed to get scale-in protection: %v", err)
+		} else {
+			listener.protected = protected
+			listener.protectedAt = now
+		}
+	}
+	if listener.protected {
+		return listener.Interval * spotProtectedBackoffFactor
+	}
+
+	return listener.Interval
+}
+
 func (listener *SpotListener) Type() string {
 	return "spot"
 }
 
+// Listen hands every notice from a receive to the handler before receiving
+// again. The messages of notices waiting their turn are held from the
+// receive on, so they aren't received again while earlier notices are
+// handled, and the handler holds each one once it's been sent.
 func (listener *LifecycleListener) Listen(ctx context.Context) error {
-	var notice Notice
-	var notices chan Notice
+	var pending []Notice
+	var releases []func()
+	defer func() {
+		for _, release := range releases {
+			release()
+		}
+	}()
+
 	for {
-		if notice != nil {
-			notices = listener.Notices
+		if len(pending) == 0 {
+			var err error
+			pending, err = listener.Client.GetLifecycleNotices(ctx, listener.Queue)
+			if err != nil {
+				log.Printf("failed to get lifecycle notices from queue %v: %v", listener.Queue.Name, err)
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			pending = listener.filter(pending)
+			releases = make([]func(), len(pending))
+			for i, notice := range pending {
+				releases[i] = listener.hold(ctx, notice)
+			}
+			continue
 		}
 
 		select {
-		case notices <- notice:
-			notices = nil
+		case listener.Notices <- pending[0]:
+			releases[0]()
+			pending, releases = pending[1:], releases[1:]
 		case <-ctx.Done():
 			return nil
-		default:
-			var err error
-			notice, err = listener.Client.GetLifecycleNotice(ctx, listener.Queue)
-			if err != nil {
-				log.Printf("failed to get lifecycle notice from queue %v: %v", listener.Queue.Name, err)
-			}
 		}
 	}
 }
 
+// filter drops rebalance notices unless Rebalance is set.
+func (listener *LifecycleListener) filter(notices []Notice) []Notice {
+	if listener.Rebalance {
+		return notices
+	}
+	filtered := notices[:0]
+	for _, notice := range notices {
+		if _, ok := notice.(*RebalanceNotice); ok {
+			debugf("ignoring rebalance recommendation from queue %s, draining on rebalance isn't enabled", listener.Queue.Name)
+			continue
+		}
+		filtered = append(filtered, notice)
+	}
+	return filtered
+}
+
+// hold keeps a pending notice's message hidden until the returned function
+// is called, which waits for holding to stop.
+func (listener *LifecycleListener) hold(ctx context.Context, notice Notice) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		HoldNoticeMessage(ctx, listener.Client, listener.Clock, notice)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (listener *LifecycleListener) Type() string {
+	return "lifecycle"
+}
+
 func (listener *LaunchListener) Type() string {
 	return "launch"
 }
@@ -118,3 +309,59 @@ func (listener *LaunchListener) Type() string {
 func (listener *TerminationListener) Type() string {
 	return "termination"
 }
+
+func (listener *ScheduledActionListener) Listen(ctx context.Context) error {
+	ticker := listener.Clock.NewTicker(listener.Interval)
+	defer ticker.Stop()
+
+	warned := make(map[string]time.Time)
+	for {
+		now := listener.Clock.Now()
+		for key, startTime := range warned {
+			if startTime.Before(now) {
+				delete(warned, key)
+			}
+		}
+
+		notices, err := listener.check(ctx, now)
+		if err != nil {
+			log.Printf("failed to get scheduled actions: %v", err)
+		}
+		for _, notice := range notices {
+			key := notice.Name + "@" + notice.StartTime.String()
+			if _, ok := warned[key]; ok {
+				continue
+			}
+			warned[key] = notice.StartTime
+			SendToSinks(ctx, listener.Sinks, notice)
+		}
+
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (listener *ScheduledActionListener) check(ctx context.Context, now time.Time) ([]*ScheduledActionNotice, error) {
+	actions, err := listener.Client.GetScheduledActions(ctx, now, now.Add(listener.Lookahead))
+	if err != nil || len(actions) == 0 {
+		return nil, err
+	}
+
+	capacity, err := listener.Client.GetDesiredCapacity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	notices := make([]*ScheduledActionNotice, 0, len(actions))
+	for _, action := range actions {
+		notices = append(notices, NewScheduledActionNotice(action, capacity))
+	}
+	return notices, nil
+}
+
+func (listener *ScheduledActionListener) Type() string {
+	return "scheduled-action"
+}
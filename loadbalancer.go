@@ -0,0 +1,423 @@
+package lcmgr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+)
+
+const (
+	defaultLoadBalancerPollInterval = 5 * time.Second
+
+	// Target health states reported by elbv2 while and after a target is
+	// deregistered.
+	drainingTargetState = "draining"
+	unusedTargetState   = "unused"
+
+	// deadlineMargin is left before a notice's deadline when CapToDeadline
+	// cuts a drain short, so later steps still have time to run.
+	deadlineMargin = 10 * time.Second
+)
+
+const (
+	ApplicationLoadBalancer = "application"
+	NetworkLoadBalancer     = "network"
+	GatewayLoadBalancer     = "gateway"
+)
+
+// TargetGroup describes the parts of a target group that affect draining.
+type TargetGroup struct {
+	ARN                   string
+	Type                  string
+	DeregistrationDelay   time.Duration
+	ConnectionTermination bool
+}
+
+// Target is a registration of this instance in a target group.
+type Target struct {
+	ID    string
+	Port  int64
+	State string
+}
+
+// LoadBalancerDrainHandler deregisters the instance from target groups and
+// waits for its traffic to drain. Rather than waiting out the whole
+// deregistration delay, it polls ConnectionsURL, an application endpoint
+// reporting the number of open connections or in flight requests, and
+// returns once the count is at or below Threshold. Without ConnectionsURL it
+// waits for the targets to finish draining.
+//
+// Network and gateway load balancers route by flow rather than by request,
+// so existing flows keep reaching a deregistered target until they close or
+// the deregistration delay (often several minutes) passes. Their targets are
+// always waited on until draining finishes, and when CapToDeadline is set the
+// wait ends shortly before the notice's deadline instead.
+//
+// ClassicNames are classic load balancers to deregister from, waiting for
+// their connection draining to finish. With Discover set, the target groups
+// and classic load balancers the instance is registered with are found when
+// the drain starts and handled along with the configured ones.
+type LoadBalancerDrainHandler struct {
+	TargetGroupARNs []string
+	ClassicNames    []string
+	Discover        bool
+	ConnectionsURL  string
+	Threshold       int
+	Interval        time.Duration
+	CapToDeadline   bool
+	Client          AWSClient
+	HTTPClient      *http.Client
+	Clock           Clock
+
+	targetGroups []string
+	classics     []string
+}
+
+func NewLoadBalancerDrainHandler(targetGroupARNs []string, connectionsURL string, threshold int, interval time.Duration, capToDeadline bool, client AWSClient) *LoadBalancerDrainHandler {
+	if interval <= 0 {
+		interval = defaultLoadBalancerPollInterval
+	}
+	return &LoadBalancerDrainHandler{
+		TargetGroupARNs: targetGroupARNs,
+		ConnectionsURL:  connectionsURL,
+		Threshold:       threshold,
+		Interval:        interval,
+		CapToDeadline:   capToDeadline,
+		Client:          client,
+		HTTPClient:      &http.Client{Timeout: 5 * time.Second},
+		Clock:           NewClock(),
+	}
+}
+
+func (handler *LoadBalancerDrainHandler) Handle(ctx context.Context, notice Notice) error {
+	return Drain(ctx, handler, notice)
+}
+
+// Deregister removes the instance from every target group and classic load
+// balancer.
+func (handler *LoadBalancerDrainHandler) Deregister(ctx context.Context, notice Notice) error {
+	if err := handler.resolve(ctx); err != nil {
+		return err
+	}
+
+	deregistered := 0
+	defer func() {
+		Annotate(ctx, "targets_deregistered", strconv.Itoa(deregistered))
+	}()
+
+	for _, name := range handler.classics {
+		if err := handler.Client.DeregisterFromClassicLoadBalancer(ctx, name); err != nil {
+			return fmt.Errorf("failed to deregister from %s: %v", name, err)
+		}
+		deregistered++
+		log.Printf("deregistered from classic load balancer %s", name)
+	}
+
+	for _, arn := range handler.targetGroups {
+		group, err := handler.Client.GetTargetGroup(ctx, arn)
+		if err != nil {
+			return fmt.Errorf("failed to describe %s: %v", arn, err)
+		}
+		if isFlowBased(group) && !group.ConnectionTermination {
+			log.Printf("existing flows to %s %s may take up to %v to drain", group.Type, arn, group.DeregistrationDelay)
+		}
+
+		targets, err := handler.Client.GetTargetHealth(ctx, arn)
+		if err != nil {
+			return fmt.Errorf("failed to get targets of %s: %v", arn, err)
+		}
+		if len(targets) == 0 {
+			log.Printf("instance is not registered with %s", arn)
+			continue
+		}
+		if err := handler.Client.DeregisterTargets(ctx, arn, targets); err != nil {
+			return fmt.Errorf("failed to deregister from %s: %v", arn, err)
+		}
+		deregistered += len(targets)
+		log.Printf("deregistered from %s", arn)
+	}
+	return nil
+}
+
+// WaitDrained waits for connections and flows to drain, or until shortly
+// before the deadline when CapToDeadline is set.
+func (handler *LoadBalancerDrainHandler) WaitDrained(ctx context.Context, notice Notice) error {
+	var flowBased []string
+	for _, arn := range handler.targetGroups {
+		group, err := handler.Client.GetTargetGroup(ctx, arn)
+		if err != nil {
+			return fmt.Errorf("failed to describe %s: %v", arn, err)
+		}
+		if isFlowBased(group) {
+			flowBased = append(flowBased, arn)
+		}
+	}
+
+	var cutoff <-chan time.Time
+	if deadline, ok := noticeDeadline(ctx, notice); ok && handler.CapToDeadline {
+		cutoff = handler.Clock.After(deadline.Sub(handler.Clock.Now()) - deadlineMargin)
+	}
+
+	for {
+		drained, err := handler.drained(ctx, flowBased)
+		if err != nil {
+			log.Printf("failed to check drain progress: %v", err)
+		} else if drained {
+			return nil
+		}
+
+		select {
+		case <-handler.Clock.After(handler.Interval):
+		case <-cutoff:
+			log.Printf("stopped waiting for load balancer drain to leave time before the %s notice deadline", notice.Type())
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (handler *LoadBalancerDrainHandler) Finalize(ctx context.Context, notice Notice) error {
+	return nil
+}
+
+func isFlowBased(group *TargetGroup) bool {
+	return group.Type == NetworkLoadBalancer || group.Type == GatewayLoadBalancer
+}
+
+// drained reports whether connections are below the threshold and the flow
+// based target groups and classic load balancers have finished draining.
+func (handler *LoadBalancerDrainHandler) drained(ctx context.Context, flowBased []string) (bool, error) {
+	if drained, err := handler.classicsDrained(ctx); err != nil || !drained {
+		return false, err
+	}
+	if handler.ConnectionsURL != "" {
+		connections, err := readConnections(ctx, handler.HTTPClient, handler.ConnectionsURL)
+		if err != nil {
+			return false, err
+		}
+		log.Printf("%d connections remaining", connections)
+		if connections > handler.Threshold {
+			return false, nil
+		}
+		return handler.targetsDrained(ctx, flowBased)
+	}
+	return handler.targetsDrained(ctx, handler.targetGroups)
+}
+
+// resolve settles the target groups and classic load balancers to drain,
+// adding the discovered ones to the configured ones when Discover is set.
+func (handler *LoadBalancerDrainHandler) resolve(ctx context.Context) error {
+	handler.targetGroups = handler.TargetGroupARNs
+	handler.classics = handler.ClassicNames
+	if !handler.Discover {
+		return nil
+	}
+
+	found, err := handler.Client.DiscoverLoadBalancers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to discover load balancers: %v", err)
+	}
+	handler.targetGroups = appendMissing(handler.targetGroups, found.TargetGroupARNs)
+	handler.classics = appendMissing(handler.classics, found.ClassicNames)
+	log.Printf("discovered %d target groups and %d classic load balancers", len(found.TargetGroupARNs), len(found.ClassicNames))
+	return nil
+}
+
+func (handler *LoadBalancerDrainHandler) classicsDrained(ctx context.Context) (bool, error) {
+	for _, name := range handler.classics {
+		drained, err := handler.Client.ClassicLoadBalancerDrained(ctx, name)
+		if err != nil || !drained {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// appendMissing appends the values not already in list.
+func appendMissing(list, values []string) []string {
+	result := append([]string(nil), list...)
+	for _, value := range values {
+		missing := true
+		for _, existing := range result {
+			if existing == value {
+				missing = false
+				break
+			}
+		}
+		if missing {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+func (handler *LoadBalancerDrainHandler) targetsDrained(ctx context.Context, arns []string) (bool, error) {
+	for _, arn := range arns {
+		targets, err := handler.Client.GetTargetHealth(ctx, arn)
+		if err != nil {
+			return false, err
+		}
+		for _, target := range targets {
+			if target.State == drainingTargetState {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// readConnections reads a connection count from an application endpoint,
+// either a bare number or a JSON object with a connections field.
+func readConnections(ctx context.Context, client *http.Client, url string) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status from %s: %s", url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	if n, err := strconv.Atoi(strings.TrimSpace(string(body))); err == nil {
+		return n, nil
+	}
+	var payload struct {
+		Connections *int `json:"connections"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Connections == nil {
+		return 0, errors.New("expected a number or {\"connections\": n} from " + url)
+	}
+	return *payload.Connections, nil
+}
+
+func (client *awsClient) GetTargetGroup(ctx context.Context, targetGroupARN string) (*TargetGroup, error) {
+	output, err := client.ELBV2().DescribeTargetGroups(ctx, &elasticloadbalancingv2.DescribeTargetGroupsInput{
+		TargetGroupArns: []string{targetGroupARN},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.TargetGroups) != 1 {
+		return nil, fmt.Errorf("target group %s not found", targetGroupARN)
+	}
+
+	group := &TargetGroup{
+		ARN:  targetGroupARN,
+		Type: loadBalancerType(string(output.TargetGroups[0].Protocol)),
+	}
+
+	attributes, err := client.ELBV2().DescribeTargetGroupAttributes(ctx, &elasticloadbalancingv2.DescribeTargetGroupAttributesInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, attribute := range attributes.Attributes {
+		switch aws.ToString(attribute.Key) {
+		case "deregistration_delay.timeout_seconds":
+			seconds, _ := strconv.Atoi(aws.ToString(attribute.Value))
+			group.DeregistrationDelay = time.Duration(seconds) * time.Second
+		case "deregistration_delay.connection_termination.enabled":
+			group.ConnectionTermination = aws.ToString(attribute.Value) == "true"
+		}
+	}
+	return group, nil
+}
+
+// loadBalancerType infers the kind of load balancer a target group belongs
+// to from its protocol.
+func loadBalancerType(protocol string) string {
+	switch protocol {
+	case "TCP", "UDP", "TCP_UDP", "TLS":
+		return NetworkLoadBalancer
+	case "GENEVE":
+		return GatewayLoadBalancer
+	default:
+		return ApplicationLoadBalancer
+	}
+}
+
+// GetTargetHealth returns this instance's registrations in a target group.
+// Targets that have finished deregistering aren't included.
+func (client *awsClient) GetTargetHealth(ctx context.Context, targetGroupARN string) ([]*Target, error) {
+	instanceID, err := client.GetInstanceID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &elasticloadbalancingv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+	}
+	output, err := client.ELBV2().DescribeTargetHealth(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []*Target
+	for _, description := range output.TargetHealthDescriptions {
+		if aws.ToString(description.Target.Id) != instanceID {
+			continue
+		}
+		state := string(description.TargetHealth.State)
+		if state == unusedTargetState {
+			continue
+		}
+		targets = append(targets, &Target{
+			ID:    instanceID,
+			Port:  int64(aws.ToInt32(description.Target.Port)),
+			State: state,
+		})
+	}
+	return targets, nil
+}
+
+func (client *awsClient) RegisterTargets(ctx context.Context, targetGroupARN string, targets []*Target) error {
+	input := &elasticloadbalancingv2.RegisterTargetsInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+		Targets:        targetDescriptions(targets),
+	}
+	_, err := client.ELBV2().RegisterTargets(ctx, input)
+	return err
+}
+
+func (client *awsClient) DeregisterTargets(ctx context.Context, targetGroupARN string, targets []*Target) error {
+	input := &elasticloadbalancingv2.DeregisterTargetsInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+		Targets:        targetDescriptions(targets),
+	}
+	_, err := client.ELBV2().DeregisterTargets(ctx, input)
+	return err
+}
+
+func targetDescriptions(targets []*Target) []types.TargetDescription {
+	descriptions := make([]types.TargetDescription, 0, len(targets))
+	for _, target := range targets {
+		description := types.TargetDescription{Id: aws.String(target.ID)}
+		if target.Port != 0 {
+			description.Port = aws.Int32(int32(target.Port))
+		}
+		descriptions = append(descriptions, description)
+	}
+	return descriptions
+}
@@ -0,0 +1,89 @@
+package lcmgr
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// DefaultMaintenanceLead is how long before a scheduled event starts its
+// notice is sent by default.
+const DefaultMaintenanceLead = 10 * time.Minute
+
+// maintenanceEventCodes are the scheduled event codes that take the host
+// away from the instance.
+var maintenanceEventCodes = []string{"system-reboot", "system-maintenance"}
+
+// ScheduledEventListener polls instance metadata for EC2 scheduled
+// maintenance events and sends a ScheduledEventNotice for each active
+// system-reboot or system-maintenance event once it is due to start within
+// Lead, so services can be stopped gracefully before the host goes away.
+type ScheduledEventListener struct {
+	Notices  chan Notice
+	Interval time.Duration
+	Lead     time.Duration
+	Client   AWSClient
+	Clock    Clock
+
+	sent map[string]bool
+}
+
+func NewScheduledEventListener(notices chan Notice, interval, lead time.Duration, client AWSClient) Listener {
+	return &ScheduledEventListener{
+		Notices:  notices,
+		Interval: interval,
+		Lead:     lead,
+		Client:   client,
+		Clock:    NewClock(),
+		sent:     make(map[string]bool),
+	}
+}
+
+func (listener *ScheduledEventListener) Listen(ctx context.Context) error {
+	ticker := listener.Clock.NewTicker(listener.Interval)
+	defer ticker.Stop()
+
+	for {
+		for _, notice := range listener.due(ctx) {
+			select {
+			case listener.Notices <- notice:
+				listener.sent[notice.ID] = true
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// due returns notices for maintenance events that haven't been sent yet and
+// start within the lead time.
+func (listener *ScheduledEventListener) due(ctx context.Context) []*ScheduledEventNotice {
+	events, err := listener.Client.GetScheduledEvents(ctx)
+	if err != nil {
+		log.Printf("failed to get scheduled events: %v", err)
+		return nil
+	}
+
+	now := listener.Clock.Now()
+	var notices []*ScheduledEventNotice
+	for _, event := range events {
+		if event.State != "active" || listener.sent[event.ID] || !containsString(maintenanceEventCodes, event.Code) {
+			continue
+		}
+		if event.NotBefore.Sub(now) > listener.Lead {
+			continue
+		}
+		notices = append(notices, NewScheduledEventNotice(event))
+	}
+	return notices
+}
+
+func (listener *ScheduledEventListener) Type() string {
+	return "scheduled-event"
+}
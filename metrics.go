@@ -0,0 +1,196 @@
+package lcmgr
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	CounterMetric = "counter"
+	GaugeMetric   = "gauge"
+)
+
+// Registry holds metrics and renders them in the Prometheus text exposition
+// format. It is intentionally small, lcmgr only needs counters and gauges.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*Metric
+}
+
+type Metric struct {
+	Name       string
+	Help       string
+	Kind       string
+	LabelNames []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	labels map[string][]string
+}
+
+// Sample is the value of one labeled series of a metric.
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (registry *Registry) Counter(name, help string, labelNames ...string) *Metric {
+	return registry.register(name, help, CounterMetric, labelNames)
+}
+
+func (registry *Registry) Gauge(name, help string, labelNames ...string) *Metric {
+	return registry.register(name, help, GaugeMetric, labelNames)
+}
+
+func (registry *Registry) register(name, help, kind string, labelNames []string) *Metric {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	metric := &Metric{
+		Name:       name,
+		Help:       help,
+		Kind:       kind,
+		LabelNames: labelNames,
+		values:     make(map[string]float64),
+		labels:     make(map[string][]string),
+	}
+	registry.metrics = append(registry.metrics, metric)
+	return metric
+}
+
+// Metrics returns every registered metric.
+func (registry *Registry) Metrics() []*Metric {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return append([]*Metric{}, registry.metrics...)
+}
+
+func (registry *Registry) WriteTo(w io.Writer) (int64, error) {
+	registry.mu.Lock()
+	metrics := append([]*Metric{}, registry.metrics...)
+	registry.mu.Unlock()
+
+	counter := &countingWriter{w: bufio.NewWriter(w)}
+	for _, metric := range metrics {
+		metric.writeTo(counter)
+	}
+	if err := counter.w.(*bufio.Writer).Flush(); err != nil {
+		return counter.n, err
+	}
+	return counter.n, counter.err
+}
+
+func (registry *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	registry.WriteTo(w)
+}
+
+func (metric *Metric) Set(value float64, labelValues ...string) {
+	key := metric.key(labelValues)
+
+	metric.mu.Lock()
+	defer metric.mu.Unlock()
+	metric.values[key] = value
+	metric.labels[key] = labelValues
+}
+
+func (metric *Metric) Add(delta float64, labelValues ...string) {
+	key := metric.key(labelValues)
+
+	metric.mu.Lock()
+	defer metric.mu.Unlock()
+	metric.values[key] += delta
+	metric.labels[key] = labelValues
+}
+
+func (metric *Metric) Inc(labelValues ...string) {
+	metric.Add(1, labelValues...)
+}
+
+func (metric *Metric) Value(labelValues ...string) float64 {
+	key := metric.key(labelValues)
+
+	metric.mu.Lock()
+	defer metric.mu.Unlock()
+	return metric.values[key]
+}
+
+// Samples returns the current value of every series of the metric.
+func (metric *Metric) Samples() []Sample {
+	metric.mu.Lock()
+	defer metric.mu.Unlock()
+
+	samples := make([]Sample, 0, len(metric.values))
+	for key, value := range metric.values {
+		labels := make(map[string]string, len(metric.LabelNames))
+		for i, labelValue := range metric.labels[key] {
+			labels[metric.LabelNames[i]] = labelValue
+		}
+		samples = append(samples, Sample{Labels: labels, Value: value})
+	}
+	return samples
+}
+
+func (metric *Metric) key(labelValues []string) string {
+	if len(labelValues) != len(metric.LabelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", metric.Name, len(metric.LabelNames), len(labelValues)))
+	}
+	if len(labelValues) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(labelValues))
+	for i, value := range labelValues {
+		pairs[i] = metric.LabelNames[i] + "=" + strconv.Quote(value)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (metric *Metric) writeTo(w io.Writer) {
+	metric.mu.Lock()
+	defer metric.mu.Unlock()
+
+	if len(metric.values) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(metric.values))
+	for key := range metric.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n", metric.Name, metric.Help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", metric.Name, metric.Kind)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", metric.Name, key, strconv.FormatFloat(metric.values[key], 'g', -1, 64))
+	}
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (writer *countingWriter) Write(p []byte) (int, error) {
+	if writer.err != nil {
+		return 0, writer.err
+	}
+	n, err := writer.w.Write(p)
+	writer.n += int64(n)
+	writer.err = err
+	return n, err
+}
@@ -2,17 +2,97 @@ package lcmgr
 
 import "time"
 
+// Notice is something that happened to the instance.
 type Notice interface {
 	Type() string
 }
 
+// DeadlineNotice is implemented by notices that say when the instance will be
+// interrupted regardless of how handling them goes, such as spot notices.
+// It's separate from Notice so notices defined outside lcmgr, which only
+// have to implement Type, keep working.
+type DeadlineNotice interface {
+	Notice
+	Deadline() (time.Time, bool)
+}
+
+// noticeDeadlineOf returns when notice has to be handled by, if it has a
+// deadline.
+func noticeDeadlineOf(notice Notice) (time.Time, bool) {
+	if deadlined, ok := notice.(DeadlineNotice); ok {
+		return deadlined.Deadline()
+	}
+	return time.Time{}, false
+}
+
+// Spot interruption actions, what happens to a spot instance when it is
+// interrupted.
+const (
+	SpotTerminateAction = "terminate"
+	SpotStopAction      = "stop"
+	SpotHibernateAction = "hibernate"
+)
+
+// SpotNotice reports a spot interruption. Action is what happens to the
+// instance at TerminationTime: terminate, stop, or hibernate.
 type SpotNotice struct {
+	Action          string
 	TerminationTime time.Time
 }
 
+// RebalanceNotice reports that EC2 recommended rebalancing the instance
+// because its interruption risk is elevated, usually well before a spot
+// notice would arrive.
+type RebalanceNotice struct {
+	NoticeTime time.Time
+}
+
+// ScheduledActionNotice warns about an upcoming scheduled scaling action on
+// the instance's auto scaling group. It is informational only, any resulting
+// termination still arrives as a lifecycle notice.
+type ScheduledActionNotice struct {
+	*ScheduledAction
+	CurrentCapacity int64
+}
+
+// ScheduledEventNotice warns that an EC2 scheduled event, e.g. a
+// system-reboot for host maintenance, is about to start.
+type ScheduledEventNotice struct {
+	*ScheduledEvent
+}
+
+// DegradedNotice reports that the instance's system or instance status
+// checks are failing, so workloads can move off unhealthy hardware before
+// EC2 recovers or retires the instance.
+type DegradedNotice struct {
+	*StatusChecks
+}
+
+// ServiceFailureNotice reports that a supervised service failed and is being
+// restarted. It's only sent to sinks.
+type ServiceFailureNotice struct {
+	Service  string
+	Restarts int
+	Error    string
+}
+
+// LifecycleNotice is a pending lifecycle action. HeartbeatTimeout,
+// GlobalTimeout, and DefaultResult are copied from the hook once it's known,
+// and are zero otherwise. Receipt, ReceivedAt, TraceHeader, and Attributes
+// are set for notices received from a queue, ReceivedAt from when the message
+// was first sent so it doesn't reset when the message is received again, and
+// the latter two from the message's AWSTraceHeader and message attributes.
 type LifecycleNotice struct {
 	LifecycleHookName    string
 	LifecycleActionToken string
+	HeartbeatTimeout     time.Duration
+	GlobalTimeout        time.Duration
+	DefaultResult        string
+	NotificationMetadata string            `json:",omitempty"`
+	Receipt              *Receipt          `json:"-"`
+	ReceivedAt           time.Time         `json:"-"`
+	TraceHeader          string            `json:",omitempty"`
+	Attributes           map[string]string `json:",omitempty"`
 }
 
 type LaunchNotice struct {
@@ -23,12 +103,38 @@ type TerminationNotice struct {
 	*LifecycleNotice
 }
 
-func NewSpotNotice(terminationTime time.Time) *SpotNotice {
+func NewSpotNotice(action string, terminationTime time.Time) *SpotNotice {
 	return &SpotNotice{
+		Action:          action,
 		TerminationTime: terminationTime,
 	}
 }
 
+func NewRebalanceNotice(noticeTime time.Time) *RebalanceNotice {
+	return &RebalanceNotice{
+		NoticeTime: noticeTime,
+	}
+}
+
+func NewScheduledActionNotice(action *ScheduledAction, currentCapacity int64) *ScheduledActionNotice {
+	return &ScheduledActionNotice{
+		ScheduledAction: action,
+		CurrentCapacity: currentCapacity,
+	}
+}
+
+func NewScheduledEventNotice(event *ScheduledEvent) *ScheduledEventNotice {
+	return &ScheduledEventNotice{
+		ScheduledEvent: event,
+	}
+}
+
+func NewDegradedNotice(checks *StatusChecks) *DegradedNotice {
+	return &DegradedNotice{
+		StatusChecks: checks,
+	}
+}
+
 func NewLaunchNotice(hook, token string) *LaunchNotice {
 	return &LaunchNotice{
 		&LifecycleNotice{
@@ -51,6 +157,93 @@ func (notice *SpotNotice) Type() string {
 	return "spot"
 }
 
+// Deadline is the termination time.
+func (notice *SpotNotice) Deadline() (time.Time, bool) {
+	return notice.TerminationTime, !notice.TerminationTime.IsZero()
+}
+
+func (notice *RebalanceNotice) Type() string {
+	return "rebalance"
+}
+
+func (notice *ScheduledActionNotice) Type() string {
+	return "scheduled-action"
+}
+
+// Direction describes whether the action will scale the group in or out,
+// based on the capacity at the time the action was discovered.
+func (notice *ScheduledActionNotice) Direction() string {
+	if notice.DesiredCapacity == nil {
+		return "capacity change"
+	}
+
+	switch desired := *notice.DesiredCapacity; {
+	case desired < notice.CurrentCapacity:
+		return "scale-in"
+	case desired > notice.CurrentCapacity:
+		return "scale-out"
+	default:
+		return "capacity change"
+	}
+}
+
+func (notice *ScheduledEventNotice) Type() string {
+	return "scheduled-event"
+}
+
+// Deadline is the end of the event's window. The event can start as early as
+// NotBefore, but draining is only cut short once it can't have been
+// postponed any further.
+func (notice *ScheduledEventNotice) Deadline() (time.Time, bool) {
+	return notice.NotAfter, !notice.NotAfter.IsZero()
+}
+
+func (notice *DegradedNotice) Type() string {
+	return "degraded"
+}
+
+func (notice *ServiceFailureNotice) Type() string {
+	return "service-failure"
+}
+
+// heartbeatTimeoutFraction is how many heartbeats are sent per heartbeat
+// timeout, leaving room for a couple to fail.
+const heartbeatTimeoutFraction = 3
+
+// SetHook records the timeouts of the notice's lifecycle hook.
+func (notice *LifecycleNotice) SetHook(hook *LifecycleHook) {
+	notice.HeartbeatTimeout = hook.HeartbeatTimeout
+	notice.GlobalTimeout = hook.Budget()
+	notice.DefaultResult = hook.DefaultResult
+}
+
+// Expiry returns when the lifecycle action times out however many heartbeats
+// are sent, the hook's global timeout after the notice was sent, if both are
+// known.
+func (notice *LifecycleNotice) Expiry() (time.Time, bool) {
+	if notice.ReceivedAt.IsZero() || notice.GlobalTimeout == 0 {
+		return time.Time{}, false
+	}
+	return notice.ReceivedAt.Add(notice.GlobalTimeout), true
+}
+
+// HeartbeatExpiry returns when the lifecycle action times out if no
+// heartbeat is sent, the hook's heartbeat timeout after the notice was sent,
+// if both are known. Past it the action may still be alive if someone else,
+// e.g. a previous run, sent heartbeats.
+func (notice *LifecycleNotice) HeartbeatExpiry() (time.Time, bool) {
+	if notice.ReceivedAt.IsZero() || notice.HeartbeatTimeout == 0 {
+		return time.Time{}, false
+	}
+	return notice.ReceivedAt.Add(notice.HeartbeatTimeout), true
+}
+
+// HeartbeatInterval returns a safe heartbeat cadence for the hook, a third
+// of its heartbeat timeout, or 0 if the timeout isn't known.
+func (notice *LifecycleNotice) HeartbeatInterval() time.Duration {
+	return notice.HeartbeatTimeout / heartbeatTimeoutFraction
+}
+
 func (notice *LaunchNotice) Type() string {
 	return "launch"
 }
@@ -58,3 +251,11 @@ func (notice *LaunchNotice) Type() string {
 func (notice *TerminationNotice) Type() string {
 	return "termination"
 }
+
+// ShutdownNotice reports that the operating system started shutting down
+// without any notice from AWS, e.g. an operator ran shutdown by hand.
+type ShutdownNotice struct{}
+
+func (notice *ShutdownNotice) Type() string {
+	return "shutdown"
+}
@@ -0,0 +1,83 @@
+package lcmgr
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+const (
+	ManageMode     = "manage"
+	NotifyOnlyMode = "notify-only"
+)
+
+var DefaultFlagDir = defaultFlagDir()
+
+var noticesCounter = DefaultRegistry.Counter("lcmgr_notices_total", "Number of notices received", "notice")
+
+func defaultFlagDir() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "lcmgr", "flags")
+	}
+	return "/run/lcmgr"
+}
+
+// NotifyHandler only reports notices, for instances that drain entirely in
+// the application and use lcmgr just to detect notices. It never stops
+// services or completes lifecycle actions, leaving the hook to time out or be
+// completed by the application. Each notice is sent to Sinks and written to
+// a flag file named after its type in FlagDir, e.g. /run/lcmgr/termination,
+// holding the notice's metadata as JSON. The notice's message is deleted once
+// it's been reported.
+type NotifyHandler struct {
+	Sinks   []Sink
+	FlagDir string
+	Client  AWSClient
+}
+
+func NewNotifyHandler(sinks []Sink, flagDir string, client AWSClient) *NotifyHandler {
+	return &NotifyHandler{
+		Sinks:   sinks,
+		FlagDir: flagDir,
+		Client:  client,
+	}
+}
+
+func (handler *NotifyHandler) Handle(ctx context.Context, notice Notice) error {
+	noticesCounter.Inc(notice.Type())
+	ctx = WithNoticeTrace(ctx, notice)
+	noticeReceived(ctx, notice)
+
+	if err := handler.writeFlag(notice); err != nil {
+		log.Printf("failed to write %s flag file: %v", notice.Type(), err)
+	}
+	for _, sink := range handler.Sinks {
+		if err := sink.Send(ctx, notice); err != nil {
+			log.Printf("failed to send %s notice: %v", notice.Type(), err)
+		}
+	}
+	acknowledgeNotice(ctx, handler.Client, notice)
+	return nil
+}
+
+// writeFlag replaces the notice's flag file atomically so applications
+// polling for it never read a partial file.
+func (handler *NotifyHandler) writeFlag(notice Notice) error {
+	data, err := json.Marshal(NoticeMetadata(notice))
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(handler.FlagDir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(handler.FlagDir, notice.Type())
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
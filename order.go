@@ -0,0 +1,112 @@
+package lcmgr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+const (
+	AutoServiceOrder   = "auto"
+	ConfigServiceOrder = "config"
+)
+
+// StopOrderFunc returns the order services should be stopped in. They are
+// started in the reverse order.
+type StopOrderFunc func(context.Context, []string) ([]string, error)
+
+// CycleError is returned when the dependencies between services can't be
+// satisfied by any stop order.
+type CycleError struct {
+	Services []string
+}
+
+func (err *CycleError) Error() string {
+	return fmt.Sprintf("dependency cycle between services %s", strings.Join(err.Services, ", "))
+}
+
+// DependencyStopOrder orders services using the dependencies reported by the
+// service manager, so that a service is stopped before anything it depends on
+// (systemd's After=, Requires=, and BindsTo=). Only dependencies between the
+// given services are considered, and services without a dependency between
+// them keep their configured order.
+func DependencyStopOrder(manager ServiceManager) StopOrderFunc {
+	return func(ctx context.Context, services []string) ([]string, error) {
+		dependencies := make(map[string][]string, len(services))
+		for _, service := range services {
+			deps, err := manager.ServiceDependencies(ctx, service)
+			if err != nil {
+				return nil, err
+			}
+			dependencies[service] = deps
+		}
+		return SortServices(services, dependencies)
+	}
+}
+
+// SortServices topologically sorts services so that each comes before the
+// services it depends on.
+func SortServices(services []string, dependencies map[string][]string) ([]string, error) {
+	managed := make(map[string]bool, len(services))
+	for _, service := range services {
+		managed[service] = true
+	}
+
+	// dependents counts, for each service, the managed services that depend
+	// on it and so have to be stopped first.
+	dependents := make(map[string]int, len(services))
+	for _, service := range services {
+		for _, dependency := range dependencies[service] {
+			if managed[dependency] && dependency != service {
+				dependents[dependency]++
+			}
+		}
+	}
+
+	ordered := make([]string, 0, len(services))
+	done := make(map[string]bool, len(services))
+	for len(ordered) < len(services) {
+		progress := false
+		for _, service := range services {
+			if done[service] || dependents[service] > 0 {
+				continue
+			}
+
+			ordered = append(ordered, service)
+			done[service] = true
+			progress = true
+			for _, dependency := range dependencies[service] {
+				if managed[dependency] && dependency != service {
+					dependents[dependency]--
+				}
+			}
+			break
+		}
+
+		if !progress {
+			var cycle []string
+			for _, service := range services {
+				if !done[service] {
+					cycle = append(cycle, service)
+				}
+			}
+			return nil, &CycleError{Services: cycle}
+		}
+	}
+
+	return ordered, nil
+}
+
+func (handler *ServiceHandler) stopOrder(ctx context.Context) []string {
+	if handler.StopOrder == nil || len(handler.Services) < 2 {
+		return handler.Services
+	}
+
+	ordered, err := handler.StopOrder(ctx, handler.Services)
+	if err != nil {
+		log.Printf("failed to order services by dependencies, using configured order: %v", err)
+		return handler.Services
+	}
+	return ordered
+}
@@ -0,0 +1,319 @@
+package lcmgr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOTLPInterval = 30 * time.Second
+	// maxOTLPLogRecords caps the logs buffered between exports, dropping the
+	// oldest while the collector is unreachable.
+	maxOTLPLogRecords = 1000
+	// severityInfo is the OTLP severity number for INFO.
+	severityInfo = 9
+)
+
+// OTLPExporter sends lcmgr's metrics and logs to an OpenTelemetry collector
+// over OTLP/HTTP with JSON encoding, so environments standardized on a
+// collector get all of lcmgr's telemetry through one pipe. Endpoint is the
+// collector's base URL, e.g. http://localhost:4318, and Resource holds the
+// resource attributes attached to everything exported. Logs are captured by
+// using the exporter as a log output.
+type OTLPExporter struct {
+	Endpoint   string
+	Interval   time.Duration
+	Resource   map[string]string
+	Registry   *Registry
+	HTTPClient *http.Client
+	Clock      Clock
+
+	mu    sync.Mutex
+	logs  []otlpLogRecord
+	start time.Time
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpMetric struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Gauge       *otlpGauge `json:"gauge,omitempty"`
+	Sum         *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano   string       `json:"timeUnixNano"`
+	SeverityNumber int          `json:"severityNumber"`
+	SeverityText   string       `json:"severityText"`
+	Body           otlpAnyValue `json:"body"`
+}
+
+func NewOTLPExporter(endpoint string, interval time.Duration, resource map[string]string) *OTLPExporter {
+	if interval <= 0 {
+		interval = defaultOTLPInterval
+	}
+	clock := NewClock()
+	return &OTLPExporter{
+		Endpoint:   strings.TrimSuffix(endpoint, "/"),
+		Interval:   interval,
+		Resource:   resource,
+		Registry:   DefaultRegistry,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		Clock:      clock,
+		start:      clock.Now(),
+	}
+}
+
+// OTLPResource returns the resource attributes describing this instance.
+// Anything that can't be looked up is left out.
+func OTLPResource(ctx context.Context, client AWSClient) map[string]string {
+	resource := map[string]string{
+		"service.name":    "lcmgr",
+		"service.version": Version,
+		"cloud.provider":  "aws",
+		"cloud.platform":  "aws_ec2",
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		resource["host.name"] = hostname
+	}
+
+	identity := NewIdentity(ctx, client)
+	if identity.InstanceID != "" {
+		resource["host.id"] = identity.InstanceID
+	}
+	if identity.AutoScalingGroupName != "" {
+		resource["aws.autoscaling.group.name"] = identity.AutoScalingGroupName
+	}
+	for key, value := range map[string]string{
+		"cloud.region":            identity.Region,
+		"cloud.account.id":        identity.AccountID,
+		"cloud.availability_zone": identity.AvailabilityZone,
+		"host.type":               identity.InstanceType,
+	} {
+		if value != "" {
+			resource[key] = value
+		}
+	}
+	return resource
+}
+
+// Write buffers a log line for the next export. It never fails so the
+// exporter can sit beside stderr in an io.MultiWriter.
+func (exporter *OTLPExporter) Write(p []byte) (int, error) {
+	record := otlpLogRecord{
+		TimeUnixNano:   unixNano(exporter.Clock.Now()),
+		SeverityNumber: severityInfo,
+		SeverityText:   "INFO",
+		Body:           otlpAnyValue{StringValue: strings.TrimRight(string(p), "\n")},
+	}
+
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	if len(exporter.logs) >= maxOTLPLogRecords {
+		exporter.logs = exporter.logs[1:]
+	}
+	exporter.logs = append(exporter.logs, record)
+	return len(p), nil
+}
+
+// Listen exports every Interval until ctx is done, then exports once more so
+// nothing logged during shutdown is lost.
+func (exporter *OTLPExporter) Listen(ctx context.Context) error {
+	ticker := exporter.Clock.NewTicker(exporter.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			exporter.export(ctx)
+		case <-ctx.Done():
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+			exporter.export(ctx)
+			cancel()
+			return nil
+		}
+	}
+}
+
+func (exporter *OTLPExporter) Type() string {
+	return "otlp"
+}
+
+// export sends metrics and buffered logs. Failures go to stderr rather than
+// the log, which would feed them back into the exporter.
+func (exporter *OTLPExporter) export(ctx context.Context) {
+	if err := postJSON(ctx, exporter.HTTPClient, exporter.Endpoint+"/v1/metrics", exporter.metrics()); err != nil {
+		printStderr("failed to export metrics over otlp: %v", err)
+	}
+
+	exporter.mu.Lock()
+	logs := exporter.logs
+	exporter.logs = nil
+	exporter.mu.Unlock()
+	if len(logs) == 0 {
+		return
+	}
+
+	request := &otlpLogsRequest{ResourceLogs: []otlpResourceLogs{{
+		Resource: exporter.resource(),
+		ScopeLogs: []otlpScopeLogs{{
+			Scope:      exporter.scope(),
+			LogRecords: logs,
+		}},
+	}}}
+	if err := postJSON(ctx, exporter.HTTPClient, exporter.Endpoint+"/v1/logs", request); err != nil {
+		printStderr("failed to export logs over otlp: %v", err)
+		exporter.requeue(logs)
+	}
+}
+
+// requeue puts logs that failed to export back in front of those buffered
+// since, dropping the oldest beyond maxOTLPLogRecords.
+func (exporter *OTLPExporter) requeue(logs []otlpLogRecord) {
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	logs = append(logs, exporter.logs...)
+	if len(logs) > maxOTLPLogRecords {
+		logs = logs[len(logs)-maxOTLPLogRecords:]
+	}
+	exporter.logs = logs
+}
+
+func (exporter *OTLPExporter) metrics() *otlpMetricsRequest {
+	now := unixNano(exporter.Clock.Now())
+	start := unixNano(exporter.start)
+
+	var metrics []otlpMetric
+	for _, metric := range exporter.Registry.Metrics() {
+		samples := metric.Samples()
+		if len(samples) == 0 {
+			continue
+		}
+
+		points := make([]otlpDataPoint, 0, len(samples))
+		for _, sample := range samples {
+			point := otlpDataPoint{
+				Attributes:   otlpAttributes(sample.Labels),
+				TimeUnixNano: now,
+				AsDouble:     sample.Value,
+			}
+			if metric.Kind == CounterMetric {
+				point.StartTimeUnixNano = start
+			}
+			points = append(points, point)
+		}
+
+		converted := otlpMetric{Name: metric.Name, Description: metric.Help}
+		if metric.Kind == CounterMetric {
+			// 2 is AGGREGATION_TEMPORALITY_CUMULATIVE.
+			converted.Sum = &otlpSum{DataPoints: points, AggregationTemporality: 2, IsMonotonic: true}
+		} else {
+			converted.Gauge = &otlpGauge{DataPoints: points}
+		}
+		metrics = append(metrics, converted)
+	}
+
+	return &otlpMetricsRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: exporter.resource(),
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   exporter.scope(),
+			Metrics: metrics,
+		}},
+	}}}
+}
+
+func (exporter *OTLPExporter) resource() otlpResource {
+	return otlpResource{Attributes: otlpAttributes(exporter.Resource)}
+}
+
+func (exporter *OTLPExporter) scope() otlpScope {
+	return otlpScope{Name: "github.com/vanstee/lcmgr", Version: Version}
+}
+
+func otlpAttributes(values map[string]string) []otlpAttribute {
+	attributes := make([]otlpAttribute, 0, len(values))
+	for key, value := range values {
+		attributes = append(attributes, otlpAttribute{Key: key, Value: otlpAnyValue{StringValue: value}})
+	}
+	sort.Slice(attributes, func(i, j int) bool {
+		return attributes[i].Key < attributes[j].Key
+	})
+	return attributes
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func printStderr(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
@@ -0,0 +1,174 @@
+package lcmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// pendingCompletionsKey is the Store key of lifecycle actions waiting to be
+// completed.
+const pendingCompletionsKey = "pending_completions"
+
+// PendingCompletion is a lifecycle action lcmgr has decided to complete but
+// hasn't yet heard back from Auto Scaling about.
+type PendingCompletion struct {
+	Notice               string    `json:"notice"`
+	LifecycleHookName    string    `json:"lifecycle_hook_name"`
+	LifecycleActionToken string    `json:"lifecycle_action_token"`
+	Result               string    `json:"result"`
+	Recorded             time.Time `json:"recorded"`
+}
+
+// CompletionOutbox completes lifecycle actions in two phases. The intent to
+// complete, the token and result, is written to Store before Auto Scaling is
+// called and removed once the call succeeds, so completions interrupted by a
+// crash or restart are retried by Replay rather than left to time out.
+type CompletionOutbox struct {
+	Store  Store
+	Client AWSClient
+	Clock  Clock
+
+	mu sync.Mutex
+}
+
+func NewCompletionOutbox(store Store, client AWSClient) *CompletionOutbox {
+	return &CompletionOutbox{
+		Store:  store,
+		Client: client,
+		Clock:  NewClock(),
+	}
+}
+
+// Complete records the intent to complete notice's lifecycle action with
+// result and then completes it.
+func (outbox *CompletionOutbox) Complete(ctx context.Context, notice Notice, result string) error {
+	lifecycle := lifecycleNotice(notice)
+	if lifecycle == nil {
+		return outbox.Client.CompleteLifecycleAction(ctx, notice, result)
+	}
+
+	pending := &PendingCompletion{
+		Notice:               notice.Type(),
+		LifecycleHookName:    lifecycle.LifecycleHookName,
+		LifecycleActionToken: lifecycle.LifecycleActionToken,
+		Result:               result,
+		Recorded:             outbox.Clock.Now(),
+	}
+	if err := outbox.update(pending.LifecycleActionToken, pending); err != nil {
+		log.Printf("failed to record pending %s lifecycle action completion: %v", notice.Type(), err)
+	}
+
+	return outbox.commit(ctx, notice, pending)
+}
+
+// Replay retries completions recorded before a restart. Ones older than the
+// longest a lifecycle action can be kept open are dropped.
+func (outbox *CompletionOutbox) Replay(ctx context.Context) {
+	outbox.mu.Lock()
+	pending, err := outbox.load()
+	outbox.mu.Unlock()
+	if err != nil {
+		log.Printf("failed to load pending lifecycle action completions: %v", err)
+		return
+	}
+
+	for _, completion := range pending {
+		if outbox.Clock.Now().Sub(completion.Recorded) > maxLifecycleActionTimeout {
+			outbox.forget(completion)
+			continue
+		}
+
+		var notice Notice
+		switch completion.Notice {
+		case "launch":
+			notice = NewLaunchNotice(completion.LifecycleHookName, completion.LifecycleActionToken)
+		case "termination":
+			notice = NewTerminationNotice(completion.LifecycleHookName, completion.LifecycleActionToken)
+		default:
+			outbox.forget(completion)
+			continue
+		}
+
+		log.Printf("retrying interrupted completion of %s lifecycle action with %s", completion.Notice, completion.Result)
+		if err := outbox.commit(ctx, notice, completion); err != nil {
+			log.Printf("failed to complete %s lifecycle action: %v", completion.Notice, err)
+		}
+	}
+}
+
+// commit completes the lifecycle action and forgets the pending completion
+// once Auto Scaling accepted it or no longer knows the action, which counts
+// as completed. Any other rejection keeps the completion pending.
+func (outbox *CompletionOutbox) commit(ctx context.Context, notice Notice, completion *PendingCompletion) error {
+	err := outbox.Client.CompleteLifecycleAction(ctx, notice, completion.Result)
+	switch {
+	case isLifecycleActionGone(err):
+		log.Printf("%s lifecycle action was already completed or timed out: %v", notice.Type(), err)
+		err = nil
+	case isValidationError(err):
+		log.Printf("Auto Scaling rejected completing %s lifecycle action with hook %s, keeping it pending: %v", notice.Type(), completion.LifecycleHookName, err)
+	}
+	if err == nil {
+		outbox.forget(completion)
+	}
+	return err
+}
+
+func (outbox *CompletionOutbox) forget(completion *PendingCompletion) {
+	if err := outbox.update(completion.LifecycleActionToken, nil); err != nil {
+		log.Printf("failed to clear pending %s lifecycle action completion: %v", completion.Notice, err)
+	}
+}
+
+// update records completion as pending for token, or clears token when
+// completion is nil.
+func (outbox *CompletionOutbox) update(token string, completion *PendingCompletion) error {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+
+	pending, err := outbox.load()
+	if err != nil {
+		return err
+	}
+	if completion != nil {
+		pending[token] = completion
+	} else {
+		delete(pending, token)
+	}
+	if len(pending) == 0 {
+		return outbox.Store.Delete(pendingCompletionsKey)
+	}
+	return outbox.Store.Put(pendingCompletionsKey, pending)
+}
+
+func (outbox *CompletionOutbox) load() (map[string]*PendingCompletion, error) {
+	pending := make(map[string]*PendingCompletion)
+	if _, err := outbox.Store.Get(pendingCompletionsKey, &pending); err != nil {
+		return nil, fmt.Errorf("failed to load pending completions: %v", err)
+	}
+	return pending, nil
+}
+
+// lifecycleActionGoneMessage starts the message of the ValidationError Auto
+// Scaling returns for a token that no longer names an active lifecycle
+// action. A wrong hook, group or malformed token is a ValidationError too.
+const lifecycleActionGoneMessage = "No active Lifecycle Action found"
+
+// isLifecycleActionGone returns true when Auto Scaling rejected a call
+// because the lifecycle action was already completed or timed out.
+func isLifecycleActionGone(err error) bool {
+	var apiErr smithy.APIError
+	return isValidationError(err) && errors.As(err, &apiErr) && strings.Contains(apiErr.ErrorMessage(), lifecycleActionGoneMessage)
+}
+
+func isValidationError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "ValidationError"
+}
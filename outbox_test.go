@@ -0,0 +1,168 @@
+package lcmgr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+// memoryStore is a Store that keeps JSON encoded values in a map.
+type memoryStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{values: make(map[string][]byte)}
+}
+
+func (store *memoryStore) Get(key string, value interface{}) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	data, ok := store.values[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, value)
+}
+
+func (store *memoryStore) Put(key string, value interface{}) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	store.values[key] = data
+	return nil
+}
+
+func (store *memoryStore) Delete(key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.values, key)
+	return nil
+}
+
+// fakeAWSClient answers the lifecycle calls tests need and panics on any
+// other AWSClient method.
+type fakeAWSClient struct {
+	AWSClient
+
+	mu           sync.Mutex
+	hooks        []*LifecycleHook
+	discovered   chan struct{}
+	heartbeatErr error
+	completeErr  error
+	heartbeats   int
+	completed    []string
+	deleted      int
+}
+
+func (client *fakeAWSClient) GetLifecycleHooks(ctx context.Context) ([]*LifecycleHook, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.hooks, nil
+}
+
+// GetLifecycleNoticeQueues finds no queues, signalling discovered if it's
+// set.
+func (client *fakeAWSClient) GetLifecycleNoticeQueues(ctx context.Context) ([]*Queue, error) {
+	if client.discovered != nil {
+		client.discovered <- struct{}{}
+	}
+	return nil, nil
+}
+
+func (client *fakeAWSClient) SendHeartbeat(ctx context.Context, notice Notice) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.heartbeats++
+	return client.heartbeatErr
+}
+
+func (client *fakeAWSClient) CompleteLifecycleAction(ctx context.Context, notice Notice, result string) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.completeErr != nil {
+		return client.completeErr
+	}
+	client.completed = append(client.completed, result)
+	return nil
+}
+
+func (client *fakeAWSClient) DeleteNoticeMessage(ctx context.Context, notice Notice) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.deleted++
+	return nil
+}
+
+func TestCompletionOutboxKeepsRejectedCompletions(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		err     error
+		gone    bool
+		pending bool
+	}{
+		{
+			name: "completed",
+		},
+		{
+			name: "action gone",
+			err:  &smithy.GenericAPIError{Code: "ValidationError", Message: "No active Lifecycle Action found with instance ID i-0123456789abcdef0"},
+			gone: true,
+		},
+		{
+			name: "wrapped action gone",
+			err:  fmt.Errorf("operation error: %w", &smithy.GenericAPIError{Code: "ValidationError", Message: "No active Lifecycle Action found with token abc"}),
+			gone: true,
+		},
+		{
+			name:    "unknown hook",
+			err:     &smithy.GenericAPIError{Code: "ValidationError", Message: "Unable to find lifecycle hook 'drain' for autoscaling group 'web'"},
+			pending: true,
+		},
+		{
+			name:    "malformed token",
+			err:     &smithy.GenericAPIError{Code: "ValidationError", Message: "1 validation error detected: Value 'x' at 'lifecycleActionToken' failed to satisfy constraint"},
+			pending: true,
+		},
+		{
+			name:    "throttled",
+			err:     &smithy.GenericAPIError{Code: "Throttling", Message: "Rate exceeded"},
+			pending: true,
+		},
+		{
+			name:    "network",
+			err:     errors.New("connection reset by peer"),
+			pending: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if gone := isLifecycleActionGone(test.err); gone != test.gone {
+				t.Errorf("isLifecycleActionGone = %v, want %v", gone, test.gone)
+			}
+
+			store := newMemoryStore()
+			outbox := NewCompletionOutbox(store, &fakeAWSClient{completeErr: test.err})
+			err := outbox.Complete(context.Background(), NewTerminationNotice("drain", "token"), ContinueResult)
+			if wantErr := test.err != nil && !test.gone; (err != nil) != wantErr {
+				t.Errorf("Complete returned %v, want error %v", err, wantErr)
+			}
+
+			pending, err := outbox.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := pending["token"]; ok != test.pending {
+				t.Errorf("completion pending is %v, want %v", ok, test.pending)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+#!/bin/sh
+# Enables lcmgr on a fresh install and restarts it on upgrade. The service
+# isn't started on install since it needs services listed in
+# /etc/lcmgr/config.json first.
+set -e
+
+command -v systemctl >/dev/null 2>&1 || exit 0
+
+systemctl daemon-reload || true
+
+# deb passes "configure" with the previous version on upgrade, rpm passes
+# the number of installed versions.
+if { [ "$1" = "configure" ] && [ -z "$2" ]; } || [ "$1" = "1" ]; then
+	systemctl enable lcmgr.service || true
+else
+	systemctl try-restart lcmgr.service || true
+fi
@@ -0,0 +1,9 @@
+#!/bin/sh
+# Stops and disables lcmgr when the package is removed, but not on upgrade.
+set -e
+
+command -v systemctl >/dev/null 2>&1 || exit 0
+
+if [ "$1" = "remove" ] || [ "$1" = "0" ]; then
+	systemctl disable --now lcmgr.service || true
+fi
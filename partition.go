@@ -0,0 +1,57 @@
+package lcmgr
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+const (
+	AWSPartition      = "aws"
+	ChinaPartition    = "aws-cn"
+	GovCloudPartition = "aws-us-gov"
+)
+
+// partitionRegionPrefixes maps region name prefixes to the partitions outside
+// the commercial one, longest prefix first.
+var partitionRegionPrefixes = []struct {
+	prefix    string
+	partition string
+}{
+	{"us-gov-", GovCloudPartition},
+	{"us-isob-", "aws-iso-b"},
+	{"us-iso-", "aws-iso"},
+	{"cn-", ChinaPartition},
+}
+
+// PartitionForRegion returns the partition region is in, e.g. aws-cn for
+// cn-north-1. Unknown and empty regions are assumed to be commercial.
+func PartitionForRegion(region string) string {
+	for _, mapping := range partitionRegionPrefixes {
+		if strings.HasPrefix(region, mapping.prefix) {
+			return mapping.partition
+		}
+	}
+	return AWSPartition
+}
+
+// partition returns the partition of the region the client calls.
+func (client *awsClient) partition() string {
+	return PartitionForRegion(client.Config.Region)
+}
+
+// parsePartitionARN parses the ARN of a resource lcmgr calls, e.g. a hook's
+// notification target, and checks that it's in the client's partition, since
+// credentials and endpoints don't cross partitions and the call would
+// otherwise fail with a misleading authorization error.
+func (client *awsClient) parsePartitionARN(resource string) (arn.ARN, error) {
+	parsed, err := arn.Parse(resource)
+	if err != nil {
+		return parsed, err
+	}
+	if partition := client.partition(); parsed.Partition != partition {
+		return parsed, fmt.Errorf("%s is in the %s partition but lcmgr is running in %s (%s)", resource, parsed.Partition, partition, client.Config.Region)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,49 @@
+package lcmgr
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestPartitionForRegion(t *testing.T) {
+	for _, test := range []struct {
+		region    string
+		partition string
+	}{
+		{"", AWSPartition},
+		{"us-east-1", AWSPartition},
+		{"eu-west-2", AWSPartition},
+		{"us-gov-west-1", GovCloudPartition},
+		{"cn-north-1", ChinaPartition},
+		{"cn-northwest-1", ChinaPartition},
+		{"us-iso-east-1", "aws-iso"},
+		{"us-isob-east-1", "aws-iso-b"},
+		{"unknown-1", AWSPartition},
+	} {
+		if partition := PartitionForRegion(test.region); partition != test.partition {
+			t.Errorf("PartitionForRegion(%q) = %q, want %q", test.region, partition, test.partition)
+		}
+	}
+}
+
+func TestParsePartitionARN(t *testing.T) {
+	for _, test := range []struct {
+		region   string
+		resource string
+		ok       bool
+	}{
+		{"us-east-1", "arn:aws:sns:us-east-1:123456789012:lifecycle", true},
+		{"cn-north-1", "arn:aws-cn:sns:cn-north-1:123456789012:lifecycle", true},
+		{"us-gov-west-1", "arn:aws-us-gov:sqs:us-gov-west-1:123456789012:lifecycle", true},
+		{"us-east-1", "arn:aws-cn:sns:cn-north-1:123456789012:lifecycle", false},
+		{"cn-north-1", "arn:aws:sns:us-east-1:123456789012:lifecycle", false},
+		{"us-east-1", "not-an-arn", false},
+	} {
+		client := &awsClient{Config: aws.Config{Region: test.region}}
+		_, err := client.parsePartitionARN(test.resource)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("parsePartitionARN(%q) in %s returned %v, want ok %v", test.resource, test.region, err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package lcmgr
+
+import (
+	"sort"
+	"strings"
+)
+
+const policyVersion = "2012-10-17"
+
+var (
+	lifecycleQueueActions = []string{
+		"autoscaling:DescribeAutoScalingInstances",
+		"autoscaling:DescribeLifecycleHooks",
+		"sqs:GetQueueUrl",
+	}
+	queueMessageActions = []string{
+		"sqs:ReceiveMessage",
+		"sqs:DeleteMessage",
+		"sqs:DeleteMessageBatch",
+		"sqs:ChangeMessageVisibility",
+		"sqs:ChangeMessageVisibilityBatch",
+	}
+	lifecycleActionActions = []string{
+		"autoscaling:RecordLifecycleActionHeartbeat",
+		"autoscaling:CompleteLifecycleAction",
+	}
+	ephemeralQueueActions = []string{
+		"sqs:CreateQueue",
+		"sqs:GetQueueAttributes",
+		"sqs:SetQueueAttributes",
+		"sqs:DeleteQueue",
+	}
+	discoverLoadBalancerActions = []string{
+		"autoscaling:DescribeAutoScalingInstances",
+		"autoscaling:DescribeAutoScalingGroups",
+		"elasticloadbalancing:DescribeTargetGroups",
+		"elasticloadbalancing:DescribeTargetHealth",
+		"elasticloadbalancing:DescribeLoadBalancers",
+	}
+	describeLoadBalancerActions = []string{
+		"elasticloadbalancing:DescribeTargetGroups",
+		"elasticloadbalancing:DescribeTargetGroupAttributes",
+		"elasticloadbalancing:DescribeTargetHealth",
+		"elasticloadbalancing:DescribeInstanceHealth",
+	}
+	deregisterTargetsAction  = "elasticloadbalancing:DeregisterTargets"
+	deregisterInstanceAction = "elasticloadbalancing:DeregisterInstancesFromLoadBalancer"
+)
+
+// PolicyDocument is an IAM policy document.
+type PolicyDocument struct {
+	Version   string             `json:"Version"`
+	Statement []*PolicyStatement `json:"Statement"`
+}
+
+// PolicyStatement is a statement of an IAM policy document.
+type PolicyStatement struct {
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+// permissions maps the actions lcmgr needs to the resources it needs them
+// on.
+type permissions map[string]map[string]bool
+
+func (permissions permissions) add(resources []string, actions ...string) {
+	for _, action := range actions {
+		if permissions[action] == nil {
+			permissions[action] = map[string]bool{}
+		}
+		for _, resource := range resources {
+			if resource == "" {
+				continue
+			}
+			permissions[action][resource] = true
+		}
+	}
+}
+
+// RequiredPolicy returns the least-privilege policy for the instance role
+// given the listeners, handlers and steps config enables. Actions are scoped
+// to the resources config names, such as target groups, SSM parameters and
+// S3 objects, and otherwise allowed on every resource since queues, hooks and
+// groups are only discovered at runtime. ARNs are built in the partition of
+// config's region. Calls lcmgr makes to the instance metadata service need no
+// permissions and aren't included.
+func RequiredPolicy(config *Config) *PolicyDocument {
+	permissions := permissions{}
+	all := []string{"*"}
+	partition := PartitionForRegion(config.AWSRegion)
+
+	if !config.NoASG {
+		permissions.add(all, lifecycleQueueActions...)
+		permissions.add(all, queueMessageActions...)
+		if config.Mode != NotifyOnlyMode {
+			permissions.add(all, lifecycleActionActions...)
+		}
+		if config.EphemeralQueue {
+			permissions.add([]string{policyARN(partition, "sqs", ephemeralQueuePrefix+"*")}, ephemeralQueueActions...)
+			permissions.add(all, "sns:Subscribe", "sns:Unsubscribe")
+		}
+		if len(config.SNSSubscriptions) > 0 {
+			permissions.add(all, "sns:SetSubscriptionAttributes")
+		}
+		if config.DrainOnDegraded {
+			permissions.add(all, "ec2:DescribeInstanceStatus")
+		}
+		if config.ScheduledActionLookahead > 0 {
+			permissions.add(all, "autoscaling:DescribeScheduledActions", "autoscaling:DescribeAutoScalingGroups")
+		}
+		if config.AdaptiveSpot {
+			permissions.add(all, "autoscaling:DescribeAutoScalingInstances")
+		}
+		if config.SuspendProcesses && config.AdminAddress != "" {
+			permissions.add(all, "autoscaling:SuspendProcesses", "autoscaling:ResumeProcesses")
+		}
+	}
+	if len(config.EventBridgeQueues) > 0 {
+		// EventBridge queues are also listened on by standalone instances.
+		permissions.add(all, queueMessageActions...)
+	}
+	if config.SQSRoleARN != "" {
+		permissions.add([]string{config.SQSRoleARN}, "sts:AssumeRole")
+	}
+	if config.RefreshWait > 0 {
+		permissions.add(all, "autoscaling:DescribeInstanceRefreshes", "autoscaling:DescribeAutoScalingGroups")
+	}
+	if config.DeregisterLBs {
+		permissions.add(all, discoverLoadBalancerActions...)
+		permissions.add(all, describeLoadBalancerActions...)
+		permissions.add(all, deregisterTargetsAction, deregisterInstanceAction)
+	}
+
+	steps := config.everyStep()
+	if len(steps) > 0 {
+		// Step conditions are evaluated against the instance's group.
+		permissions.add(all, "autoscaling:DescribeAutoScalingInstances")
+	}
+	for _, step := range steps {
+		if lb := step.LoadBalancer; lb != nil {
+			permissions.add(all, describeLoadBalancerActions...)
+			if lb.Discover {
+				permissions.add(all, discoverLoadBalancerActions...)
+				permissions.add(all, deregisterTargetsAction, deregisterInstanceAction)
+			}
+			permissions.add(lb.TargetGroups, deregisterTargetsAction)
+			for _, name := range lb.Classic {
+				permissions.add([]string{policyARN(partition, "elasticloadbalancing", "loadbalancer/"+name)}, deregisterInstanceAction)
+			}
+		}
+		if ga := step.GlobalAccelerator; ga != nil {
+			permissions.add([]string{ga.EndpointGroup}, "globalaccelerator:DescribeEndpointGroup", "globalaccelerator:UpdateEndpointGroup")
+		}
+		if prefetch := step.Prefetch; prefetch != nil {
+			for _, object := range prefetch.Objects {
+				bucket, key, err := parseS3URL(object.Source)
+				if err != nil {
+					continue
+				}
+				permissions.add([]string{"arn:" + partition + ":s3:::" + bucket + "/" + key}, "s3:GetObject")
+			}
+		}
+		if register := step.Register; register != nil {
+			permissions.add(register.TargetGroups, "elasticloadbalancing:RegisterTargets")
+		}
+		if approval := step.Approval; approval != nil && approval.SSMParameter != "" {
+			permissions.add([]string{ssmParameterARN(partition, approval.SSMParameter)}, "ssm:GetParameter")
+		}
+	}
+
+	if config.SQSRoleARN != "" {
+		// SQS calls are made with the assumed role, which needs them instead.
+		for action := range permissions {
+			if strings.HasPrefix(action, "sqs:") {
+				delete(permissions, action)
+			}
+		}
+	}
+	return permissions.policy()
+}
+
+// RequiredActions returns the IAM actions in RequiredPolicy in order.
+func RequiredActions(config *Config) []string {
+	var actions []string
+	for _, statement := range RequiredPolicy(config).Statement {
+		actions = append(actions, statement.Action...)
+	}
+	return sortedUnique(actions)
+}
+
+// policy renders the permissions as one statement per set of resources.
+// Resources of actions also allowed on every resource are left out.
+func (permissions permissions) policy() *PolicyDocument {
+	statements := map[string]*PolicyStatement{}
+	for action, resources := range permissions {
+		if len(resources) == 0 {
+			continue
+		}
+		var scoped []string
+		if resources["*"] {
+			scoped = []string{"*"}
+		} else {
+			for resource := range resources {
+				scoped = append(scoped, resource)
+			}
+			scoped = sortedUnique(scoped)
+		}
+
+		key := strings.Join(scoped, ",")
+		statement, ok := statements[key]
+		if !ok {
+			statement = &PolicyStatement{Effect: "Allow", Resource: scoped}
+			statements[key] = statement
+		}
+		statement.Action = append(statement.Action, action)
+	}
+
+	keys := make([]string, 0, len(statements))
+	for key := range statements {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	document := &PolicyDocument{Version: policyVersion}
+	for _, key := range keys {
+		statement := statements[key]
+		statement.Action = sortedUnique(statement.Action)
+		document.Statement = append(document.Statement, statement)
+	}
+	return document
+}
+
+// policyARN returns the ARN of resource in service in any region and
+// account of partition.
+func policyARN(partition, service, resource string) string {
+	return "arn:" + partition + ":" + service + ":*:*:" + resource
+}
+
+// ssmParameterARN returns the ARN of an SSM parameter named by name, which
+// may already be an ARN and may contain {instance_id}.
+func ssmParameterARN(partition, name string) string {
+	name = strings.Replace(name, "{instance_id}", "*", -1)
+	if strings.HasPrefix(name, "arn:") {
+		return name
+	}
+	return policyARN(partition, "ssm", "parameter/"+strings.TrimPrefix(name, "/"))
+}
+
+func sortedUnique(values []string) []string {
+	sort.Strings(values)
+	unique := values[:0]
+	for i, value := range values {
+		if i == 0 || value != values[i-1] {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// everyStep returns every configured step, including those of drain
+// policies and the members of groups.
+func (config *Config) everyStep() []StepConfig {
+	var steps []StepConfig
+	var walk func([]StepConfig)
+	walk = func(configs []StepConfig) {
+		for _, step := range configs {
+			steps = append(steps, step)
+			walk(step.Group)
+		}
+	}
+	walk(config.Steps)
+	walk(config.Launch)
+	walk(config.Rollback)
+	walk(config.LastGasp)
+	for _, policy := range config.Policies {
+		walk(policy.Steps)
+	}
+	return steps
+}
@@ -0,0 +1,110 @@
+package lcmgr
+
+import (
+	"strings"
+	"testing"
+)
+
+// policyResources returns the resources each action in policy is allowed on.
+func policyResources(policy *PolicyDocument) map[string][]string {
+	resources := make(map[string][]string)
+	for _, statement := range policy.Statement {
+		for _, action := range statement.Action {
+			resources[action] = append(resources[action], statement.Resource...)
+		}
+	}
+	return resources
+}
+
+func TestRequiredPolicy(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		config  Config
+		allowed map[string]string
+		denied  []string
+	}{
+		{
+			name:   "lifecycle queues",
+			config: Config{Mode: ManageMode},
+			allowed: map[string]string{
+				"autoscaling:DescribeLifecycleHooks":       "*",
+				"autoscaling:CompleteLifecycleAction":      "*",
+				"sqs:ReceiveMessage":                       "*",
+				"sqs:ChangeMessageVisibilityBatch":         "*",
+				"autoscaling:DescribeAutoScalingInstances": "*",
+			},
+			denied: []string{"sqs:CreateQueue", "sns:Subscribe"},
+		},
+		{
+			name:    "notify only",
+			config:  Config{Mode: NotifyOnlyMode},
+			allowed: map[string]string{"sqs:ReceiveMessage": "*"},
+			denied:  []string{"autoscaling:CompleteLifecycleAction", "autoscaling:RecordLifecycleActionHeartbeat"},
+		},
+		{
+			name:   "standalone",
+			config: Config{NoASG: true},
+			denied: []string{"sqs:ReceiveMessage", "autoscaling:DescribeLifecycleHooks", "autoscaling:CompleteLifecycleAction"},
+		},
+		{
+			name:   "standalone with eventbridge queues",
+			config: Config{NoASG: true, EventBridgeQueues: []string{"https://sqs.us-east-1.amazonaws.com/123456789012/events"}},
+			allowed: map[string]string{
+				"sqs:ReceiveMessage":          "*",
+				"sqs:DeleteMessageBatch":      "*",
+				"sqs:ChangeMessageVisibility": "*",
+			},
+			denied: []string{"autoscaling:DescribeLifecycleHooks", "autoscaling:CompleteLifecycleAction"},
+		},
+		{
+			name:   "ephemeral queue in china",
+			config: Config{EphemeralQueue: true, AWSRegion: "cn-north-1"},
+			allowed: map[string]string{
+				"sqs:CreateQueue": "arn:aws-cn:sqs:*:*:lcmgr-*",
+				"sqs:DeleteQueue": "arn:aws-cn:sqs:*:*:lcmgr-*",
+				"sns:Subscribe":   "*",
+			},
+		},
+		{
+			name:    "sqs role",
+			config:  Config{SQSRoleARN: "arn:aws:iam::123456789012:role/queues"},
+			allowed: map[string]string{"sts:AssumeRole": "arn:aws:iam::123456789012:role/queues"},
+			denied:  []string{"sqs:ReceiveMessage", "sqs:GetQueueUrl"},
+		},
+		{
+			name: "steps in govcloud",
+			config: Config{
+				NoASG:     true,
+				AWSRegion: "us-gov-west-1",
+				Steps: []StepConfig{{
+					LoadBalancer: &LoadBalancerConfig{Classic: []string{"web"}},
+				}},
+				Launch: []StepConfig{{
+					Prefetch: &PrefetchConfig{Objects: []PrefetchObject{{Source: "s3://bucket/model.bin"}}},
+				}, {
+					Approval: &ApprovalConfig{SSMParameter: "/lcmgr/{instance_id}/approved"},
+				}},
+			},
+			allowed: map[string]string{
+				"elasticloadbalancing:DeregisterInstancesFromLoadBalancer": "arn:aws-us-gov:elasticloadbalancing:*:*:loadbalancer/web",
+				"s3:GetObject":     "arn:aws-us-gov:s3:::bucket/model.bin",
+				"ssm:GetParameter": "arn:aws-us-gov:ssm:*:*:parameter/lcmgr/*/approved",
+			},
+			denied: []string{"sqs:ReceiveMessage"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resources := policyResources(RequiredPolicy(&test.config))
+			for action, resource := range test.allowed {
+				if got := strings.Join(resources[action], ","); got != resource {
+					t.Errorf("%s is allowed on %q, want %q", action, got, resource)
+				}
+			}
+			for _, action := range test.denied {
+				if _, ok := resources[action]; ok {
+					t.Errorf("%s is allowed, want it left out", action)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package lcmgr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Policy selects a chain by when a notice arrives, e.g. a full graceful drain
+// during business hours and a fast one overnight.
+type Policy struct {
+	Name   string
+	Window *TimeWindow
+	Chain  *Chain
+}
+
+// TimeWindow is a daily window between Start and End, both offsets from
+// midnight in Location, on Days (all days when empty). A window whose end is
+// before its start wraps past midnight, and belongs to the day it started on.
+type TimeWindow struct {
+	Start    time.Duration
+	End      time.Duration
+	Days     map[time.Weekday]bool
+	Location *time.Location
+}
+
+// PolicyHandler runs the chain of the first policy whose window contains the
+// current time, or Default when none do.
+type PolicyHandler struct {
+	Policies []*Policy
+	Default  Handler
+	Clock    Clock
+}
+
+// NewPolicyHandler builds policies from config, falling back to the handler's
+// chain or, without one, to starting and stopping services.
+func NewPolicyHandler(configs []PolicyConfig, identity *Identity, handler *ServiceHandler) (*PolicyHandler, error) {
+	policyHandler := &PolicyHandler{
+		Default: handler.Chain,
+		Clock:   NewClock(),
+	}
+	if policyHandler.Default == nil {
+		policyHandler.Default = HandlerFunc(handler.HandleServices)
+	}
+
+	for i, config := range configs {
+		name := config.Name
+		if name == "" {
+			name = fmt.Sprintf("policy %d", i+1)
+		}
+
+		window, err := ParseTimeWindow(config.Between, config.Days, config.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		chain, err := NewChain(config.Steps, identity, handler)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		policyHandler.Policies = append(policyHandler.Policies, &Policy{
+			Name:   name,
+			Window: window,
+			Chain:  chain,
+		})
+	}
+	return policyHandler, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseTimeWindow parses a window like "09:00-17:00" on the given days, e.g.
+// mon, tue, in timezone, an IANA name that defaults to UTC.
+func ParseTimeWindow(between string, days []string, timezone string) (*TimeWindow, error) {
+	parts := strings.Split(between, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", between)
+	}
+
+	window := &TimeWindow{Days: make(map[time.Weekday]bool)}
+	var err error
+	if window.Start, err = parseClock(parts[0]); err != nil {
+		return nil, err
+	}
+	if window.End, err = parseClock(parts[1]); err != nil {
+		return nil, err
+	}
+
+	for _, day := range days {
+		key := strings.ToLower(day)
+		if len(key) > 3 {
+			key = key[:3]
+		}
+		weekday, ok := weekdays[key]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q", day)
+		}
+		window.Days[weekday] = true
+	}
+
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	if window.Location, err = time.LoadLocation(timezone); err != nil {
+		return nil, err
+	}
+	return window, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (window *TimeWindow) Contains(t time.Time) bool {
+	t = t.In(window.Location)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, window.Location)
+	offset := t.Sub(midnight)
+	day := t.Weekday()
+
+	if window.Start <= window.End {
+		return window.onDay(day) && offset >= window.Start && offset < window.End
+	}
+	if offset >= window.Start {
+		return window.onDay(day)
+	}
+	return offset < window.End && window.onDay((day+6)%7)
+}
+
+func (window *TimeWindow) onDay(day time.Weekday) bool {
+	return len(window.Days) == 0 || window.Days[day]
+}
+
+func (handler *PolicyHandler) Handle(ctx context.Context, notice Notice) error {
+	now := handler.Clock.Now()
+	for _, policy := range handler.Policies {
+		if policy.Window.Contains(now) {
+			log.Printf("using %s drain policy for %s notice", policy.Name, notice.Type())
+			return policy.Chain.Handle(ctx, notice)
+		}
+	}
+	return handler.Default.Handle(ctx, notice)
+}
@@ -0,0 +1,219 @@
+package lcmgr
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"golang.org/x/sync/errgroup"
+)
+
+const defaultPrefetchParallelism = 4
+
+// PrefetchObject is an S3 object to download to Path, given as an s3:// URL.
+type PrefetchObject struct {
+	Source string `json:"source"`
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// PrefetchHandler downloads S3 objects in parallel before an instance enters
+// service, e.g. model weights on inference fleets. Downloads are written to a
+// .partial file next to the destination and resumed from where they left off
+// when retried, even across restarts, as long as the object hasn't changed. A file is only moved into place once
+// it's complete and its checksum, if given, matches.
+type PrefetchHandler struct {
+	Objects     []PrefetchObject
+	Parallelism int
+	Client      AWSClient
+}
+
+func NewPrefetchHandler(objects []PrefetchObject, parallelism int, client AWSClient) *PrefetchHandler {
+	if parallelism <= 0 {
+		parallelism = defaultPrefetchParallelism
+	}
+	return &PrefetchHandler{
+		Objects:     objects,
+		Parallelism: parallelism,
+		Client:      client,
+	}
+}
+
+func (handler *PrefetchHandler) Handle(ctx context.Context, notice Notice) error {
+	group, ctx := errgroup.WithContext(ctx)
+	semaphore := make(chan struct{}, handler.Parallelism)
+	for _, object := range handler.Objects {
+		object := object
+		group.Go(func() error {
+			select {
+			case semaphore <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			defer func() { <-semaphore }()
+
+			if err := handler.fetch(ctx, object); err != nil {
+				return fmt.Errorf("failed to prefetch %s: %v", object.Source, err)
+			}
+			return nil
+		})
+	}
+	return group.Wait()
+}
+
+// fetch downloads an object, starting over if it changes partway through.
+func (handler *PrefetchHandler) fetch(ctx context.Context, object PrefetchObject) error {
+	err := handler.download(ctx, object)
+	if isPreconditionFailed(err) {
+		log.Printf("%s changed while downloading, starting over", object.Source)
+		err = handler.download(ctx, object)
+	}
+	return err
+}
+
+// download resumes the .partial download of an object if it was started on
+// the same version, recorded by ETag in a .etag file next to it. The object
+// is requested with If-Match so a version that changed since is never
+// spliced onto it.
+func (handler *PrefetchHandler) download(ctx context.Context, object PrefetchObject) error {
+	bucket, key, err := parseS3URL(object.Source)
+	if err != nil {
+		return err
+	}
+
+	info, err := handler.Client.GetObjectInfo(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(object.Path), 0755); err != nil {
+		return err
+	}
+	partial := object.Path + ".partial"
+	etagPath := partial + ".etag"
+	file, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Hash what was already downloaded so the checksum covers the whole file.
+	hash := sha256.New()
+	offset, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	etag, _ := ioutil.ReadFile(etagPath)
+	if offset > info.Size || (offset > 0 && string(etag) != info.ETag) {
+		offset = 0
+		hash.Reset()
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(etagPath, []byte(info.ETag), 0644); err != nil {
+		return err
+	}
+
+	if offset < info.Size {
+		if offset > 0 {
+			log.Printf("resuming download of %s at %d of %d bytes", object.Source, offset, info.Size)
+		}
+		body, err := handler.Client.GetObject(ctx, bucket, key, info.ETag, offset)
+		if isPreconditionFailed(err) {
+			file.Truncate(0)
+			os.Remove(etagPath)
+		}
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+
+		if _, err := io.Copy(io.MultiWriter(file, hash), body); err != nil {
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	os.Remove(etagPath)
+	if err := renameVerified(partial, object.Path, object.SHA256, hash); err != nil {
+		return err
+	}
+	log.Printf("downloaded %s to %s", object.Source, object.Path)
+	return nil
+}
+
+func parseS3URL(source string) (string, string, error) {
+	parsed, err := url.Parse(source)
+	if err != nil {
+		return "", "", err
+	}
+	if parsed.Scheme != "s3" || parsed.Host == "" {
+		return "", "", errors.New("expected an s3://bucket/key url")
+	}
+	return parsed.Host, strings.TrimPrefix(parsed.Path, "/"), nil
+}
+
+// ObjectInfo describes the current version of an S3 object.
+type ObjectInfo struct {
+	Size int64
+	ETag string
+}
+
+func (client *awsClient) GetObjectInfo(ctx context.Context, bucket, key string) (*ObjectInfo, error) {
+	output, err := client.S3().HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{
+		Size: aws.ToInt64(output.ContentLength),
+		ETag: aws.ToString(output.ETag),
+	}, nil
+}
+
+// GetObject returns the contents of an object starting at offset. If etag is
+// set the request fails with PreconditionFailed once the object has changed.
+func (client *awsClient) GetObject(ctx context.Context, bucket, key, etag string, offset int64) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if etag != "" {
+		input.IfMatch = aws.String(etag)
+	}
+	if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	output, err := client.S3().GetObject(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+// isPreconditionFailed returns true if S3 rejected a conditional request
+// because the object changed.
+func isPreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed"
+}
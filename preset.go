@@ -0,0 +1,100 @@
+package lcmgr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Preset is a built-in configuration for a well-known stack, so new users get
+// a sensible drain without writing steps. A preset only fills in settings the
+// config file and flags leave unset.
+type Preset struct {
+	Description string
+	Services    []string
+	NoticeSLO   Duration
+	Steps       []StepConfig
+	Launch      []StepConfig
+}
+
+// Presets are the built-in presets by name.
+var Presets = map[string]*Preset{
+	"nginx-alb": {
+		Description: "nginx behind an application load balancer: deregister and wait for requests to drain, then stop nginx",
+		Services:    []string{"nginx"},
+		NoticeSLO:   Duration(10 * time.Minute),
+		Steps: []StepConfig{
+			{
+				Name:         "deregister",
+				LoadBalancer: &LoadBalancerConfig{Discover: true, CapToDeadline: true},
+				Timeout:      Duration(5 * time.Minute),
+			},
+			{Name: "stop nginx", Service: StopServicesAction, Timeout: Duration(time.Minute)},
+		},
+		Launch: []StepConfig{
+			{Name: "start nginx", Service: StartServicesAction, Timeout: Duration(time.Minute)},
+			{Name: "healthy", Health: &HealthConfig{URL: "http://127.0.0.1/"}, Timeout: Duration(5 * time.Minute)},
+		},
+	},
+	"sidekiq-worker": {
+		Description: "Sidekiq worker: quiet it with TSTP so it stops fetching jobs, then stop it once running jobs finish",
+		Services:    []string{"sidekiq"},
+		NoticeSLO:   Duration(30 * time.Minute),
+		Steps: []StepConfig{
+			{Name: "quiet sidekiq", Signal: &SignalConfig{Service: "sidekiq", Signal: "TSTP"}},
+			{Name: "stop sidekiq", Service: StopServicesAction, Timeout: Duration(25 * time.Minute)},
+		},
+		Launch: []StepConfig{
+			{Name: "start sidekiq", Service: StartServicesAction, Timeout: Duration(2 * time.Minute)},
+		},
+	},
+	"kafka-broker": {
+		Description: "Kafka broker: stop it with a controlled shutdown that moves partition leadership away first",
+		Services:    []string{"kafka"},
+		NoticeSLO:   Duration(time.Hour),
+		Steps: []StepConfig{
+			{Name: "stop kafka", Service: StopServicesAction, Timeout: Duration(45 * time.Minute)},
+		},
+		Launch: []StepConfig{
+			{Name: "start kafka", Service: StartServicesAction, Timeout: Duration(10 * time.Minute)},
+		},
+	},
+}
+
+// PresetNames returns the names of the built-in presets in order.
+func PresetNames() []string {
+	names := make([]string, 0, len(Presets))
+	for name := range Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyPreset fills in the settings of the preset named by config.Preset that
+// config leaves unset. Steps and launch steps are taken as a whole, so
+// configuring any steps replaces the preset's.
+func (config *Config) ApplyPreset() error {
+	if config.Preset == "" {
+		return nil
+	}
+	preset, ok := Presets[config.Preset]
+	if !ok {
+		return fmt.Errorf("unknown preset %q, must be one of %s", config.Preset, strings.Join(PresetNames(), ", "))
+	}
+
+	if len(config.ServiceNames()) == 0 {
+		config.Services = preset.Services
+	}
+	if config.NoticeSLO == 0 {
+		config.NoticeSLO = preset.NoticeSLO
+	}
+	if len(config.Steps) == 0 && len(config.Policies) == 0 {
+		config.Steps = preset.Steps
+	}
+	if len(config.Launch) == 0 {
+		config.Launch = preset.Launch
+	}
+	return nil
+}
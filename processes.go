@@ -0,0 +1,298 @@
+package lcmgr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+)
+
+const (
+	// suspendedProcessesKey is the Store key of scaling processes suspended
+	// through the admin API and when to resume them.
+	suspendedProcessesKey = "suspended_processes"
+	// DefaultSuspendDuration is how long processes stay suspended when no
+	// duration is given.
+	DefaultSuspendDuration = 30 * time.Minute
+	// maxSuspendDuration caps suspensions so a forgotten one can't leave the
+	// group unable to replace unhealthy instances for long.
+	maxSuspendDuration = 6 * time.Hour
+	// resumeRetryInterval is how long to wait before retrying a resume when
+	// the safety timer couldn't resume processes, doubling on each failure.
+	resumeRetryInterval = 10 * time.Second
+)
+
+// defaultSuspendedProcesses are suspended when none are named, keeping Auto
+// Scaling from terminating or replacing the instance during maintenance.
+var defaultSuspendedProcesses = []string{"Terminate", "HealthCheck"}
+
+var scalingProcesses = map[string]bool{
+	"Launch":            true,
+	"Terminate":         true,
+	"AddToLoadBalancer": true,
+	"AlarmNotification": true,
+	"AZRebalance":       true,
+	"HealthCheck":       true,
+	"InstanceRefresh":   true,
+	"ReplaceUnhealthy":  true,
+	"ScheduledActions":  true,
+}
+
+// SuspendProcesses suspends scaling processes on the instance's group.
+func (client *awsClient) SuspendProcesses(ctx context.Context, processes []string) error {
+	autoScalingGroupName, err := client.GetAutoScalingGroupName(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.AutoScaling().SuspendProcesses(ctx, &autoscaling.SuspendProcessesInput{
+		AutoScalingGroupName: aws.String(autoScalingGroupName),
+		ScalingProcesses:     processes,
+	})
+	return err
+}
+
+// ResumeProcesses resumes scaling processes on the instance's group.
+func (client *awsClient) ResumeProcesses(ctx context.Context, processes []string) error {
+	autoScalingGroupName, err := client.GetAutoScalingGroupName(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.AutoScaling().ResumeProcesses(ctx, &autoscaling.ResumeProcessesInput{
+		AutoScalingGroupName: aws.String(autoScalingGroupName),
+		ScalingProcesses:     processes,
+	})
+	return err
+}
+
+// ProcessSuspender suspends scaling processes on the instance's group around
+// maintenance done from the instance, e.g. so a reboot doesn't fail health
+// checks and get the instance replaced. Every suspension has a safety timer
+// that resumes the processes if nobody does. Suspensions are kept in Store so
+// Restore can rearm the timers after a restart.
+type ProcessSuspender struct {
+	Client AWSClient
+	Store  Store
+	Clock  Clock
+
+	mu        sync.Mutex
+	suspended map[string]time.Time
+}
+
+func NewProcessSuspender(client AWSClient, store Store) *ProcessSuspender {
+	return &ProcessSuspender{
+		Client:    client,
+		Store:     store,
+		Clock:     NewClock(),
+		suspended: make(map[string]time.Time),
+	}
+}
+
+// Suspend suspends processes, or Terminate and HealthCheck when none are
+// given, until they're resumed or duration passes, and returns when they'll
+// be resumed.
+func (suspender *ProcessSuspender) Suspend(ctx context.Context, processes []string, duration time.Duration) (time.Time, error) {
+	if len(processes) == 0 {
+		processes = defaultSuspendedProcesses
+	}
+	for _, process := range processes {
+		if !scalingProcesses[process] {
+			return time.Time{}, fmt.Errorf("unknown scaling process %q", process)
+		}
+	}
+	if duration <= 0 {
+		duration = DefaultSuspendDuration
+	}
+	if duration > maxSuspendDuration {
+		duration = maxSuspendDuration
+	}
+
+	if err := suspender.Client.SuspendProcesses(ctx, processes); err != nil {
+		return time.Time{}, err
+	}
+
+	until := suspender.Clock.Now().Add(duration)
+	suspender.mu.Lock()
+	for _, process := range processes {
+		suspender.suspended[process] = until
+	}
+	suspender.save()
+	suspender.mu.Unlock()
+
+	go suspender.expire(processes, until)
+	return until, nil
+}
+
+// Resume resumes processes, or every process suspended through the
+// suspender when none are given.
+func (suspender *ProcessSuspender) Resume(ctx context.Context, processes []string) error {
+	suspender.mu.Lock()
+	if len(processes) == 0 {
+		for process := range suspender.suspended {
+			processes = append(processes, process)
+		}
+		sort.Strings(processes)
+	}
+	suspender.mu.Unlock()
+	if len(processes) == 0 {
+		return nil
+	}
+
+	if err := suspender.Client.ResumeProcesses(ctx, processes); err != nil {
+		return err
+	}
+
+	suspender.mu.Lock()
+	for _, process := range processes {
+		delete(suspender.suspended, process)
+	}
+	suspender.save()
+	suspender.mu.Unlock()
+	return nil
+}
+
+// Suspended returns the suspended processes and when each will be resumed.
+func (suspender *ProcessSuspender) Suspended() map[string]time.Time {
+	suspender.mu.Lock()
+	defer suspender.mu.Unlock()
+
+	suspended := make(map[string]time.Time, len(suspender.suspended))
+	for process, until := range suspender.suspended {
+		suspended[process] = until
+	}
+	return suspended
+}
+
+// Restore loads suspensions made before a restart, resuming the ones whose
+// safety timer ran out while lcmgr was down and rearming the rest.
+func (suspender *ProcessSuspender) Restore(ctx context.Context) {
+	suspended := make(map[string]time.Time)
+	if _, err := suspender.Store.Get(suspendedProcessesKey, &suspended); err != nil {
+		log.Printf("failed to load suspended scaling processes: %v", err)
+		return
+	}
+
+	suspender.mu.Lock()
+	suspender.suspended = suspended
+	suspender.mu.Unlock()
+
+	for process, until := range suspended {
+		go suspender.expire([]string{process}, until)
+	}
+}
+
+// expire resumes processes at until unless they were resumed or suspended
+// again in the meantime, retrying with backoff until the resume succeeds.
+func (suspender *ProcessSuspender) expire(processes []string, until time.Time) {
+	wait := until.Sub(suspender.Clock.Now())
+	for attempt := 1; ; attempt++ {
+		<-suspender.Clock.After(wait)
+
+		suspender.mu.Lock()
+		var expired []string
+		for _, process := range processes {
+			if current, ok := suspender.suspended[process]; ok && current.Equal(until) {
+				expired = append(expired, process)
+			}
+		}
+		suspender.mu.Unlock()
+		if len(expired) == 0 {
+			return
+		}
+
+		log.Printf("safety timer expired, resuming scaling processes %v", expired)
+		err := suspender.Resume(context.Background(), expired)
+		if err == nil {
+			return
+		}
+		wait = restartBackoff(resumeRetryInterval, attempt)
+		log.Printf("failed to resume scaling processes %v, retrying in %s: %v", expired, wait, err)
+	}
+}
+
+func (suspender *ProcessSuspender) save() {
+	var err error
+	if len(suspender.suspended) == 0 {
+		err = suspender.Store.Delete(suspendedProcessesKey)
+	} else {
+		err = suspender.Store.Put(suspendedProcessesKey, suspender.suspended)
+	}
+	if err != nil {
+		log.Printf("failed to save suspended scaling processes: %v", err)
+	}
+}
+
+// EnableProcessSuspension serves suspending and resuming the group's scaling
+// processes through suspender.
+func (api *API) EnableProcessSuspension(suspender *ProcessSuspender) {
+	api.Suspender = suspender
+	api.mux.HandleFunc("/v1/suspend", api.suspend)
+	api.mux.HandleFunc("/v1/resume", api.resume)
+}
+
+func (api *API) suspend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Processes []string `json:"processes"`
+		Duration  Duration `json:"duration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, process := range request.Processes {
+		if !scalingProcesses[process] {
+			http.Error(w, fmt.Sprintf("unknown scaling process %q", process), http.StatusBadRequest)
+			return
+		}
+	}
+
+	until, err := api.Suspender.Suspend(r.Context(), request.Processes, time.Duration(request.Duration))
+	if err != nil {
+		log.Printf("failed to suspend scaling processes: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	log.Printf("scaling processes suspended until %s through admin api", until.Format(time.RFC3339))
+	writeJSON(w, struct {
+		Suspended map[string]time.Time `json:"suspended"`
+	}{api.Suspender.Suspended()})
+}
+
+func (api *API) resume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Processes []string `json:"processes"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := api.Suspender.Resume(r.Context(), request.Processes); err != nil {
+		log.Printf("failed to resume scaling processes: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	log.Printf("scaling processes resumed through admin api")
+	w.WriteHeader(http.StatusNoContent)
+}
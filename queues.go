@@ -0,0 +1,120 @@
+package lcmgr
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// QueueRefresher runs a LifecycleListener for each lifecycle hook queue. The
+// queues are rediscovered every Interval, when it isn't zero, and whenever
+// Reload receives, so hooks added after startup are picked up and listeners
+// for removed hooks stop. Static queues, e.g. EventBridge queues, are always
+// listened on. Rebalance is passed on to every LifecycleListener. SetHooks,
+// if set, is given the hooks each time the queues are rediscovered, so
+// notices from new hooks get their timeouts.
+type QueueRefresher struct {
+	Notices   chan Notice
+	Queues    []*Queue
+	Static    []*Queue
+	Interval  time.Duration
+	Rebalance bool
+	Reload    <-chan os.Signal
+	SetHooks  func([]*LifecycleHook)
+	Client    AWSClient
+	Clock     Clock
+}
+
+// NewQueueRefresher starts out listening on queues, already discovered, and
+// static.
+func NewQueueRefresher(notices chan Notice, queues, static []*Queue, interval time.Duration, client AWSClient) *QueueRefresher {
+	return &QueueRefresher{
+		Notices:  notices,
+		Queues:   queues,
+		Static:   static,
+		Interval: interval,
+		Client:   client,
+		Clock:    NewClock(),
+	}
+}
+
+func (refresher *QueueRefresher) Type() string {
+	return "queues"
+}
+
+func (refresher *QueueRefresher) Listen(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	listeners := make(map[string]context.CancelFunc)
+	update := func(queues []*Queue) {
+		wanted := make(map[string]*Queue)
+		for _, queue := range append(queues, refresher.Static...) {
+			wanted[queue.URL] = queue
+		}
+
+		for url, cancel := range listeners {
+			if _, ok := wanted[url]; !ok {
+				log.Printf("lifecycle hook queue %s was removed, no longer listening on it", url)
+				cancel()
+				delete(listeners, url)
+			}
+		}
+		for url, queue := range wanted {
+			if _, ok := listeners[url]; ok {
+				continue
+			}
+			listenerCtx, cancel := context.WithCancel(ctx)
+			listeners[url] = cancel
+			listener := NewLifecycleListener(refresher.Notices, queue, refresher.Rebalance, refresher.Client)
+			debugf("listening on %s queue %s", listener.Type(), url)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := listener.Listen(listenerCtx); err != nil {
+					log.Printf("failed to listen on queue %s: %v", url, err)
+				}
+			}()
+		}
+	}
+	update(refresher.Queues)
+
+	var tick <-chan time.Time
+	if refresher.Interval > 0 {
+		ticker := refresher.Clock.NewTicker(refresher.Interval)
+		defer ticker.Stop()
+		tick = ticker.C()
+	}
+
+	for {
+		select {
+		case <-tick:
+		case <-refresher.Reload:
+			log.Printf("rediscovering lifecycle hook queues")
+		case <-ctx.Done():
+			return nil
+		}
+
+		if refresher.SetHooks != nil {
+			hooks, err := refresher.Client.GetLifecycleHooks(ctx)
+			if err != nil {
+				log.Printf("failed to rediscover lifecycle hooks: %v", err)
+			} else {
+				refresher.SetHooks(hooks)
+			}
+		}
+		queues, err := refresher.Client.GetLifecycleNoticeQueues(ctx)
+		if err != nil {
+			log.Printf("failed to rediscover lifecycle hook queues: %v", err)
+			continue
+		}
+		for _, queue := range queues {
+			if _, ok := listeners[queue.URL]; !ok {
+				log.Printf("found new lifecycle hook queue %s", queue.URL)
+			}
+		}
+		update(queues)
+	}
+}
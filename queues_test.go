@@ -0,0 +1,48 @@
+package lcmgr
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestQueueRefresherUpdatesHooks(t *testing.T) {
+	client := &fakeAWSClient{discovered: make(chan struct{})}
+	handler := NewServiceHandler(nil, time.Minute, client, nil)
+	handler.SetHooks([]*LifecycleHook{{Name: "launch", HeartbeatTimeout: time.Minute}})
+
+	reload := make(chan os.Signal, 1)
+	refresher := NewQueueRefresher(make(chan Notice), nil, nil, 0, client)
+	refresher.Reload = reload
+	refresher.SetHooks = handler.SetHooks
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- refresher.Listen(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-errs; err != nil {
+			t.Errorf("Listen returned %v", err)
+		}
+	}()
+
+	client.mu.Lock()
+	client.hooks = []*LifecycleHook{
+		{Name: "launch", HeartbeatTimeout: time.Minute},
+		{Name: "drain", HeartbeatTimeout: 10 * time.Minute, GlobalTimeout: 2 * time.Hour, DefaultResult: AbandonResult},
+	}
+	client.mu.Unlock()
+	reload <- os.Interrupt
+	select {
+	case <-client.discovered:
+	case <-time.After(10 * time.Second):
+		t.Fatal("queues weren't rediscovered")
+	}
+
+	notice := NewTerminationNotice("drain", "token")
+	handler.attachHook(notice)
+	if notice.HeartbeatTimeout != 10*time.Minute || notice.GlobalTimeout != 2*time.Hour || notice.DefaultResult != AbandonResult {
+		t.Errorf("notice from the new hook has heartbeat timeout %s, global timeout %s and default result %q", notice.HeartbeatTimeout, notice.GlobalTimeout, notice.DefaultResult)
+	}
+}
@@ -0,0 +1,95 @@
+package lcmgr
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultQuorumInterval = 10 * time.Second
+
+// QuorumHandler gates a drain on the cluster's own view of whether it is
+// safe to lose this instance, the usual pattern for consensus-based
+// datastores in auto scaling groups. It polls URL until it responds with a
+// 2xx status, and any other status means losing the instance now would
+// break quorum. The lifecycle action keeps being heartbeated while it waits,
+// up to the hook's budget. {instance_id} in URL is replaced with the instance
+// ID, e.g. http://localhost:8080/safe-to-remove?node={instance_id}.
+type QuorumHandler struct {
+	URL        string
+	Interval   time.Duration
+	Client     AWSClient
+	HTTPClient *http.Client
+	Clock      Clock
+}
+
+func NewQuorumHandler(url string, interval time.Duration, client AWSClient) *QuorumHandler {
+	if interval <= 0 {
+		interval = defaultQuorumInterval
+	}
+	return &QuorumHandler{
+		URL:        url,
+		Interval:   interval,
+		Client:     client,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		Clock:      NewClock(),
+	}
+}
+
+func (handler *QuorumHandler) Handle(ctx context.Context, notice Notice) error {
+	url := handler.URL
+	if strings.Contains(url, "{instance_id}") {
+		instanceID, err := handler.Client.GetInstanceID(ctx)
+		if err != nil {
+			return err
+		}
+		url = strings.Replace(url, "{instance_id}", instanceID, -1)
+	}
+
+	for {
+		safe, reason, err := handler.check(ctx, url)
+		if err != nil {
+			log.Printf("failed to check cluster quorum: %v", err)
+		} else if safe {
+			return nil
+		} else {
+			log.Printf("cluster is not ready to lose this instance, waiting: %s", reason)
+		}
+
+		select {
+		case <-handler.Clock.After(handler.Interval):
+		case <-ctx.Done():
+			return fmt.Errorf("cluster never became safe to drain: %v", ctx.Err())
+		}
+	}
+}
+
+// check returns whether the cluster is safe to drain, and the status and
+// start of the response body explaining why when it isn't.
+func (handler *QuorumHandler) check(ctx context.Context, url string) (bool, string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return false, "", err
+	}
+	resp, err := handler.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return true, "", nil
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+	reason := resp.Status
+	if message := strings.TrimSpace(string(body)); message != "" {
+		reason += ": " + message
+	}
+	return false, reason, nil
+}
@@ -0,0 +1,139 @@
+package lcmgr
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Receipt identifies the SQS message a lifecycle notice was received in, so
+// the message is only deleted once the notice has been handled and comes
+// back to be handled again if lcmgr crashes first.
+type Receipt struct {
+	QueueURL      string
+	ReceiptHandle string
+}
+
+// maxBatchEntries is the most entries an SQS batch request accepts.
+const maxBatchEntries = 10
+
+// holdInterval is how often a received message's visibility timeout is
+// extended while its notice is handled.
+const holdInterval = receiveVisibilityTimeout * time.Second / 3
+
+// noticeReceipt returns the receipt of a lifecycle notice, or nil.
+func noticeReceipt(notice Notice) *Receipt {
+	if lifecycle := lifecycleNotice(notice); lifecycle != nil {
+		return lifecycle.Receipt
+	}
+	return nil
+}
+
+// messageSentAt returns when message was first sent to its queue, or
+// fallback if SQS didn't say. Unlike when it's received, this doesn't move
+// when the message is received again after its visibility timeout.
+func messageSentAt(message types.Message, fallback time.Time) time.Time {
+	sent, err := strconv.ParseInt(message.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)], 10, 64)
+	if err != nil {
+		return fallback
+	}
+	return time.UnixMilli(sent)
+}
+
+// DeleteNoticeMessage deletes the message a notice was received in, if any,
+// once it has been handled.
+func (client *awsClient) DeleteNoticeMessage(ctx context.Context, notice Notice) error {
+	receipt := noticeReceipt(notice)
+	if receipt == nil {
+		return nil
+	}
+
+	input := &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(receipt.QueueURL),
+		ReceiptHandle: aws.String(receipt.ReceiptHandle),
+	}
+	_, err := client.SQS().DeleteMessage(ctx, input, withSQSMaxAttempts(deleteMaxAttempts))
+	return err
+}
+
+// deleteMessages deletes messages received from queue in batches.
+func (client *awsClient) deleteMessages(ctx context.Context, queue *Queue, messages []types.Message) {
+	for len(messages) > 0 {
+		batch := messages
+		if len(batch) > maxBatchEntries {
+			batch = batch[:maxBatchEntries]
+		}
+		messages = messages[len(batch):]
+
+		entries := make([]types.DeleteMessageBatchRequestEntry, 0, len(batch))
+		for i, message := range batch {
+			entries = append(entries, types.DeleteMessageBatchRequestEntry{
+				Id:            aws.String(strconv.Itoa(i)),
+				ReceiptHandle: message.ReceiptHandle,
+			})
+		}
+		output, err := client.SQS().DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{
+			QueueUrl: aws.String(queue.URL),
+			Entries:  entries,
+		}, withSQSMaxAttempts(deleteMaxAttempts))
+		if err != nil {
+			log.Printf("failed to delete messages from %s: %v", queue.Name, err)
+			continue
+		}
+		for _, failed := range output.Failed {
+			log.Printf("failed to delete message from %s: %s", queue.Name, aws.ToString(failed.Message))
+		}
+	}
+}
+
+// ExtendNoticeMessage keeps the message a notice was received in, if any,
+// hidden from other receives for another visibility timeout.
+func (client *awsClient) ExtendNoticeMessage(ctx context.Context, notice Notice) error {
+	receipt := noticeReceipt(notice)
+	if receipt == nil {
+		return nil
+	}
+
+	input := &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(receipt.QueueURL),
+		ReceiptHandle:     aws.String(receipt.ReceiptHandle),
+		VisibilityTimeout: receiveVisibilityTimeout,
+	}
+	_, err := client.SQS().ChangeMessageVisibility(ctx, input)
+	return err
+}
+
+// HoldNoticeMessage extends the visibility timeout of the message a notice
+// was received in until ctx is canceled, so it isn't received again while
+// the notice is handled.
+func HoldNoticeMessage(ctx context.Context, client AWSClient, clock Clock, notice Notice) {
+	if noticeReceipt(notice) == nil {
+		return
+	}
+
+	ticker := clock.NewTicker(holdInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if err := client.ExtendNoticeMessage(ctx, notice); err != nil && ctx.Err() == nil {
+				log.Printf("failed to extend visibility of %s notice message: %v", notice.Type(), err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// acknowledgeNotice deletes the message a notice was received in now that
+// it's been handled.
+func acknowledgeNotice(ctx context.Context, client AWSClient, notice Notice) {
+	if err := client.DeleteNoticeMessage(ctx, notice); err != nil {
+		log.Printf("failed to delete %s notice message, it will be received again: %v", notice.Type(), err)
+	}
+}
@@ -0,0 +1,26 @@
+package lcmgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestMessageSentAt(t *testing.T) {
+	fallback := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name       string
+		attributes map[string]string
+		want       time.Time
+	}{
+		{"sent timestamp", map[string]string{"SentTimestamp": "1709290800123"}, time.UnixMilli(1709290800123)},
+		{"missing", nil, fallback},
+		{"malformed", map[string]string{"SentTimestamp": "yesterday"}, fallback},
+	} {
+		message := types.Message{Attributes: test.attributes}
+		if got := messageSentAt(message, fallback); !got.Equal(test.want) {
+			t.Errorf("%s: messageSentAt = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package lcmgr
+
+import (
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// redactedPrefixLength is how much of a redacted value is kept so log lines
+// can still be correlated.
+const redactedPrefixLength = 8
+
+// defaultRedactPatterns match lifecycle action tokens and SQS queue URLs.
+var defaultRedactPatterns = []string{
+	`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+	`https://sqs\.[a-z0-9-]+\.amazonaws\.com(\.cn)?/[0-9]{12}/[A-Za-z0-9_.-]+`,
+}
+
+// Redactor is a log output that shortens sensitive values, lifecycle action
+// tokens, queue URLs, and any registered secrets like webhook URLs, to a
+// prefix before writing to Output, so logs can be shipped to third parties.
+// URLs keep their scheme and host.
+type Redactor struct {
+	Output   io.Writer
+	Patterns []*regexp.Regexp
+
+	mu      sync.Mutex
+	secrets []string
+}
+
+// NewRedactor returns a redactor matching the default patterns and patterns,
+// which are regular expressions.
+func NewRedactor(output io.Writer, patterns []string) (*Redactor, error) {
+	redactor := &Redactor{Output: output}
+	for _, pattern := range append(defaultRedactPatterns, patterns...) {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		redactor.Patterns = append(redactor.Patterns, compiled)
+	}
+	return redactor, nil
+}
+
+// AddSecret redacts every occurrence of secret.
+func (redactor *Redactor) AddSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	redactor.mu.Lock()
+	defer redactor.mu.Unlock()
+	redactor.secrets = append(redactor.secrets, secret)
+}
+
+func (redactor *Redactor) Write(p []byte) (int, error) {
+	line := string(p)
+
+	redactor.mu.Lock()
+	secrets := redactor.secrets
+	redactor.mu.Unlock()
+	for _, secret := range secrets {
+		line = strings.Replace(line, secret, Redact(secret), -1)
+	}
+	for _, pattern := range redactor.Patterns {
+		line = pattern.ReplaceAllStringFunc(line, Redact)
+	}
+
+	if _, err := io.WriteString(redactor.Output, line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Redact shortens value to a prefix, keeping the scheme and host of URLs.
+func Redact(value string) string {
+	if parsed, err := url.Parse(value); err == nil && parsed.Scheme != "" && parsed.Host != "" {
+		base := parsed.Scheme + "://" + parsed.Host
+		return base + redactPrefix(strings.TrimPrefix(value, base))
+	}
+	return redactPrefix(value)
+}
+
+func redactPrefix(value string) string {
+	if len(value) <= redactedPrefixLength {
+		return strings.Repeat("*", len(value))
+	}
+	return value[:redactedPrefixLength] + "***"
+}
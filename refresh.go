@@ -0,0 +1,143 @@
+package lcmgr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+)
+
+// refreshPollInterval is how often replacement capacity is checked while
+// waiting on an instance refresh.
+const refreshPollInterval = 15 * time.Second
+
+// InstanceRefresh is an instance refresh running on the instance's group.
+// LaunchFirst is true when the refresh may exceed the desired capacity, so
+// replacements are launched before instances are terminated.
+type InstanceRefresh struct {
+	ID                 string
+	Status             string
+	PercentageComplete int
+	InstancesToUpdate  int
+	LaunchFirst        bool
+}
+
+// GetInstanceRefresh returns the pending or in progress instance refresh of
+// the instance's group, or nil when there isn't one.
+func (client *awsClient) GetInstanceRefresh(ctx context.Context) (*InstanceRefresh, error) {
+	autoScalingGroupName, err := client.GetAutoScalingGroupName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := client.AutoScaling().DescribeInstanceRefreshes(ctx, &autoscaling.DescribeInstanceRefreshesInput{
+		AutoScalingGroupName: aws.String(autoScalingGroupName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, refresh := range output.InstanceRefreshes {
+		if refresh.Status != types.InstanceRefreshStatusPending && refresh.Status != types.InstanceRefreshStatusInProgress {
+			continue
+		}
+		launchFirst := false
+		if refresh.Preferences != nil && aws.ToInt32(refresh.Preferences.MaxHealthyPercentage) > 100 {
+			launchFirst = true
+		}
+		return &InstanceRefresh{
+			ID:                 aws.ToString(refresh.InstanceRefreshId),
+			Status:             string(refresh.Status),
+			PercentageComplete: int(aws.ToInt32(refresh.PercentageComplete)),
+			InstancesToUpdate:  int(aws.ToInt32(refresh.InstancesToUpdate)),
+			LaunchFirst:        launchFirst,
+		}, nil
+	}
+	return nil, nil
+}
+
+// GetInServiceCapacity returns how many of the group's other instances are
+// InService and the group's desired capacity.
+func (client *awsClient) GetInServiceCapacity(ctx context.Context) (int, int, error) {
+	instanceID, err := client.GetInstanceID(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	autoScalingGroupName, err := client.GetAutoScalingGroupName(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	output, err := client.AutoScaling().DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []string{autoScalingGroupName},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(output.AutoScalingGroups) != 1 {
+		return 0, 0, fmt.Errorf("auto scaling group %s not found", autoScalingGroupName)
+	}
+
+	group := output.AutoScalingGroups[0]
+	inService := 0
+	for _, instance := range group.Instances {
+		if aws.ToString(instance.InstanceId) != instanceID && instance.LifecycleState == types.LifecycleStateInService {
+			inService++
+		}
+	}
+	return inService, int(aws.ToInt32(group.DesiredCapacity)), nil
+}
+
+// waitForRefresh holds off completing a termination lifecycle action during
+// an instance refresh until the group's other InService instances make up
+// its desired capacity, or RefreshWait passes. The refresh is recorded on
+// the notice's annotations. Refreshes that terminate before launching only
+// replace the instance once the action is completed, so they aren't waited
+// on.
+func (handler *ServiceHandler) waitForRefresh(ctx context.Context, notice Notice) {
+	if _, ok := notice.(*TerminationNotice); !ok || handler.RefreshWait <= 0 {
+		return
+	}
+
+	timeout := handler.Clock.After(handler.RefreshWait)
+	for {
+		refresh, err := handler.Client.GetInstanceRefresh(ctx)
+		if err != nil {
+			log.Printf("failed to get instance refresh: %v", err)
+			return
+		}
+		if refresh == nil {
+			return
+		}
+		Annotate(ctx, "instance_refresh_id", refresh.ID)
+		Annotate(ctx, "instance_refresh_percentage", strconv.Itoa(refresh.PercentageComplete))
+		if !refresh.LaunchFirst {
+			return
+		}
+
+		inService, desired, err := handler.Client.GetInServiceCapacity(ctx)
+		if err != nil {
+			log.Printf("failed to get in service capacity: %v", err)
+			return
+		}
+		if inService >= desired {
+			log.Printf("replacement capacity is in service (%d of %d), completing %s lifecycle action", inService, desired, notice.Type())
+			return
+		}
+		log.Printf("instance refresh %s is %d%% complete, waiting for replacement capacity (%d of %d in service)", refresh.ID, refresh.PercentageComplete, inService, desired)
+
+		select {
+		case <-handler.Clock.After(refreshPollInterval):
+		case <-timeout:
+			log.Printf("gave up waiting for replacement capacity after %s", handler.RefreshWait)
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
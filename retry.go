@@ -0,0 +1,54 @@
+package lcmgr
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+const (
+	// defaultAWSMaxAttempts bounds retries of AWS calls that don't set their
+	// own limit.
+	defaultAWSMaxAttempts = 5
+	// maxAWSBackoff caps the delay between attempts of an AWS call.
+	maxAWSBackoff = 20 * time.Second
+
+	// Calls that drop a lifecycle action or a notice when they give up get
+	// more attempts.
+	receiveMaxAttempts   = 8
+	deleteMaxAttempts    = 8
+	heartbeatMaxAttempts = 8
+	completeMaxAttempts  = 10
+)
+
+// newRetryer retries throttling, transient 5xx, and connection errors with
+// exponential backoff and full jitter. The SDK's client-side retry quota is
+// disabled so a burst of throttling doesn't stop retries across the process
+// when they're needed most.
+func newRetryer() aws.Retryer {
+	return retry.NewStandard(func(options *retry.StandardOptions) {
+		options.MaxAttempts = defaultAWSMaxAttempts
+		options.MaxBackoff = maxAWSBackoff
+		options.Backoff = retry.NewExponentialJitterBackoff(maxAWSBackoff)
+		options.RateLimiter = ratelimit.None
+	})
+}
+
+// withSQSMaxAttempts overrides the attempts of a single SQS call.
+func withSQSMaxAttempts(attempts int) func(*sqs.Options) {
+	return func(options *sqs.Options) {
+		options.RetryMaxAttempts = attempts
+	}
+}
+
+// withAutoScalingMaxAttempts overrides the attempts of a single Auto Scaling
+// call.
+func withAutoScalingMaxAttempts(attempts int) func(*autoscaling.Options) {
+	return func(options *autoscaling.Options) {
+		options.RetryMaxAttempts = attempts
+	}
+}
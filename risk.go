@@ -0,0 +1,97 @@
+package lcmgr
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+var spotRiskGauge = DefaultRegistry.Gauge("lcmgr_spot_risk", "Estimated risk between 0 and 1 that this spot instance is interrupted soon")
+
+// Rebalance recommendations usually precede interruptions, so the score ramps
+// up from rebalanceBaseRisk to rebalanceMaxRisk over rebalanceRampDuration
+// after one is received. An actual interruption notice is certain.
+const (
+	rebalanceBaseRisk     = 0.5
+	rebalanceMaxRisk      = 0.9
+	rebalanceRampDuration = 30 * time.Minute
+)
+
+// SpotRiskListener periodically scores the instance's interruption risk from
+// its purchase option, rebalance recommendations, and interruption notices and
+// exposes the result as the lcmgr_spot_risk metric.
+type SpotRiskListener struct {
+	Interval time.Duration
+	Client   AWSClient
+	Clock    Clock
+}
+
+func NewSpotRiskListener(interval time.Duration, client AWSClient) Listener {
+	return &SpotRiskListener{
+		Interval: interval,
+		Client:   client,
+		Clock:    NewClock(),
+	}
+}
+
+func (listener *SpotRiskListener) Listen(ctx context.Context) error {
+	ticker := listener.Clock.NewTicker(listener.Interval)
+	defer ticker.Stop()
+
+	for {
+		risk, err := listener.Score(ctx)
+		if err != nil {
+			log.Printf("failed to score spot interruption risk: %v", err)
+		} else {
+			spotRiskGauge.Set(risk)
+		}
+
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (listener *SpotRiskListener) Score(ctx context.Context) (float64, error) {
+	lifeCycle, err := listener.Client.GetInstanceLifeCycle(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if lifeCycle != "spot" {
+		return 0, nil
+	}
+
+	notice, err := listener.Client.GetSpotNotice(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if notice != nil {
+		return 1, nil
+	}
+
+	recommendation, err := listener.Client.GetRebalanceRecommendation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if recommendation == nil {
+		return 0, nil
+	}
+
+	return RebalanceRisk(listener.Clock.Now().Sub(*recommendation)), nil
+}
+
+func RebalanceRisk(age time.Duration) float64 {
+	if age <= 0 {
+		return rebalanceBaseRisk
+	}
+	if age >= rebalanceRampDuration {
+		return rebalanceMaxRisk
+	}
+	return rebalanceBaseRisk + (rebalanceMaxRisk-rebalanceBaseRisk)*float64(age)/float64(rebalanceRampDuration)
+}
+
+func (listener *SpotRiskListener) Type() string {
+	return "spot-risk"
+}
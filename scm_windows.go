@@ -0,0 +1,179 @@
+package lcmgr
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const scmPollInterval = 500 * time.Millisecond
+
+type SCMManager struct{}
+
+func NewServiceManager(config *Config) (ServiceManager, error) {
+	if config.ServiceBackend == KubernetesBackend {
+		return NewKubernetesManager(), nil
+	}
+	if config.ServiceBackend != "" && config.ServiceBackend != AutoBackend {
+		return nil, fmt.Errorf("service backend %s is not supported on windows", config.ServiceBackend)
+	}
+	return &SCMManager{}, nil
+}
+
+func (manager *SCMManager) StartService(ctx context.Context, service string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return wrapPermissionError("failed to connect to service control manager", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(service)
+	if err == windows.ERROR_SERVICE_DOES_NOT_EXIST {
+		return &ServiceNotFoundError{Service: service}
+	} else if err != nil {
+		return wrapPermissionError(fmt.Sprintf("failed to open windows service %s", service), err)
+	}
+	defer s.Close()
+
+	if err := s.Start(); err != nil {
+		return wrapPermissionError(fmt.Sprintf("failed to start windows service %s", service), err)
+	}
+
+	return waitForServiceState(ctx, s, svc.Running)
+}
+
+func (manager *SCMManager) StopService(ctx context.Context, service string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return wrapPermissionError("failed to connect to service control manager", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(service)
+	if err == windows.ERROR_SERVICE_DOES_NOT_EXIST {
+		return &ServiceNotFoundError{Service: service}
+	} else if err != nil {
+		return wrapPermissionError(fmt.Sprintf("failed to open windows service %s", service), err)
+	}
+	defer s.Close()
+
+	if _, err := s.Control(svc.Stop); err != nil {
+		return wrapPermissionError(fmt.Sprintf("failed to stop windows service %s", service), err)
+	}
+
+	return waitForServiceState(ctx, s, svc.Stopped)
+}
+
+func (manager *SCMManager) ServiceState(ctx context.Context, service string) (*ServiceState, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, wrapPermissionError("failed to connect to service control manager", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(service)
+	if err == windows.ERROR_SERVICE_DOES_NOT_EXIST {
+		return &ServiceState{LoadState: "not-found", ActiveState: "inactive"}, nil
+	} else if err != nil {
+		return nil, wrapPermissionError(fmt.Sprintf("failed to open windows service %s", service), err)
+	}
+	defer s.Close()
+
+	config, err := s.Config()
+	if err != nil {
+		return nil, err
+	}
+	status, err := s.Query()
+	if err != nil {
+		return nil, err
+	}
+
+	state := &ServiceState{LoadState: "loaded"}
+	if config.StartType == mgr.StartDisabled {
+		state.LoadState = "masked"
+	}
+	switch status.State {
+	case svc.Running, svc.Paused, svc.PausePending, svc.ContinuePending:
+		state.ActiveState = "active"
+	case svc.StartPending:
+		state.ActiveState = "activating"
+	case svc.StopPending:
+		state.ActiveState = "deactivating"
+	default:
+		state.ActiveState = "inactive"
+	}
+	return state, nil
+}
+
+func (manager *SCMManager) ServiceDependencies(ctx context.Context, service string) ([]string, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, wrapPermissionError("failed to connect to service control manager", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(service)
+	if err != nil {
+		return nil, wrapPermissionError(fmt.Sprintf("failed to open windows service %s", service), err)
+	}
+	defer s.Close()
+
+	config, err := s.Config()
+	if err != nil {
+		return nil, err
+	}
+	return config.Dependencies, nil
+}
+
+func waitForServiceState(ctx context.Context, s *mgr.Service, state svc.State) error {
+	ticker := time.NewTicker(scmPollInterval)
+	defer ticker.Stop()
+
+	for {
+		status, err := s.Query()
+		if err != nil {
+			return err
+		}
+		if status.State == state {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// SignalService maps HUP, the signal reload steps send, to the parameter
+// change control, which is how Windows services are asked to reread their
+// configuration. Windows has no other signals.
+func (manager *SCMManager) SignalService(ctx context.Context, service, signal string) error {
+	if signal != "HUP" {
+		return fmt.Errorf("can't send SIG%s to windows service %s, only HUP is supported", signal, service)
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return wrapPermissionError("failed to connect to service control manager", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(service)
+	if err == windows.ERROR_SERVICE_DOES_NOT_EXIST {
+		return &ServiceNotFoundError{Service: service}
+	} else if err != nil {
+		return wrapPermissionError(fmt.Sprintf("failed to open windows service %s", service), err)
+	}
+	defer s.Close()
+
+	if _, err := s.Control(svc.ParamChange); err != nil {
+		return wrapPermissionError(fmt.Sprintf("failed to signal windows service %s", service), err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package lcmgr
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	AutoBackend      = "auto"
+	DBusBackend      = "dbus"
+	SystemctlBackend = "systemctl"
+)
+
+// DefaultDrainTarget is the systemd target shipped with lcmgr for units that
+// want to take part in drains.
+const DefaultDrainTarget = "lcmgr-draining.target"
+
+// ServiceManager starts and stops the service managed by lcmgr using the
+// operating system's native service manager: systemd on Linux and the
+// Service Control Manager on Windows.
+type ServiceManager interface {
+	StartService(context.Context, string) error
+	StopService(context.Context, string) error
+	ServiceState(context.Context, string) (*ServiceState, error)
+	ServiceDependencies(context.Context, string) ([]string, error)
+}
+
+// ServiceSignaler is implemented by service managers that can send a signal,
+// named without the SIG prefix (e.g. HUP), to a service's processes.
+type ServiceSignaler interface {
+	SignalService(context.Context, string, string) error
+}
+
+// ServiceState uses systemd's vocabulary for unit states. Other managers map
+// their states onto it.
+type ServiceState struct {
+	LoadState   string
+	ActiveState string
+}
+
+// Idle reports whether the service has no running workload, meaning stopping
+// it would be a no-op.
+func (state *ServiceState) Idle() bool {
+	switch state.LoadState {
+	case "masked", "not-found":
+		return true
+	}
+	switch state.ActiveState {
+	case "inactive", "failed":
+		return true
+	}
+	return false
+}
+
+const (
+	MissingServiceFail = "fail"
+	MissingServiceSkip = "skip"
+)
+
+// ServiceNotFoundError is returned when the managed service doesn't exist,
+// which depending on configuration is either a failure or means there is
+// nothing to drain.
+type ServiceNotFoundError struct {
+	Service string
+}
+
+func (err *ServiceNotFoundError) Error() string {
+	return fmt.Sprintf("service %s not found", err.Service)
+}
+
+// PermissionError is returned when an operation is rejected by the operating
+// system rather than failing on its own, which on hardened images usually
+// means a SELinux or AppArmor policy is denying lcmgr access.
+type PermissionError struct {
+	Op  string
+	Err error
+}
+
+func (err *PermissionError) Error() string {
+	return fmt.Sprintf("%s: %v (permission denied, check for SELinux/AppArmor denials or try a different service backend or state directory)", err.Op, err.Err)
+}
+
+func wrapPermissionError(op string, err error) error {
+	if err == nil || !isPermissionDenied(err) {
+		return err
+	}
+	return &PermissionError{Op: op, Err: err}
+}
+
+func isPermissionDenied(err error) bool {
+	if os.IsPermission(err) {
+		return true
+	}
+
+	message := err.Error()
+	for _, s := range []string{"AccessDenied", "Access denied", "Permission denied", "permission denied"} {
+		if strings.Contains(message, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package lcmgr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultShedSteps    = 4
+	defaultShedDuration = time.Minute
+)
+
+// ShedHandler winds an application down gradually instead of stopping it
+// abruptly. It POSTs a decreasing capacity percentage, e.g. {"capacity": 75},
+// to an admin endpoint in Steps even steps spread over Duration, ending at 0.
+// Steps are compressed to fit the time left before the notice's deadline so
+// shedding always finishes in time.
+type ShedHandler struct {
+	URL      string
+	Steps    int
+	Duration time.Duration
+	Client   *http.Client
+	Clock    Clock
+}
+
+type shedPayload struct {
+	Capacity int `json:"capacity"`
+}
+
+func NewShedHandler(url string, steps int, duration time.Duration) *ShedHandler {
+	if steps <= 0 {
+		steps = defaultShedSteps
+	}
+	if duration <= 0 {
+		duration = defaultShedDuration
+	}
+	return &ShedHandler{
+		URL:      url,
+		Steps:    steps,
+		Duration: duration,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		Clock:    NewClock(),
+	}
+}
+
+func (handler *ShedHandler) Handle(ctx context.Context, notice Notice) error {
+	duration := handler.Duration
+	if deadline, ok := noticeDeadline(ctx, notice); ok {
+		if remaining := deadline.Sub(handler.Clock.Now()); remaining < duration {
+			duration = remaining
+		}
+	}
+	if duration < 0 {
+		duration = 0
+	}
+	interval := duration / time.Duration(handler.Steps)
+
+	for step := 1; step <= handler.Steps; step++ {
+		capacity := 100 - 100*step/handler.Steps
+		if err := handler.setCapacity(ctx, capacity); err != nil {
+			return err
+		}
+		log.Printf("shed load to %d%% capacity", capacity)
+
+		if step == handler.Steps {
+			break
+		}
+		select {
+		case <-handler.Clock.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (handler *ShedHandler) setCapacity(ctx context.Context, capacity int) error {
+	body, err := json.Marshal(&shedPayload{Capacity: capacity})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, handler.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := handler.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status setting capacity to %d%%: %s", capacity, resp.Status)
+	}
+	return nil
+}
+
+// noticeDeadline returns the earlier of the context deadline and, for spot
+// notices, the termination time.
+func noticeDeadline(ctx context.Context, notice Notice) (time.Time, bool) {
+	deadline, ok := ctx.Deadline()
+	if spot, isSpot := notice.(*SpotNotice); isSpot && (!ok || spot.TerminationTime.Before(deadline)) {
+		return spot.TerminationTime, true
+	}
+	return deadline, ok
+}
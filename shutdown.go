@@ -0,0 +1,76 @@
+package lcmgr
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lastGaspTimeout bounds the last-gasp handler so it finishes inside logind's
+// default InhibitDelayMaxSec of 5s.
+const lastGaspTimeout = 4 * time.Second
+
+// LastGaspHandler runs a compressed set of actions when the operating system
+// shuts down without a notice, before everything is killed. It writes the
+// shutdown flag file to FlagDir, flushes the current metrics to MetricsPath
+// so they survive the instance, and runs Chain, if set.
+type LastGaspHandler struct {
+	FlagDir     string
+	MetricsPath string
+	Chain       Handler
+}
+
+func NewLastGaspHandler(flagDir, metricsPath string) *LastGaspHandler {
+	return &LastGaspHandler{
+		FlagDir:     flagDir,
+		MetricsPath: metricsPath,
+	}
+}
+
+func (handler *LastGaspHandler) Handle(ctx context.Context, notice Notice) error {
+	ctx, cancel := context.WithTimeout(ctx, lastGaspTimeout)
+	defer cancel()
+
+	noticesCounter.Inc(notice.Type())
+
+	flags := &NotifyHandler{FlagDir: handler.FlagDir}
+	if err := flags.writeFlag(notice); err != nil {
+		log.Printf("failed to write %s flag file: %v", notice.Type(), err)
+	}
+
+	var err error
+	if handler.Chain != nil {
+		err = handler.Chain.Handle(ctx, notice)
+	}
+
+	if handler.MetricsPath != "" {
+		if err := handler.flushMetrics(); err != nil {
+			log.Printf("failed to flush metrics: %v", err)
+		}
+	}
+	return err
+}
+
+func (handler *LastGaspHandler) flushMetrics() error {
+	if err := os.MkdirAll(filepath.Dir(handler.MetricsPath), 0700); err != nil {
+		return err
+	}
+	file, err := os.Create(handler.MetricsPath + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := DefaultRegistry.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(handler.MetricsPath+".tmp", handler.MetricsPath)
+}
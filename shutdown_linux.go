@@ -0,0 +1,118 @@
+//go:build linux
+// +build linux
+
+package lcmgr
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/coreos/go-systemd/login1"
+	godbus "github.com/godbus/dbus"
+)
+
+const prepareForShutdownSignal = "org.freedesktop.login1.Manager.PrepareForShutdown"
+
+// ShutdownListener detects the operating system shutting down without a
+// notice. It holds a logind delay inhibitor lock so that when logind emits
+// PrepareForShutdown, Handler runs before the shutdown continues. Handler is
+// called directly rather than through the notices channel since a drain may
+// already be in progress.
+type ShutdownListener struct {
+	Handler Handler
+}
+
+func NewShutdownListener(handler Handler) Listener {
+	return &ShutdownListener{
+		Handler: handler,
+	}
+}
+
+func (listener *ShutdownListener) Type() string {
+	return "shutdown"
+}
+
+func (listener *ShutdownListener) Listen(ctx context.Context) error {
+	conn, err := login1.New()
+	if err != nil {
+		log.Printf("failed to connect to logind, shutdowns without a notice won't be detected: %v", err)
+		return nil
+	}
+	defer conn.Close()
+
+	signals := conn.Subscribe("PrepareForShutdown")
+
+	lock, err := listener.inhibit(conn)
+	if err != nil {
+		log.Printf("failed to take shutdown inhibitor lock, shutdowns without a notice won't be detected: %v", err)
+		return nil
+	}
+	defer func() {
+		if lock != nil {
+			lock.Close()
+		}
+	}()
+
+	for {
+		var signal *godbus.Signal
+		select {
+		case signal = <-signals:
+		case <-ctx.Done():
+			return nil
+		}
+		if signal == nil {
+			log.Printf("lost connection to logind, shutdowns without a notice won't be detected")
+			return nil
+		}
+		if signal.Name != prepareForShutdownSignal || len(signal.Body) == 0 {
+			continue
+		}
+
+		active, _ := signal.Body[0].(bool)
+		if !active {
+			// The shutdown was cancelled, take the lock again for the next one.
+			if lock == nil {
+				if lock, err = listener.inhibit(conn); err != nil {
+					log.Printf("failed to take shutdown inhibitor lock: %v", err)
+				}
+			}
+			continue
+		}
+
+		// lcmgr is usually sent SIGTERM as part of the same shutdown, so the
+		// last-gasp actions must not be cancelled along with ctx.
+		log.Printf("operating system is shutting down without a notice, running last-gasp actions")
+		if err := listener.Handler.Handle(context.WithoutCancel(ctx), &ShutdownNotice{}); err != nil {
+			log.Printf("failed to handle shutdown notice: %v", err)
+		}
+		if lock != nil {
+			lock.Close()
+			lock = nil
+		}
+	}
+}
+
+func (listener *ShutdownListener) inhibit(conn *login1.Conn) (*os.File, error) {
+	return conn.Inhibit("shutdown", "lcmgr", "Running last-gasp actions", "delay")
+}
+
+// InhibitShutdown takes a logind delay inhibitor lock, so a shutdown started
+// before release is called waits up to InhibitDelayMaxSec for it.
+func InhibitShutdown(why string) (release func(), err error) {
+	conn, err := login1.New()
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := conn.Inhibit("shutdown", "lcmgr", why, "delay")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return func() {
+		lock.Close()
+		conn.Close()
+	}, nil
+}
@@ -0,0 +1,36 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package lcmgr
+
+import (
+	"context"
+	"errors"
+	"log"
+)
+
+// ShutdownListener detects shutdowns without a notice through logind on Linux
+// and the service control manager on Windows.
+type ShutdownListener struct {
+	Handler Handler
+}
+
+func NewShutdownListener(handler Handler) Listener {
+	return &ShutdownListener{
+		Handler: handler,
+	}
+}
+
+func (listener *ShutdownListener) Type() string {
+	return "shutdown"
+}
+
+func (listener *ShutdownListener) Listen(ctx context.Context) error {
+	log.Printf("detecting shutdowns without a notice is only supported on linux and windows")
+	return nil
+}
+
+// InhibitShutdown takes a logind inhibitor lock, which only exists on Linux.
+func InhibitShutdown(why string) (release func(), err error) {
+	return nil, errors.New("shutdown inhibitor locks are only supported on linux")
+}
@@ -0,0 +1,58 @@
+package lcmgr
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// ShutdownListener detects the operating system shutting down without a
+// notice. The service control manager sends lcmgr a preshutdown request and
+// waits, up to the service's preshutdown timeout, for Handler to finish
+// before the shutdown continues. It only works when lcmgr runs as a Windows
+// service.
+type ShutdownListener struct {
+	Handler Handler
+}
+
+func NewShutdownListener(handler Handler) Listener {
+	return &ShutdownListener{
+		Handler: handler,
+	}
+}
+
+func (listener *ShutdownListener) Type() string {
+	return "shutdown"
+}
+
+func (listener *ShutdownListener) Listen(ctx context.Context) error {
+	if isService, err := svc.IsWindowsService(); err != nil || !isService {
+		log.Printf("not running as a windows service, shutdowns without a notice won't be detected")
+		return nil
+	}
+
+	for {
+		var handled chan struct{}
+		select {
+		case handled = <-preshutdowns:
+		case <-ctx.Done():
+			return nil
+		}
+
+		// lcmgr is stopped as part of the same shutdown, so the last-gasp
+		// actions must not be cancelled along with ctx.
+		log.Printf("operating system is shutting down without a notice, running last-gasp actions")
+		if err := listener.Handler.Handle(context.WithoutCancel(ctx), &ShutdownNotice{}); err != nil {
+			log.Printf("failed to handle shutdown notice: %v", err)
+		}
+		close(handled)
+	}
+}
+
+// InhibitShutdown takes a logind inhibitor lock, which only exists on Linux.
+// On Windows the preshutdown request already holds off the shutdown.
+func InhibitShutdown(why string) (release func(), err error) {
+	return nil, errors.New("shutdown inhibitor locks are only supported on linux")
+}
@@ -0,0 +1,41 @@
+package lcmgr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+const defaultReloadSignal = "HUP"
+
+// SignalHandler sends a signal to a service, e.g. SIGHUP to consul-template
+// so it re-renders proxy configs as soon as the instance is deregistered
+// rather than on its next poll.
+type SignalHandler struct {
+	Service string
+	Signal  string
+	Manager ServiceManager
+}
+
+func NewSignalHandler(service, signal string, manager ServiceManager) *SignalHandler {
+	signal = strings.TrimPrefix(strings.ToUpper(signal), "SIG")
+	if signal == "" {
+		signal = defaultReloadSignal
+	}
+	return &SignalHandler{
+		Service: service,
+		Signal:  signal,
+		Manager: manager,
+	}
+}
+
+func (handler *SignalHandler) Handle(ctx context.Context, notice Notice) error {
+	signaler, ok := handler.Manager.(ServiceSignaler)
+	if !ok {
+		return fmt.Errorf("service backend can't signal %s", handler.Service)
+	}
+
+	log.Printf("sending SIG%s to %s", handler.Signal, handler.Service)
+	return signaler.SignalService(ctx, handler.Service, handler.Signal)
+}
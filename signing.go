@@ -0,0 +1,80 @@
+package lcmgr
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	TimestampHeader = "X-Lcmgr-Timestamp"
+	NonceHeader     = "X-Lcmgr-Nonce"
+	SignatureHeader = "X-Lcmgr-Signature"
+)
+
+// signatureVersion prefixes signatures so the scheme can change without
+// breaking receivers.
+const signatureVersion = "v1="
+
+// PayloadSigner signs webhook payloads with an HMAC-SHA256 of the timestamp,
+// nonce, and body joined by dots, keyed by a secret shared with receivers.
+// Receivers should reject stale timestamps and nonces they've already seen.
+type PayloadSigner struct {
+	Secret []byte
+	Clock  Clock
+}
+
+func NewPayloadSigner(secret string) *PayloadSigner {
+	return &PayloadSigner{
+		Secret: []byte(secret),
+		Clock:  NewClock(),
+	}
+}
+
+// Sign sets the timestamp, nonce, and signature headers on a request with
+// body.
+func (signer *PayloadSigner) Sign(request *http.Request, body []byte) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(signer.Clock.Now().Unix(), 10)
+	encodedNonce := hex.EncodeToString(nonce)
+	request.Header.Set(TimestampHeader, timestamp)
+	request.Header.Set(NonceHeader, encodedNonce)
+	request.Header.Set(SignatureHeader, signatureVersion+signPayload(signer.Secret, timestamp, encodedNonce, body))
+	return nil
+}
+
+// VerifyPayload checks the signature headers of a payload signed by a
+// PayloadSigner with secret and that its timestamp is within tolerance of
+// now. Tracking nonces to reject replays is left to the receiver.
+func VerifyPayload(secret string, header http.Header, body []byte, now time.Time, tolerance time.Duration) error {
+	timestamp := header.Get(TimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid payload timestamp")
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+		return errors.New("payload timestamp is outside the allowed window")
+	}
+
+	expected := signatureVersion + signPayload([]byte(secret), timestamp, header.Get(NonceHeader), body)
+	if !hmac.Equal([]byte(header.Get(SignatureHeader)), []byte(expected)) {
+		return errors.New("payload signature doesn't match")
+	}
+	return nil
+}
+
+func signPayload(secret []byte, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "." + nonce + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,173 @@
+package lcmgr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Sink receives notices that should be reported to people or other systems
+// rather than acted upon.
+type Sink interface {
+	Send(context.Context, Notice) error
+}
+
+type LogSink struct{}
+
+// WebhookSink posts notices as JSON. When Identity is set it's included so
+// receivers can check which instance sent the notice, and when Signer is set
+// payloads are signed so receivers can check they came from lcmgr. Breaker,
+// if set, stops a down receiver from holding up every notice.
+type WebhookSink struct {
+	URL      string
+	Client   *http.Client
+	Identity *InstanceIdentity
+	Signer   *PayloadSigner
+	Breaker  *CircuitBreaker
+}
+
+type webhookPayload struct {
+	Type        string            `json:"type"`
+	Message     string            `json:"message"`
+	Notice      Notice            `json:"notice"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Identity    *InstanceIdentity `json:"identity,omitempty"`
+}
+
+func NewLogSink() Sink {
+	return &LogSink{}
+}
+
+func NewWebhookSink(url string) Sink {
+	return &WebhookSink{
+		URL:     url,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+		Breaker: NewCircuitBreaker(webhookBreakerName(url), 0, 0),
+	}
+}
+
+func NewSinks(config *Config) []Sink {
+	sinks := []Sink{NewLogSink()}
+	for _, url := range config.Webhooks {
+		sink := NewWebhookSink(url)
+		if config.WebhookSecret != "" {
+			sink.(*WebhookSink).Signer = NewPayloadSigner(config.WebhookSecret)
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks
+}
+
+// AttachIdentity includes identity in everything webhook sinks send.
+func AttachIdentity(sinks []Sink, identity *InstanceIdentity) {
+	for _, sink := range sinks {
+		if webhook, ok := sink.(*WebhookSink); ok {
+			webhook.Identity = identity
+		}
+	}
+}
+
+func (sink *LogSink) Send(ctx context.Context, notice Notice) error {
+	if annotations := NoticeAnnotations(ctx); annotations != nil {
+		log.Printf("%s (%s)", DescribeNotice(notice), formatAnnotations(annotations))
+		return nil
+	}
+	log.Printf("%s", DescribeNotice(notice))
+	return nil
+}
+
+func (sink *WebhookSink) Send(ctx context.Context, notice Notice) error {
+	return sink.Breaker.Call(ctx, func() error {
+		return postSignedJSON(ctx, sink.Client, sink.URL, sink.Signer, &webhookPayload{
+			Type:        notice.Type(),
+			Message:     DescribeNotice(notice),
+			Notice:      notice,
+			Annotations: NoticeAnnotations(ctx),
+			Identity:    sink.Identity,
+		})
+	})
+}
+
+// webhookBreakerName names a webhook's breaker by its host, keeping any
+// credentials in the URL out of metrics.
+func webhookBreakerName(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return "webhook"
+	}
+	return "webhook:" + parsed.Host
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, value interface{}) error {
+	return postSignedJSON(ctx, client, url, nil, value)
+}
+
+// postSignedJSON posts value as JSON, signed by signer unless it's nil.
+func postSignedJSON(ctx context.Context, client *http.Client, url string, signer *PayloadSigner, value interface{}) error {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	setTraceHeaders(ctx, request.Header)
+	if signer != nil {
+		if err := signer.Sign(request, payload); err != nil {
+			return err
+		}
+	}
+
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s returned %s", url, response.Status)
+	}
+	return nil
+}
+
+func SendToSinks(ctx context.Context, sinks []Sink, notice Notice) {
+	for _, sink := range sinks {
+		if err := sink.Send(ctx, notice); err != nil {
+			log.Printf("failed to send %s notice to sink: %v", notice.Type(), err)
+		}
+	}
+}
+
+// DescribeNotice returns a human readable summary of a notice.
+func DescribeNotice(notice Notice) string {
+	switch n := notice.(type) {
+	case *ScheduledActionNotice:
+		return fmt.Sprintf("%s scheduled at %s affecting this auto scaling group (scheduled action %s)", n.Direction(), n.StartTime.Format(time.RFC3339), n.Name)
+	case *SpotNotice:
+		return fmt.Sprintf("spot instance will be interrupted (%s) at %s", n.Action, n.TerminationTime.Format(time.RFC3339))
+	case *RebalanceNotice:
+		return fmt.Sprintf("ec2 recommended rebalancing at %s, spot interruption risk is elevated", n.NoticeTime.Format(time.RFC3339))
+	case *ScheduledEventNotice:
+		return fmt.Sprintf("%s scheduled at %s (event %s): %s", n.Code, n.NotBefore.Format(time.RFC3339), n.ID, n.Description)
+	case *DegradedNotice:
+		return fmt.Sprintf("ec2 status checks are failing (system %s, instance %s): %s", n.System, n.Instance, strings.Join(n.Failing, ", "))
+	case *ServiceFailureNotice:
+		if n.Error != "" {
+			return fmt.Sprintf("%s failed and couldn't be restarted (restart %d): %s", n.Service, n.Restarts, n.Error)
+		}
+		return fmt.Sprintf("%s failed and was restarted (restart %d)", n.Service, n.Restarts)
+	case *ShutdownNotice:
+		return "operating system is shutting down without a notice"
+	default:
+		return fmt.Sprintf("received %s notice", notice.Type())
+	}
+}
@@ -0,0 +1,104 @@
+package lcmgr
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	noticeHandlingGauge       = DefaultRegistry.Gauge("lcmgr_notice_handling_seconds", "Time taken to handle the most recent notice", "notice")
+	sloBreachCounter          = DefaultRegistry.Counter("lcmgr_notice_slo_breaches_total", "Notices whose handling took longer than the SLO", "notice")
+	sloProjectedBreachCounter = DefaultRegistry.Counter("lcmgr_notice_slo_projected_breaches_total", "Notices whose handling was projected to take longer than the SLO partway through", "notice")
+)
+
+type sloTimerKey struct{}
+
+// SLOTracker measures the time from when a notice is received to when
+// handling it completes against an SLO. Handlers report progress through
+// their phases with ReportProgress, which lets the tracker warn as soon as a
+// drain is on pace to blow past the SLO instead of only once it has.
+type SLOTracker struct {
+	SLO   time.Duration
+	Clock Clock
+}
+
+type SLOTimer struct {
+	tracker *SLOTracker
+	notice  Notice
+	start   time.Time
+	done    chan struct{}
+
+	mu        sync.Mutex
+	projected bool
+}
+
+func NewSLOTracker(slo time.Duration) *SLOTracker {
+	return &SLOTracker{
+		SLO:   slo,
+		Clock: NewClock(),
+	}
+}
+
+// Start begins timing a notice and returns a context carrying the timer for
+// ReportProgress. Finish must be called once the notice is handled.
+func (tracker *SLOTracker) Start(ctx context.Context, notice Notice) (context.Context, *SLOTimer) {
+	timer := &SLOTimer{
+		tracker: tracker,
+		notice:  notice,
+		start:   tracker.Clock.Now(),
+		done:    make(chan struct{}),
+	}
+	go timer.watch()
+	return context.WithValue(ctx, sloTimerKey{}, timer), timer
+}
+
+func (timer *SLOTimer) watch() {
+	select {
+	case <-timer.tracker.Clock.After(timer.tracker.SLO):
+		log.Printf("warning: %s notice has been handled for longer than the %v SLO", timer.notice.Type(), timer.tracker.SLO)
+	case <-timer.done:
+	}
+}
+
+func (timer *SLOTimer) Finish() {
+	close(timer.done)
+
+	elapsed := timer.tracker.Clock.Now().Sub(timer.start)
+	noticeHandlingGauge.Set(elapsed.Seconds(), timer.notice.Type())
+	if elapsed > timer.tracker.SLO {
+		sloBreachCounter.Inc(timer.notice.Type())
+		log.Printf("warning: %s notice took %v to handle, exceeding the %v SLO", timer.notice.Type(), elapsed, timer.tracker.SLO)
+	}
+}
+
+func (timer *SLOTimer) progress(done, total int) {
+	if done <= 0 || done >= total {
+		return
+	}
+
+	elapsed := timer.tracker.Clock.Now().Sub(timer.start)
+	projected := time.Duration(float64(elapsed) * float64(total) / float64(done))
+	if projected <= timer.tracker.SLO {
+		return
+	}
+
+	timer.mu.Lock()
+	defer timer.mu.Unlock()
+	if timer.projected {
+		return
+	}
+	timer.projected = true
+
+	sloProjectedBreachCounter.Inc(timer.notice.Type())
+	log.Printf("warning: %s notice is projected to take %v to handle after %d of %d phases, exceeding the %v SLO", timer.notice.Type(), projected.Round(time.Second), done, total, timer.tracker.SLO)
+}
+
+// ReportProgress records that done of total phases of handling the notice in
+// ctx have completed. It does nothing when the notice isn't being timed.
+func ReportProgress(ctx context.Context, done, total int) {
+	if timer, ok := ctx.Value(sloTimerKey{}).(*SLOTimer); ok {
+		timer.progress(done, total)
+	}
+}
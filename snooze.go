@@ -0,0 +1,64 @@
+package lcmgr
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// SnoozeHandler defers the rest of a drain by Duration, e.g. to let an in
+// progress deploy finish first. Like any snooze it's bounded by the hook
+// budget.
+type SnoozeHandler struct {
+	Duration time.Duration
+	Handler  *ServiceHandler
+}
+
+func NewSnoozeHandler(duration time.Duration, handler *ServiceHandler) *SnoozeHandler {
+	return &SnoozeHandler{
+		Duration: duration,
+		Handler:  handler,
+	}
+}
+
+func (handler *SnoozeHandler) Handle(ctx context.Context, notice Notice) error {
+	handler.Handler.Activity.Snooze(handler.Handler.Clock.Now().Add(handler.Duration))
+	return handler.Handler.WaitForSnooze(ctx, notice)
+}
+
+// WaitForSnooze blocks while drains are snoozed. Snoozes are cut short so
+// that the expected drain time, if known, still fits before the notice's
+// deadline.
+func (handler *ServiceHandler) WaitForSnooze(ctx context.Context, notice Notice) error {
+	until := handler.Activity.SnoozedUntil()
+	if deadline, ok := NoticeDeadline(ctx); ok {
+		if estimate, ok := handler.estimate(notice); ok {
+			deadline = deadline.Add(-estimate)
+		}
+		deadline = deadline.Add(-deadlineMargin)
+		if until.After(deadline) {
+			log.Printf("snooze cut short to %s to leave time to drain before the deadline", deadline.Format(time.RFC3339))
+			until = deadline
+		}
+	}
+
+	wait := until.Sub(handler.Clock.Now())
+	if wait <= 0 {
+		return nil
+	}
+
+	log.Printf("%s drain snoozed until %s", notice.Type(), until.Format(time.RFC3339))
+	select {
+	case <-handler.Clock.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (handler *ServiceHandler) estimate(notice Notice) (time.Duration, bool) {
+	if handler.Estimator == nil {
+		return 0, false
+	}
+	return handler.Estimator.Estimate(notice.Type())
+}
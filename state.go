@@ -0,0 +1,27 @@
+package lcmgr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+var DefaultStateDir = defaultStateDir()
+
+func defaultStateDir() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "lcmgr")
+	}
+	return "/var/lib/lcmgr"
+}
+
+// EnsureStateDir creates the directory lcmgr keeps its state in. It runs at
+// startup so that a policy denying writes to the directory is reported
+// immediately instead of in the middle of a drain.
+func EnsureStateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return wrapPermissionError(fmt.Sprintf("failed to create state directory %s", dir), err)
+	}
+	return nil
+}
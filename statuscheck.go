@@ -0,0 +1,126 @@
+package lcmgr
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// impairedStatus is the summary status of failing status checks.
+const impairedStatus = "impaired"
+
+// StatusChecks summarizes the instance's EC2 status checks. System and
+// Instance are the summary statuses, e.g. ok or impaired, and Failing lists
+// the checks that failed, e.g. system:reachability.
+type StatusChecks struct {
+	System   string
+	Instance string
+	Failing  []string
+}
+
+// Degraded returns true when either the system or instance checks are
+// impaired.
+func (checks *StatusChecks) Degraded() bool {
+	return checks.System == impairedStatus || checks.Instance == impairedStatus
+}
+
+// GetStatusChecks describes the instance's status checks, or returns nil if
+// EC2 hasn't reported them yet.
+func (client *awsClient) GetStatusChecks(ctx context.Context) (*StatusChecks, error) {
+	instanceID, err := client.GetInstanceID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := client.EC2().DescribeInstanceStatus(ctx, &ec2.DescribeInstanceStatusInput{
+		InstanceIds:         []string{instanceID},
+		IncludeAllInstances: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.InstanceStatuses) == 0 {
+		return nil, nil
+	}
+
+	status := output.InstanceStatuses[0]
+	checks := &StatusChecks{}
+	checks.System, checks.Failing = summarizeStatus("system", status.SystemStatus, checks.Failing)
+	checks.Instance, checks.Failing = summarizeStatus("instance", status.InstanceStatus, checks.Failing)
+	return checks, nil
+}
+
+func summarizeStatus(kind string, summary *types.InstanceStatusSummary, failing []string) (string, []string) {
+	if summary == nil {
+		return "", failing
+	}
+	for _, detail := range summary.Details {
+		if detail.Status == types.StatusTypeFailed {
+			failing = append(failing, kind+":"+string(detail.Name))
+		}
+	}
+	return string(summary.Status), failing
+}
+
+// StatusCheckListener polls the instance's EC2 status checks and sends a
+// DegradedNotice when the system or instance checks become impaired, so
+// handlers can move workloads off unhealthy hardware before EC2's own
+// recovery kicks in. Another notice is only sent once the checks have
+// recovered and failed again.
+type StatusCheckListener struct {
+	Notices  chan Notice
+	Interval time.Duration
+	Client   AWSClient
+	Clock    Clock
+
+	degraded bool
+}
+
+func NewStatusCheckListener(notices chan Notice, interval time.Duration, client AWSClient) Listener {
+	return &StatusCheckListener{
+		Notices:  notices,
+		Interval: interval,
+		Client:   client,
+		Clock:    NewClock(),
+	}
+}
+
+func (listener *StatusCheckListener) Listen(ctx context.Context) error {
+	ticker := listener.Clock.NewTicker(listener.Interval)
+	defer ticker.Stop()
+
+	for {
+		checks, err := listener.Client.GetStatusChecks(ctx)
+		switch {
+		case err != nil:
+			log.Printf("failed to get status checks: %v", err)
+		case checks == nil:
+		case !checks.Degraded():
+			if listener.degraded {
+				log.Printf("status checks recovered (system %s, instance %s)", checks.System, checks.Instance)
+			}
+			listener.degraded = false
+		case !listener.degraded:
+			select {
+			case listener.Notices <- NewDegradedNotice(checks):
+				listener.degraded = true
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (listener *StatusCheckListener) Type() string {
+	return "degraded"
+}
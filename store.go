@@ -0,0 +1,90 @@
+package lcmgr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store persists small pieces of state across restarts, keyed by name.
+// Values are encoded as JSON.
+type Store interface {
+	Get(key string, value interface{}) (bool, error)
+	Put(key string, value interface{}) error
+	Delete(key string) error
+}
+
+// FileStore is a Store that keeps each key in its own JSON file in Dir,
+// replacing files atomically so a crash never leaves a partial value.
+type FileStore struct {
+	Dir string
+
+	mu sync.Mutex
+}
+
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{Dir: dir}
+}
+
+func (store *FileStore) Get(key string, value interface{}) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	data, err := ioutil.ReadFile(store.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return false, fmt.Errorf("failed to decode %s: %v", key, err)
+	}
+	return true, nil
+}
+
+func (store *FileStore) Put(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	file, err := ioutil.TempFile(store.Dir, "."+key+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), store.path(key))
+}
+
+func (store *FileStore) Delete(key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if err := os.Remove(store.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (store *FileStore) path(key string) string {
+	return filepath.Join(store.Dir, key+".json")
+}
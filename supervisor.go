@@ -0,0 +1,126 @@
+package lcmgr
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	// DefaultSuperviseInterval is how often supervised services are checked.
+	DefaultSuperviseInterval = 10 * time.Second
+	// maxRestartBackoff caps the delay between restarts of a failing service.
+	maxRestartBackoff = 5 * time.Minute
+	// restartStableWindow is how long a restarted service has to stay active
+	// before its backoff is reset, so a crash loop still backs off.
+	restartStableWindow = 5 * time.Minute
+)
+
+var serviceRestartsCounter = DefaultRegistry.Counter("lcmgr_service_restarts_total", "Number of times a supervised service was restarted after failing", "service")
+
+// Supervisor watches the handler's services between notices and restarts any
+// that fail, doubling the delay between restarts of the same service up to
+// maxRestartBackoff and alerting Sinks each time. The delay is reset once the
+// service has stayed active for restartStableWindow. It stands down while a
+// notice is handled and after a drain, so services stopped on purpose stay
+// stopped until the next launch.
+type Supervisor struct {
+	Handler  *ServiceHandler
+	Sinks    []Sink
+	Interval time.Duration
+	Clock    Clock
+
+	restarts    map[string]int
+	nextRestart map[string]time.Time
+	activeSince map[string]time.Time
+}
+
+func NewSupervisor(handler *ServiceHandler, sinks []Sink, interval time.Duration) Listener {
+	return &Supervisor{
+		Handler:     handler,
+		Sinks:       sinks,
+		Interval:    interval,
+		Clock:       NewClock(),
+		restarts:    make(map[string]int),
+		nextRestart: make(map[string]time.Time),
+		activeSince: make(map[string]time.Time),
+	}
+}
+
+func (supervisor *Supervisor) Listen(ctx context.Context) error {
+	ticker := supervisor.Clock.NewTicker(supervisor.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return nil
+		}
+
+		if _, notice := supervisor.Handler.Activity.Current(); notice != nil || supervisor.Handler.Activity.Drained() {
+			continue
+		}
+		for _, service := range supervisor.Handler.Services {
+			supervisor.check(ctx, service)
+		}
+	}
+}
+
+func (supervisor *Supervisor) check(ctx context.Context, service string) {
+	state, err := supervisor.Handler.Manager.ServiceState(ctx, service)
+	if err != nil {
+		log.Printf("failed to get state of %s: %v", service, err)
+		return
+	}
+	now := supervisor.Clock.Now()
+	if state.ActiveState != "failed" {
+		if state.ActiveState != "active" {
+			delete(supervisor.activeSince, service)
+			return
+		}
+		since, ok := supervisor.activeSince[service]
+		if !ok {
+			supervisor.activeSince[service] = now
+		} else if now.Sub(since) >= restartStableWindow {
+			delete(supervisor.restarts, service)
+			delete(supervisor.nextRestart, service)
+		}
+		return
+	}
+
+	delete(supervisor.activeSince, service)
+	if now.Before(supervisor.nextRestart[service]) {
+		return
+	}
+
+	restarts := supervisor.restarts[service] + 1
+	supervisor.restarts[service] = restarts
+	supervisor.nextRestart[service] = now.Add(restartBackoff(supervisor.Interval, restarts))
+	serviceRestartsCounter.Inc(service)
+
+	log.Printf("%s failed, restarting it (restart %d)", service, restarts)
+	notice := &ServiceFailureNotice{Service: service, Restarts: restarts}
+	if err := supervisor.Handler.Manager.StartService(ctx, service); err != nil {
+		log.Printf("failed to restart %s: %v", service, err)
+		notice.Error = err.Error()
+	}
+	SendToSinks(ctx, supervisor.Sinks, notice)
+}
+
+// restartBackoff doubles interval for every restart after the first, up to
+// maxRestartBackoff.
+func restartBackoff(interval time.Duration, restarts int) time.Duration {
+	backoff := interval
+	for i := 1; i < restarts && backoff < maxRestartBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRestartBackoff {
+		return maxRestartBackoff
+	}
+	return backoff
+}
+
+func (supervisor *Supervisor) Type() string {
+	return "supervise"
+}
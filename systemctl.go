@@ -0,0 +1,143 @@
+//go:build !windows
+// +build !windows
+
+package lcmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// NsenterCommand prefixes systemctl so that it runs in the namespaces of the
+// host's PID 1. It requires the container to share the host PID namespace and
+// have CAP_SYS_ADMIN.
+var NsenterCommand = []string{"nsenter", "--target", "1", "--mount", "--uts", "--ipc", "--net", "--pid", "--"}
+
+// SystemctlManager controls units by running systemctl instead of talking to
+// systemd over D-Bus, for hosts where the D-Bus socket is unavailable or
+// blocked by policy.
+type SystemctlManager struct {
+	Command []string
+}
+
+func NewSystemctlManager() *SystemctlManager {
+	return &SystemctlManager{
+		Command: []string{"systemctl"},
+	}
+}
+
+func (manager *SystemctlManager) StartService(ctx context.Context, service string) error {
+	if err := manager.checkExists(ctx, service); err != nil {
+		return err
+	}
+	if _, err := runCommand(ctx, manager.Command, "start", service); err != nil {
+		return wrapPermissionError(fmt.Sprintf("failed to start systemd unit %s", service), err)
+	}
+
+	state, err := manager.ActiveState(ctx, service)
+	if err != nil {
+		return err
+	}
+	if state != "active" {
+		return fmt.Errorf("failed to start systemd unit %s, unit is %s", service, state)
+	}
+
+	return nil
+}
+
+func (manager *SystemctlManager) StopService(ctx context.Context, service string) error {
+	if err := manager.checkExists(ctx, service); err != nil {
+		return err
+	}
+	if _, err := runCommand(ctx, manager.Command, "stop", service); err != nil {
+		return wrapPermissionError(fmt.Sprintf("failed to stop systemd unit %s", service), err)
+	}
+
+	state, err := manager.ActiveState(ctx, service)
+	if err != nil {
+		return err
+	}
+	if state == "active" || state == "deactivating" {
+		return fmt.Errorf("failed to stop systemd unit %s, unit is %s", service, state)
+	}
+
+	return nil
+}
+
+func (manager *SystemctlManager) SignalService(ctx context.Context, service, signal string) error {
+	if err := manager.checkExists(ctx, service); err != nil {
+		return err
+	}
+	if _, err := runCommand(ctx, manager.Command, "kill", "--signal="+strings.ToUpper(signal), service); err != nil {
+		return wrapPermissionError(fmt.Sprintf("failed to signal systemd unit %s", service), err)
+	}
+	return nil
+}
+
+// ActiveState returns the unit's state as reported by systemctl is-active.
+// is-active exits non-zero for any state other than active, so the exit code
+// is ignored as long as a state was printed.
+func (manager *SystemctlManager) ActiveState(ctx context.Context, service string) (string, error) {
+	output, err := runCommand(ctx, manager.Command, "is-active", service)
+	state := strings.TrimSpace(output)
+	if state == "" {
+		if err == nil {
+			err = errors.New("no state returned")
+		}
+		return "", wrapPermissionError(fmt.Sprintf("failed to check state of systemd unit %s", service), err)
+	}
+	return state, nil
+}
+
+func (manager *SystemctlManager) ServiceState(ctx context.Context, service string) (*ServiceState, error) {
+	output, err := runCommand(ctx, manager.Command, "show", "--property=LoadState,ActiveState", service)
+	if err != nil {
+		return nil, wrapPermissionError(fmt.Sprintf("failed to show systemd unit %s", service), err)
+	}
+
+	state := &ServiceState{}
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "LoadState":
+			state.LoadState = parts[1]
+		case "ActiveState":
+			state.ActiveState = parts[1]
+		}
+	}
+
+	return state, nil
+}
+
+func (manager *SystemctlManager) ServiceDependencies(ctx context.Context, service string) ([]string, error) {
+	output, err := runCommand(ctx, manager.Command, "show", "--property="+strings.Join(unitDependencyProperties, ","), service)
+	if err != nil {
+		return nil, wrapPermissionError(fmt.Sprintf("failed to show systemd unit %s", service), err)
+	}
+
+	var dependencies []string
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		dependencies = append(dependencies, strings.Fields(parts[1])...)
+	}
+	return dependencies, nil
+}
+
+func (manager *SystemctlManager) checkExists(ctx context.Context, service string) error {
+	state, err := manager.ServiceState(ctx, service)
+	if err != nil {
+		return err
+	}
+	if state.LoadState == "not-found" {
+		return &ServiceNotFoundError{Service: service}
+	}
+	return nil
+}
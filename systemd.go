@@ -0,0 +1,223 @@
+//go:build !windows
+// +build !windows
+
+package lcmgr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-systemd/dbus"
+	godbus "github.com/godbus/dbus"
+	"golang.org/x/sys/unix"
+)
+
+// unitDependencyProperties are the unit properties that make a unit depend on
+// others for ordering purposes. systemd mirrors Before= into After= on the
+// other unit, so After= alone covers both directions.
+var unitDependencyProperties = []string{"After", "Requires", "BindsTo"}
+
+// SystemdManager controls units over D-Bus. Address overrides the bus to
+// connect to, e.g. a host socket mounted into a container; by default the
+// system bus is used with a fallback to systemd's private socket.
+type SystemdManager struct {
+	Address string
+}
+
+func NewSystemdManager(address string) *SystemdManager {
+	return &SystemdManager{
+		Address: address,
+	}
+}
+
+func NewServiceManager(config *Config) (ServiceManager, error) {
+	systemctl := NewSystemctlManager()
+	if config.HostPID {
+		systemctl.Command = append(append([]string{}, NsenterCommand...), systemctl.Command...)
+	}
+
+	switch config.ServiceBackend {
+	case "", AutoBackend:
+		manager := NewSystemdManager(config.DBusAddress)
+		if err := manager.Probe(); err != nil {
+			log.Printf("d-bus is unavailable, falling back to systemctl: %v", err)
+			return systemctl, nil
+		}
+		return manager, nil
+	case DBusBackend:
+		return NewSystemdManager(config.DBusAddress), nil
+	case SystemctlBackend:
+		return systemctl, nil
+	case KubernetesBackend:
+		return NewKubernetesManager(), nil
+	default:
+		return nil, fmt.Errorf("unknown service backend %s", config.ServiceBackend)
+	}
+}
+
+// Probe checks that systemd is reachable over D-Bus, which is commonly not
+// the case inside containers and on minimal images without a bus socket.
+func (manager *SystemdManager) Probe() error {
+	conn, err := manager.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.SystemState()
+	return err
+}
+
+func (manager *SystemdManager) connect() (*dbus.Conn, error) {
+	if manager.Address == "" {
+		return dbus.New()
+	}
+
+	// Connections to systemd's private socket skip the Hello call, since
+	// there is no bus daemon on the other end to answer it.
+	hello := !strings.HasSuffix(manager.Address, "/systemd/private")
+	return dbus.NewConnection(func() (*godbus.Conn, error) {
+		conn, err := godbus.Dial(manager.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		methods := []godbus.Auth{godbus.AuthExternal(strconv.Itoa(os.Getuid()))}
+		if err := conn.Auth(methods); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		if hello {
+			if err := conn.Hello(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+
+		return conn, nil
+	})
+}
+
+func (manager *SystemdManager) StartService(ctx context.Context, service string) error {
+	return manager.runJob(ctx, service, "start")
+}
+
+func (manager *SystemdManager) StopService(ctx context.Context, service string) error {
+	return manager.runJob(ctx, service, "stop")
+}
+
+func (manager *SystemdManager) runJob(ctx context.Context, service, verb string) error {
+	conn, err := manager.connect()
+	if err != nil {
+		return wrapPermissionError("failed to connect to systemd over d-bus", err)
+	}
+	defer conn.Close()
+
+	state, err := unitState(conn, service)
+	if err != nil {
+		return err
+	}
+	if state.LoadState == "not-found" {
+		return &ServiceNotFoundError{Service: service}
+	}
+
+	results := make(chan string, 1)
+	var n int
+	switch verb {
+	case "start":
+		n, err = conn.StartUnit(service, "fail", results)
+	case "stop":
+		n, err = conn.StopUnit(service, "fail", results)
+	}
+	if err != nil {
+		return wrapPermissionError(fmt.Sprintf("failed to %s systemd unit %s", verb, service), err)
+	} else if n == 0 {
+		return fmt.Errorf("failed to %s systemd unit %s due to unknown error", verb, service)
+	}
+
+	select {
+	case result := <-results:
+		if result != "done" {
+			return fmt.Errorf("failed to %s systemd unit %s, job returned %v result", verb, service, result)
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+func (manager *SystemdManager) SignalService(ctx context.Context, service, signal string) error {
+	number := unix.SignalNum("SIG" + strings.ToUpper(signal))
+	if number == 0 {
+		return fmt.Errorf("unknown signal %s", signal)
+	}
+
+	conn, err := manager.connect()
+	if err != nil {
+		return wrapPermissionError("failed to connect to systemd over d-bus", err)
+	}
+	defer conn.Close()
+
+	state, err := unitState(conn, service)
+	if err != nil {
+		return err
+	}
+	if state.LoadState == "not-found" {
+		return &ServiceNotFoundError{Service: service}
+	}
+
+	conn.KillUnit(service, int32(number))
+	return nil
+}
+
+func (manager *SystemdManager) ServiceState(ctx context.Context, service string) (*ServiceState, error) {
+	conn, err := manager.connect()
+	if err != nil {
+		return nil, wrapPermissionError("failed to connect to systemd over d-bus", err)
+	}
+	defer conn.Close()
+
+	return unitState(conn, service)
+}
+
+func (manager *SystemdManager) ServiceDependencies(ctx context.Context, service string) ([]string, error) {
+	conn, err := manager.connect()
+	if err != nil {
+		return nil, wrapPermissionError("failed to connect to systemd over d-bus", err)
+	}
+	defer conn.Close()
+
+	properties, err := conn.GetUnitProperties(service)
+	if err != nil {
+		return nil, wrapPermissionError(fmt.Sprintf("failed to get properties of systemd unit %s", service), err)
+	}
+
+	var dependencies []string
+	for _, name := range unitDependencyProperties {
+		if units, ok := properties[name].([]string); ok {
+			dependencies = append(dependencies, units...)
+		}
+	}
+	return dependencies, nil
+}
+
+func unitState(conn *dbus.Conn, service string) (*ServiceState, error) {
+	units, err := conn.ListUnitsByNames([]string{service})
+	if err != nil {
+		return nil, wrapPermissionError(fmt.Sprintf("failed to list status of systemd unit %s", service), err)
+	}
+	if len(units) != 1 {
+		return &ServiceState{LoadState: "not-found", ActiveState: "inactive"}, nil
+	}
+
+	return &ServiceState{
+		LoadState:   units[0].LoadState,
+		ActiveState: units[0].ActiveState,
+	}, nil
+}
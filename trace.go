@@ -0,0 +1,144 @@
+package lcmgr
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// traceHeader is the HTTP header X-Ray reads trace context from.
+const traceHeader = "X-Amzn-Trace-Id"
+
+var invalidMetadataKeyChars = regexp.MustCompile(`[^a-z0-9_]`)
+
+// TraceContext is the X-Ray trace a notice's message was sent in, taken from
+// the message's AWSTraceHeader attribute, e.g.
+// Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1.
+type TraceContext struct {
+	TraceID  string
+	ParentID string
+	Sampled  bool
+}
+
+// ParseTraceHeader parses an X-Ray trace header, returning nil when it has
+// no root trace ID.
+func ParseTraceHeader(header string) *TraceContext {
+	trace := &TraceContext{}
+	for _, field := range strings.Split(header, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch key {
+		case "Root":
+			trace.TraceID = value
+		case "Parent":
+			trace.ParentID = value
+		case "Sampled":
+			trace.Sampled = value == "1"
+		}
+	}
+	if trace.TraceID == "" {
+		return nil
+	}
+	return trace
+}
+
+// Header formats the trace as an X-Ray trace header.
+func (trace *TraceContext) Header() string {
+	header := "Root=" + trace.TraceID
+	if trace.ParentID != "" {
+		header += ";Parent=" + trace.ParentID
+	}
+	if trace.Sampled {
+		header += ";Sampled=1"
+	} else {
+		header += ";Sampled=0"
+	}
+	return header
+}
+
+// Traceparent formats the trace as a W3C traceparent header so OpenTelemetry
+// instrumented receivers join the same trace, or returns an empty string
+// when it has no parent to hang spans off.
+func (trace *TraceContext) Traceparent() string {
+	traceID := strings.ReplaceAll(strings.TrimPrefix(trace.TraceID, "1-"), "-", "")
+	if len(traceID) != 32 || len(trace.ParentID) != 16 {
+		return ""
+	}
+	flags := "00"
+	if trace.Sampled {
+		flags = "01"
+	}
+	return "00-" + traceID + "-" + trace.ParentID + "-" + flags
+}
+
+// messageTrace returns the trace header and string attributes of a received
+// message.
+func messageTrace(message sqstypes.Message) (string, map[string]string) {
+	header := message.Attributes[string(sqstypes.MessageSystemAttributeNameAWSTraceHeader)]
+
+	var attributes map[string]string
+	for name, value := range message.MessageAttributes {
+		if value.StringValue == nil {
+			continue
+		}
+		if attributes == nil {
+			attributes = make(map[string]string)
+		}
+		attributes[name] = aws.ToString(value.StringValue)
+	}
+	return header, attributes
+}
+
+// traceMetadata adds a lifecycle notice's trace header and message
+// attributes to its metadata, the attributes as attribute_<name>.
+func traceMetadata(metadata map[string]string, notice *LifecycleNotice) {
+	if notice.TraceHeader != "" {
+		metadata["trace_header"] = notice.TraceHeader
+	}
+	for name, value := range notice.Attributes {
+		key := invalidMetadataKeyChars.ReplaceAllString(strings.ToLower(name), "_")
+		metadata["attribute_"+key] = value
+	}
+}
+
+type traceKey struct{}
+
+// WithNoticeTrace returns a context carrying the trace notice's message was
+// sent in, if any, so requests made while handling it join that trace. A
+// trace already carried by ctx is kept.
+func WithNoticeTrace(ctx context.Context, notice Notice) context.Context {
+	if TraceFromContext(ctx) != nil {
+		return ctx
+	}
+	lifecycle := lifecycleNotice(notice)
+	if lifecycle == nil || lifecycle.TraceHeader == "" {
+		return ctx
+	}
+	trace := ParseTraceHeader(lifecycle.TraceHeader)
+	if trace == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+// TraceFromContext returns the trace carried by ctx, or nil.
+func TraceFromContext(ctx context.Context) *TraceContext {
+	trace, _ := ctx.Value(traceKey{}).(*TraceContext)
+	return trace
+}
+
+// setTraceHeaders propagates the trace carried by ctx, if any, to an outgoing
+// request in both X-Ray and W3C formats.
+func setTraceHeaders(ctx context.Context, header http.Header) {
+	trace := TraceFromContext(ctx)
+	if trace == nil {
+		return
+	}
+	header.Set(traceHeader, trace.Header())
+	if traceparent := trace.Traceparent(); traceparent != "" {
+		header.Set("traceparent", traceparent)
+	}
+}
@@ -0,0 +1,203 @@
+package lcmgr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// ImageVerifier checks an image while it's being baked, so a config that
+// would fail at the first scale-in fails the bake instead. It checks that the
+// steps build, that the services and units they name exist, that the
+// commands they run are installed and, given PolicyPath, that the IAM policy
+// shipped with the image allows every action the config needs.
+type ImageVerifier struct {
+	Config     *Config
+	Manager    ServiceManager
+	PolicyPath string
+}
+
+func NewImageVerifier(config *Config, manager ServiceManager, policyPath string) *ImageVerifier {
+	return &ImageVerifier{
+		Config:     config,
+		Manager:    manager,
+		PolicyPath: policyPath,
+	}
+}
+
+// Verify returns every problem found with the image.
+func (verifier *ImageVerifier) Verify(ctx context.Context) []error {
+	var problems []error
+	problems = append(problems, verifier.checkSteps()...)
+	problems = append(problems, verifier.checkServices(ctx)...)
+	problems = append(problems, verifier.checkCommands()...)
+	if verifier.PolicyPath != "" {
+		problems = append(problems, verifier.checkPolicy()...)
+	}
+	return problems
+}
+
+// checkSteps builds each chain the way listen does, without looking up the
+// instance's identity.
+func (verifier *ImageVerifier) checkSteps() []error {
+	config := verifier.Config
+	handler := NewServiceHandler(config.ServiceNames(), 0, nil, verifier.Manager)
+	identity := &Identity{}
+
+	var problems []error
+	chains := []struct {
+		name  string
+		steps []StepConfig
+	}{
+		{"steps", config.Steps},
+		{"launch", config.Launch},
+		{"rollback", config.Rollback},
+		{"last_gasp", config.LastGasp},
+	}
+	for _, chain := range chains {
+		if _, err := NewChain(chain.steps, identity, handler); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %v", chain.name, err))
+		}
+	}
+	if len(config.Policies) > 0 {
+		if _, err := NewPolicyHandler(config.Policies, identity, handler); err != nil {
+			problems = append(problems, fmt.Errorf("policies: %v", err))
+		}
+	}
+	return problems
+}
+
+// checkServices checks that the managed services, the drain target and the
+// units signaled by steps exist. Kubernetes nodes aren't checked, since they
+// don't exist until the instance joins the cluster, and neither are missing
+// services lcmgr is configured to skip.
+func (verifier *ImageVerifier) checkServices(ctx context.Context) []error {
+	config := verifier.Config
+	if config.ServiceBackend == KubernetesBackend {
+		return nil
+	}
+	if len(config.ServiceNames()) == 0 && config.Mode != NotifyOnlyMode {
+		return []error{fmt.Errorf("no services configured")}
+	}
+	if config.MissingService == MissingServiceSkip {
+		return nil
+	}
+
+	services := append([]string{}, config.ServiceNames()...)
+	if config.DrainTarget != "" {
+		services = append(services, config.DrainTarget)
+	}
+	for _, step := range config.everyStep() {
+		if step.Signal != nil && step.Signal.Service != "" {
+			services = append(services, step.Signal.Service)
+		}
+	}
+
+	var problems []error
+	checked := map[string]bool{}
+	for _, service := range services {
+		if checked[service] {
+			continue
+		}
+		checked[service] = true
+
+		state, err := verifier.Manager.ServiceState(ctx, service)
+		if err != nil {
+			problems = append(problems, err)
+		} else if state.LoadState == "not-found" {
+			problems = append(problems, &ServiceNotFoundError{Service: service})
+		}
+	}
+	return problems
+}
+
+// checkCommands checks that the command of every exec step is installed.
+func (verifier *ImageVerifier) checkCommands() []error {
+	var problems []error
+	for _, step := range verifier.Config.everyStep() {
+		if len(step.Exec) == 0 {
+			continue
+		}
+		if _, err := exec.LookPath(step.Exec[0]); err != nil {
+			problems = append(problems, fmt.Errorf("step %q: %v", step.Name, err))
+		}
+	}
+	return problems
+}
+
+// checkPolicy checks that the policy document at PolicyPath allows every
+// action RequiredActions returns.
+func (verifier *ImageVerifier) checkPolicy() []error {
+	data, err := ioutil.ReadFile(verifier.PolicyPath)
+	if err != nil {
+		return []error{err}
+	}
+	allowed, err := allowedActions(data)
+	if err != nil {
+		return []error{fmt.Errorf("failed to parse policy %s: %v", verifier.PolicyPath, err)}
+	}
+
+	var problems []error
+	for _, action := range RequiredActions(verifier.Config) {
+		if !matchesAction(allowed, action) {
+			problems = append(problems, fmt.Errorf("policy %s doesn't allow %s", verifier.PolicyPath, action))
+		}
+	}
+	return problems
+}
+
+// allowedActions returns the action patterns an IAM policy document allows.
+// Statement and Action may each be a single value or a list.
+func allowedActions(data []byte) ([]string, error) {
+	var document struct {
+		Statement json.RawMessage
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	var statements []struct {
+		Effect string
+		Action json.RawMessage
+	}
+	if err := unmarshalOneOrMany(document.Statement, &statements); err != nil {
+		return nil, fmt.Errorf("Statement: %v", err)
+	}
+
+	var allowed []string
+	for _, statement := range statements {
+		if statement.Effect != "Allow" || statement.Action == nil {
+			continue
+		}
+		var actions []string
+		if err := unmarshalOneOrMany(statement.Action, &actions); err != nil {
+			return nil, fmt.Errorf("Action: %v", err)
+		}
+		allowed = append(allowed, actions...)
+	}
+	return allowed, nil
+}
+
+// unmarshalOneOrMany unmarshals data, a JSON value or list of values, into
+// the slice v points to.
+func unmarshalOneOrMany(data json.RawMessage, v interface{}) error {
+	if len(data) > 0 && data[0] != '[' {
+		data = append(append([]byte{'['}, data...), ']')
+	}
+	return json.Unmarshal(data, v)
+}
+
+// matchesAction returns true if action matches one of patterns, which may use
+// IAM's * and ? wildcards. Actions are case insensitive.
+func matchesAction(patterns []string, action string) bool {
+	action = strings.ToLower(action)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), action); ok {
+			return true
+		}
+	}
+	return false
+}
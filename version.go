@@ -0,0 +1,139 @@
+package lcmgr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Build information, set at link time with e.g.
+//
+//	-ldflags "-X github.com/vanstee/lcmgr.Version=v1.2.3"
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	Date    = "unknown"
+)
+
+func VersionString() string {
+	return fmt.Sprintf("lcmgr %s (commit %s, built %s, %s %s/%s)", Version, Commit, Date, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	Comment          string            `json:"comment,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	Element        string `json:"spdxElementId"`
+	Type           string `json:"relationshipType"`
+	RelatedElement string `json:"relatedSpdxElement"`
+}
+
+// WriteSBOM writes an SPDX 2.3 JSON software bill of materials for the
+// running binary, built from the module information the Go linker embeds.
+// Build settings such as the VCS revision and compiler flags are recorded in
+// the main package's comment as provenance.
+func WriteSBOM(w io.Writer) error {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return errors.New("binary was built without module support")
+	}
+
+	created := Date
+	if _, err := time.Parse(time.RFC3339, created); err != nil {
+		created = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	main := spdxPackage{
+		Name:             info.Main.Path,
+		SPDXID:           "SPDXRef-Package-main",
+		VersionInfo:      Version,
+		DownloadLocation: "NOASSERTION",
+		ExternalRefs:     purl(info.Main.Path, Version),
+	}
+	settings := []string{"go=" + info.GoVersion}
+	for _, setting := range info.Settings {
+		settings = append(settings, setting.Key+"="+setting.Value)
+	}
+	main.Comment = strings.Join(settings, "\n")
+
+	document := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              "lcmgr-" + Version,
+		DocumentNamespace: fmt.Sprintf("https://github.com/vanstee/lcmgr/spdx/lcmgr-%s-%s", Version, Commit),
+		CreationInfo: spdxCreationInfo{
+			Created:  created,
+			Creators: []string{"Tool: lcmgr-" + Version},
+		},
+		Packages: []spdxPackage{main},
+		Relationships: []spdxRelationship{
+			{Element: "SPDXRef-DOCUMENT", Type: "DESCRIBES", RelatedElement: main.SPDXID},
+		},
+	}
+
+	for i, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		pkg := spdxPackage{
+			Name:             dep.Path,
+			SPDXID:           fmt.Sprintf("SPDXRef-Package-%d", i),
+			VersionInfo:      dep.Version,
+			DownloadLocation: "NOASSERTION",
+			ExternalRefs:     purl(dep.Path, dep.Version),
+		}
+		document.Packages = append(document.Packages, pkg)
+		document.Relationships = append(document.Relationships, spdxRelationship{
+			Element:        main.SPDXID,
+			Type:           "DEPENDS_ON",
+			RelatedElement: pkg.SPDXID,
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(document)
+}
+
+func purl(path, version string) []spdxExternalRef {
+	return []spdxExternalRef{{
+		ReferenceCategory: "PACKAGE-MANAGER",
+		ReferenceType:     "purl",
+		ReferenceLocator:  fmt.Sprintf("pkg:golang/%s@%s", path, version),
+	}}
+}
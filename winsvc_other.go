@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package lcmgr
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// NotifyStop relays the signals that stop lcmgr to c, including the SIGTERM
+// service managers and container runtimes stop it with.
+func NotifyStop(c chan<- os.Signal) {
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+}
+
+// RunService calls run. lcmgr only runs under a service control manager on
+// Windows.
+func RunService(name string, run func()) error {
+	run()
+	return nil
+}
@@ -0,0 +1,119 @@
+package lcmgr
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// stopPendingInterval is how often a new checkpoint is reported to the
+// service control manager while lcmgr stops, so it keeps waiting on a drain
+// instead of giving up on the service.
+const stopPendingInterval = 5 * time.Second
+
+var (
+	stopMu      sync.Mutex
+	stopSignals []chan<- os.Signal
+
+	// preshutdowns carries shutdown notifications from the service control
+	// manager to the ShutdownListener, each with a channel it closes once the
+	// last-gasp actions are done.
+	preshutdowns = make(chan chan struct{})
+)
+
+// NotifyStop relays the signals that stop lcmgr to c, along with stop and
+// shutdown requests from the service control manager.
+func NotifyStop(c chan<- os.Signal) {
+	signal.Notify(c, os.Interrupt)
+	stopMu.Lock()
+	stopSignals = append(stopSignals, c)
+	stopMu.Unlock()
+}
+
+func stop() {
+	stopMu.Lock()
+	defer stopMu.Unlock()
+	for _, c := range stopSignals {
+		select {
+		case c <- os.Interrupt:
+		default:
+		}
+	}
+}
+
+// RunService runs run as the Windows service name when lcmgr was started by
+// the service control manager, reporting its status until run returns.
+// Otherwise run is just called.
+func RunService(name string, run func()) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		run()
+		return nil
+	}
+	return svc.Run(name, &windowsService{run: run})
+}
+
+type windowsService struct {
+	run func()
+}
+
+func (service *windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		service.run()
+	}()
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPreShutdown}
+
+	for {
+		select {
+		case <-done:
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				changes <- request.CurrentStatus
+			case svc.PreShutdown:
+				handled := make(chan struct{})
+				select {
+				case preshutdowns <- handled:
+					reportStopPending(changes, handled)
+				default:
+				}
+				stop()
+				reportStopPending(changes, done)
+				return false, 0
+			case svc.Stop, svc.Shutdown:
+				stop()
+				reportStopPending(changes, done)
+				return false, 0
+			}
+		}
+	}
+}
+
+// reportStopPending reports the service as stopping until done is closed.
+func reportStopPending(changes chan<- svc.Status, done <-chan struct{}) {
+	ticker := time.NewTicker(stopPendingInterval)
+	defer ticker.Stop()
+
+	for checkpoint := uint32(1); ; checkpoint++ {
+		changes <- svc.Status{
+			State:      svc.StopPending,
+			CheckPoint: checkpoint,
+			WaitHint:   uint32(2 * stopPendingInterval / time.Millisecond),
+		}
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
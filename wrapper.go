@@ -0,0 +1,62 @@
+package lcmgr
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// CommandRunner runs a command while keeping a lifecycle action alive with
+// heartbeats, then completes the action with CONTINUE if the command exits
+// successfully or ABANDON if it fails. It lets existing bootstrap and drain
+// scripts take part in lifecycle hooks without any other configuration.
+type CommandRunner struct {
+	Command           []string
+	HeartbeatInterval time.Duration
+	Client            AWSClient
+}
+
+func NewCommandRunner(command []string, heartbeatInterval time.Duration, client AWSClient) *CommandRunner {
+	return &CommandRunner{
+		Command:           command,
+		HeartbeatInterval: heartbeatInterval,
+		Client:            client,
+	}
+}
+
+// Run returns the command's exit code, or an error if it couldn't be run or
+// the lifecycle action couldn't be completed.
+func (runner *CommandRunner) Run(ctx context.Context, notice Notice) (int, error) {
+	heartbeatCtx, cancel := context.WithCancel(ctx)
+	go NewHeartbeater(heartbeatInterval(notice, runner.HeartbeatInterval), runner.Client).Run(heartbeatCtx, notice)
+
+	cmd := exec.CommandContext(ctx, runner.Command[0], runner.Command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	code := 0
+	if err := cmd.Run(); err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			cancel()
+			return 0, err
+		}
+		code = exitErr.ExitCode()
+	}
+
+	cancel() // Stop sending heartbeats
+
+	result := ContinueResult
+	if code != 0 {
+		result = AbandonResult
+	}
+	log.Printf("command exited with code %d, completing %s lifecycle action with %s", code, notice.Type(), result)
+
+	if err := runner.Client.CompleteLifecycleAction(ctx, notice, result); err != nil {
+		return code, err
+	}
+	return code, nil
+}
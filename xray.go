@@ -0,0 +1,206 @@
+package lcmgr
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/smithy-go/middleware"
+)
+
+const (
+	// DefaultXRayDaemonAddress is where the X-Ray daemon listens for segments
+	// unless AWS_XRAY_DAEMON_ADDRESS says otherwise.
+	DefaultXRayDaemonAddress = "127.0.0.1:2000"
+	// xrayHeader precedes every segment document sent to the daemon.
+	xrayHeader = `{"format": "json", "version": 1}` + "\n"
+)
+
+// XRayTracer sends X-Ray segments to the X-Ray daemon over UDP, an
+// alternative to the OTLP exporter for shops standardized on X-Ray. Handling
+// a notice is recorded as a segment, joined to the trace of the message it
+// arrived in when there is one, and every AWS call made while handling it as
+// a subsegment.
+type XRayTracer struct {
+	Name string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewXRayTracer sends segments named name to the daemon at address, or at
+// AWS_XRAY_DAEMON_ADDRESS or DefaultXRayDaemonAddress when it's empty.
+func NewXRayTracer(address, name string) (*XRayTracer, error) {
+	if address == "" {
+		address = os.Getenv("AWS_XRAY_DAEMON_ADDRESS")
+	}
+	if address == "" {
+		address = DefaultXRayDaemonAddress
+	}
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &XRayTracer{Name: name, conn: conn}, nil
+}
+
+// XRaySegment is a segment or subsegment document.
+type XRaySegment struct {
+	Name        string            `json:"name"`
+	ID          string            `json:"id"`
+	TraceID     string            `json:"trace_id"`
+	ParentID    string            `json:"parent_id,omitempty"`
+	Type        string            `json:"type,omitempty"`
+	Namespace   string            `json:"namespace,omitempty"`
+	StartTime   float64           `json:"start_time"`
+	EndTime     float64           `json:"end_time"`
+	Error       bool              `json:"error,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	AWS         map[string]string `json:"aws,omitempty"`
+
+	tracer *XRayTracer
+}
+
+type segmentKey struct{}
+
+// StartSegment starts a segment for handling notice and returns a context
+// carrying it, so AWS calls and webhooks made with the context are traced
+// under it.
+func (tracer *XRayTracer) StartSegment(ctx context.Context, notice Notice) (context.Context, *XRaySegment) {
+	segment := &XRaySegment{
+		Name:        tracer.Name,
+		ID:          newXRayID(8),
+		StartTime:   xrayTime(time.Now()),
+		Annotations: map[string]string{"notice": notice.Type()},
+		tracer:      tracer,
+	}
+	if trace := TraceFromContext(WithNoticeTrace(ctx, notice)); trace != nil {
+		segment.TraceID, segment.ParentID = trace.TraceID, trace.ParentID
+	} else {
+		segment.TraceID = newXRayTraceID()
+	}
+	if lifecycle := lifecycleNotice(notice); lifecycle != nil {
+		segment.Annotations["lifecycle_hook_name"] = lifecycle.LifecycleHookName
+	}
+
+	ctx = context.WithValue(ctx, segmentKey{}, segment)
+	ctx = context.WithValue(ctx, traceKey{}, &TraceContext{TraceID: segment.TraceID, ParentID: segment.ID, Sampled: true})
+	return ctx, segment
+}
+
+// Close ends the segment, marking it as an error if err isn't nil, and sends
+// it to the daemon.
+func (segment *XRaySegment) Close(err error) {
+	segment.EndTime = xrayTime(time.Now())
+	segment.Error = err != nil
+	segment.tracer.send(segment)
+}
+
+// subsegment starts a subsegment of segment sent on its own, so it reaches
+// the daemon even if the segment is still open.
+func (segment *XRaySegment) subsegment(name string) *XRaySegment {
+	return &XRaySegment{
+		Name:      name,
+		ID:        newXRayID(8),
+		TraceID:   segment.TraceID,
+		ParentID:  segment.ID,
+		Type:      "subsegment",
+		StartTime: xrayTime(time.Now()),
+		tracer:    segment.tracer,
+	}
+}
+
+func (tracer *XRayTracer) send(segment *XRaySegment) {
+	document, err := json.Marshal(segment)
+	if err != nil {
+		log.Printf("failed to encode x-ray segment: %v", err)
+		return
+	}
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	if _, err := tracer.conn.Write(append([]byte(xrayHeader), document...)); err != nil {
+		debugf("failed to send x-ray segment: %v", err)
+	}
+}
+
+// WithXRay records every AWS call made with a context carrying an X-Ray
+// segment as a subsegment of it.
+func WithXRay() AWSOption {
+	return func(options *awsOptions) {
+		options.load = append(options.load, config.WithAPIOptions([]func(*middleware.Stack) error{
+			func(stack *middleware.Stack) error {
+				return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("lcmgrXRay", xrayCall), middleware.Before)
+			},
+		}))
+	}
+}
+
+func xrayCall(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	segment, ok := ctx.Value(segmentKey{}).(*XRaySegment)
+	if !ok {
+		return next.HandleInitialize(ctx, in)
+	}
+
+	service, operation := awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx)
+	subsegment := segment.subsegment(service)
+	subsegment.Namespace = "aws"
+
+	out, metadata, err := next.HandleInitialize(ctx, in)
+	requestID, _ := awsmiddleware.GetRequestIDMetadata(metadata)
+	subsegment.AWS = map[string]string{
+		"operation":  operation,
+		"region":     awsmiddleware.GetRegion(ctx),
+		"request_id": requestID,
+	}
+	subsegment.Close(err)
+	return out, metadata, err
+}
+
+// XRayHandler records handling each notice as an X-Ray segment.
+type XRayHandler struct {
+	Handler Handler
+	Tracer  *XRayTracer
+}
+
+func NewXRayHandler(handler Handler, tracer *XRayTracer) Handler {
+	return &XRayHandler{
+		Handler: handler,
+		Tracer:  tracer,
+	}
+}
+
+func (handler *XRayHandler) Handle(ctx context.Context, notice Notice) error {
+	ctx, segment := handler.Tracer.StartSegment(ctx, notice)
+	err := handler.Handler.Handle(ctx, notice)
+	segment.Close(err)
+	return err
+}
+
+// newXRayTraceID returns a trace ID in X-Ray's format, the start time in
+// epoch seconds followed by 96 random bits, e.g.
+// 1-5759e988-bd862e3fe1be46a994272793.
+func newXRayTraceID() string {
+	return fmt.Sprintf("1-%08x-%s", time.Now().Unix(), newXRayID(12))
+}
+
+func newXRayID(n int) string {
+	id := make([]byte, n)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+func xrayTime(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}